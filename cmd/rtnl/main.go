@@ -3,9 +3,12 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"os"
+	"os/user"
+	"sort"
 	"strings"
 	"text/tabwriter"
 	"time"
@@ -15,12 +18,14 @@ import (
 	confire "github.com/rotationalio/confire/usage"
 	"github.com/rotationalio/rtnl.link/pkg"
 	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
 	"github.com/rotationalio/rtnl.link/pkg/client"
 	"github.com/rotationalio/rtnl.link/pkg/config"
 	"github.com/rotationalio/rtnl.link/pkg/keygen"
 	"github.com/rotationalio/rtnl.link/pkg/passwd"
 	"github.com/rotationalio/rtnl.link/pkg/rtnl"
 	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/migrations"
 	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 	"github.com/urfave/cli/v2"
 )
@@ -85,7 +90,31 @@ func main() {
 			Usage:    "generate apikeys in maintenance mode",
 			Action:   register,
 			Before:   configure,
-			Flags:    []cli.Flag{},
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "name",
+					Aliases: []string{"n"},
+					Usage:   "a human readable name to identify the api key",
+				},
+				&cli.StringSliceFlag{
+					Name:    "scope",
+					Aliases: []string{"s"},
+					Usage:   "grant a scope to the api key (defaults to all scopes except replicate and admin)",
+				},
+				&cli.DurationFlag{
+					Name:    "expires",
+					Aliases: []string{"e"},
+					Usage:   "amount of time until the api key expires (never expires if omitted)",
+				},
+				&cli.Uint64Flag{
+					Name:  "links-per-day",
+					Usage: "maximum number of links that can be created with the key per day",
+				},
+				&cli.Uint64Flag{
+					Name:  "active-links",
+					Usage: "maximum number of unexpired links created with the key",
+				},
+			},
 		},
 		{
 			Name:     "anonymize",
@@ -95,6 +124,29 @@ func main() {
 			Before:   configure,
 			Flags:    []cli.Flag{},
 		},
+		{
+			Name:     "recount",
+			Category: "admin",
+			Usage:    "rebuild the aggregate link counts from a full scan of the database",
+			Action:   recount,
+			Before:   configure,
+			Flags:    []cli.Flag{},
+		},
+		{
+			Name:     "db:compact",
+			Category: "admin",
+			Usage:    "flatten the database and garbage collect the value log in maintenance mode",
+			Action:   compact,
+			Before:   configure,
+			Flags: []cli.Flag{
+				&cli.Float64Flag{
+					Name:    "discard-ratio",
+					Aliases: []string{"r"},
+					Usage:   "fraction of a value log file that must be discardable for it to be rewritten",
+					Value:   0.5,
+				},
+			},
+		},
 		{
 			Name:      "shorten",
 			Category:  "client",
@@ -133,6 +185,26 @@ func main() {
 			Before:    makeClient,
 			Flags:     []cli.Flag{},
 		},
+		{
+			Name:      "edit",
+			Category:  "client",
+			Usage:     "edit the title and description of a short url",
+			ArgsUsage: "urlID",
+			Action:    edit,
+			Before:    makeClient,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "title",
+					Aliases: []string{"T"},
+					Usage:   "the title of the short url",
+				},
+				&cli.StringFlag{
+					Name:    "description",
+					Aliases: []string{"d"},
+					Usage:   "a description of the short url",
+				},
+			},
+		},
 		{
 			Name:      "delete",
 			Category:  "client",
@@ -150,6 +222,271 @@ func main() {
 			Before:   makeClient,
 			Flags:    []cli.Flag{},
 		},
+		{
+			Name:     "apikeys",
+			Category: "client",
+			Usage:    "manage the api keys that can access the shortener service",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list all api keys including revoked keys",
+					Action: listAPIKeys,
+					Before: makeClient,
+				},
+				{
+					Name:   "create",
+					Usage:  "create a new api key and print its token",
+					Action: createAPIKey,
+					Before: makeClient,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:     "name",
+							Aliases:  []string{"n"},
+							Usage:    "a human readable name to identify the api key",
+							Required: true,
+						},
+						&cli.StringSliceFlag{
+							Name:    "scope",
+							Aliases: []string{"s"},
+							Usage:   "grant a scope to the api key (defaults to all scopes except replicate and admin)",
+						},
+						&cli.DurationFlag{
+							Name:    "expires",
+							Aliases: []string{"e"},
+							Usage:   "amount of time until the api key expires (never expires if omitted)",
+						},
+						&cli.Uint64Flag{
+							Name:  "links-per-day",
+							Usage: "maximum number of links that can be created with the key per day",
+						},
+						&cli.Uint64Flag{
+							Name:  "active-links",
+							Usage: "maximum number of unexpired links created with the key",
+						},
+					},
+				},
+				{
+					Name:      "rename",
+					Usage:     "change the name of an api key",
+					ArgsUsage: "clientID name",
+					Action:    renameAPIKey,
+					Before:    makeClient,
+				},
+				{
+					Name:      "revoke",
+					Usage:     "revoke api keys so they can no longer be used",
+					ArgsUsage: "clientID [clientID ...]",
+					Action:    revokeAPIKeys,
+					Before:    makeClient,
+				},
+				{
+					Name:      "rotate",
+					Usage:     "replace the secret of an api key and print its new token",
+					ArgsUsage: "clientID",
+					Action:    rotateAPIKey,
+					Before:    makeClient,
+				},
+			},
+		},
+		{
+			Name:     "sessions",
+			Category: "client",
+			Usage:    "manage the login sessions of web users",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list active sessions",
+					Action: listSessions,
+					Before: makeClient,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "email",
+							Aliases: []string{"u"},
+							Usage:   "only list the sessions of the user with this email address",
+						},
+					},
+				},
+				{
+					Name:      "revoke",
+					Usage:     "revoke sessions so their tokens can no longer be used",
+					ArgsUsage: "sessionID [sessionID ...]",
+					Action:    revokeSessions,
+					Before:    makeClient,
+				},
+				{
+					Name:      "logout",
+					Usage:     "revoke all sessions of users to log them out everywhere",
+					ArgsUsage: "email [email ...]",
+					Action:    logoutUsers,
+					Before:    makeClient,
+				},
+			},
+		},
+		{
+			Name:     "audit",
+			Category: "client",
+			Usage:    "query the audit log of link and administrative actions",
+			Action:   auditLog,
+			Before:   makeClient,
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:    "actor",
+					Aliases: []string{"u"},
+					Usage:   "only list actions performed by this email address or client ID",
+				},
+				&cli.StringFlag{
+					Name:    "after",
+					Aliases: []string{"a"},
+					Usage:   "only list actions at or after this timestamp (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)",
+				},
+				&cli.StringFlag{
+					Name:    "before",
+					Aliases: []string{"b"},
+					Usage:   "only list actions before this timestamp (YYYY-MM-DD or YYYY-MM-DD HH:MM:SS)",
+				},
+				&cli.IntFlag{
+					Name:    "limit",
+					Aliases: []string{"n"},
+					Usage:   "maximum number of actions to list, most recent first",
+				},
+			},
+		},
+		{
+			Name:     "users",
+			Category: "admin",
+			Usage:    "assign roles to web users and manage local accounts in maintenance mode",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "list",
+					Usage:  "list the users that have been assigned a role in the database",
+					Action: listUsers,
+					Before: configure,
+				},
+				{
+					Name:      "role",
+					Usage:     "assign a role (viewer, editor, or admin) to a user by email",
+					ArgsUsage: "email role",
+					Action:    assignRole,
+					Before:    configure,
+				},
+				{
+					Name:      "add",
+					Usage:     "create a local account that can log in with an email and password",
+					ArgsUsage: "email",
+					Action:    addUser,
+					Before:    configure,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "name",
+							Aliases: []string{"n"},
+							Usage:   "the full name of the user",
+						},
+						&cli.StringFlag{
+							Name:    "role",
+							Aliases: []string{"r"},
+							Usage:   "the role of the user (viewer, editor, or admin; defaults to the configured default role)",
+						},
+						&cli.StringFlag{
+							Name:    "password",
+							Aliases: []string{"p"},
+							Usage:   "the password of the user (a random password is generated and printed if omitted)",
+						},
+					},
+				},
+				{
+					Name:      "reset",
+					Usage:     "reset the password of a local account, unlocking and enabling it and logging it out everywhere",
+					ArgsUsage: "email",
+					Action:    resetPassword,
+					Before:    configure,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "password",
+							Aliases: []string{"p"},
+							Usage:   "the new password of the user (a random password is generated and printed if omitted)",
+						},
+					},
+				},
+				{
+					Name:      "disable",
+					Usage:     "disable users so they can no longer log in or use their tokens and log them out everywhere",
+					ArgsUsage: "email [email ...]",
+					Action:    disableUsers,
+					Before:    configure,
+				},
+				{
+					Name:      "remove",
+					Usage:     "remove users so their role is determined by the configuration",
+					ArgsUsage: "email [email ...]",
+					Action:    removeUsers,
+					Before:    configure,
+				},
+			},
+		},
+		{
+			Name:     "keys",
+			Category: "admin",
+			Usage:    "manage the rsa keys used to sign access and refresh tokens",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "generate",
+					Usage:  "write a new signing key named by its ulid to the keys directory",
+					Action: generateKey,
+					Flags: []cli.Flag{
+						&cli.StringFlag{
+							Name:    "dir",
+							Aliases: []string{"d"},
+							Usage:   "the directory to write the key to",
+							Value:   ".",
+							EnvVars: []string{"RTNL_AUTH_KEYS_DIR"},
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:     "db:migrate",
+			Category: "admin",
+			Usage:    "inspect and manage database migrations",
+			Subcommands: []*cli.Command{
+				{
+					Name:   "status",
+					Usage:  "show the current migration, its history, and pending migrations",
+					Action: migrateStatus,
+					Before: openStore,
+					After:  closeStore,
+				},
+				{
+					Name:   "up",
+					Usage:  "apply all pending migrations",
+					Action: migrateUp,
+					Before: openStore,
+					After:  closeStore,
+				},
+				{
+					Name:   "down",
+					Usage:  "roll back the most recently applied migrations",
+					Action: migrateDown,
+					Before: openStore,
+					After:  closeStore,
+					Flags: []cli.Flag{
+						&cli.UintFlag{
+							Name:    "steps",
+							Aliases: []string{"n"},
+							Usage:   "the number of migrations to roll back",
+							Value:   1,
+						},
+					},
+				},
+				{
+					Name:   "dry-run",
+					Usage:  "apply pending migrations in a discarded transaction and report changes",
+					Action: migrateDryRun,
+					Before: openStore,
+					After:  closeStore,
+				},
+			},
+		},
 		{
 			Name:     "db:keys",
 			Category: "debug",
@@ -214,8 +551,30 @@ func register(c *cli.Context) (err error) {
 	defer store.Close()
 
 	// Generate API key pair
+	scopes := auth.Scopes(c.StringSlice("scope"))
+	if len(scopes) == 0 {
+		scopes = auth.DefaultScopes
+	}
+
+	if err = scopes.Validate(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
 	apikey := &models.APIKey{
 		ClientID: keygen.KeyID(),
+		Name:     c.String("name"),
+		Scopes:   scopes,
+	}
+
+	if expires := c.Duration("expires"); expires > 0 {
+		apikey.Expires = time.Now().Add(expires)
+	}
+
+	if c.Uint64("links-per-day") > 0 || c.Uint64("active-links") > 0 {
+		apikey.Quota = &models.Quota{
+			LinksPerDay: c.Uint64("links-per-day"),
+			ActiveLinks: c.Uint64("active-links"),
+		}
 	}
 
 	secret := keygen.Secret()
@@ -227,6 +586,12 @@ func register(c *cli.Context) (err error) {
 		return cli.Exit(err, 1)
 	}
 
+	// The key has been registered so an audit failure is reported but does not fail
+	event := &models.AuditEvent{Actor: cliActor(), Action: models.ActionAPIKeyCreated, Target: apikey.ClientID, After: apikey.Summary()}
+	if err = store.Audit(event); err != nil {
+		fmt.Fprintf(os.Stderr, "could not record audit event: %s\n", err)
+	}
+
 	fmt.Println(apikey.ClientID + "-" + secret)
 	return nil
 }
@@ -252,43 +617,42 @@ func anonymize(c *cli.Context) (err error) {
 
 	counts := make(map[string]int)
 	err = db.Update(func(txn *badger.Txn) error {
-		// TODO: provide more semantic method of listing API keys
+		// Only the API keys bucket is anonymized; selecting keys by their length would
+		// also match other buckets such as users whose keys happen to be the same size.
 		iter := txn.NewIterator(badger.DefaultIteratorOptions)
 		defer iter.Close()
 
-		for iter.Rewind(); iter.Valid(); iter.Next() {
+		prefix := models.APIKeysBucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
 			item := iter.Item()
-			key := item.Key()
-
-			counts[fmt.Sprintf("keysize %d", len(key))]++
-
-			if len(key) == 16 {
-				apikey := &models.APIKey{}
-				if err = item.Value(apikey.UnmarshalValue); err != nil {
-					fmt.Println(err)
-					counts["errors"]++
-					continue
-				}
-
-				apikey.ClientID = keygen.KeyID()
-				if apikey.DerivedKey, err = passwd.CreateDerivedKey(keygen.Secret()); err != nil {
-					fmt.Println(err)
-					counts["errors"]++
-					continue
-				}
-
-				var data []byte
-				if data, err = apikey.MarshalValue(); err != nil {
-					fmt.Println(err)
-					counts["errors"]++
-					continue
-				}
-
-				if err = txn.Set(key, data); err != nil {
-					return err
-				}
-				counts["anonymized"]++
+			key := item.KeyCopy(nil)
+			counts["api keys"]++
+
+			apikey := &models.APIKey{}
+			if err = item.Value(apikey.UnmarshalValue); err != nil {
+				fmt.Println(err)
+				counts["errors"]++
+				continue
+			}
+
+			apikey.ClientID = keygen.KeyID()
+			if apikey.DerivedKey, err = passwd.CreateDerivedKey(keygen.Secret()); err != nil {
+				fmt.Println(err)
+				counts["errors"]++
+				continue
+			}
+
+			var data []byte
+			if data, err = apikey.MarshalValue(); err != nil {
+				fmt.Println(err)
+				counts["errors"]++
+				continue
+			}
+
+			if err = txn.Set(key, data); err != nil {
+				return err
 			}
+			counts["anonymized"]++
 		}
 
 		return nil
@@ -305,6 +669,63 @@ func anonymize(c *cli.Context) (err error) {
 	return nil
 }
 
+func recount(c *cli.Context) (err error) {
+	if !conf.Maintenance {
+		return cli.Exit("server must be in maintenance mode", 1)
+	}
+
+	// Open the database
+	var store storage.Storage
+	if store, err = storage.Open(conf.Storage); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer store.Close()
+
+	var counts *models.Counts
+	if counts, err = store.Recount(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(counts.ToAPI())
+}
+
+func compact(c *cli.Context) (err error) {
+	if !conf.Maintenance {
+		return cli.Exit("server must be in maintenance mode", 1)
+	}
+
+	ratio := c.Float64("discard-ratio")
+	if ratio <= 0 || ratio >= 1 {
+		return cli.Exit("discard ratio must be between 0 and 1", 1)
+	}
+
+	// Open the database
+	var store storage.Storage
+	if store, err = storage.Open(conf.Storage); err != nil {
+		return cli.Exit(err, 1)
+	}
+	defer store.Close()
+
+	// Sweep expired links so their contribution is removed from the counts
+	var expired int
+	if expired, err = store.ExpireLinks(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	// Flatten before GC so that compaction can discard deleted and expired keys
+	if err = store.Flatten(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var reclaimed int64
+	if reclaimed, err = store.RunGC(ratio); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("swept %d expired links and reclaimed %d bytes from the value log\n", expired, reclaimed)
+	return nil
+}
+
 //===========================================================================
 // Client Commands
 //===========================================================================
@@ -321,45 +742,60 @@ func shorten(c *cli.Context) (err error) {
 		return cli.Exit("specify either expires or ttl not both", 1)
 	}
 
-	req := &api.LongURL{}
+	var expires string
 	if expiresAt != nil && !expiresAt.IsZero() {
-		req.Expires = expiresAt.Format(time.RFC3339)
+		expires = expiresAt.Format(time.RFC3339)
 	}
 
 	if ttl > 0 {
-		req.Expires = time.Now().Add(ttl).Format(time.RFC3339)
+		expires = time.Now().Add(ttl).Format(time.RFC3339)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	out := make([]*api.ShortURL, 0, c.NArg())
-	for i := 0; i < c.NArg(); i++ {
-		req.URL = c.Args().Get(i)
-
-		var rep *api.ShortURL
-		if rep, err = svc.ShortenURL(ctx, req); err != nil {
+	if c.NArg() == 1 {
+		var out *api.ShortURL
+		if out, err = svc.ShortenURL(ctx, &api.LongURL{URL: c.Args().First(), Expires: expires}); err != nil {
 			return cli.Exit(err, 1)
 		}
-		out = append(out, rep)
+		return display(out)
 	}
 
-	if len(out) == 1 {
-		return display(out[0])
+	// Shorten multiple urls with batch requests
+	results := make([]*api.BatchResult, 0, c.NArg())
+	for start := 0; start < c.NArg(); start += api.MaxBatchSize {
+		req := &api.LongURLBatch{URLs: make([]*api.LongURL, 0, api.MaxBatchSize)}
+		for i := start; i < c.NArg() && i < start+api.MaxBatchSize; i++ {
+			req.URLs = append(req.URLs, &api.LongURL{URL: c.Args().Get(i), Expires: expires})
+		}
+
+		var rep *api.ShortURLBatch
+		if rep, err = svc.ShortenURLs(ctx, req); err != nil {
+			return cli.Exit(err, 1)
+		}
+		results = append(results, rep.Results...)
 	}
-	return display(out)
+
+	return display(results)
 }
 
 func listLinks(c *cli.Context) (err error) {
 	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
-	var out *api.ShortURLList
-	if out, err = svc.ShortURLList(ctx, nil); err != nil {
+	// Fetch every page of short urls from the server
+	out := make([]*api.ShortURL, 0)
+	pages := client.NewPageIterator(svc, nil)
+	for pages.Next(ctx) {
+		out = append(out, pages.Page().URLs...)
+	}
+
+	if err = pages.Err(); err != nil {
 		return cli.Exit(err, 1)
 	}
 
-	return display(out)
+	return display(&api.ShortURLList{URLs: out, Page: &api.PageQuery{}})
 }
 
 func info(c *cli.Context) (err error) {
@@ -393,6 +829,31 @@ func info(c *cli.Context) (err error) {
 	return display(out)
 }
 
+func edit(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.Exit("specify the short url ID to edit", 1)
+	}
+
+	sid := c.Args().Get(0)
+	if strings.HasPrefix(sid, "http") {
+		if u, err := url.Parse(sid); err == nil {
+			sid = strings.TrimPrefix(u.Path, "/")
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	in := &api.ShortURL{Title: c.String("title"), Description: c.String("description")}
+
+	var out *api.ShortURL
+	if out, err = svc.UpdateShortURL(ctx, sid, in); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(out)
+}
+
 func delete(c *cli.Context) (err error) {
 	if c.NArg() == 0 {
 		return cli.Exit("specify at least one short url ID to get info for", 1)
@@ -429,6 +890,464 @@ func status(c *cli.Context) (err error) {
 	return display(status)
 }
 
+func listAPIKeys(c *cli.Context) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out *api.APIKeyList
+	if out, err = svc.APIKeyList(ctx); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(out)
+}
+
+func createAPIKey(c *cli.Context) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	in := &api.APIKey{Name: c.String("name"), Scopes: c.StringSlice("scope")}
+	if expires := c.Duration("expires"); expires > 0 {
+		ts := time.Now().Add(expires)
+		in.Expires = &ts
+	}
+
+	if c.Uint64("links-per-day") > 0 || c.Uint64("active-links") > 0 {
+		in.Quota = &api.Quota{
+			LinksPerDay: c.Uint64("links-per-day"),
+			ActiveLinks: c.Uint64("active-links"),
+		}
+	}
+
+	var out *api.APIKey
+	if out, err = svc.CreateAPIKey(ctx, in); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Println(out.Token())
+	return nil
+}
+
+func renameAPIKey(c *cli.Context) (err error) {
+	if c.NArg() != 2 {
+		return cli.Exit("specify the client ID and the new name of the api key", 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out *api.APIKey
+	if out, err = svc.UpdateAPIKey(ctx, &api.APIKey{ClientID: c.Args().Get(0), Name: c.Args().Get(1)}); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(out)
+}
+
+func revokeAPIKeys(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return cli.Exit("specify at least one client ID to revoke", 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i := 0; i < c.NArg(); i++ {
+		clientID := c.Args().Get(i)
+		if err = svc.RevokeAPIKey(ctx, clientID); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("api key %s has been revoked\n", clientID)
+	}
+	return nil
+}
+
+func rotateAPIKey(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.Exit("specify the client ID of the api key to rotate", 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out *api.APIKey
+	if out, err = svc.RotateAPIKey(ctx, c.Args().Get(0)); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Println(out.Token())
+	return nil
+}
+
+//===========================================================================
+// Key Commands
+//===========================================================================
+
+func generateKey(c *cli.Context) (err error) {
+	var path string
+	if path, err = auth.GenerateKey(c.String("dir")); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Println(path)
+	return nil
+}
+
+//===========================================================================
+// Session Commands
+//===========================================================================
+
+func listSessions(c *cli.Context) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var out *api.SessionList
+	if out, err = svc.SessionList(ctx, &api.SessionQuery{Email: c.String("email")}); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(out)
+}
+
+func revokeSessions(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return cli.Exit("specify at least one session ID to revoke", 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i := 0; i < c.NArg(); i++ {
+		id := c.Args().Get(i)
+		if err = svc.RevokeSession(ctx, id); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("session %s has been revoked\n", id)
+	}
+	return nil
+}
+
+func logoutUsers(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return cli.Exit("specify at least one email address to log out", 1)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for i := 0; i < c.NArg(); i++ {
+		email := c.Args().Get(i)
+		if err = svc.RevokeUserSessions(ctx, email); err != nil {
+			return cli.Exit(err, 1)
+		}
+		fmt.Printf("all sessions of %s have been revoked\n", email)
+	}
+	return nil
+}
+
+func auditLog(c *cli.Context) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	in := &api.AuditQuery{
+		Actor:  c.String("actor"),
+		After:  c.String("after"),
+		Before: c.String("before"),
+		Limit:  c.Int("limit"),
+	}
+
+	var out *api.AuditLog
+	if out, err = svc.AuditLog(ctx, in); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	return display(out)
+}
+
+//===========================================================================
+// User Commands
+//===========================================================================
+
+func listUsers(c *cli.Context) (err error) {
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var users []*models.User
+	if users, err = db.ListUsers(); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	// Never display the derived keys of local accounts
+	for _, user := range users {
+		if user.IsLocal() {
+			user.DerivedKey = "[redacted]"
+		}
+	}
+
+	return display(users)
+}
+
+func assignRole(c *cli.Context) (err error) {
+	if c.NArg() != 2 {
+		return cli.Exit("specify the email address of the user and their role", 1)
+	}
+
+	var role string
+	if role, err = auth.ParseRole(c.Args().Get(1)); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	// Load the existing user so that the role can be changed without modifying the
+	// local account of the user.
+	var user *models.User
+	if user, err = db.RetrieveUser(c.Args().Get(0)); err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			return cli.Exit(err, 1)
+		}
+		user = &models.User{Email: c.Args().Get(0)}
+	}
+
+	user.Role = role
+	if err = db.SaveUser(user); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("%s has been assigned the %s role\n", user.Email, user.Role)
+	return nil
+}
+
+func addUser(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.Exit("specify the email address of the user", 1)
+	}
+
+	role := c.String("role")
+	if role == "" {
+		role = conf.Auth.DefaultRole
+	}
+
+	if role, err = auth.ParseRole(role); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	password, generated := c.String("password"), false
+	if password == "" {
+		password, generated = keygen.Secret(), true
+	}
+
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	user := &models.User{Email: c.Args().Get(0), Name: c.String("name"), Role: role}
+	if existing, err := db.RetrieveUser(user.Email); err == nil && existing.IsLocal() {
+		return cli.Exit(fmt.Errorf("%s already has a local account, use rtnl users reset to change the password", user.Email), 1)
+	}
+
+	if user.DerivedKey, err = passwd.CreateDerivedKey(password); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if err = db.SaveUser(user); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	fmt.Printf("created local account for %s with the %s role\n", user.Email, user.Role)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+	return nil
+}
+
+func resetPassword(c *cli.Context) (err error) {
+	if c.NArg() != 1 {
+		return cli.Exit("specify the email address of the user", 1)
+	}
+
+	password, generated := c.String("password"), false
+	if password == "" {
+		password, generated = keygen.Secret(), true
+	}
+
+	var derivedKey string
+	if derivedKey, err = passwd.CreateDerivedKey(password); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	email := c.Args().Get(0)
+	if err = db.SetPassword(email, derivedKey); err != nil {
+		return cli.Exit(fmt.Errorf("could not reset password of %s: %w", email, err), 1)
+	}
+
+	fmt.Printf("the password of %s has been reset and their sessions revoked\n", email)
+	if generated {
+		fmt.Printf("password: %s\n", password)
+	}
+	return nil
+}
+
+func disableUsers(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return cli.Exit("specify at least one user email address to disable", 1)
+	}
+
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for i := 0; i < c.NArg(); i++ {
+		email := c.Args().Get(i)
+		if err = db.DisableUser(email); err != nil {
+			return cli.Exit(fmt.Errorf("could not disable %s: %w", email, err), 1)
+		}
+		fmt.Printf("%s has been disabled and their sessions revoked\n", email)
+	}
+	return nil
+}
+
+func removeUsers(c *cli.Context) (err error) {
+	if c.NArg() == 0 {
+		return cli.Exit("specify at least one user email address to remove", 1)
+	}
+
+	var db storage.Storage
+	if db, err = openStorage(); err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for i := 0; i < c.NArg(); i++ {
+		email := c.Args().Get(i)
+		if err = db.DeleteUser(email); err != nil {
+			return cli.Exit(fmt.Errorf("could not remove %s: %w", email, err), 1)
+		}
+		fmt.Printf("%s has been removed\n", email)
+	}
+	return nil
+}
+
+//===========================================================================
+// Migration Commands
+//===========================================================================
+
+func migrateStatus(c *cli.Context) (err error) {
+	var (
+		current *migrations.Migration
+		pending migrations.Migrations
+	)
+
+	if err = store.View(func(txn *badger.Txn) (err error) {
+		current, pending, err = migrations.Status(txn)
+		return err
+	}); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	tabs := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	fmt.Fprintln(tabs, "Version\tStatus\tApplied\tDuration\tDescription")
+
+	if current != nil {
+		for _, m := range current.History() {
+			fmt.Fprintf(tabs, "%d\tapplied\t%s\t%s\t%s\n", m.Version, m.Applied.Format(time.RFC3339), m.Duration, m.Description)
+		}
+	}
+
+	for _, m := range pending {
+		fmt.Fprintf(tabs, "%d\tpending\t-\t-\t%s\n", m.Version, m.Description)
+	}
+
+	tabs.Flush()
+	return nil
+}
+
+func migrateUp(c *cli.Context) (err error) {
+	if err = migrations.Migrate(store); err != nil {
+		return cli.Exit(err, 1)
+	}
+	return migrateStatus(c)
+}
+
+func migrateDown(c *cli.Context) (err error) {
+	for i := uint(0); i < c.Uint("steps"); i++ {
+		var current *migrations.Migration
+		if err = store.Update(func(txn *badger.Txn) (err error) {
+			current, err = migrations.Rollback(txn)
+			return err
+		}); err != nil {
+			return cli.Exit(err, 1)
+		}
+
+		if current == nil {
+			break
+		}
+	}
+	return migrateStatus(c)
+}
+
+func migrateDryRun(c *cli.Context) (err error) {
+	// The transaction is always discarded so that no changes are persisted.
+	txn := store.NewTransaction(true)
+	defer txn.Discard()
+
+	var report *migrations.Report
+	if report, err = migrations.DryRun(txn); err != nil {
+		return cli.Exit(err, 1)
+	}
+
+	if len(report.Applied) == 0 {
+		fmt.Printf("database is at the latest migration (version %d)\n", report.From)
+		return nil
+	}
+
+	fmt.Printf("migrating from version %d to version %d would apply:\n\n", report.From, report.To)
+	tabs := tabwriter.NewWriter(os.Stdout, 1, 0, 4, ' ', 0)
+	fmt.Fprintln(tabs, "Version\tDuration\tDescription")
+	for _, m := range report.Applied {
+		fmt.Fprintf(tabs, "%d\t%s\t%s\n", m.Version, m.Duration, m.Description)
+	}
+	tabs.Flush()
+
+	buckets := make([]string, 0, len(report.After))
+	for bucket := range report.Before {
+		buckets = append(buckets, bucket)
+	}
+	for bucket := range report.After {
+		if _, ok := report.Before[bucket]; !ok {
+			buckets = append(buckets, bucket)
+		}
+	}
+	sort.Strings(buckets)
+
+	fmt.Println("\nresulting in the following changes to the number of keys:")
+	fmt.Println()
+	fmt.Fprintln(tabs, "Bucket\tBefore\tAfter\tChange")
+	for _, bucket := range buckets {
+		before, after := report.Before[bucket], report.After[bucket]
+		fmt.Fprintf(tabs, "%s\t%d\t%d\t%+d\n", bucket, before, after, after-before)
+	}
+	tabs.Flush()
+	return nil
+}
+
 //===========================================================================
 // Debug Commands
 //===========================================================================
@@ -487,6 +1406,19 @@ func openStore(c *cli.Context) (err error) {
 	return nil
 }
 
+// Opens the rtnl storage for commands that modify the database directly, which
+// requires the server to be in maintenance mode.
+func openStorage() (db storage.Storage, err error) {
+	if !conf.Maintenance {
+		return nil, cli.Exit("server must be in maintenance mode", 1)
+	}
+
+	if db, err = storage.Open(conf.Storage); err != nil {
+		return nil, cli.Exit(err, 1)
+	}
+	return db, nil
+}
+
 func closeStore(c *cli.Context) (err error) {
 	if err = store.Close(); err != nil {
 		return cli.Exit(err, 1)
@@ -494,6 +1426,15 @@ func closeStore(c *cli.Context) (err error) {
 	return nil
 }
 
+// Returns the actor recorded in the audit log for changes made with the CLI, which
+// identifies the local user that ran the command if it is known.
+func cliActor() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return "cli:" + u.Username
+	}
+	return "cli"
+}
+
 func display(v any) error {
 	encoder := json.NewEncoder(os.Stdout)
 	encoder.SetIndent("", "  ")
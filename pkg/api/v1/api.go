@@ -17,13 +17,37 @@ type Service interface {
 	Status(context.Context) (*StatusReply, error)
 
 	// URL Management
-	// TODO: edit short url with details
 	ShortURLList(context.Context, *PageQuery) (*ShortURLList, error)
 	ShortenURL(context.Context, *LongURL) (*ShortURL, error)
+	ShortenURLs(context.Context, *LongURLBatch) (*ShortURLBatch, error)
 	ShortURLInfo(context.Context, string) (*ShortURL, error)
+	UpdateShortURL(context.Context, string, *ShortURL) (*ShortURL, error)
 	DeleteShortURL(context.Context, string) error
 
+	// API Key Management
+	APIKeyList(context.Context) (*APIKeyList, error)
+	CreateAPIKey(context.Context, *APIKey) (*APIKey, error)
+	UpdateAPIKey(context.Context, *APIKey) (*APIKey, error)
+	RevokeAPIKey(context.Context, string) error
+	RotateAPIKey(context.Context, string) (*APIKey, error)
+
+	// Personal Access Tokens
+	TokenList(context.Context) (*APIKeyList, error)
+	CreateToken(context.Context, *APIKey) (*APIKey, error)
+	RevokeToken(context.Context, string) error
+
+	// Session Management
+	SessionList(context.Context, *SessionQuery) (*SessionList, error)
+	RevokeSession(context.Context, string) error
+	RevokeUserSessions(context.Context, string) error
+
+	// Audit Log
+	AuditLog(context.Context, *AuditQuery) (*AuditLog, error)
+
 	// Stats/Info
+	ShortcrustStats(context.Context) (*ShortcrustInfo, error)
+	ShortURLQRCode(context.Context, string) ([]byte, error)
+	Updates(context.Context, string) (<-chan *Click, error)
 
 	// Campaigns
 }
@@ -52,9 +76,24 @@ type PageQuery struct {
 	NextPageToken string `json:"next_page_token" url:"next_page_token,omitempty" form:"next_page_token"`
 }
 
-// LoginForm is used for Google to submit an id token back to the server.
+// LinkQuery filters the links returned by a list request. Links can be filtered by the
+// host they redirect to, by the user or client that created them, and by the date that
+// they were created; filters are combined so that links must match all of them. Mine
+// restricts the links to those created by the authenticated user or API key.
+type LinkQuery struct {
+	Host      string `json:"host,omitempty" url:"host,omitempty" form:"host"`
+	CreatedBy string `json:"created_by,omitempty" url:"created_by,omitempty" form:"created_by"`
+	Mine      bool   `json:"mine,omitempty" url:"mine,omitempty" form:"mine"`
+	After     string `json:"after,omitempty" url:"after,omitempty" form:"after"`
+	Before    string `json:"before,omitempty" url:"before,omitempty" form:"before"`
+}
+
+// LoginForm is used for Google to submit an id token back to the server or for users
+// of local accounts to submit their email address and password.
 type LoginForm struct {
 	Credential string `json:"credential" url:"credential" form:"credential"`
+	Email      string `json:"email,omitempty" url:"email,omitempty" form:"email"`
+	Password   string `json:"password,omitempty" url:"password,omitempty" form:"password"`
 	Next       string `json:"next" url:"next" form:"next"`
 }
 
@@ -78,6 +117,7 @@ type ShortURL struct {
 	Expires     *time.Time `json:"expires,omitempty"`
 	Created     *time.Time `json:"created,omitempty"`
 	Modified    *time.Time `json:"modified,omitempty"`
+	CreatedBy   string     `json:"created_by,omitempty"`
 	CampaignID  uint64     `json:"campaign_id,omitempty"`
 	Campaigns   []uint64   `json:"campaigns,omitempty"`
 }
@@ -87,6 +127,132 @@ type ShortURLList struct {
 	Page *PageQuery  `json:"page"`
 }
 
+// The maximum number of urls that can be shortened in a single batch request.
+const MaxBatchSize = 100
+
+// LongURLBatch is used to shorten several urls with a single request.
+type LongURLBatch struct {
+	URLs []*LongURL `json:"urls"`
+}
+
+// Statuses of the urls in a batch request.
+const (
+	BatchCreated  = "created"
+	BatchExisting = "existing"
+	BatchError    = "error"
+)
+
+// ShortURLBatch contains the result of each url in a batch request in the same order
+// as the urls in the request.
+type ShortURLBatch struct {
+	Results []*BatchResult `json:"results"`
+}
+
+// BatchResult describes the short url that was created or that already existed for a
+// url in a batch request, or the reason the url could not be shortened.
+type BatchResult struct {
+	Status string    `json:"status"`
+	Link   *ShortURL `json:"link,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+//===========================================================================
+// API Key Management Endpoints
+//===========================================================================
+
+// APIKey describes an API key without its secret. The client secret is only returned
+// when the key is created or rotated and cannot be retrieved again afterwards.
+type APIKey struct {
+	ClientID     string     `json:"client_id,omitempty"`
+	ClientSecret string     `json:"client_secret,omitempty"`
+	Name         string     `json:"name" form:"name"`
+	Scopes       []string   `json:"scopes,omitempty" form:"scopes"`
+	Owner        string     `json:"owner,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	Quota        *Quota     `json:"quota,omitempty"`
+	LastUsed     *time.Time `json:"last_used,omitempty"`
+	Revoked      *time.Time `json:"revoked,omitempty"`
+	Created      *time.Time `json:"created,omitempty"`
+	Modified     *time.Time `json:"modified,omitempty"`
+}
+
+// Quota limits the number of links that can be created with an API key; a zero value
+// for either limit means that the limit is not enforced.
+type Quota struct {
+	LinksPerDay uint64 `json:"links_per_day,omitempty"`
+	ActiveLinks uint64 `json:"active_links,omitempty"`
+}
+
+type APIKeyList struct {
+	APIKeys []*APIKey `json:"apikeys"`
+}
+
+// Token returns the bearer token used to authenticate with the key if the secret is
+// available (e.g. the key has just been created or rotated).
+func (k *APIKey) Token() string {
+	if k.ClientID == "" || k.ClientSecret == "" {
+		return ""
+	}
+	return k.ClientID + "-" + k.ClientSecret
+}
+
+//===========================================================================
+// Session Management Endpoints
+//===========================================================================
+
+// Session describes the active login of a web user. Sessions are identified by the ID
+// of the user's access and refresh tokens, which changes when the tokens are refreshed.
+type Session struct {
+	ID        string     `json:"id"`
+	Email     string     `json:"email"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	IPAddr    string     `json:"ip_addr,omitempty"`
+	Created   time.Time  `json:"created"`
+	Refreshed *time.Time `json:"refreshed,omitempty"`
+	Expires   time.Time  `json:"expires"`
+}
+
+type SessionList struct {
+	Sessions []*Session `json:"sessions"`
+}
+
+// SessionQuery filters the sessions returned by a list request to a single user.
+type SessionQuery struct {
+	Email string `json:"email,omitempty" url:"email,omitempty" form:"email"`
+}
+
+//===========================================================================
+// Audit Log Endpoints
+//===========================================================================
+
+// AuditEvent records who created, modified, or deleted a link or API key, or logged
+// in; the before and after fields summarize the target of the action.
+type AuditEvent struct {
+	ID        string    `json:"id"`
+	Time      time.Time `json:"time"`
+	Actor     string    `json:"actor"`
+	Action    string    `json:"action"`
+	Target    string    `json:"target,omitempty"`
+	IPAddr    string    `json:"ip_addr,omitempty"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	Before    string    `json:"before,omitempty"`
+	After     string    `json:"after,omitempty"`
+}
+
+// AuditLog returns audit events with the most recent events first.
+type AuditLog struct {
+	Events []*AuditEvent `json:"events"`
+}
+
+// AuditQuery filters the audit log by the actor that performed the action and by the
+// time range the action occurred in. At most limit events are returned.
+type AuditQuery struct {
+	Actor  string `json:"actor,omitempty" url:"actor,omitempty" form:"actor"`
+	After  string `json:"after,omitempty" url:"after,omitempty" form:"after"`
+	Before string `json:"before,omitempty" url:"before,omitempty" form:"before"`
+	Limit  int    `json:"limit,omitempty" url:"limit,omitempty" form:"limit"`
+}
+
 //===========================================================================
 // API Input Validation
 //===========================================================================
@@ -98,6 +264,99 @@ func (p *PageQuery) Validate() error {
 	return nil
 }
 
+func (q *LinkQuery) Validate() (err error) {
+	q.Host = strings.TrimSpace(q.Host)
+	q.CreatedBy = strings.TrimSpace(q.CreatedBy)
+	q.After = strings.TrimSpace(q.After)
+	q.Before = strings.TrimSpace(q.Before)
+
+	if q.Mine && q.CreatedBy != "" {
+		return ErrMineCreatedBy
+	}
+
+	var after, before time.Time
+	if after, before, err = q.Range(); err != nil {
+		return err
+	}
+
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return ErrInvalidRange
+	}
+	return nil
+}
+
+// Range parses the after and before timestamps of the query; if either is omitted a
+// zero valued timestamp is returned in its place.
+func (q *LinkQuery) Range() (after, before time.Time, err error) {
+	if q.After != "" {
+		if after, err = parseTimestamp(q.After); err != nil {
+			return after, before, ErrCannotParseRange
+		}
+	}
+
+	if q.Before != "" {
+		if before, err = parseTimestamp(q.Before); err != nil {
+			return after, before, ErrCannotParseRange
+		}
+	}
+
+	return after, before, nil
+}
+
+func (q *AuditQuery) Validate() (err error) {
+	q.Actor = strings.TrimSpace(q.Actor)
+	q.After = strings.TrimSpace(q.After)
+	q.Before = strings.TrimSpace(q.Before)
+
+	if q.Limit < 0 {
+		return ErrInvalidLimit
+	}
+
+	var after, before time.Time
+	if after, before, err = q.Range(); err != nil {
+		return err
+	}
+
+	if !after.IsZero() && !before.IsZero() && !after.Before(before) {
+		return ErrInvalidRange
+	}
+	return nil
+}
+
+// Range parses the after and before timestamps of the query; if either is omitted a
+// zero valued timestamp is returned in its place.
+func (q *AuditQuery) Range() (after, before time.Time, err error) {
+	if q.After != "" {
+		if after, err = parseTimestamp(q.After); err != nil {
+			return after, before, ErrCannotParseRange
+		}
+	}
+
+	if q.Before != "" {
+		if before, err = parseTimestamp(q.Before); err != nil {
+			return after, before, ErrCannotParseRange
+		}
+	}
+
+	return after, before, nil
+}
+
+func (k *APIKey) Validate() error {
+	k.Name = strings.TrimSpace(k.Name)
+	if k.Name == "" {
+		return ErrMissingName
+	}
+
+	if k.ClientSecret != "" || k.Owner != "" || k.LastUsed != nil || k.Revoked != nil {
+		return ErrReadOnlyField
+	}
+
+	if k.Expires != nil && !k.Expires.After(time.Now()) {
+		return ErrInvalidKeyExpires
+	}
+	return nil
+}
+
 func (u *LongURL) Validate() error {
 	u.URL = strings.TrimSpace(u.URL)
 	u.Expires = strings.TrimSpace(u.Expires)
@@ -120,6 +379,19 @@ func (u *LongURL) Validate() error {
 	return nil
 }
 
+// Validate the size of the batch; each of the urls is validated individually so that an
+// invalid url does not fail the entire batch.
+func (b *LongURLBatch) Validate() error {
+	if len(b.URLs) == 0 {
+		return ErrEmptyBatch
+	}
+
+	if len(b.URLs) > MaxBatchSize {
+		return ErrBatchTooLarge
+	}
+	return nil
+}
+
 var dateFormats = []string{
 	time.RFC3339,
 	"2006-01-02",
@@ -132,13 +404,20 @@ func (u *LongURL) ExpiresAt() (time.Time, error) {
 		return time.Time{}, nil
 	}
 
+	ts, err := parseTimestamp(u.Expires)
+	if err != nil {
+		return time.Time{}, ErrCannotParseExpires
+	}
+	return ts, nil
+}
+
+func parseTimestamp(s string) (time.Time, error) {
 	for _, layout := range dateFormats {
-		if ts, err := time.Parse(layout, u.Expires); err == nil {
+		if ts, err := time.Parse(layout, s); err == nil {
 			return ts, nil
 		}
 	}
-
-	return time.Time{}, ErrCannotParseExpires
+	return time.Time{}, ErrCannotParseTimestamp
 }
 
 func (u *ShortURL) InfoURL() string {
@@ -166,6 +445,14 @@ type ShortcrustInfo struct {
 	Campaigns uint64 `json:"campaigns"`
 }
 
+// Click is sent on the updates websocket when a short url is visited. The time is
+// truncated to the hour so that clicks can be aggregated into hourly counts.
+type Click struct {
+	Link  string    `json:"link"`
+	Time  time.Time `json:"time"`
+	Views uint64    `json:"views"`
+}
+
 func (s *ShortcrustInfo) CampaignsPerLink() float64 {
 	if s.Campaigns == 0 {
 		return 0.0
@@ -14,14 +14,33 @@ var (
 )
 
 var (
-	ErrMissingURL         = errors.New("a url is required for shortening")
-	ErrCannotParseExpires = errors.New("expires must be a timestamp in the form of YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
-	ErrInvalidExpires     = errors.New("expiration must be valid timestamp in the future")
-	ErrParseBearer        = errors.New("could not parse Bearer token from Authorization header")
-	ErrNoAuthorization    = errors.New("no authorization header in request")
-	ErrInvalidToken       = errors.New("invalid bearer token in Authorization header")
-	ErrUnauthenticated    = errors.New("this endpoint requires authentication")
-	ErrForwardsBackwards  = errors.New("cannot specify both prev and next page token in page query")
+	ErrMissingURL           = errors.New("a url is required for shortening")
+	ErrCannotParseExpires   = errors.New("expires must be a timestamp in the form of YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+	ErrInvalidExpires       = errors.New("expiration must be valid timestamp in the future")
+	ErrParseBearer          = errors.New("could not parse Bearer token from Authorization header")
+	ErrNoAuthorization      = errors.New("no authorization header in request")
+	ErrInvalidToken         = errors.New("invalid bearer token in Authorization header")
+	ErrUnauthenticated      = errors.New("this endpoint requires authentication")
+	ErrForbidden            = errors.New("this endpoint requires a permission that has not been granted")
+	ErrCSRFVerification     = errors.New("csrf token is missing or does not match")
+	ErrForwardsBackwards    = errors.New("cannot specify both prev and next page token in page query")
+	ErrCannotParseTimestamp = errors.New("could not parse timestamp")
+	ErrCannotParseRange     = errors.New("after and before must be timestamps in the form of YYYY-MM-DD or YYYY-MM-DD HH:MM:SS")
+	ErrInvalidRange         = errors.New("after must be earlier than before in link query")
+	ErrMineCreatedBy        = errors.New("cannot specify both mine and created by in link query")
+	ErrNotOwner             = errors.New("only the creator of the link or an admin can modify it")
+	ErrMissingName          = errors.New("a name is required for the api key")
+	ErrReadOnlyField        = errors.New("cannot set read-only fields on the api key")
+	ErrInvalidKeyExpires    = errors.New("api key expiration must be in the future")
+	ErrQuotaExceeded        = errors.New("the quota for this api key has been exceeded")
+	ErrRateLimited          = errors.New("too many requests, please try again later")
+	ErrMissingEmail         = errors.New("an email address is required")
+	ErrInvalidCredentials   = errors.New("invalid email address or password")
+	ErrLocalAccounts        = errors.New("login with an email address and password is not enabled")
+	ErrWebLoginRequired     = errors.New("personal access tokens can only be managed by logged in users")
+	ErrInvalidLimit         = errors.New("limit must not be negative")
+	ErrEmptyBatch           = errors.New("at least one url is required for batch shortening")
+	ErrBatchTooLarge        = fmt.Errorf("at most %d urls can be shortened in a batch", MaxBatchSize)
 )
 
 // Construct a new response for an error or simply return unsuccessful.
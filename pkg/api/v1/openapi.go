@@ -0,0 +1,14 @@
+package api
+
+import _ "embed"
+
+// The OpenAPI specification of the v1 API; every route must be described so that the
+// specification does not drift from the server (see the routes test in pkg/rtnl).
+//
+//go:embed openapi.json
+var openapi []byte
+
+// OpenAPI returns the OpenAPI 3 specification of the v1 API as JSON.
+func OpenAPI() []byte {
+	return openapi
+}
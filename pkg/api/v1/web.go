@@ -28,21 +28,67 @@ func Prepare(conf config.Config) {
 			GoogleClientID: conf.Auth.GoogleClientID,
 			LoginURI:       loginURI.String(),
 		}
+
+		loginData.LocalAccounts = conf.Auth.LocalAccounts
+		if conf.Auth.OIDC.Enabled {
+			loginData.OIDCName = conf.Auth.OIDC.Name
+			loginData.OIDCLoginURI = "/login/oidc"
+		}
 	})
 }
 
 type WebData struct {
 	Version string
+	User    *Principal
 }
 
 func GetWebData() WebData {
 	return webData
 }
 
+// WithUser returns a copy of the web data with the principal of the current request so
+// that templates can display who is logged in.
+func (d WebData) WithUser(user *Principal) WebData {
+	d.User = user
+	return d
+}
+
+// Principal describes the web user or API key that authenticated the current request.
+// The ID is the identity that is recorded as the creator of links: the email address of
+// web users or the client ID of API keys; personal access tokens are identified by the
+// email address of their owner.
+type Principal struct {
+	ID       string   `json:"id"`
+	Subject  string   `json:"subject,omitempty"`
+	Name     string   `json:"name,omitempty"`
+	Email    string   `json:"email,omitempty"`
+	Picture  string   `json:"picture,omitempty"`
+	Role     string   `json:"role,omitempty"`
+	ClientID string   `json:"client_id,omitempty"`
+	Scopes   []string `json:"scopes,omitempty"`
+}
+
+// DisplayName returns the name of the user if available, otherwise their ID.
+func (p *Principal) DisplayName() string {
+	if p.Name != "" {
+		return p.Name
+	}
+	return p.ID
+}
+
+// IsAPIKey returns true if the principal authenticated with an API key.
+func (p *Principal) IsAPIKey() bool {
+	return p.ClientID != ""
+}
+
 type LoginData struct {
 	WebData
 	GoogleClientID string
 	LoginURI       string
+	OIDCName       string
+	OIDCLoginURI   string
+	LocalAccounts  bool
+	Next           string
 }
 
 func GetLoginData() LoginData {
@@ -12,6 +12,7 @@ type Claims struct {
 	Email   string `json:"email,omitempty"`
 	Picture string `json:"picture,omitempty"`
 	Locale  string `json:"locale,omitempty"`
+	Role    string `json:"role,omitempty"`
 }
 
 // Used to extract expiration and not before timestamps without having to use public keys
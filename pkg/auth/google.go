@@ -2,34 +2,46 @@ package auth
 
 import (
 	"context"
-	"fmt"
+	"errors"
 
 	"github.com/golang-jwt/jwt/v4"
 	"google.golang.org/api/idtoken"
 )
 
+var ErrGoogleDisabled = errors.New("google sign in is not enabled")
+
+// CheckGoogleIDToken validates the credential returned by Google sign in and creates
+// claims for the user if they are allowed to log in by the login policy.
 func (tm *TokenManager) CheckGoogleIDToken(ctx context.Context, credential string) (claims *Claims, err error) {
+	if tm.conf.GoogleClientID == "" {
+		return nil, ErrGoogleDisabled
+	}
+
 	var payload *idtoken.Payload
 	if payload, err = tm.gValidator.Validate(ctx, credential, tm.conf.GoogleClientID); err != nil {
 		return nil, err
 	}
 
-	if hd := payload.Claims["hd"].(string); hd != tm.conf.HDClaim {
-		return nil, fmt.Errorf("%s is not an authorized domain", hd)
+	// Personal accounts do not have a hosted domain so missing claims must be checked
+	hd, _ := payload.Claims["hd"].(string)
+	email, _ := payload.Claims["email"].(string)
+	if verified, ok := payload.Claims["email_verified"].(bool); ok && !verified {
+		return nil, ErrUnverifiedEmail
+	}
+
+	if err = tm.policy.Authorize(email, hd); err != nil {
+		return nil, err
 	}
 
 	claims = &Claims{
 		RegisteredClaims: jwt.RegisteredClaims{
 			Subject: payload.Subject,
 		},
-		Name:    payload.Claims["name"].(string),
-		Email:   payload.Claims["email"].(string),
-		Picture: payload.Claims["picture"].(string),
-	}
-
-	if payload.Claims["locale"] != nil {
-		claims.Locale = payload.Claims["locale"].(string)
+		Email: email,
 	}
 
+	claims.Name, _ = payload.Claims["name"].(string)
+	claims.Picture, _ = payload.Claims["picture"].(string)
+	claims.Locale, _ = payload.Claims["locale"].(string)
 	return claims, nil
 }
@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/oklog/ulid/v2"
+)
+
+const (
+	// The size of the RSA signing keys that are generated.
+	KeySize = 4096
+
+	// The extension of PEM encoded key files in the keys directory.
+	keyExt = ".pem"
+)
+
+var ErrNoSigningKeys = errors.New("no signing keys are configured")
+
+// JWKS is the json web key set of the public keys used to verify rtnl tokens, so that
+// other services can verify the tokens without sharing the private keys.
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWK is an RSA public key in a json web key set.
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use,omitempty"`
+	Algorithm string `json:"alg,omitempty"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+// NewJWK creates a json web key for verifying signatures with the RSA public key.
+func NewJWK(kid string, key *rsa.PublicKey) JWK {
+	return JWK{
+		KeyType:   "RSA",
+		KeyID:     kid,
+		Use:       "sig",
+		Algorithm: signingMethod.Alg(),
+		N:         base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}
+}
+
+// PublicKey parses the RSA public key from the json web key.
+func (k JWK) PublicKey() (_ *rsa.PublicKey, err error) {
+	var n, e []byte
+	if n, err = base64.RawURLEncoding.DecodeString(k.N); err != nil {
+		return nil, err
+	}
+
+	if e, err = base64.RawURLEncoding.DecodeString(k.E); err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+}
+
+// JWKS returns the public keys that are currently used to verify tokens sorted by key
+// ID so that the most recent signing key is last.
+func (tm *TokenManager) JWKS() *JWKS {
+	tm.RLock()
+	defer tm.RUnlock()
+
+	jwks := &JWKS{Keys: make([]JWK, 0, len(tm.keys))}
+	for kid, key := range tm.keys {
+		jwks.Keys = append(jwks.Keys, NewJWK(kid.String(), key))
+	}
+
+	sort.Slice(jwks.Keys, func(i, j int) bool { return jwks.Keys[i].KeyID < jwks.Keys[j].KeyID })
+	return jwks
+}
+
+// Reload the keys from the paths in the configuration and the PEM files in the keys
+// directory. All of the keys are used to verify tokens and the most recent key by ULID
+// that has been loaded for longer than the signing delay becomes the signing key, so
+// that other instances and services have time to fetch a new key before tokens signed
+// by it are issued. A new key is rotated in by adding it to the keys directory and an
+// old key is retired by removing it. If the keys cannot be loaded the current keys are
+// not modified.
+func (tm *TokenManager) Reload() (err error) {
+	keys := make(map[ulid.ULID]*rsa.PrivateKey)
+	for kid, path := range tm.conf.Keys {
+		if err = loadKey(keys, kid, path); err != nil {
+			return err
+		}
+	}
+
+	if tm.conf.KeysDir != "" {
+		var paths []string
+		if paths, err = filepath.Glob(filepath.Join(tm.conf.KeysDir, "*"+keyExt)); err != nil {
+			return fmt.Errorf("could not list keys in %s: %w", tm.conf.KeysDir, err)
+		}
+
+		for _, path := range paths {
+			kid := strings.TrimSuffix(filepath.Base(path), keyExt)
+			if err = loadKey(keys, kid, path); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(keys) == 0 {
+		return ErrNoSigningKeys
+	}
+
+	tm.Lock()
+	defer tm.Unlock()
+
+	// Record when each key can be used to sign tokens. Keys loaded when the token
+	// manager is created are used immediately since there is no previous signing key.
+	now := time.Now()
+	activates := make(map[ulid.ULID]time.Time, len(keys))
+	for keyID := range keys {
+		ts, ok := tm.activates[keyID]
+		if !ok {
+			ts = now
+			if tm.currentKey != nil {
+				ts = now.Add(tm.conf.KeysSignDelay)
+			}
+		}
+		activates[keyID] = ts
+	}
+
+	signingKey, signingKeyID := latestKey(keys, func(keyID ulid.ULID) bool {
+		return !activates[keyID].After(now)
+	})
+
+	// If none of the keys can be used to sign yet, keep signing with the ephemeral key;
+	// otherwise the previous signing key was removed so the latest key must be used.
+	if signingKey == nil {
+		if tm.ephemeral != nil && tm.currentKeyID == tm.ephemeral.id {
+			signingKey, signingKeyID = tm.currentKey, tm.currentKeyID
+		} else {
+			signingKey, signingKeyID = latestKey(keys, func(ulid.ULID) bool { return true })
+		}
+	}
+
+	tm.keys = make(map[ulid.ULID]*rsa.PublicKey, len(keys)+1)
+	for keyID, key := range keys {
+		tm.keys[keyID] = &key.PublicKey
+	}
+
+	// The ephemeral key is kept to verify the tokens that it signed until they expire.
+	if tm.ephemeral != nil {
+		if signingKeyID != tm.ephemeral.id && tm.ephemeral.retires.IsZero() {
+			tm.ephemeral.retires = now.Add(tm.maxTokenDuration())
+		}
+
+		if tm.ephemeral.retires.IsZero() || now.Before(tm.ephemeral.retires) {
+			tm.keys[tm.ephemeral.id] = tm.ephemeral.key
+		} else {
+			tm.ephemeral = nil
+		}
+	}
+
+	tm.activates = activates
+	tm.currentKey, tm.currentKeyID = signingKey, signingKeyID
+	return nil
+}
+
+// An ephemeral key is generated when no signing keys are configured. It is retired
+// once a configured key becomes the signing key and the tokens it signed have expired.
+type ephemeralKey struct {
+	id      ulid.ULID
+	key     *rsa.PublicKey
+	retires time.Time
+}
+
+// Returns the most recent key by ULID that matches the filter or nil if none match.
+func latestKey(keys map[ulid.ULID]*rsa.PrivateKey, filter func(ulid.ULID) bool) (latest *rsa.PrivateKey, latestID ulid.ULID) {
+	for keyID, key := range keys {
+		if !filter(keyID) {
+			continue
+		}
+
+		if latest == nil || keyID.Compare(latestID) > 0 {
+			latest, latestID = key, keyID
+		}
+	}
+	return latest, latestID
+}
+
+// Returns the longest amount of time that a token signed by the manager is valid.
+func (tm *TokenManager) maxTokenDuration() time.Duration {
+	if tm.conf.RefreshDuration > tm.conf.AccessDuration {
+		return tm.conf.RefreshDuration
+	}
+	return tm.conf.AccessDuration
+}
+
+func loadKey(keys map[ulid.ULID]*rsa.PrivateKey, kid, path string) (err error) {
+	// Parse the key id
+	var keyID ulid.ULID
+	if keyID, err = ulid.Parse(kid); err != nil {
+		return fmt.Errorf("could not parse kid %q for path %s: %s", kid, path, err)
+	}
+
+	// Load the keys from disk
+	var data []byte
+	if data, err = os.ReadFile(path); err != nil {
+		return fmt.Errorf("could not read kid %s from %s: %s", kid, path, err)
+	}
+
+	var key *rsa.PrivateKey
+	if key, err = jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
+		return fmt.Errorf("could not parse RSA private key kid %s from %s: %s", kid, path, err)
+	}
+
+	keys[keyID] = key
+	return nil
+}
+
+// GenerateKey creates a new RSA signing key and writes it as a PEM encoded file named
+// by a new ULID to the directory, returning the path of the key. Because the ULID of the
+// key is the most recent, the key becomes the signing key once it has been reloaded and
+// the signing delay has passed.
+func GenerateKey(dir string) (path string, err error) {
+	var key *rsa.PrivateKey
+	if key, err = rsa.GenerateKey(rand.Reader, KeySize); err != nil {
+		return "", err
+	}
+
+	if err = os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	path = filepath.Join(dir, ulid.Make().String()+keyExt)
+	if err = os.WriteFile(path, data, 0600); err != nil {
+		return "", err
+	}
+	return path, nil
+}
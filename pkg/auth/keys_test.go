@@ -0,0 +1,174 @@
+package auth_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	oldKeyID = "01GE6191AQTGMCJ9BN0QC3CCVG"
+	newKeyID = "01GE62EXXR0X0561XD53RDFBQJ"
+)
+
+func TestReloadKeys(t *testing.T) {
+	dir := t.TempDir()
+	copyKey(t, oldKeyID, dir)
+
+	tm, err := auth.New(config.AuthConfig{
+		KeysDir:         dir,
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  1 * time.Hour,
+		RefreshDuration: 2 * time.Hour,
+		RefreshOverlap:  -15 * time.Minute,
+	})
+	require.NoError(t, err, "could not create token manager from keys dir")
+	require.Equal(t, ulid.MustParse(oldKeyID), tm.CurrentKey())
+
+	atks, _, err := tm.CreateTokenPair(&auth.Claims{Email: "jdoe@example.com"})
+	require.NoError(t, err, "could not create tokens with the old key")
+
+	// Adding a more recent key should make it the signing key while the old key can
+	// still be used to verify tokens.
+	copyKey(t, newKeyID, dir)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, ulid.MustParse(newKeyID), tm.CurrentKey())
+
+	_, err = tm.Verify(atks)
+	require.NoError(t, err, "tokens signed by the old key should still be verified")
+
+	jwks := tm.JWKS()
+	require.Len(t, jwks.Keys, 2)
+	require.Equal(t, oldKeyID, jwks.Keys[0].KeyID)
+	require.Equal(t, newKeyID, jwks.Keys[1].KeyID)
+
+	// Removing the old key retires it so its tokens can no longer be verified
+	require.NoError(t, os.Remove(filepath.Join(dir, oldKeyID+".pem")))
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Len(t, tm.JWKS().Keys, 1)
+
+	_, err = tm.Verify(atks)
+	require.Error(t, err, "tokens signed by a retired key should not be verified")
+
+	// If the keys cannot be loaded the current keys should not be modified
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notaulid.pem"), []byte("foo"), 0600))
+	require.Error(t, tm.Reload(), "expected invalid key name to fail")
+	require.NoError(t, os.Remove(filepath.Join(dir, "notaulid.pem")))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, newKeyID+".pem")))
+	require.ErrorIs(t, tm.Reload(), auth.ErrNoSigningKeys)
+	require.Equal(t, ulid.MustParse(newKeyID), tm.CurrentKey())
+	require.Len(t, tm.JWKS().Keys, 1)
+
+	// A generated key should become the signing key since it is the most recent key
+	path, err := auth.GenerateKey(dir)
+	require.NoError(t, err, "could not generate key")
+	require.NoError(t, tm.Reload(), "could not reload generated key")
+	require.Equal(t, filepath.Base(path), tm.CurrentKey().String()+".pem")
+}
+
+func TestSignDelay(t *testing.T) {
+	dir := t.TempDir()
+	copyKey(t, oldKeyID, dir)
+
+	tm, err := auth.New(config.AuthConfig{
+		KeysDir:         dir,
+		KeysSignDelay:   200 * time.Millisecond,
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  1 * time.Hour,
+		RefreshDuration: 2 * time.Hour,
+		RefreshOverlap:  -15 * time.Minute,
+	})
+	require.NoError(t, err, "could not create token manager from keys dir")
+	require.Equal(t, ulid.MustParse(oldKeyID), tm.CurrentKey(), "keys loaded on startup should be used immediately")
+
+	// A new key should be published for verification before it is used for signing
+	copyKey(t, newKeyID, dir)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, ulid.MustParse(oldKeyID), tm.CurrentKey())
+	require.Len(t, tm.JWKS().Keys, 2)
+
+	time.Sleep(250 * time.Millisecond)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, ulid.MustParse(newKeyID), tm.CurrentKey())
+
+	// If no loaded key is active the latest key is used since the previous signing key
+	// was retired by removing it.
+	require.NoError(t, os.Remove(filepath.Join(dir, oldKeyID+".pem")))
+	require.NoError(t, os.Remove(filepath.Join(dir, newKeyID+".pem")))
+	path, err := auth.GenerateKey(dir)
+	require.NoError(t, err, "could not generate key")
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, filepath.Base(path), tm.CurrentKey().String()+".pem")
+}
+
+func TestEphemeralKey(t *testing.T) {
+	dir := t.TempDir()
+	tm, err := auth.New(config.AuthConfig{
+		KeysDir:         dir,
+		KeysSignDelay:   200 * time.Millisecond,
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  500 * time.Millisecond,
+		RefreshDuration: 1 * time.Second,
+		RefreshOverlap:  -250 * time.Millisecond,
+	})
+	require.NoError(t, err, "could not create token manager with a generated key")
+	ephemeral := tm.CurrentKey()
+
+	_, rtks, err := tm.CreateTokenPair(&auth.Claims{Email: "jdoe@example.com"})
+	require.NoError(t, err, "could not create tokens with the ephemeral key")
+
+	// The ephemeral key signs tokens until the first key in the keys dir is active
+	copyKey(t, oldKeyID, dir)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, ephemeral, tm.CurrentKey())
+	require.Len(t, tm.JWKS().Keys, 2)
+
+	time.Sleep(250 * time.Millisecond)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Equal(t, ulid.MustParse(oldKeyID), tm.CurrentKey())
+
+	// The ephemeral key is kept to verify its tokens until they expire
+	require.Len(t, tm.JWKS().Keys, 2)
+	_, err = tm.Parse(rtks)
+	require.NoError(t, err, "tokens signed by the ephemeral key should still be verified")
+
+	time.Sleep(1100 * time.Millisecond)
+	require.NoError(t, tm.Reload(), "could not reload keys")
+	require.Len(t, tm.JWKS().Keys, 1)
+	require.Equal(t, oldKeyID, tm.JWKS().Keys[0].KeyID)
+}
+
+func TestJWK(t *testing.T) {
+	tm, err := auth.New(config.AuthConfig{
+		Keys:     map[string]string{newKeyID: "testdata/" + newKeyID + ".pem"},
+		Audience: audience,
+		Issuer:   issuer,
+	})
+	require.NoError(t, err, "could not create token manager")
+
+	jwks := tm.JWKS()
+	require.Len(t, jwks.Keys, 1)
+	require.Equal(t, "RSA", jwks.Keys[0].KeyType)
+	require.Equal(t, "RS256", jwks.Keys[0].Algorithm)
+	require.Equal(t, "sig", jwks.Keys[0].Use)
+
+	key, err := jwks.Keys[0].PublicKey()
+	require.NoError(t, err, "could not parse public key from jwk")
+	require.Equal(t, jwks.Keys[0], auth.NewJWK(newKeyID, key))
+}
+
+func copyKey(t *testing.T, kid, dir string) {
+	data, err := os.ReadFile(filepath.Join("testdata", kid+".pem"))
+	require.NoError(t, err, "could not read key from testdata")
+	require.NoError(t, os.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600), "could not copy key")
+}
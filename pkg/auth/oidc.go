@@ -0,0 +1,333 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"golang.org/x/oauth2"
+)
+
+// The path that openid connect providers serve their discovery document at.
+const discoveryPath = "/.well-known/openid-configuration"
+
+var (
+	ErrOIDCDisabled      = errors.New("openid connect login is not enabled")
+	ErrMissingIDToken    = errors.New("no id token returned by the openid connect provider")
+	ErrInvalidNonce      = errors.New("id token nonce does not match the login request")
+	ErrMissingEmail      = errors.New("id token does not contain an email address")
+	ErrUnverifiedEmail   = errors.New("email address has not been verified by the provider")
+	ErrUnknownSigningKey = errors.New("id token was signed with an unknown key")
+)
+
+// OIDC logs users in with a generic OpenID Connect provider using the authorization
+// code flow with PKCE. The endpoints of the provider are discovered from its issuer
+// the first time they are needed and id tokens are verified with the RSA keys that
+// the provider publishes at its jwks uri.
+type OIDC struct {
+	sync.RWMutex
+	conf     config.OIDCConfig
+	client   *http.Client
+	oauth    *oauth2.Config
+	provider *discovery
+	keys     map[string]*rsa.PublicKey
+}
+
+// The subset of the provider's discovery document that is required for login.
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// NewOIDC creates an OpenID Connect login provider; the provider is not contacted
+// until a user attempts to log in.
+func NewOIDC(conf config.OIDCConfig) *OIDC {
+	return &OIDC{
+		conf:   conf,
+		client: &http.Client{Timeout: 30 * time.Second},
+		keys:   make(map[string]*rsa.PublicKey),
+	}
+}
+
+// AuthCodeURL returns the url of the provider to redirect the user to in order to log
+// in. The state and nonce must be checked when the user is redirected back and the
+// verifier must be used to exchange the authorization code (see NewOIDCLogin).
+func (o *OIDC) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (_ string, err error) {
+	var conf *oauth2.Config
+	if conf, err = o.config(ctx); err != nil {
+		return "", err
+	}
+	return conf.AuthCodeURL(state, oauth2.S256ChallengeOption(verifier), oauth2.SetAuthURLParam("nonce", nonce)), nil
+}
+
+// Exchange the authorization code for an id token using the PKCE verifier, verify the
+// id token and its nonce, and create claims from the mapped id token claims along with
+// the organization domain that the provider asserts the user belongs to.
+func (o *OIDC) Exchange(ctx context.Context, code, nonce, verifier string) (claims *Claims, domain string, err error) {
+	var conf *oauth2.Config
+	if conf, err = o.config(ctx); err != nil {
+		return nil, "", err
+	}
+
+	var token *oauth2.Token
+	if token, err = conf.Exchange(context.WithValue(ctx, oauth2.HTTPClient, o.client), code, oauth2.VerifierOption(verifier)); err != nil {
+		return nil, "", fmt.Errorf("could not exchange authorization code: %w", err)
+	}
+
+	idToken, _ := token.Extra("id_token").(string)
+	if idToken == "" {
+		return nil, "", ErrMissingIDToken
+	}
+
+	return o.Verify(ctx, idToken, nonce)
+}
+
+// Verify the signature, issuer, audience, expiration, and nonce of the id token and
+// return claims that are mapped from the id token using the configured claim names.
+// The domain is read from the configured domain claim or mapped from the tenant of the
+// user; it is empty if the provider does not assert an organization for the user.
+func (o *OIDC) Verify(ctx context.Context, idToken, nonce string) (claims *Claims, domain string, err error) {
+	if _, err = o.config(ctx); err != nil {
+		return nil, "", err
+	}
+
+	payload := jwt.MapClaims{}
+	parser := &jwt.Parser{ValidMethods: []string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodRS384.Alg(), jwt.SigningMethodRS512.Alg()}}
+	if _, err = parser.ParseWithClaims(idToken, payload, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return o.key(ctx, kid)
+	}); err != nil {
+		return nil, "", err
+	}
+
+	if !payload.VerifyIssuer(o.provider.Issuer, true) {
+		return nil, "", fmt.Errorf("invalid issuer %q", payload["iss"])
+	}
+
+	if !payload.VerifyAudience(o.conf.ClientID, true) {
+		return nil, "", fmt.Errorf("invalid audience %q", payload["aud"])
+	}
+
+	if tokenNonce, _ := payload["nonce"].(string); tokenNonce != nonce {
+		return nil, "", ErrInvalidNonce
+	}
+
+	// Providers that do not verify email addresses must not be trusted with them
+	if verified, _ := payload["email_verified"].(bool); !verified {
+		return nil, "", ErrUnverifiedEmail
+	}
+
+	claims = &Claims{}
+	claims.Subject, _ = payload["sub"].(string)
+	claims.Email, _ = payload[o.conf.EmailClaim].(string)
+	claims.Name, _ = payload[o.conf.NameClaim].(string)
+	claims.Picture, _ = payload[o.conf.PictureClaim].(string)
+	claims.Locale, _ = payload["locale"].(string)
+
+	if claims.Email == "" {
+		return nil, "", ErrMissingEmail
+	}
+
+	if o.conf.DomainClaim != "" {
+		domain, _ = payload[o.conf.DomainClaim].(string)
+	} else if tenant, _ := payload[o.conf.TenantClaim].(string); tenant != "" {
+		domain = o.conf.Tenants[tenant]
+	}
+	return claims, domain, nil
+}
+
+// Returns the oauth2 config for the provider, discovering the provider if necessary.
+func (o *OIDC) config(ctx context.Context) (_ *oauth2.Config, err error) {
+	o.RLock()
+	conf := o.oauth
+	o.RUnlock()
+
+	if conf != nil {
+		return conf, nil
+	}
+
+	o.Lock()
+	defer o.Unlock()
+	if o.oauth != nil {
+		return o.oauth, nil
+	}
+
+	provider := &discovery{}
+	if err = o.get(ctx, strings.TrimSuffix(o.conf.Issuer, "/")+discoveryPath, provider); err != nil {
+		return nil, fmt.Errorf("could not discover openid connect provider: %w", err)
+	}
+
+	// The issuer must match the discovery document to prevent token substitution
+	if strings.TrimSuffix(provider.Issuer, "/") != strings.TrimSuffix(o.conf.Issuer, "/") {
+		return nil, fmt.Errorf("discovered issuer %q does not match configured issuer %q", provider.Issuer, o.conf.Issuer)
+	}
+
+	o.provider = provider
+	o.oauth = &oauth2.Config{
+		ClientID:     o.conf.ClientID,
+		ClientSecret: o.conf.ClientSecret,
+		RedirectURL:  o.conf.RedirectURL,
+		Scopes:       o.conf.Scopes,
+		Endpoint: oauth2.Endpoint{
+			AuthURL:  provider.AuthorizationEndpoint,
+			TokenURL: provider.TokenEndpoint,
+		},
+	}
+	return o.oauth, nil
+}
+
+// Returns the public key with the specified id, fetching the provider's keys if the
+// key is not known (e.g. because the provider has rotated its keys).
+func (o *OIDC) key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	o.RLock()
+	key, ok := o.keys[kid]
+	o.RUnlock()
+
+	if ok {
+		return key, nil
+	}
+
+	set := &JWKS{}
+	if err := o.get(ctx, o.provider.JWKSURI, set); err != nil {
+		return nil, fmt.Errorf("could not fetch openid connect provider keys: %w", err)
+	}
+
+	// Only RSA signing keys are used to verify id tokens
+	keys := make(map[string]*rsa.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		if jwk.KeyType != "RSA" || (jwk.Use != "" && jwk.Use != "sig") {
+			continue
+		}
+
+		if key, err := jwk.PublicKey(); err == nil {
+			keys[jwk.KeyID] = key
+		}
+	}
+
+	o.Lock()
+	o.keys = keys
+	o.Unlock()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, ErrUnknownSigningKey
+	}
+	return key, nil
+}
+
+func (o *OIDC) get(ctx context.Context, url string, v interface{}) (err error) {
+	var req *http.Request
+	if req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil); err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var rep *http.Response
+	if rep, err = o.client.Do(req); err != nil {
+		return err
+	}
+	defer rep.Body.Close()
+
+	if rep.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, rep.Status)
+	}
+	return json.NewDecoder(rep.Body).Decode(v)
+}
+
+// OIDCEnabled returns true if users can log in with an openid connect provider.
+func (tm *TokenManager) OIDCEnabled() bool {
+	return tm.oidc != nil
+}
+
+// OIDCAuthCodeURL returns the url to redirect the user to in order to log in with the
+// openid connect provider.
+func (tm *TokenManager) OIDCAuthCodeURL(ctx context.Context, login *OIDCLogin) (string, error) {
+	if tm.oidc == nil {
+		return "", ErrOIDCDisabled
+	}
+	return tm.oidc.AuthCodeURL(ctx, login.State, login.Nonce, login.Verifier)
+}
+
+// CheckOIDCCode exchanges the authorization code returned by the openid connect
+// provider for claims, ensuring that the user is allowed to log in by the login policy.
+func (tm *TokenManager) CheckOIDCCode(ctx context.Context, code string, login *OIDCLogin) (claims *Claims, err error) {
+	if tm.oidc == nil {
+		return nil, ErrOIDCDisabled
+	}
+
+	var domain string
+	if claims, domain, err = tm.oidc.Exchange(ctx, code, login.Nonce, login.Verifier); err != nil {
+		return nil, err
+	}
+
+	if err = tm.policy.Authorize(claims.Email, domain); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// OIDCLogin holds the random values that bind the authorization request to the user's
+// browser: the state prevents cross-site request forgery, the nonce prevents id token
+// replay, and the verifier proves that the code is exchanged by the same client. Next
+// is the page the user is redirected to after they log in.
+type OIDCLogin struct {
+	State    string
+	Nonce    string
+	Verifier string
+	Next     string
+}
+
+// NewOIDCLogin generates the random values for a new login request.
+func NewOIDCLogin() *OIDCLogin {
+	return &OIDCLogin{
+		State:    random(),
+		Nonce:    random(),
+		Verifier: oauth2.GenerateVerifier(),
+	}
+}
+
+// Encode the login so it can be stored in a cookie while the user logs in.
+func (l *OIDCLogin) Encode() string {
+	parts := []string{l.State, l.Nonce, l.Verifier}
+	if l.Next != "" {
+		parts = append(parts, base64.RawURLEncoding.EncodeToString([]byte(l.Next)))
+	}
+	return strings.Join(parts, ".")
+}
+
+// DecodeOIDCLogin parses a login that was encoded with Encode.
+func DecodeOIDCLogin(s string) (*OIDCLogin, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 3 || len(parts) > 4 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, errors.New("could not decode openid connect login")
+	}
+
+	login := &OIDCLogin{State: parts[0], Nonce: parts[1], Verifier: parts[2]}
+	if len(parts) == 4 {
+		next, err := base64.RawURLEncoding.DecodeString(parts[3])
+		if err != nil || len(next) == 0 {
+			return nil, errors.New("could not decode openid connect login")
+		}
+		login.Next = string(next)
+	}
+	return login, nil
+}
+
+func random() string {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(data)
+}
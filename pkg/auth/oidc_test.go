@@ -0,0 +1,357 @@
+package auth_test
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	jwt "github.com/golang-jwt/jwt/v4"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/oauth2"
+)
+
+const (
+	oidcClientID     = "rtnl-test"
+	oidcClientSecret = "supersecret"
+	oidcRedirectURL  = "http://localhost:8765/login/oidc/callback"
+	oidcKeyID        = "01GE6191AQTGMCJ9BN0QC3CCVG"
+)
+
+func TestOIDC(t *testing.T) {
+	provider := newMockOIDC(t)
+	tm := newOIDCTokenManager(t, provider.URL(), "example.com")
+	require.True(t, tm.OIDCEnabled())
+
+	// A successful login should create claims from the mapped id token claims
+	login := auth.NewOIDCLogin()
+	code := provider.Authorize(t, tm, login)
+	claims, err := tm.CheckOIDCCode(context.Background(), code, login)
+	require.NoError(t, err, "could not exchange authorization code")
+	require.Equal(t, "1234", claims.Subject)
+	require.Equal(t, "jdoe@example.com", claims.Email)
+	require.Equal(t, "Jane Doe", claims.Name)
+	require.Equal(t, "https://example.com/jdoe.png", claims.Picture)
+
+	// The code cannot be exchanged with a different verifier
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, &auth.OIDCLogin{State: login.State, Nonce: login.Nonce, Verifier: oauth2.GenerateVerifier()})
+	require.Error(t, err, "expected exchange with the wrong verifier to fail")
+
+	// The id token must contain the nonce of the login request
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, &auth.OIDCLogin{State: login.State, Nonce: "replayed", Verifier: login.Verifier})
+	require.ErrorIs(t, err, auth.ErrInvalidNonce)
+
+	// Users must have a verified email address
+	provider.Set("email_verified", false)
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.ErrorIs(t, err, auth.ErrUnverifiedEmail)
+
+	// Email addresses are not trusted unless the provider asserts they are verified
+	provider.Delete("email_verified")
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.ErrorIs(t, err, auth.ErrUnverifiedEmail)
+	provider.Set("email_verified", true)
+
+	// Users must belong to the authorized domain
+	provider.Set("hd", "example.org")
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.ErrorIs(t, err, auth.ErrUnauthorizedDomain)
+
+	// The domain of the email address is not trusted without the domain claim
+	provider.Delete("hd")
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.ErrorIs(t, err, auth.ErrUnauthorizedDomain)
+
+	// The id token must be issued for this client
+	provider.Set("hd", "example.com")
+	provider.Set("aud", "another-client")
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.Error(t, err, "expected an id token for another client to be rejected")
+}
+
+func TestOIDCTenants(t *testing.T) {
+	provider := newMockOIDC(t)
+	provider.Delete("hd")
+	provider.Set("tid", "9188040d-6c67-4c5b-b112-36a304b66dad")
+
+	conf := oidcConfig(provider.URL(), "example.com")
+	conf.OIDC.DomainClaim = ""
+	conf.OIDC.TenantClaim = "tid"
+	conf.OIDC.Tenants = map[string]string{"9188040d-6c67-4c5b-b112-36a304b66dad": "example.com"}
+
+	tm, err := auth.New(conf)
+	require.NoError(t, err, "could not create token manager")
+
+	// The domain of the user is mapped from their tenant
+	login := auth.NewOIDCLogin()
+	code := provider.Authorize(t, tm, login)
+	claims, err := tm.CheckOIDCCode(context.Background(), code, login)
+	require.NoError(t, err, "could not exchange authorization code")
+	require.Equal(t, "jdoe@example.com", claims.Email)
+
+	// Users of unknown tenants cannot log in even with an authorized email domain
+	provider.Set("tid", "72f988bf-86f1-41af-91ab-2d7cd011db47")
+	login = auth.NewOIDCLogin()
+	code = provider.Authorize(t, tm, login)
+	_, err = tm.CheckOIDCCode(context.Background(), code, login)
+	require.ErrorIs(t, err, auth.ErrUnauthorizedDomain)
+}
+
+func TestOIDCDisabled(t *testing.T) {
+	tm, err := auth.New(config.AuthConfig{
+		Keys:            map[string]string{oidcKeyID: "testdata/" + oidcKeyID + ".pem"},
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  1 * time.Hour,
+		RefreshDuration: 2 * time.Hour,
+		RefreshOverlap:  -15 * time.Minute,
+	})
+	require.NoError(t, err, "could not create token manager")
+	require.False(t, tm.OIDCEnabled())
+
+	_, err = tm.OIDCAuthCodeURL(context.Background(), auth.NewOIDCLogin())
+	require.ErrorIs(t, err, auth.ErrOIDCDisabled)
+
+	_, err = tm.CheckGoogleIDToken(context.Background(), "credential")
+	require.ErrorIs(t, err, auth.ErrGoogleDisabled)
+}
+
+func TestOIDCLogin(t *testing.T) {
+	login := auth.NewOIDCLogin()
+	require.NotEqual(t, login.State, login.Nonce)
+
+	cmp, err := auth.DecodeOIDCLogin(login.Encode())
+	require.NoError(t, err, "could not decode login")
+	require.Equal(t, login, cmp)
+
+	// The next page is carried through the login
+	login.Next = "/abc/info?tab=stats"
+	cmp, err = auth.DecodeOIDCLogin(login.Encode())
+	require.NoError(t, err, "could not decode login with next page")
+	require.Equal(t, login, cmp)
+
+	for _, s := range []string{"", "state", "state.nonce", "state..verifier", "a.b.c.d", "a.b.c.", "a.b.c.L2Fi.e"} {
+		_, err = auth.DecodeOIDCLogin(s)
+		require.Error(t, err, "expected %q to be invalid", s)
+	}
+}
+
+func newOIDCTokenManager(t *testing.T, issuerURL, domain string) *auth.TokenManager {
+	tm, err := auth.New(oidcConfig(issuerURL, domain))
+	require.NoError(t, err, "could not create token manager")
+	return tm
+}
+
+func oidcConfig(issuerURL, domain string) config.AuthConfig {
+	return config.AuthConfig{
+		Domains:         []string{domain},
+		Keys:            map[string]string{oidcKeyID: "testdata/" + oidcKeyID + ".pem"},
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  1 * time.Hour,
+		RefreshDuration: 2 * time.Hour,
+		RefreshOverlap:  -15 * time.Minute,
+		OIDC: config.OIDCConfig{
+			Enabled:      true,
+			Issuer:       issuerURL,
+			ClientID:     oidcClientID,
+			ClientSecret: oidcClientSecret,
+			RedirectURL:  oidcRedirectURL,
+			Scopes:       []string{"openid", "email", "profile"},
+			EmailClaim:   "upn",
+			NameClaim:    "name",
+			PictureClaim: "picture",
+			DomainClaim:  "hd",
+		},
+	}
+}
+
+// mockOIDC is a minimal OpenID Connect provider that implements discovery, the
+// authorization code flow with PKCE, and a json web key set for testing.
+type mockOIDC struct {
+	sync.Mutex
+	srv    *httptest.Server
+	key    *rsa.PrivateKey
+	claims jwt.MapClaims
+	codes  map[string]url.Values
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	data, err := os.ReadFile("testdata/" + oidcKeyID + ".pem")
+	require.NoError(t, err, "could not read signing key")
+
+	m := &mockOIDC{codes: make(map[string]url.Values)}
+	m.key, err = jwt.ParseRSAPrivateKeyFromPEM(data)
+	require.NoError(t, err, "could not parse signing key")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("/authorize", m.authorize)
+	mux.HandleFunc("/token", m.token)
+	mux.HandleFunc("/jwks", m.jwks)
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+
+	m.claims = jwt.MapClaims{
+		"iss":            m.srv.URL,
+		"sub":            "1234",
+		"aud":            oidcClientID,
+		"upn":            "jdoe@example.com",
+		"email_verified": true,
+		"hd":             "example.com",
+		"name":           "Jane Doe",
+		"picture":        "https://example.com/jdoe.png",
+	}
+	return m
+}
+
+func (m *mockOIDC) URL() string {
+	return m.srv.URL
+}
+
+// Set a claim that is added to subsequent id tokens.
+func (m *mockOIDC) Set(claim string, value interface{}) {
+	m.Lock()
+	defer m.Unlock()
+	m.claims[claim] = value
+}
+
+// Delete a claim so that it is omitted from subsequent id tokens.
+func (m *mockOIDC) Delete(claim string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.claims, claim)
+}
+
+// Authorize simulates the user logging in with the provider and returns the code that
+// the provider redirects back to rtnl with.
+func (m *mockOIDC) Authorize(t *testing.T, tm *auth.TokenManager, login *auth.OIDCLogin) string {
+	authURL, err := tm.OIDCAuthCodeURL(context.Background(), login)
+	require.NoError(t, err, "could not create auth code url")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	rep, err := client.Get(authURL)
+	require.NoError(t, err, "could not make authorization request")
+	rep.Body.Close()
+	require.Equal(t, http.StatusFound, rep.StatusCode)
+
+	location, err := url.Parse(rep.Header.Get("Location"))
+	require.NoError(t, err, "could not parse redirect")
+	require.Equal(t, login.State, location.Query().Get("state"))
+	return location.Query().Get("code")
+}
+
+func (m *mockOIDC) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]string{
+		"issuer":                 m.srv.URL,
+		"authorization_endpoint": m.srv.URL + "/authorize",
+		"token_endpoint":         m.srv.URL + "/token",
+		"jwks_uri":               m.srv.URL + "/jwks",
+	})
+}
+
+func (m *mockOIDC) authorize(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("client_id") != oidcClientID || query.Get("redirect_uri") != oidcRedirectURL || query.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	m.Lock()
+	code := auth.NewOIDCLogin().State
+	m.codes[code] = query
+	m.Unlock()
+
+	redirect, _ := url.Parse(oidcRedirectURL)
+	redirect.RawQuery = url.Values{"code": {code}, "state": {query.Get("state")}}.Encode()
+	http.Redirect(w, r, redirect.String(), http.StatusFound)
+}
+
+func (m *mockOIDC) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if clientID, secret, ok := r.BasicAuth(); !ok || clientID != oidcClientID || secret != oidcClientSecret {
+		http.Error(w, `{"error":"invalid_client"}`, http.StatusUnauthorized)
+		return
+	}
+
+	m.Lock()
+	defer m.Unlock()
+	request, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+
+	// Verify the PKCE challenge from the authorization request
+	if !ok || oauth2.S256ChallengeFromVerifier(r.PostForm.Get("code_verifier")) != request.Get("code_challenge") {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error":"invalid_grant"}`))
+		return
+	}
+
+	claims := jwt.MapClaims{
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"nonce": request.Get("nonce"),
+	}
+	for key, val := range m.claims {
+		claims[key] = val
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = oidcKeyID
+	idToken, err := token.SignedString(m.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func (m *mockOIDC) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": oidcKeyID,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+			},
+		},
+	})
+}
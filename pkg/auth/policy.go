@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/rotationalio/rtnl.link/pkg/config"
+)
+
+var (
+	ErrLoginDenied        = errors.New("user is not allowed to log in")
+	ErrUnauthorizedDomain = errors.New("user does not belong to an authorized domain")
+)
+
+// LoginPolicy determines which users are allowed to log in to the web interface. Users
+// on the denylist (by email address or domain) are always rejected, users on the email
+// allowlist are always accepted (e.g. outside collaborators), and otherwise the user
+// must belong to one of the authorized domains.
+type LoginPolicy struct {
+	domains map[string]struct{}
+	allow   map[string]struct{}
+	deny    map[string]struct{}
+}
+
+// NewLoginPolicy creates a login policy from the domains, allowlist, and denylist in
+// the auth configuration. Entries are compared case insensitively.
+func NewLoginPolicy(conf config.AuthConfig) *LoginPolicy {
+	return &LoginPolicy{
+		domains: set(conf.Domains),
+		allow:   set(conf.Allow),
+		deny:    set(conf.Deny),
+	}
+}
+
+// Authorize returns an error if the user with the specified email address is not
+// allowed to log in. The domain is the organization that the identity provider asserts
+// the user belongs to (e.g. the hd claim of a Google Workspace account); it is checked
+// against the authorized domains instead of the domain of the email address since
+// personal accounts can be registered with any email address.
+func (p *LoginPolicy) Authorize(email, domain string) error {
+	email = normalize(email)
+	domain = normalize(domain)
+
+	if at := strings.LastIndex(email, "@"); at < 1 || at == len(email)-1 {
+		return ErrMissingEmail
+	}
+
+	if contains(p.deny, email, emailDomain(email), domain) {
+		return ErrLoginDenied
+	}
+
+	if contains(p.allow, email) {
+		return nil
+	}
+
+	if domain == "" {
+		return ErrUnauthorizedDomain
+	}
+
+	if !contains(p.domains, domain) {
+		return fmt.Errorf("%w: %s", ErrUnauthorizedDomain, domain)
+	}
+	return nil
+}
+
+// AuthorizeLocal returns an error if the user with the specified email address is on
+// the denylist. Local accounts are created by an administrator rather than asserted by
+// an identity provider, so they are not required to belong to an authorized domain.
+func (p *LoginPolicy) AuthorizeLocal(email string) error {
+	email = normalize(email)
+	if contains(p.deny, email, emailDomain(email)) {
+		return ErrLoginDenied
+	}
+	return nil
+}
+
+// AuthorizeLocal returns an error if the login policy does not allow the local account
+// with the specified email address to log in with a password.
+func (tm *TokenManager) AuthorizeLocal(email string) error {
+	return tm.policy.AuthorizeLocal(email)
+}
+
+func contains(entries map[string]struct{}, values ...string) bool {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+
+		if _, ok := entries[value]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the domain of the email address or an empty string if it has no domain.
+func emailDomain(email string) string {
+	if at := strings.LastIndex(email, "@"); at >= 0 {
+		return email[at+1:]
+	}
+	return ""
+}
+
+func set(entries []string) map[string]struct{} {
+	s := make(map[string]struct{}, len(entries))
+	for _, entry := range entries {
+		if entry = normalize(entry); entry != "" {
+			s[entry] = struct{}{}
+		}
+	}
+	return s
+}
+
+func normalize(s string) string {
+	return strings.ToLower(strings.TrimSpace(s))
+}
@@ -0,0 +1,59 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoginPolicy(t *testing.T) {
+	policy := auth.NewLoginPolicy(config.AuthConfig{
+		Domains: []string{"example.com", " Example.org "},
+		Allow:   []string{"Collaborator@gmail.com", "consultant@example.net"},
+		Deny:    []string{"intern@example.com", "contractors.example.org", "consultant@example.net"},
+	})
+
+	testCases := []struct {
+		email  string
+		domain string
+		err    error
+	}{
+		{"jdoe@example.com", "example.com", nil},
+		{"JDoe@Example.com", "EXAMPLE.COM", nil},
+		{"asmith@example.org", "example.org", nil},
+		{"collaborator@gmail.com", "", nil},
+		{"jdoe@gmail.com", "", auth.ErrUnauthorizedDomain},
+		{"jdoe@example.net", "example.net", auth.ErrUnauthorizedDomain},
+		{"jdoe@example.com", "", auth.ErrUnauthorizedDomain},
+		{"intern@example.com", "example.com", auth.ErrLoginDenied},
+		{"jdoe@contractors.example.org", "example.org", auth.ErrLoginDenied},
+		{"jdoe@example.org", "contractors.example.org", auth.ErrLoginDenied},
+		{"consultant@example.net", "", auth.ErrLoginDenied},
+		{"", "example.com", auth.ErrMissingEmail},
+		{"jdoe", "example.com", auth.ErrMissingEmail},
+		{"@example.com", "example.com", auth.ErrMissingEmail},
+		{"jdoe@", "example.com", auth.ErrMissingEmail},
+	}
+
+	for i, tc := range testCases {
+		err := policy.Authorize(tc.email, tc.domain)
+		if tc.err == nil {
+			require.NoError(t, err, "expected %q to be authorized in test case %d", tc.email, i)
+		} else {
+			require.ErrorIs(t, err, tc.err, "expected %q to be rejected in test case %d", tc.email, i)
+		}
+	}
+
+	// If no domains are configured only the allowlist can log in
+	policy = auth.NewLoginPolicy(config.AuthConfig{Allow: []string{"jdoe@example.com"}})
+	require.NoError(t, policy.Authorize("jdoe@example.com", "example.com"))
+	require.ErrorIs(t, policy.Authorize("asmith@example.com", "example.com"), auth.ErrUnauthorizedDomain)
+
+	// Local accounts are only checked against the denylist
+	policy = auth.NewLoginPolicy(config.AuthConfig{Domains: []string{"example.com"}, Deny: []string{"intern@example.com", "contractors.example.org"}})
+	require.NoError(t, policy.AuthorizeLocal("jdoe@gmail.com"))
+	require.ErrorIs(t, policy.AuthorizeLocal("Intern@Example.com"), auth.ErrLoginDenied)
+	require.ErrorIs(t, policy.AuthorizeLocal("jdoe@contractors.example.org"), auth.ErrLoginDenied)
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Roles are assigned to web users by email address and determine the scopes that the
+// user is granted when they log in.
+const (
+	RoleViewer = "viewer"
+	RoleEditor = "editor"
+	RoleAdmin  = "admin"
+)
+
+var (
+	// Roles lists every role that can be assigned in order of increasing permissions.
+	Roles = []string{RoleViewer, RoleEditor, RoleAdmin}
+
+	ErrUnknownRole = errors.New("unknown role")
+)
+
+// Viewers can browse links and stats, editors can also create and edit links, and
+// admins can delete links and manage API keys and users.
+var roleScopes = map[string]Scopes{
+	RoleViewer: {ScopeLinksRead, ScopeStatsRead},
+	RoleEditor: {ScopeLinksRead, ScopeLinksWrite, ScopeStatsRead},
+	RoleAdmin:  {ScopeAdmin},
+}
+
+// ParseRole normalizes the role and returns an error if it is not a known role.
+func ParseRole(role string) (string, error) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if _, ok := roleScopes[role]; !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownRole, role)
+	}
+	return role, nil
+}
+
+// RoleScopes returns the scopes granted to the role; unknown roles have no scopes.
+func RoleScopes(role string) Scopes {
+	return roleScopes[role]
+}
+
+// Role returns the role assigned to the email address in the configuration or the
+// default role if the email address has not been assigned a role.
+func (tm *TokenManager) Role(email string) string {
+	email = strings.ToLower(strings.TrimSpace(email))
+	for user, role := range tm.conf.Roles {
+		if strings.ToLower(strings.TrimSpace(user)) == email {
+			role, _ = ParseRole(role)
+			return role
+		}
+	}
+
+	role, _ := ParseRole(tm.conf.DefaultRole)
+	return role
+}
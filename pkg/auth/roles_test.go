@@ -0,0 +1,71 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	for _, role := range auth.Roles {
+		parsed, err := auth.ParseRole(role)
+		require.NoError(t, err, "could not parse role %q", role)
+		require.Equal(t, role, parsed)
+		require.NoError(t, auth.RoleScopes(role).Validate())
+	}
+
+	role, err := auth.ParseRole("  Editor ")
+	require.NoError(t, err)
+	require.Equal(t, auth.RoleEditor, role)
+
+	_, err = auth.ParseRole("owner")
+	require.ErrorIs(t, err, auth.ErrUnknownRole)
+	require.Empty(t, auth.RoleScopes("owner"))
+
+	// Viewers can only read, editors can also create, and admins can do everything
+	viewer := auth.RoleScopes(auth.RoleViewer)
+	require.True(t, viewer.Has(auth.ScopeLinksRead))
+	require.True(t, viewer.Has(auth.ScopeStatsRead))
+	require.False(t, viewer.Has(auth.ScopeLinksWrite))
+
+	editor := auth.RoleScopes(auth.RoleEditor)
+	require.True(t, editor.Has(auth.ScopeLinksWrite))
+	require.False(t, editor.Has(auth.ScopeLinksDelete))
+	require.False(t, editor.Has(auth.ScopeAdmin))
+
+	admin := auth.RoleScopes(auth.RoleAdmin)
+	require.True(t, admin.Has(auth.ScopeLinksDelete))
+	require.True(t, admin.Has(auth.ScopeAdmin))
+}
+
+func TestConfiguredRoles(t *testing.T) {
+	conf := config.AuthConfig{
+		Keys:            map[string]string{"01GE6191AQTGMCJ9BN0QC3CCVG": "testdata/01GE6191AQTGMCJ9BN0QC3CCVG.pem"},
+		Audience:        audience,
+		Issuer:          issuer,
+		AccessDuration:  1 * time.Hour,
+		RefreshDuration: 2 * time.Hour,
+		RefreshOverlap:  -15 * time.Minute,
+		Roles:           map[string]string{"JDoe@example.com": "admin", "asmith@example.com": "Editor"},
+		DefaultRole:     "viewer",
+	}
+
+	tm, err := auth.New(conf)
+	require.NoError(t, err, "could not create token manager")
+	require.Equal(t, auth.RoleAdmin, tm.Role("jdoe@example.com"))
+	require.Equal(t, auth.RoleEditor, tm.Role("asmith@example.com"))
+	require.Equal(t, auth.RoleViewer, tm.Role("bwayne@example.com"))
+
+	// Invalid roles should not be accepted
+	conf.Roles["bwayne@example.com"] = "superuser"
+	_, err = auth.New(conf)
+	require.ErrorIs(t, err, auth.ErrUnknownRole)
+
+	delete(conf.Roles, "bwayne@example.com")
+	conf.DefaultRole = "guest"
+	_, err = auth.New(conf)
+	require.ErrorIs(t, err, auth.ErrUnknownRole)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Scopes are the permissions granted to an API key or user to access the API.
+const (
+	ScopeLinksRead   = "links:read"
+	ScopeLinksWrite  = "links:write"
+	ScopeLinksDelete = "links:delete"
+	ScopeStatsRead   = "stats:read"
+	ScopeReplicate   = "replicate"
+	ScopeAdmin       = "admin"
+)
+
+var (
+	// AllScopes lists every scope that can be granted.
+	AllScopes = Scopes{ScopeLinksRead, ScopeLinksWrite, ScopeLinksDelete, ScopeStatsRead, ScopeReplicate, ScopeAdmin}
+
+	// DefaultScopes are granted to API keys when no scopes are specified; they allow
+	// access to every endpoint except for replication and administrative endpoints like
+	// key management.
+	DefaultScopes = Scopes{ScopeLinksRead, ScopeLinksWrite, ScopeLinksDelete, ScopeStatsRead}
+
+	ErrUnknownScope = errors.New("unknown scope")
+)
+
+// Scopes is a set of permissions. The admin scope implies all other scopes.
+type Scopes []string
+
+// Has returns true if the scope has been granted.
+func (s Scopes) Has(scope string) bool {
+	for _, granted := range s {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if any of the scopes are unknown.
+func (s Scopes) Validate() error {
+	for _, scope := range s {
+		if !AllScopes.contains(scope) {
+			return fmt.Errorf("%w %q", ErrUnknownScope, scope)
+		}
+	}
+	return nil
+}
+
+// Restrict returns the scopes that are also granted by the other scopes, e.g. to limit
+// the scopes of a personal access token to the scopes of the role of its owner.
+func (s Scopes) Restrict(granted Scopes) Scopes {
+	out := make(Scopes, 0, len(s))
+	for _, scope := range s {
+		if granted.Has(scope) {
+			out = append(out, scope)
+		}
+	}
+	return out
+}
+
+func (s Scopes) contains(scope string) bool {
+	for _, item := range s {
+		if item == scope {
+			return true
+		}
+	}
+	return false
+}
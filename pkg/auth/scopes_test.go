@@ -0,0 +1,43 @@
+package auth_test
+
+import (
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScopes(t *testing.T) {
+	scopes := auth.Scopes{auth.ScopeLinksRead, auth.ScopeStatsRead}
+	require.True(t, scopes.Has(auth.ScopeLinksRead))
+	require.True(t, scopes.Has(auth.ScopeStatsRead))
+	require.False(t, scopes.Has(auth.ScopeLinksWrite))
+	require.False(t, scopes.Has(auth.ScopeAdmin))
+	require.False(t, auth.Scopes{}.Has(auth.ScopeLinksRead))
+
+	// The admin scope implies all other scopes
+	admin := auth.Scopes{auth.ScopeAdmin}
+	for _, scope := range auth.AllScopes {
+		require.True(t, admin.Has(scope), "admin should have scope %q", scope)
+	}
+
+	// The default scopes should not include admin or replication
+	require.False(t, auth.DefaultScopes.Has(auth.ScopeAdmin))
+	require.False(t, auth.DefaultScopes.Has(auth.ScopeReplicate))
+	require.NoError(t, auth.DefaultScopes.Validate())
+	require.NoError(t, auth.AllScopes.Validate())
+	require.ErrorIs(t, auth.Scopes{auth.ScopeLinksRead, "links:*"}.Validate(), auth.ErrUnknownScope)
+}
+
+func TestRestrictScopes(t *testing.T) {
+	viewer := auth.RoleScopes(auth.RoleViewer)
+	require.Equal(t, auth.Scopes{auth.ScopeLinksRead, auth.ScopeStatsRead}, auth.DefaultScopes.Restrict(viewer))
+	require.Equal(t, auth.Scopes{auth.ScopeLinksRead}, auth.Scopes{auth.ScopeLinksRead, auth.ScopeAdmin}.Restrict(viewer))
+	require.Empty(t, auth.Scopes{auth.ScopeLinksDelete}.Restrict(viewer))
+	require.Empty(t, auth.DefaultScopes.Restrict(nil))
+
+	// The admin scope grants every scope but is only kept if it is granted
+	admin := auth.RoleScopes(auth.RoleAdmin)
+	require.Equal(t, auth.DefaultScopes, auth.DefaultScopes.Restrict(admin))
+	require.Equal(t, auth.Scopes{auth.ScopeAdmin}, auth.Scopes{auth.ScopeAdmin}.Restrict(admin))
+}
@@ -7,8 +7,8 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"os"
 	"strings"
+	"sync"
 	"time"
 
 	jwt "github.com/golang-jwt/jwt/v4"
@@ -25,12 +25,17 @@ var (
 )
 
 type TokenManager struct {
+	sync.RWMutex
 	conf         config.AuthConfig
 	currentKeyID ulid.ULID
 	currentKey   *rsa.PrivateKey
 	keys         map[ulid.ULID]*rsa.PublicKey
+	activates    map[ulid.ULID]time.Time
+	ephemeral    *ephemeralKey
 	kidEntropy   io.Reader
 	gValidator   *idtoken.Validator
+	oidc         *OIDC
+	policy       *LoginPolicy
 }
 
 // New creates a TokenManager with the specified keys which should be a mapping of ULID
@@ -38,53 +43,18 @@ type TokenManager struct {
 // specifically designed for the config environment variable so that keys can be loaded
 // from k8s or vault secrets that are mounted as files on disk.
 func New(conf config.AuthConfig) (tm *TokenManager, err error) {
-	tm = &TokenManager{
-		conf: conf,
-		keys: make(map[ulid.ULID]*rsa.PublicKey),
-		kidEntropy: &ulid.LockedMonotonicReader{
-			MonotonicReader: ulid.Monotonic(rand.Reader, 0),
-		},
-	}
-
-	// Initialize google token validator
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
-	if tm.gValidator, err = idtoken.NewValidator(ctx); err != nil {
+	if tm, err = newTokenManager(conf); err != nil {
 		return nil, err
 	}
 
 	// Load keys from disk if specified by the configuration
-	for kid, path := range conf.Keys {
-		// Parse the key id
-		var keyID ulid.ULID
-		if keyID, err = ulid.Parse(kid); err != nil {
-			return nil, fmt.Errorf("could not parse kid %q for path %s: %s", kid, path, err)
-		}
-
-		// Load the keys from disk
-		var data []byte
-		if data, err = os.ReadFile(path); err != nil {
-			return nil, fmt.Errorf("could not read kid %s from %s: %s", kid, path, err)
-		}
-
-		var key *rsa.PrivateKey
-		if key, err = jwt.ParseRSAPrivateKeyFromPEM(data); err != nil {
-			return nil, fmt.Errorf("could not parse RSA private key kid %s from %s: %s", kid, path, err)
-		}
-
-		// Add the key to the key map
-		tm.keys[keyID] = &key.PublicKey
-
-		// Set the current key if it is the latest key
-		if tm.currentKey == nil || keyID.Time() > tm.currentKeyID.Time() {
-			tm.currentKey = key
-			tm.currentKeyID = keyID
+	if err = tm.Reload(); err != nil {
+		if !errors.Is(err, ErrNoSigningKeys) {
+			return nil, err
 		}
-	}
 
-	// If there are no keys, generate a key to use for authentication.
-	if len(tm.keys) == 0 {
-		if tm.currentKey, err = rsa.GenerateKey(rand.Reader, 4096); err != nil {
+		// If there are no keys, generate a key to use for authentication.
+		if tm.currentKey, err = rsa.GenerateKey(rand.Reader, KeySize); err != nil {
 			return nil, err
 		}
 
@@ -93,35 +63,64 @@ func New(conf config.AuthConfig) (tm *TokenManager, err error) {
 		}
 
 		tm.keys[tm.currentKeyID] = &tm.currentKey.PublicKey
+		tm.ephemeral = &ephemeralKey{id: tm.currentKeyID, key: &tm.currentKey.PublicKey}
 	}
 
 	return tm, nil
 }
 
 func NewWithKey(key *rsa.PrivateKey, conf config.AuthConfig) (tm *TokenManager, err error) {
+	if tm, err = newTokenManager(conf); err != nil {
+		return nil, err
+	}
+
+	var kid ulid.ULID
+	if kid, err = tm.genKeyID(); err != nil {
+		return nil, err
+	}
+
+	tm.keys[kid] = &key.PublicKey
+	tm.currentKey = key
+	tm.currentKeyID = kid
+	return tm, nil
+}
+
+// Create a token manager without any signing keys, validating the roles in the
+// configuration and initializing the login policy and identity providers.
+func newTokenManager(conf config.AuthConfig) (tm *TokenManager, err error) {
 	tm = &TokenManager{
-		conf: conf,
-		keys: make(map[ulid.ULID]*rsa.PublicKey),
+		conf:   conf,
+		keys:   make(map[ulid.ULID]*rsa.PublicKey),
+		policy: NewLoginPolicy(conf),
 		kidEntropy: &ulid.LockedMonotonicReader{
 			MonotonicReader: ulid.Monotonic(rand.Reader, 0),
 		},
 	}
 
+	// Ensure the roles assigned in the configuration are valid
+	for email, role := range conf.Roles {
+		if _, err = ParseRole(role); err != nil {
+			return nil, fmt.Errorf("invalid role for %s: %w", email, err)
+		}
+	}
+
+	if conf.DefaultRole != "" {
+		if _, err = ParseRole(conf.DefaultRole); err != nil {
+			return nil, fmt.Errorf("invalid default role: %w", err)
+		}
+	}
+
+	// Initialize the openid connect provider if enabled
+	if conf.OIDC.Enabled {
+		tm.oidc = NewOIDC(conf.OIDC)
+	}
+
 	// Initialize google token validator
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 	if tm.gValidator, err = idtoken.NewValidator(ctx); err != nil {
 		return nil, err
 	}
-
-	var kid ulid.ULID
-	if kid, err = tm.genKeyID(); err != nil {
-		return nil, err
-	}
-
-	tm.keys[kid] = &key.PublicKey
-	tm.currentKey = key
-	tm.currentKeyID = kid
 	return tm, nil
 }
 
@@ -163,6 +162,9 @@ func (tm *TokenManager) Parse(tks string) (claims *Claims, err error) {
 
 // Sign an access or refresh token and return the token string.
 func (tm *TokenManager) Sign(token *jwt.Token) (tks string, err error) {
+	tm.RLock()
+	defer tm.RUnlock()
+
 	// Sanity check to prevent nil panics.
 	if tm.currentKey == nil || tm.currentKeyID.Compare(nilID) == 0 {
 		return "", errors.New("token manager not initialized with signing keys")
@@ -261,6 +263,8 @@ func (tm *TokenManager) CreateRefreshToken(accessToken *jwt.Token) (refreshToken
 
 // CurrentKey returns the ulid of the current key being used to sign tokens.
 func (tm *TokenManager) CurrentKey() ulid.ULID {
+	tm.RLock()
+	defer tm.RUnlock()
 	return tm.currentKeyID
 }
 
@@ -286,6 +290,8 @@ func (tm *TokenManager) keyFunc(token *jwt.Token) (key interface{}, err error) {
 	}
 
 	// Fetch the key from the list of managed keys
+	tm.RLock()
+	defer tm.RUnlock()
 	if key, ok = tm.keys[keyID]; !ok {
 		return nil, errors.New("unknown signing key")
 	}
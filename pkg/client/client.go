@@ -13,26 +13,39 @@ import (
 	"time"
 
 	"github.com/google/go-querystring/query"
+	"github.com/gorilla/websocket"
 	"github.com/rotationalio/rtnl.link/pkg/api/v1"
 )
 
-// New creates a new API v1 client that implements the Service interface.
-func New(endpoint, apiKey string) (_ api.Service, err error) {
+// New creates a new API v1 client that implements the Service interface. By default
+// failed requests are retried with backoff; use the options to configure the client.
+func New(endpoint, apiKey string, opts ...Option) (_ api.Service, err error) {
 	c := &APIv1{
 		client: &http.Client{
 			Transport:     nil,
 			CheckRedirect: nil,
-			Timeout:       30 * time.Second,
+			Timeout:       DefaultTimeout,
 		},
-		apiKey: apiKey,
+		apiKey:     apiKey,
+		retries:    DefaultRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
 	}
 
 	if c.endpoint, err = url.Parse(endpoint); err != nil {
 		return nil, fmt.Errorf("could not parse endpoint: %s", err)
 	}
 
-	if c.client.Jar, err = cookiejar.New(nil); err != nil {
-		return nil, fmt.Errorf("could not create cookiejar: %w", err)
+	for _, opt := range opts {
+		if err = opt(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.client.Jar == nil {
+		if c.client.Jar, err = cookiejar.New(nil); err != nil {
+			return nil, fmt.Errorf("could not create cookiejar: %w", err)
+		}
 	}
 
 	return c, nil
@@ -40,9 +53,12 @@ func New(endpoint, apiKey string) (_ api.Service, err error) {
 
 // APIv1 implements the Service interface
 type APIv1 struct {
-	endpoint *url.URL     // the base url for all requests
-	apiKey   string       // the API key for authorized requests
-	client   *http.Client // used to make http requests to the server
+	endpoint   *url.URL      // the base url for all requests
+	apiKey     string        // the API key for authorized requests
+	client     *http.Client  // used to make http requests to the server
+	retries    int           // the maximum number of times a failed request is retried
+	minBackoff time.Duration // the delay before the first retry
+	maxBackoff time.Duration // the maximum delay between retries
 }
 
 // Ensure the APIv1 implements the Service interface
@@ -59,9 +75,10 @@ func (c *APIv1) Status(ctx context.Context) (out *api.StatusReply, err error) {
 		return nil, err
 	}
 
-	// NOTE: we cannot use s.Do because we want to parse 503 Unavailable errors
+	// NOTE: we cannot use c.Do because we want to parse 503 Unavailable errors; the
+	// request is still retried if the server is temporarily unavailable.
 	var rep *http.Response
-	if rep, err = c.client.Do(req); err != nil {
+	if rep, err = c.send(req); err != nil {
 		return nil, err
 	}
 	defer rep.Body.Close()
@@ -92,6 +109,19 @@ func (c *APIv1) ShortenURL(ctx context.Context, in *api.LongURL) (out *api.Short
 	return out, nil
 }
 
+func (c *APIv1) ShortenURLs(ctx context.Context, in *api.LongURLBatch) (out *api.ShortURLBatch, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/links/batch", in, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 func (c *APIv1) ShortURLInfo(ctx context.Context, id string) (out *api.ShortURL, err error) {
 	endpoint := fmt.Sprintf("/v1/links/%s", id)
 
@@ -107,6 +137,21 @@ func (c *APIv1) ShortURLInfo(ctx context.Context, id string) (out *api.ShortURL,
 	return out, nil
 }
 
+func (c *APIv1) UpdateShortURL(ctx context.Context, id string, in *api.ShortURL) (out *api.ShortURL, err error) {
+	endpoint := fmt.Sprintf("/v1/links/%s", id)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPatch, endpoint, in, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 func (c *APIv1) DeleteShortURL(ctx context.Context, id string) (err error) {
 	endpoint := fmt.Sprintf("/v1/links/%s", id)
 
@@ -144,6 +189,309 @@ func (c *APIv1) ShortURLList(ctx context.Context, page *api.PageQuery) (out *api
 	return out, nil
 }
 
+func (c *APIv1) ShortURLQRCode(ctx context.Context, id string) (out []byte, err error) {
+	endpoint := fmt.Sprintf("/v1/links/%s/qrcode", id)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, endpoint, nil, nil); err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", acceptPNG)
+
+	// NOTE: we cannot use c.Do because the response is a png image rather than JSON
+	var rep *http.Response
+	if rep, err = c.send(req); err != nil {
+		return nil, err
+	}
+	defer rep.Body.Close()
+
+	if err = checkResponse(rep); err != nil {
+		return nil, err
+	}
+
+	if out, err = io.ReadAll(rep.Body); err != nil {
+		return nil, fmt.Errorf("could not read qr code: %s", err)
+	}
+	return out, nil
+}
+
+// Updates opens a websocket to stream the clicks on the short url, or on all short
+// urls if the id is empty. The channel is closed when the context is done or when the
+// connection to the server is closed.
+func (c *APIv1) Updates(ctx context.Context, id string) (_ <-chan *api.Click, err error) {
+	path := "/v1/updates"
+	if id != "" {
+		path = fmt.Sprintf("/v1/links/%s/updates", id)
+	}
+
+	endpoint := c.endpoint.ResolveReference(&url.URL{Path: path})
+	switch endpoint.Scheme {
+	case "https":
+		endpoint.Scheme = "wss"
+	case "http":
+		endpoint.Scheme = "ws"
+	}
+
+	header := make(http.Header)
+	header.Add("User-Agent", userAgent)
+	if c.apiKey != "" {
+		header.Add("Authorization", "Bearer "+c.apiKey)
+	}
+
+	dialer := &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: c.client.Timeout,
+		Jar:              c.client.Jar,
+	}
+
+	var (
+		conn *websocket.Conn
+		rep  *http.Response
+	)
+	if conn, rep, err = dialer.DialContext(ctx, endpoint.String(), header); err != nil {
+		if rep != nil {
+			defer rep.Body.Close()
+			if serr := checkResponse(rep); serr != nil {
+				return nil, serr
+			}
+		}
+		return nil, fmt.Errorf("could not connect to updates stream: %s", err)
+	}
+
+	// Close the connection when the context is done to interrupt reads
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	clicks := make(chan *api.Click, clicksBufferSize)
+	go func() {
+		defer close(clicks)
+		defer close(done)
+		for {
+			click := &api.Click{}
+			if err := conn.ReadJSON(click); err != nil {
+				return
+			}
+
+			select {
+			case clicks <- click:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return clicks, nil
+}
+
+func (c *APIv1) ShortcrustStats(ctx context.Context) (out *api.ShortcrustInfo, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/stats", nil, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) APIKeyList(ctx context.Context) (out *api.APIKeyList, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/apikeys", nil, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) CreateAPIKey(ctx context.Context, in *api.APIKey) (out *api.APIKey, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/apikeys", in, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) UpdateAPIKey(ctx context.Context, in *api.APIKey) (out *api.APIKey, err error) {
+	if in.ClientID == "" {
+		return nil, ErrMissingClientID
+	}
+
+	endpoint := fmt.Sprintf("/v1/apikeys/%s", in.ClientID)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPut, endpoint, in, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) RevokeAPIKey(ctx context.Context, clientID string) (err error) {
+	endpoint := fmt.Sprintf("/v1/apikeys/%s", clientID)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, endpoint, nil, nil); err != nil {
+		return err
+	}
+
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *APIv1) RotateAPIKey(ctx context.Context, clientID string) (out *api.APIKey, err error) {
+	endpoint := fmt.Sprintf("/v1/apikeys/%s/rotate", clientID)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, endpoint, nil, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) TokenList(ctx context.Context) (out *api.APIKeyList, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/tokens", nil, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) CreateToken(ctx context.Context, in *api.APIKey) (out *api.APIKey, err error) {
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodPost, "/v1/tokens", in, nil); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) RevokeToken(ctx context.Context, clientID string) (err error) {
+	endpoint := fmt.Sprintf("/v1/tokens/%s", clientID)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, endpoint, nil, nil); err != nil {
+		return err
+	}
+
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *APIv1) SessionList(ctx context.Context, in *api.SessionQuery) (out *api.SessionList, err error) {
+	var params *url.Values
+	if in != nil {
+		var values url.Values
+		if values, err = query.Values(in); err != nil {
+			return nil, fmt.Errorf("could not encode query params: %w", err)
+		}
+		params = &values
+	}
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/sessions", nil, params); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+func (c *APIv1) RevokeSession(ctx context.Context, id string) (err error) {
+	endpoint := fmt.Sprintf("/v1/sessions/%s", id)
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, endpoint, nil, nil); err != nil {
+		return err
+	}
+
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *APIv1) RevokeUserSessions(ctx context.Context, email string) (err error) {
+	params := &url.Values{"email": []string{email}}
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodDelete, "/v1/sessions", nil, params); err != nil {
+		return err
+	}
+
+	if _, err = c.Do(req, nil, true); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c *APIv1) AuditLog(ctx context.Context, in *api.AuditQuery) (out *api.AuditLog, err error) {
+	var params *url.Values
+	if in != nil {
+		var values url.Values
+		if values, err = query.Values(in); err != nil {
+			return nil, fmt.Errorf("could not encode query params: %w", err)
+		}
+		params = &values
+	}
+
+	var req *http.Request
+	if req, err = c.NewRequest(ctx, http.MethodGet, "/v1/audit", nil, params); err != nil {
+		return nil, err
+	}
+
+	if _, err = c.Do(req, &out, true); err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
 //===========================================================================
 // Helper Methods
 //===========================================================================
@@ -154,8 +502,13 @@ const (
 	acceptLang   = "en-US,en"
 	acceptEncode = "gzip, deflate, br"
 	contentType  = "application/json; charset=utf-8"
+	acceptPNG    = "image/png"
 )
 
+// The number of clicks buffered by the updates stream before reads from the websocket
+// are paused to wait for the consumer.
+const clicksBufferSize = 64
+
 func (s *APIv1) NewRequest(ctx context.Context, method, path string, data interface{}, params *url.Values) (req *http.Request, err error) {
 	// Resolve the URL reference from the path
 	url := s.endpoint.ResolveReference(&url.URL{Path: path})
@@ -203,29 +556,18 @@ func (s *APIv1) NewRequest(ctx context.Context, method, path string, data interf
 	return req, nil
 }
 
-// Do executes an http request against the server, performs error checking, and
-// deserializes the response data into the specified struct.
+// Do executes an http request against the server, retrying it if it fails, performs
+// error checking, and deserializes the response data into the specified struct.
 func (s *APIv1) Do(req *http.Request, data interface{}, checkStatus bool) (rep *http.Response, err error) {
-	if rep, err = s.client.Do(req); err != nil {
-		return rep, fmt.Errorf("could not execute request: %s", err)
+	if rep, err = s.send(req); err != nil {
+		return rep, err
 	}
 	defer rep.Body.Close()
 
 	// Detect http status errors if they've occurred
 	if checkStatus {
-		if rep.StatusCode < 200 || rep.StatusCode >= 300 {
-			// Attempt to read the error response from JSON, if available
-			serr := &StatusError{
-				StatusCode: rep.StatusCode,
-				Reply:      api.Reply{},
-			}
-
-			if err = json.NewDecoder(rep.Body).Decode(&serr.Reply); err == nil {
-				return rep, serr
-			}
-
-			serr.Reply = api.Reply{Error: "something went wrong"}
-			return rep, serr
+		if err = checkResponse(rep); err != nil {
+			return rep, err
 		}
 	}
 
@@ -250,3 +592,23 @@ func (s *APIv1) Do(req *http.Request, data interface{}, checkStatus bool) (rep *
 
 	return rep, nil
 }
+
+// checkResponse returns a StatusError if the response does not have a 2xx status code,
+// decoding the error reply from the response body if available.
+func checkResponse(rep *http.Response) error {
+	if rep.StatusCode >= 200 && rep.StatusCode < 300 {
+		return nil
+	}
+
+	// Attempt to read the error response from JSON, if available
+	serr := &StatusError{
+		StatusCode: rep.StatusCode,
+		Reply:      api.Reply{},
+	}
+	serr.RetryAfter, _ = retryAfter(rep)
+
+	if err := json.NewDecoder(rep.Body).Decode(&serr.Reply); err != nil {
+		serr.Reply = api.Reply{Error: "something went wrong"}
+	}
+	return serr
+}
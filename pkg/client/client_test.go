@@ -0,0 +1,179 @@
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/client"
+	"github.com/stretchr/testify/require"
+)
+
+const testAPIKey = "clientid-secret"
+
+func TestStatus(t *testing.T) {
+	var calls int32
+	status := http.StatusOK
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		require.Equal(t, "/v1/status", r.URL.Path)
+		reply(w, status, &api.StatusReply{Status: "ok", Version: "1.0.0"})
+	})
+
+	svc := newClient(t, srv)
+	out, err := svc.Status(context.Background())
+	require.NoError(t, err, "could not get status")
+	require.Equal(t, "ok", out.Status)
+	require.Equal(t, "1.0.0", out.Version)
+	require.Equal(t, int32(1), calls)
+
+	// Unavailable responses are retried and then parsed as a status reply
+	atomic.StoreInt32(&calls, 0)
+	status = http.StatusServiceUnavailable
+	out, err = svc.Status(context.Background())
+	require.NoError(t, err, "unavailable status should be parsed")
+	require.Equal(t, "ok", out.Status)
+	require.Equal(t, int32(client.DefaultRetries+1), calls, "unavailable status was not retried")
+
+	// Other errors are returned
+	status = http.StatusInternalServerError
+	_, err = svc.Status(context.Background())
+	require.Error(t, err)
+}
+
+func TestShortcrustStats(t *testing.T) {
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodGet, r.Method)
+		require.Equal(t, "/v1/stats", r.URL.Path)
+		require.Equal(t, "Bearer "+testAPIKey, r.Header.Get("Authorization"))
+		reply(w, http.StatusOK, &api.ShortcrustInfo{Links: 42, Clicks: 1024, Campaigns: 3})
+	})
+
+	out, err := newClient(t, srv).ShortcrustStats(context.Background())
+	require.NoError(t, err, "could not get stats")
+	require.Equal(t, &api.ShortcrustInfo{Links: 42, Clicks: 1024, Campaigns: 3}, out)
+}
+
+func TestShortenURLs(t *testing.T) {
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, http.MethodPost, r.Method)
+		require.Equal(t, "/v1/links/batch", r.URL.Path)
+
+		in := &api.LongURLBatch{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(in))
+		require.Len(t, in.URLs, 2)
+		reply(w, http.StatusOK, &api.ShortURLBatch{Results: []*api.BatchResult{{Status: api.BatchCreated}, {Status: api.BatchError, Error: "invalid url"}}})
+	})
+
+	out, err := newClient(t, srv).ShortenURLs(context.Background(), &api.LongURLBatch{URLs: []*api.LongURL{{URL: "https://rotational.io"}, {URL: "foo"}}})
+	require.NoError(t, err, "could not shorten urls")
+	require.Len(t, out.Results, 2)
+	require.Equal(t, api.BatchCreated, out.Results[0].Status)
+	require.Equal(t, api.BatchError, out.Results[1].Status)
+}
+
+func TestShortURLQRCode(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\nnotreallyapng")
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "image/png", r.Header.Get("Accept"))
+		if r.URL.Path != "/v1/links/abc123/qrcode" {
+			reply(w, http.StatusNotFound, &api.Reply{Error: "short url not found"})
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	})
+
+	svc := newClient(t, srv)
+	out, err := svc.ShortURLQRCode(context.Background(), "abc123")
+	require.NoError(t, err, "could not get qr code")
+	require.Equal(t, png, out)
+
+	_, err = svc.ShortURLQRCode(context.Background(), "missing")
+	require.ErrorIs(t, err, client.ErrNotFound)
+
+	serr := &client.StatusError{}
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, "short url not found", serr.Reply.Error)
+}
+
+func TestUpdates(t *testing.T) {
+	now := time.Now().Truncate(time.Hour).UTC()
+	upgrader := websocket.Upgrader{}
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+testAPIKey {
+			reply(w, http.StatusUnauthorized, &api.Reply{Error: "authentication required"})
+			return
+		}
+
+		link := "all"
+		if r.URL.Path != "/v1/updates" {
+			require.Equal(t, "/v1/links/abc123/updates", r.URL.Path)
+			link = "abc123"
+		}
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err, "could not upgrade connection")
+		defer conn.Close()
+
+		for i := 1; i <= 3; i++ {
+			require.NoError(t, conn.WriteJSON(&api.Click{Link: link, Time: now, Views: uint64(i)}))
+		}
+	})
+
+	for _, id := range []string{"", "abc123"} {
+		clicks, err := newClient(t, srv).Updates(context.Background(), id)
+		require.NoError(t, err, "could not connect to updates")
+
+		// The channel is closed when the server closes the connection
+		received := make([]*api.Click, 0, 3)
+		for click := range clicks {
+			received = append(received, click)
+		}
+
+		require.Len(t, received, 3)
+		for i, click := range received {
+			require.Equal(t, uint64(i+1), click.Views)
+			require.True(t, now.Equal(click.Time))
+			if id == "" {
+				require.Equal(t, "all", click.Link)
+			} else {
+				require.Equal(t, id, click.Link)
+			}
+		}
+	}
+
+	// Handshake errors are returned as status errors
+	svc, err := client.New(srv.URL, "", client.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+	_, err = svc.Updates(context.Background(), "")
+	require.ErrorIs(t, err, client.ErrUnauthorized)
+}
+
+// Serve the handler with a test server that is closed when the test completes.
+func serve(t *testing.T, handler http.HandlerFunc) *httptest.Server {
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// Create a client for the test server that retries quickly.
+func newClient(t *testing.T, srv *httptest.Server) api.Service {
+	svc, err := client.New(srv.URL, testAPIKey, client.WithBackoff(time.Millisecond, 10*time.Millisecond))
+	require.NoError(t, err, "could not create client")
+	return svc
+}
+
+// Write the JSON reply with the status code.
+func reply(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
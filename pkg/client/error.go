@@ -1,17 +1,61 @@
 package client
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"time"
 
 	"github.com/rotationalio/rtnl.link/pkg/api/v1"
 )
 
+var (
+	ErrMissingClientID = errors.New("a client id is required to update an api key")
+	ErrInvalidRetries  = errors.New("the number of retries cannot be negative")
+	ErrInvalidBackoff  = errors.New("the minimum backoff must be positive and no greater than the maximum backoff")
+)
+
+// Sentinel errors that a StatusError matches with errors.Is based on its status code,
+// e.g. errors.Is(err, client.ErrNotFound) is true for any 404 response.
+var (
+	ErrBadRequest   = errors.New("bad request")
+	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden    = errors.New("forbidden")
+	ErrNotFound     = errors.New("not found")
+	ErrConflict     = errors.New("conflict")
+	ErrRateLimited  = errors.New("rate limited")
+	ErrUnavailable  = errors.New("service unavailable")
+)
+
 // StatusError decodes an error response from the Service
 type StatusError struct {
 	StatusCode int
 	Reply      api.Reply
+	RetryAfter time.Duration // how long the server asked the client to wait, if specified
 }
 
 func (e *StatusError) Error() string {
 	return fmt.Sprintf("[%d] %s", e.StatusCode, e.Reply.Error)
 }
+
+// Is allows callers to check the kind of error without inspecting the status code.
+func (e *StatusError) Is(target error) bool {
+	switch target {
+	case ErrBadRequest:
+		return e.StatusCode == http.StatusBadRequest
+	case ErrUnauthorized:
+		return e.StatusCode == http.StatusUnauthorized
+	case ErrForbidden:
+		return e.StatusCode == http.StatusForbidden
+	case ErrNotFound:
+		return e.StatusCode == http.StatusNotFound
+	case ErrConflict:
+		return e.StatusCode == http.StatusConflict
+	case ErrRateLimited:
+		return e.StatusCode == http.StatusTooManyRequests
+	case ErrUnavailable:
+		return e.StatusCode == http.StatusServiceUnavailable
+	default:
+		return false
+	}
+}
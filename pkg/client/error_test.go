@@ -0,0 +1,95 @@
+package client_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStatusError(t *testing.T) {
+	sentinels := []error{
+		client.ErrBadRequest,
+		client.ErrUnauthorized,
+		client.ErrForbidden,
+		client.ErrNotFound,
+		client.ErrConflict,
+		client.ErrRateLimited,
+		client.ErrUnavailable,
+	}
+
+	testCases := []struct {
+		status   int
+		expected error
+	}{
+		{http.StatusBadRequest, client.ErrBadRequest},
+		{http.StatusUnauthorized, client.ErrUnauthorized},
+		{http.StatusForbidden, client.ErrForbidden},
+		{http.StatusNotFound, client.ErrNotFound},
+		{http.StatusConflict, client.ErrConflict},
+		{http.StatusTooManyRequests, client.ErrRateLimited},
+		{http.StatusServiceUnavailable, client.ErrUnavailable},
+		{http.StatusInternalServerError, nil},
+		{http.StatusUnprocessableEntity, nil},
+	}
+
+	for _, tc := range testCases {
+		err := error(&client.StatusError{StatusCode: tc.status, Reply: api.Reply{Error: "something happened"}})
+		require.Equal(t, fmt.Sprintf("[%d] something happened", tc.status), err.Error())
+
+		// The error only matches the sentinel for its status code, even when wrapped
+		wrapped := fmt.Errorf("could not make request: %w", err)
+		for _, sentinel := range sentinels {
+			if sentinel == tc.expected {
+				require.ErrorIs(t, wrapped, sentinel, "expected %d to match %q", tc.status, sentinel)
+			} else {
+				require.NotErrorIs(t, wrapped, sentinel, "expected %d not to match %q", tc.status, sentinel)
+			}
+		}
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/links/limited":
+			w.Header().Set("Retry-After", "120")
+			reply(w, http.StatusTooManyRequests, &api.Reply{Error: "rate limit exceeded"})
+		case "/v1/links/html":
+			w.Header().Set("Content-Type", "text/html")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte("<html>forbidden</html>"))
+		default:
+			reply(w, http.StatusNotFound, &api.Reply{Error: "short url not found"})
+		}
+	})
+
+	svc, err := client.New(srv.URL, testAPIKey, client.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+
+	// The error reply from the server is decoded into the status error
+	_, err = svc.ShortURLInfo(context.Background(), "missing")
+	serr := &client.StatusError{}
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, http.StatusNotFound, serr.StatusCode)
+	require.Equal(t, "short url not found", serr.Reply.Error)
+	require.Zero(t, serr.RetryAfter)
+
+	// The Retry-After header is included in the status error
+	_, err = svc.ShortURLInfo(context.Background(), "limited")
+	require.True(t, errors.As(err, &serr))
+	require.ErrorIs(t, err, client.ErrRateLimited)
+	require.Equal(t, 2*time.Minute, serr.RetryAfter)
+
+	// Responses that are not JSON are still returned as status errors
+	_, err = svc.ShortURLInfo(context.Background(), "html")
+	require.True(t, errors.As(err, &serr))
+	require.ErrorIs(t, err, client.ErrForbidden)
+	require.Equal(t, "something went wrong", serr.Reply.Error)
+}
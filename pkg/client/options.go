@@ -0,0 +1,61 @@
+package client
+
+import (
+	"net/http"
+	"time"
+)
+
+// Default retry behavior of the client; requests are retried with jittered exponential
+// backoff between the minimum and maximum backoff.
+const (
+	DefaultRetries    = 3
+	DefaultMinBackoff = 500 * time.Millisecond
+	DefaultMaxBackoff = 30 * time.Second
+	DefaultTimeout    = 30 * time.Second
+)
+
+// Option configures the client when it is created with New.
+type Option func(c *APIv1) error
+
+// WithHTTPClient uses the specified http client to make requests, e.g. to configure a
+// custom transport. A cookie jar is added to the client if it does not have one.
+func WithHTTPClient(client *http.Client) Option {
+	return func(c *APIv1) error {
+		c.client = client
+		return nil
+	}
+}
+
+// WithTimeout sets the timeout of each attempt to make a request to the server.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *APIv1) error {
+		c.client.Timeout = timeout
+		return nil
+	}
+}
+
+// WithRetries sets the maximum number of times a failed request is retried; set to
+// zero to disable retries.
+func WithRetries(retries int) Option {
+	return func(c *APIv1) error {
+		if retries < 0 {
+			return ErrInvalidRetries
+		}
+		c.retries = retries
+		return nil
+	}
+}
+
+// WithBackoff sets the minimum and maximum amount of time to wait between retries. The
+// maximum backoff also limits how long the client will wait for a Retry-After header;
+// if the server asks the client to wait longer, the request fails without retrying.
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *APIv1) error {
+		if min <= 0 || max < min {
+			return ErrInvalidBackoff
+		}
+		c.minBackoff = min
+		c.maxBackoff = max
+		return nil
+	}
+}
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"context"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+)
+
+// PageIterator fetches the pages of a short url list request one at a time, following
+// the next page token until the last page is reached. Usage:
+//
+//	pages := client.NewPageIterator(svc, &api.PageQuery{PageSize: 50})
+//	for pages.Next(ctx) {
+//		for _, link := range pages.Page().URLs {
+//			...
+//		}
+//	}
+//
+//	if err := pages.Err(); err != nil {
+//		...
+//	}
+type PageIterator struct {
+	svc   api.Service
+	query api.PageQuery
+	page  *api.ShortURLList
+	err   error
+	done  bool
+}
+
+// NewPageIterator returns an iterator that starts at the page specified by the query;
+// if the query is nil the iterator starts at the first page with the default page size.
+func NewPageIterator(svc api.Service, query *api.PageQuery) *PageIterator {
+	it := &PageIterator{svc: svc}
+	if query != nil {
+		it.query = *query
+	}
+	return it
+}
+
+// Next fetches the next page, returning false when there are no more pages or if an
+// error occurred, in which case the error is returned by Err.
+func (it *PageIterator) Next(ctx context.Context) bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	query := it.query
+	if it.page, it.err = it.svc.ShortURLList(ctx, &query); it.err != nil {
+		it.page = nil
+		return false
+	}
+
+	// Stop after this page if there is no next page or if the server returns the same
+	// token so that the iterator cannot loop forever.
+	var next string
+	if it.page.Page != nil {
+		next = it.page.Page.NextPageToken
+	}
+
+	if next == "" || next == it.query.NextPageToken {
+		it.done = true
+	}
+
+	it.query.NextPageToken = next
+	it.query.PrevPageToken = ""
+	return true
+}
+
+// Page returns the page fetched by the last call to Next.
+func (it *PageIterator) Page() *api.ShortURLList {
+	return it.page
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *PageIterator) Err() error {
+	return it.err
+}
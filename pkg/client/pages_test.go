@@ -0,0 +1,78 @@
+package client_test
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPageIterator(t *testing.T) {
+	// Serve three pages of two links each, then an error for any unknown token
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, "/v1/links", r.URL.Path)
+		require.Equal(t, "2", r.URL.Query().Get("page_size"))
+
+		var page int
+		switch token := r.URL.Query().Get("next_page_token"); token {
+		case "":
+			page = 0
+		case "page1", "page2":
+			page, _ = strconv.Atoi(token[4:])
+		default:
+			reply(w, http.StatusBadRequest, &api.Reply{Error: "invalid page token"})
+			return
+		}
+
+		out := &api.ShortURLList{
+			URLs: []*api.ShortURL{{URL: "https://rtnl.link/" + strconv.Itoa(page*2)}, {URL: "https://rtnl.link/" + strconv.Itoa(page*2+1)}},
+			Page: &api.PageQuery{PageSize: 2},
+		}
+
+		if page < 2 {
+			out.Page.NextPageToken = "page" + strconv.Itoa(page+1)
+		}
+		reply(w, http.StatusOK, out)
+	})
+
+	svc := newClient(t, srv)
+
+	t.Run("AllPages", func(t *testing.T) {
+		pages := client.NewPageIterator(svc, &api.PageQuery{PageSize: 2})
+		urls := make([]string, 0, 6)
+		for pages.Next(context.Background()) {
+			for _, link := range pages.Page().URLs {
+				urls = append(urls, link.URL)
+			}
+		}
+
+		require.NoError(t, pages.Err())
+		require.Len(t, urls, 6)
+		for i, url := range urls {
+			require.Equal(t, "https://rtnl.link/"+strconv.Itoa(i), url)
+		}
+
+		// The iterator is exhausted
+		require.False(t, pages.Next(context.Background()))
+	})
+
+	t.Run("StartPage", func(t *testing.T) {
+		pages := client.NewPageIterator(svc, &api.PageQuery{PageSize: 2, NextPageToken: "page2"})
+		require.True(t, pages.Next(context.Background()))
+		require.Equal(t, "https://rtnl.link/4", pages.Page().URLs[0].URL)
+		require.False(t, pages.Next(context.Background()))
+		require.NoError(t, pages.Err())
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		pages := client.NewPageIterator(svc, &api.PageQuery{PageSize: 2, NextPageToken: "bad"})
+		require.False(t, pages.Next(context.Background()))
+		require.Nil(t, pages.Page())
+		require.ErrorIs(t, pages.Err(), client.ErrBadRequest)
+		require.False(t, pages.Next(context.Background()))
+	})
+}
@@ -0,0 +1,144 @@
+package client
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// send executes the request, retrying it with jittered exponential backoff if it fails
+// and it is safe to do so. Idempotent requests are retried on network errors and on
+// bad gateway, unavailable, and gateway timeout responses. Other requests are only
+// retried on too many requests responses, or on unavailable responses that specify a
+// Retry-After header, since the server rejects these requests before handling them. If
+// the server specifies a Retry-After header, the client waits for that duration instead
+// of backing off, unless it is longer than the maximum backoff.
+func (s *APIv1) send(req *http.Request) (rep *http.Response, err error) {
+	for attempt := 0; ; attempt++ {
+		if attempt > 0 {
+			if req, err = rewind(req); err != nil {
+				return nil, err
+			}
+		}
+
+		rep, err = s.client.Do(req)
+
+		wait, retry := s.retry(req, rep, err, attempt)
+		if !retry {
+			break
+		}
+
+		// Drain the body so that the connection can be reused
+		if rep != nil {
+			io.Copy(io.Discard, rep.Body)
+			rep.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, fmt.Errorf("could not execute request: %w", req.Context().Err())
+		case <-timer.C:
+		}
+	}
+
+	if err != nil {
+		return rep, fmt.Errorf("could not execute request: %w", err)
+	}
+	return rep, nil
+}
+
+// Determines if the request should be retried and how long to wait before retrying.
+func (s *APIv1) retry(req *http.Request, rep *http.Response, err error, attempt int) (time.Duration, bool) {
+	if attempt >= s.retries || req.Context().Err() != nil {
+		return 0, false
+	}
+
+	// Requests cannot be retried if the body cannot be sent again
+	if req.Body != nil && req.Body != http.NoBody && req.GetBody == nil {
+		return 0, false
+	}
+
+	if err != nil {
+		return s.backoff(attempt), idempotent(req.Method)
+	}
+
+	switch rep.StatusCode {
+	case http.StatusTooManyRequests:
+		if wait, ok := retryAfter(rep); ok {
+			return wait, wait <= s.maxBackoff
+		}
+		return s.backoff(attempt), true
+	case http.StatusServiceUnavailable:
+		// A proxy may return unavailable after the server handled the request, so only
+		// an unavailable response that asks the client to retry is safe for any request
+		if wait, ok := retryAfter(rep); ok {
+			return wait, wait <= s.maxBackoff
+		}
+		return s.backoff(attempt), idempotent(req.Method)
+	case http.StatusBadGateway, http.StatusGatewayTimeout:
+		return s.backoff(attempt), idempotent(req.Method)
+	default:
+		return 0, false
+	}
+}
+
+// Returns the exponential backoff for the attempt with jitter so that many clients
+// that failed at the same time do not retry at the same time: the backoff is a random
+// duration between half of and the full exponential delay.
+func (s *APIv1) backoff(attempt int) time.Duration {
+	delay := s.maxBackoff
+	if attempt < 32 {
+		if exp := s.minBackoff << attempt; exp > 0 && exp < s.maxBackoff {
+			delay = exp
+		}
+	}
+
+	half := int64(delay / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+// Returns a copy of the request with a fresh body so that it can be sent again.
+func rewind(req *http.Request) (_ *http.Request, err error) {
+	clone := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if clone.Body, err = req.GetBody(); err != nil {
+			return nil, fmt.Errorf("could not rewind request body: %w", err)
+		}
+	}
+	return clone, nil
+}
+
+// Parses the Retry-After header, which is either a number of seconds or a date.
+func retryAfter(rep *http.Response) (time.Duration, bool) {
+	header := rep.Header.Get("Retry-After")
+	if header == "" {
+		return 0, false
+	}
+
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if ts, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(ts); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// Idempotent requests can be safely retried even if the server may have handled them.
+func idempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,234 @@
+package client_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/client"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetries(t *testing.T) {
+	testCases := []struct {
+		method     string
+		status     int
+		retryAfter string
+		calls      int32
+	}{
+		{http.MethodGet, http.StatusTooManyRequests, "", 4},
+		{http.MethodGet, http.StatusServiceUnavailable, "", 4},
+		{http.MethodGet, http.StatusBadGateway, "", 4},
+		{http.MethodGet, http.StatusGatewayTimeout, "", 4},
+		{http.MethodDelete, http.StatusServiceUnavailable, "", 4},
+		{http.MethodGet, http.StatusInternalServerError, "", 1},
+		{http.MethodGet, http.StatusNotFound, "", 1},
+		{http.MethodPost, http.StatusTooManyRequests, "", 4},
+		{http.MethodPost, http.StatusTooManyRequests, "0", 4},
+		{http.MethodPost, http.StatusServiceUnavailable, "0", 4},
+		{http.MethodPost, http.StatusServiceUnavailable, "", 1},
+		{http.MethodPost, http.StatusBadGateway, "", 1},
+		{http.MethodPost, http.StatusGatewayTimeout, "", 1},
+		{http.MethodPatch, http.StatusServiceUnavailable, "", 1},
+	}
+
+	for _, tc := range testCases {
+		var calls int32
+		srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&calls, 1)
+			if tc.retryAfter != "" {
+				w.Header().Set("Retry-After", tc.retryAfter)
+			}
+			reply(w, tc.status, &api.Reply{Error: http.StatusText(tc.status)})
+		})
+
+		err := call(t, newClient(t, srv), tc.method)
+		require.Error(t, err, "expected %s %d to fail", tc.method, tc.status)
+		require.Equal(t, tc.calls, atomic.LoadInt32(&calls), "unexpected number of attempts for %s %d (retry-after %q)", tc.method, tc.status, tc.retryAfter)
+	}
+}
+
+func TestNetworkErrors(t *testing.T) {
+	var calls int32
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		conn, _, err := w.(http.Hijacker).Hijack()
+		require.NoError(t, err, "could not hijack connection")
+		conn.Close()
+	})
+
+	svc := newClient(t, srv)
+
+	// Idempotent requests are retried if the connection fails
+	require.Error(t, call(t, svc, http.MethodGet))
+	require.Equal(t, int32(client.DefaultRetries+1), atomic.LoadInt32(&calls))
+
+	// Other requests may have been handled by the server so they are not retried
+	atomic.StoreInt32(&calls, 0)
+	require.Error(t, call(t, svc, http.MethodPost))
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestBackoff(t *testing.T) {
+	var calls int32
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		reply(w, http.StatusBadGateway, &api.Reply{Error: "bad gateway"})
+	})
+
+	// The retries wait at least half of 40ms, 80ms, and 100ms (capped at the maximum)
+	svc, err := client.New(srv.URL, testAPIKey, client.WithBackoff(40*time.Millisecond, 100*time.Millisecond))
+	require.NoError(t, err, "could not create client")
+
+	start := time.Now()
+	_, err = svc.ShortURLInfo(context.Background(), "abc123")
+	elapsed := time.Since(start)
+
+	serr := &client.StatusError{}
+	require.ErrorAs(t, err, &serr)
+	require.Equal(t, http.StatusBadGateway, serr.StatusCode)
+	require.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	require.GreaterOrEqual(t, elapsed, 110*time.Millisecond, "client did not back off between retries")
+	require.Less(t, elapsed, 2*time.Second, "client backed off for longer than the maximum backoff")
+
+	// Retries are abandoned when the context is canceled
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	svc, err = client.New(srv.URL, testAPIKey, client.WithBackoff(time.Second, time.Second))
+	require.NoError(t, err, "could not create client")
+
+	start = time.Now()
+	_, err = svc.ShortURLInfo(ctx, "abc123")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(start), 500*time.Millisecond, "client did not stop waiting when the context was done")
+
+	// Requests are not retried if retries are disabled
+	atomic.StoreInt32(&calls, 0)
+	svc, err = client.New(srv.URL, testAPIKey, client.WithRetries(0))
+	require.NoError(t, err, "could not create client")
+	_, err = svc.ShortURLInfo(context.Background(), "abc123")
+	require.Error(t, err)
+	require.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRetryAfter(t *testing.T) {
+	var calls int32
+	var header string
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		// Only the first request is rate limited
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.Header().Set("Retry-After", header)
+			reply(w, http.StatusTooManyRequests, &api.Reply{Error: "rate limited"})
+			return
+		}
+		reply(w, http.StatusOK, &api.ShortURL{URL: "https://rtnl.link/abc123"})
+	})
+
+	t.Run("Seconds", func(t *testing.T) {
+		atomic.StoreInt32(&calls, 0)
+		header = "1"
+
+		// The client waits for the duration in the header rather than backing off
+		svc, err := client.New(srv.URL, testAPIKey, client.WithBackoff(time.Millisecond, 2*time.Second))
+		require.NoError(t, err, "could not create client")
+
+		start := time.Now()
+		_, err = svc.ShortURLInfo(context.Background(), "abc123")
+		require.NoError(t, err, "request was not retried")
+		require.GreaterOrEqual(t, time.Since(start), time.Second, "client did not wait for the retry after duration")
+		require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("Date", func(t *testing.T) {
+		// Dates in the past are retried immediately
+		atomic.StoreInt32(&calls, 0)
+		header = time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+
+		svc, err := client.New(srv.URL, testAPIKey, client.WithBackoff(time.Second, 2*time.Second))
+		require.NoError(t, err, "could not create client")
+
+		start := time.Now()
+		_, err = svc.ShortURLInfo(context.Background(), "abc123")
+		require.NoError(t, err, "request was not retried")
+		require.Less(t, time.Since(start), 500*time.Millisecond, "client backed off instead of using the retry after date")
+		require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("TooLong", func(t *testing.T) {
+		// The request fails if the server asks the client to wait longer than the max backoff
+		for _, value := range []string{"3600", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)} {
+			atomic.StoreInt32(&calls, 0)
+			header = value
+
+			_, err := newClient(t, srv).ShortURLInfo(context.Background(), "abc123")
+			require.ErrorIs(t, err, client.ErrRateLimited)
+			require.Equal(t, int32(1), atomic.LoadInt32(&calls), "request should not have been retried")
+
+			serr := &client.StatusError{}
+			require.ErrorAs(t, err, &serr)
+			require.Greater(t, serr.RetryAfter, 59*time.Minute, "retry after was not parsed from %q", value)
+		}
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		// Invalid headers are ignored and the client backs off instead
+		atomic.StoreInt32(&calls, 0)
+		header = "soon"
+
+		_, err := newClient(t, srv).ShortURLInfo(context.Background(), "abc123")
+		require.NoError(t, err, "request was not retried")
+		require.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestRewindBody(t *testing.T) {
+	var calls int32
+	bodies := make(chan string, 4)
+	srv := serve(t, func(w http.ResponseWriter, r *http.Request) {
+		data, err := io.ReadAll(r.Body)
+		require.NoError(t, err, "could not read request body")
+		bodies <- string(data)
+
+		if atomic.AddInt32(&calls, 1) < 3 {
+			reply(w, http.StatusTooManyRequests, &api.Reply{Error: "rate limited"})
+			return
+		}
+		reply(w, http.StatusCreated, &api.ShortURL{URL: "https://rtnl.link/abc123"})
+	})
+
+	out, err := newClient(t, srv).ShortenURL(context.Background(), &api.LongURL{URL: "https://rotational.io"})
+	require.NoError(t, err, "could not shorten url")
+	require.Equal(t, "https://rtnl.link/abc123", out.URL)
+	require.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	// The same body must be sent with every attempt
+	close(bodies)
+	first := <-bodies
+	require.Contains(t, first, "https://rotational.io")
+	for body := range bodies {
+		require.Equal(t, first, body, "request body was not rewound")
+	}
+}
+
+// Make a request to the test server with the specified method.
+func call(t *testing.T, svc api.Service, method string) (err error) {
+	ctx := context.Background()
+	switch method {
+	case http.MethodGet:
+		_, err = svc.ShortURLInfo(ctx, "abc123")
+	case http.MethodPost:
+		_, err = svc.ShortenURL(ctx, &api.LongURL{URL: "https://rotational.io"})
+	case http.MethodPatch:
+		_, err = svc.UpdateShortURL(ctx, "abc123", &api.ShortURL{Title: "Rotational"})
+	case http.MethodDelete:
+		err = svc.DeleteShortURL(ctx, "abc123")
+	default:
+		require.Failf(t, "unhandled method", "no request for method %s", method)
+	}
+	return err
+}
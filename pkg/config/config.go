@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -16,41 +17,107 @@ import (
 // because of this Prefix and the split_words struct tag in the conf below.
 const Prefix = "rtnl"
 
+// DefaultDomain is the email domain whose users are allowed to log in if no domains
+// are configured.
+const DefaultDomain = "rotational.io"
+
 // Config contains all of the configuration parameters for an rtnl server and is
 // loaded from the environment or a configuration file with reasonable defaults for
 // values that are omitted. The Config should be validated in preparation for running
 // the server to ensure that all server operations work as expected.
 type Config struct {
-	Maintenance  bool                `default:"false" yaml:"maintenance"`
-	Mode         string              `default:"release"`
-	LogLevel     logger.LevelDecoder `split_words:"true" default:"info" yaml:"log_level"`
-	ConsoleLog   bool                `split_words:"true" default:"false" yaml:"console_log"`
-	BindAddr     string              `split_words:"true" default:":8765" yaml:"bind_addr"`
-	AllowOrigins []string            `split_words:"true" default:"http://localhost:8765"`
-	Origin       string              `default:"https://rtnl.link"`
-	AltOrigin    string              `split_words:"true" default:"https://r8l.co"`
-	Storage      StorageConfig
-	Auth         AuthConfig
-	processed    bool
-	originURL    *url.URL
-	altURL       *url.URL
+	Maintenance    bool                `default:"false" yaml:"maintenance"`
+	Mode           string              `default:"release"`
+	LogLevel       logger.LevelDecoder `split_words:"true" default:"info" yaml:"log_level"`
+	ConsoleLog     bool                `split_words:"true" default:"false" yaml:"console_log"`
+	BindAddr       string              `split_words:"true" default:":8765" yaml:"bind_addr"`
+	AllowOrigins   []string            `split_words:"true" default:"http://localhost:8765"`
+	TrustedProxies []string            `split_words:"true" required:"false" desc:"ip addresses or cidr ranges of proxies trusted to set the client ip with X-Forwarded-For"`
+	Origin         string              `default:"https://rtnl.link"`
+	AltOrigin      string              `split_words:"true" default:"https://r8l.co"`
+	Storage        StorageConfig
+	Replica        ReplicaConfig
+	Auth           AuthConfig
+	RateLimit      RateLimitConfig `split_words:"true"`
+	processed      bool
+	originURL      *url.URL
+	altURL         *url.URL
 }
 
 type StorageConfig struct {
-	ReadOnly bool   `split_words:"true" default:"false"`
-	DataPath string `split_words:"true" required:"true"`
+	ReadOnly         bool          `split_words:"true" default:"false"`
+	DataPath         string        `split_words:"true" required:"true"`
+	ManualMigrations bool          `split_words:"true" default:"false" desc:"do not apply migrations when the database is opened (use rtnl db:migrate)"`
+	GCInterval       time.Duration `split_words:"true" default:"1h" desc:"interval between value log garbage collection runs (0 to disable)"`
+	GCDiscardRatio   float64       `split_words:"true" default:"0.5" desc:"fraction of a value log file that must be discardable for it to be rewritten"`
+	ExpireInterval   time.Duration `split_words:"true" default:"1m" desc:"interval between sweeps of expired links from the aggregate counts (0 to disable)"`
+}
+
+type ReplicaConfig struct {
+	Enabled    bool          `default:"false" desc:"run as a redirect-only follower that replicates from a primary"`
+	Primary    string        `desc:"the endpoint of the primary rtnl server to replicate from"`
+	APIKey     string        `split_words:"true" desc:"the api key used to authenticate with the primary (requires the replicate scope)"`
+	MaxBackoff time.Duration `split_words:"true" default:"1m" desc:"maximum amount of time to wait before reconnecting to the primary"`
 }
 
 type AuthConfig struct {
-	GoogleClientID  string            `split_words:"true" required:"true" desc:"the Google oauth claims client id and audience"`
-	HDClaim         string            `split_words:"true" default:"rotational.io" desc:"the email domain to allow to authenticate"`
+	GoogleClientID  string            `split_words:"true" desc:"the Google oauth claims client id and audience (disables Google sign in if omitted)"`
+	Domains         []string          `required:"false" desc:"the email domains whose users are allowed to log in (defaults to rotational.io)"`
+	HDClaim         string            `split_words:"true" required:"false" desc:"deprecated: use domains instead; the email domain to allow to authenticate"`
+	Allow           []string          `required:"false" desc:"email addresses allowed to log in regardless of their domain"`
+	Deny            []string          `required:"false" desc:"email addresses or domains that are never allowed to log in"`
 	CookieDomain    string            `split_words:"true" default:"rtnl.link" desc:"the domain to assign cookies to"`
 	Keys            map[string]string `required:"false" desc:"rsa keys for signing access tokens (generated if omitted)"`
+	KeysDir         string            `split_words:"true" desc:"directory of rsa keys in pem files named by their ulid, e.g. created with rtnl keys generate"`
+	KeysReload      time.Duration     `split_words:"true" default:"5m" desc:"interval between reloading the keys directory to rotate signing keys (0 to disable)"`
+	KeysSignDelay   time.Duration     `split_words:"true" default:"10m" desc:"amount of time a new key is published for verification before it is used to sign tokens"`
 	Audience        string            `default:"https://rtnl.link" desc:"audience to add to rtnl jwt claims"`
 	Issuer          string            `default:"https://rtnl.link" desc:"issuer to add to rtnl jwt claims"`
 	AccessDuration  time.Duration     `split_words:"true" default:"1h" desc:"amount of time access tokens are valid"`
 	RefreshDuration time.Duration     `split_words:"true" default:"2h" desc:"amount of time refresh tokens are valid"`
 	RefreshOverlap  time.Duration     `split_words:"true" default:"-15m" desc:"validity period of refresh token while access token is"`
+	RefreshReuse    time.Duration     `split_words:"true" default:"30s" desc:"amount of time a used refresh token is still accepted from concurrent requests (0 for single use)"`
+	Roles           map[string]string `required:"false" desc:"roles assigned to web users by email (viewer, editor, or admin)"`
+	DefaultRole     string            `split_words:"true" default:"editor" desc:"the role of web users that have not been assigned a role"`
+	LocalAccounts   bool              `split_words:"true" default:"false" desc:"allow users with a password created by rtnl users add to log in with their email and password"`
+	LockoutAttempts int               `split_words:"true" default:"5" desc:"number of consecutive failed password logins before a local account is locked"`
+	LockoutDuration time.Duration     `split_words:"true" default:"15m" desc:"amount of time a local account is locked after too many failed logins"`
+	OIDC            OIDCConfig
+}
+
+// RateLimitConfig configures the in-memory token bucket rate limits for each group of
+// routes. Requests are limited by API key, logged-in user, or client IP address. The
+// rate is the average number of requests per second and the burst is the maximum
+// number of requests that can be made at once.
+type RateLimitConfig struct {
+	Enabled       bool    `default:"true" desc:"throttle requests by api key, user, or client ip address"`
+	RedirectRate  float64 `split_words:"true" default:"20" desc:"requests per second allowed to redirect short links"`
+	RedirectBurst int     `split_words:"true" default:"50" desc:"maximum burst of requests to redirect short links"`
+	ShortenRate   float64 `split_words:"true" default:"1" desc:"requests per second allowed to create short links"`
+	ShortenBurst  int     `split_words:"true" default:"10" desc:"maximum burst of requests to create short links"`
+	LoginRate     float64 `split_words:"true" default:"0.1" desc:"requests per second allowed to log in"`
+	LoginBurst    int     `split_words:"true" default:"5" desc:"maximum burst of requests to log in"`
+	APIRate       float64 `split_words:"true" default:"10" desc:"requests per second allowed to the v1 api"`
+	APIBurst      int     `split_words:"true" default:"30" desc:"maximum burst of requests to the v1 api"`
+}
+
+// OIDCConfig configures a generic OpenID Connect provider (e.g. Okta, Keycloak, or
+// Azure AD) that users can log in with using the authorization code flow with PKCE.
+// The claims in the provider's id token are mapped onto the rtnl claims by name.
+type OIDCConfig struct {
+	Enabled      bool              `default:"false" desc:"allow users to log in with an openid connect provider"`
+	Name         string            `default:"Single Sign-On" desc:"the name of the provider to display on the login page"`
+	Issuer       string            `desc:"the issuer url of the provider used to discover its endpoints"`
+	ClientID     string            `split_words:"true" desc:"the client id registered with the provider"`
+	ClientSecret string            `split_words:"true" desc:"the client secret registered with the provider (omit for public clients)"`
+	RedirectURL  string            `split_words:"true" desc:"the callback url registered with the provider, e.g. https://rtnl.link/login/oidc/callback"`
+	Scopes       []string          `default:"openid,email,profile" desc:"the scopes to request from the provider"`
+	EmailClaim   string            `split_words:"true" default:"email" desc:"the id token claim that contains the email address of the user"`
+	NameClaim    string            `split_words:"true" default:"name" desc:"the id token claim that contains the name of the user"`
+	PictureClaim string            `split_words:"true" default:"picture" desc:"the id token claim that contains the avatar url of the user"`
+	DomainClaim  string            `split_words:"true" desc:"the id token claim that contains the organization domain of the user, e.g. hd"`
+	TenantClaim  string            `split_words:"true" default:"tid" desc:"the id token claim that identifies the tenant of the user in the tenant mapping"`
+	Tenants      map[string]string `required:"false" desc:"the organization domain of each tenant id if the provider does not have a domain claim"`
 }
 
 // New creates and processes a Config from the environment ready for use. If the
@@ -60,6 +127,10 @@ func New() (conf Config, err error) {
 		return conf, err
 	}
 
+	if err = conf.Auth.setDomains(); err != nil {
+		return conf, err
+	}
+
 	// Ensure the Sentry release is set to rtnl.
 	// if conf.Sentry.Release == "" {
 	// 	conf.Sentry.Release = fmt.Sprintf("rtnl@%s", pkg.Version())
@@ -99,6 +170,103 @@ func (c Config) Validate() (err error) {
 		return fmt.Errorf("invalid configuration: %q is not a valid gin mode", c.Mode)
 	}
 
+	if err = c.Storage.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.Replica.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.Auth.OIDC.Validate(); err != nil {
+		return err
+	}
+
+	if err = c.RateLimit.Validate(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (c StorageConfig) Validate() error {
+	if c.GCInterval < 0 {
+		return fmt.Errorf("invalid configuration: gc interval cannot be negative")
+	}
+
+	if c.GCInterval > 0 && (c.GCDiscardRatio <= 0 || c.GCDiscardRatio >= 1) {
+		return fmt.Errorf("invalid configuration: gc discard ratio must be between 0 and 1")
+	}
+
+	if c.ExpireInterval < 0 {
+		return fmt.Errorf("invalid configuration: expire interval cannot be negative")
+	}
+	return nil
+}
+
+func (c ReplicaConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Primary == "" || c.APIKey == "" {
+		return fmt.Errorf("invalid configuration: replicas require a primary and an api key")
+	}
+
+	if c.MaxBackoff <= 0 {
+		return fmt.Errorf("invalid configuration: replica max backoff must be greater than zero")
+	}
+	return nil
+}
+
+func (c RateLimitConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.RedirectRate <= 0 || c.ShortenRate <= 0 || c.LoginRate <= 0 || c.APIRate <= 0 {
+		return fmt.Errorf("invalid configuration: rate limits must be greater than zero")
+	}
+
+	if c.RedirectBurst < 1 || c.ShortenBurst < 1 || c.LoginBurst < 1 || c.APIBurst < 1 {
+		return fmt.Errorf("invalid configuration: rate limit bursts must be at least one")
+	}
+	return nil
+}
+
+// The hd claim was replaced by the list of authorized domains; it is still accepted as
+// an alias so that existing deployments do not start allowing rotational.io users, but
+// it is an error to configure both since it is unclear which should take precedence.
+func (c *AuthConfig) setDomains() error {
+	if c.HDClaim != "" {
+		if len(c.Domains) > 0 {
+			return fmt.Errorf("invalid configuration: hd claim is deprecated and cannot be used with domains")
+		}
+		c.Domains = []string{c.HDClaim}
+	}
+
+	if len(c.Domains) == 0 {
+		c.Domains = []string{DefaultDomain}
+	}
+	return nil
+}
+
+func (c OIDCConfig) Validate() error {
+	if !c.Enabled {
+		return nil
+	}
+
+	if c.Issuer == "" || c.ClientID == "" || c.RedirectURL == "" {
+		return fmt.Errorf("invalid configuration: oidc requires an issuer, client id, and redirect url")
+	}
+
+	if c.EmailClaim == "" {
+		return fmt.Errorf("invalid configuration: oidc requires an email claim")
+	}
+
+	if c.DomainClaim == "" && (c.TenantClaim == "" || len(c.Tenants) == 0) {
+		return fmt.Errorf("invalid configuration: oidc requires a domain claim or tenant mapping")
+	}
 	return nil
 }
 
@@ -117,3 +285,55 @@ func (c *Config) MakeOriginURLs(sid string) (link string, alt string) {
 	}
 	return link, alt
 }
+
+// SafeRedirect validates the page that a user should be redirected to after logging in
+// to prevent open redirects. Paths on this server are returned as is and URLs are only
+// returned if they are on the origin or alt origin. An empty string is returned if the
+// redirect is not safe or if it would send the user back to the login or logout pages.
+func (c *Config) SafeRedirect(next string) string {
+	// Browsers treat backslashes as slashes so /\example.com is an external URL
+	if next == "" || strings.ContainsAny(next, "\\\r\n\t") {
+		return ""
+	}
+
+	u, err := url.Parse(next)
+	if err != nil || u.Opaque != "" || u.User != nil {
+		return ""
+	}
+
+	if u.Scheme != "" || u.Host != "" {
+		if !c.isOrigin(u) {
+			return ""
+		}
+	} else if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") {
+		return ""
+	}
+
+	if !strings.HasPrefix(u.Path, "/") {
+		u.Path = "/" + u.Path
+	}
+
+	path := strings.TrimSuffix(u.Path, "/")
+	if path == "/login" || strings.HasPrefix(path, "/login/") || path == "/logout" {
+		return ""
+	}
+
+	if u.Host == "" {
+		return u.RequestURI()
+	}
+	return u.String()
+}
+
+// Returns true if the scheme and host of the URL match the origin or alt origin.
+func (c *Config) isOrigin(u *url.URL) bool {
+	for _, origin := range []string{c.Origin, c.AltOrigin} {
+		if origin == "" {
+			continue
+		}
+
+		if o, err := url.Parse(origin); err == nil && strings.EqualFold(o.Scheme, u.Scheme) && strings.EqualFold(o.Host, u.Host) {
+			return true
+		}
+	}
+	return false
+}
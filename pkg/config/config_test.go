@@ -12,25 +12,67 @@ import (
 )
 
 var testEnv = map[string]string{
-	"RTNL_MAINTENANCE":           "true",
-	"RTNL_MODE":                  "test",
-	"RTNL_LOG_LEVEL":             "debug",
-	"RTNL_CONSOLE_LOG":           "true",
-	"RTNL_BIND_ADDR":             ":8888",
-	"RTNL_ALLOW_ORIGINS":         "http://localhost:8888",
-	"RTNL_ORIGIN":                "http://localhost:8888",
-	"RTNL_ALT_ORIGIN":            "http://127.0.0.1:8888",
-	"RTNL_STORAGE_READ_ONLY":     "true",
-	"RTNL_STORAGE_DATA_PATH":     "/data/db",
-	"RTNL_AUTH_GOOGLE_CLIENT_ID": "1234-testing.apps.googleusercontent.com",
-	"RTNL_AUTH_HD_CLAIM":         "example.com",
-	"RTNL_AUTH_COOKIE_DOMAIN":    "localhost",
-	"RTNL_AUTH_KEYS":             "123:/path/to/key.pem",
-	"RTNL_AUTH_AUDIENCE":         "http://localhost:8888",
-	"RTNL_AUTH_ISSUER":           "http://localhost:8888",
-	"RTNL_AUTH_ACCESS_DURATION":  "5m",
-	"RTNL_AUTH_REFRESH_DURATION": "15m",
-	"RTNL_AUTH_REFRESH_OVERLAP":  "-5m",
+	"RTNL_MAINTENANCE":               "true",
+	"RTNL_MODE":                      "test",
+	"RTNL_LOG_LEVEL":                 "debug",
+	"RTNL_CONSOLE_LOG":               "true",
+	"RTNL_BIND_ADDR":                 ":8888",
+	"RTNL_ALLOW_ORIGINS":             "http://localhost:8888",
+	"RTNL_TRUSTED_PROXIES":           "10.0.0.0/8,127.0.0.1",
+	"RTNL_ORIGIN":                    "http://localhost:8888",
+	"RTNL_ALT_ORIGIN":                "http://127.0.0.1:8888",
+	"RTNL_STORAGE_READ_ONLY":         "true",
+	"RTNL_STORAGE_DATA_PATH":         "/data/db",
+	"RTNL_STORAGE_MANUAL_MIGRATIONS": "true",
+	"RTNL_STORAGE_GC_INTERVAL":       "30m",
+	"RTNL_STORAGE_EXPIRE_INTERVAL":   "5m",
+	"RTNL_STORAGE_GC_DISCARD_RATIO":  "0.7",
+	"RTNL_REPLICA_ENABLED":           "true",
+	"RTNL_REPLICA_PRIMARY":           "https://rtnl.link",
+	"RTNL_REPLICA_API_KEY":           "abc-123",
+	"RTNL_REPLICA_MAX_BACKOFF":       "30s",
+	"RTNL_AUTH_GOOGLE_CLIENT_ID":     "1234-testing.apps.googleusercontent.com",
+	"RTNL_AUTH_DOMAINS":              "example.com,example.org",
+	"RTNL_AUTH_ALLOW":                "collaborator@gmail.com",
+	"RTNL_AUTH_DENY":                 "intern@example.com,contractors.example.com",
+	"RTNL_AUTH_COOKIE_DOMAIN":        "localhost",
+	"RTNL_AUTH_KEYS":                 "123:/path/to/key.pem",
+	"RTNL_AUTH_KEYS_DIR":             "/etc/rtnl/keys",
+	"RTNL_AUTH_KEYS_RELOAD":          "1m",
+	"RTNL_AUTH_KEYS_SIGN_DELAY":      "2m",
+	"RTNL_AUTH_AUDIENCE":             "http://localhost:8888",
+	"RTNL_AUTH_ISSUER":               "http://localhost:8888",
+	"RTNL_AUTH_ACCESS_DURATION":      "5m",
+	"RTNL_AUTH_REFRESH_DURATION":     "15m",
+	"RTNL_AUTH_REFRESH_OVERLAP":      "-5m",
+	"RTNL_AUTH_REFRESH_REUSE":        "10s",
+	"RTNL_AUTH_ROLES":                "jdoe@example.com:admin,asmith@example.com:editor",
+	"RTNL_AUTH_DEFAULT_ROLE":         "viewer",
+	"RTNL_AUTH_LOCAL_ACCOUNTS":       "true",
+	"RTNL_AUTH_LOCKOUT_ATTEMPTS":     "3",
+	"RTNL_AUTH_LOCKOUT_DURATION":     "1h",
+	"RTNL_AUTH_OIDC_ENABLED":         "true",
+	"RTNL_AUTH_OIDC_NAME":            "Okta",
+	"RTNL_AUTH_OIDC_ISSUER":          "https://example.okta.com",
+	"RTNL_AUTH_OIDC_CLIENT_ID":       "0oa1234",
+	"RTNL_AUTH_OIDC_CLIENT_SECRET":   "supersecret",
+	"RTNL_AUTH_OIDC_REDIRECT_URL":    "http://localhost:8888/login/oidc/callback",
+	"RTNL_AUTH_OIDC_SCOPES":          "openid,email",
+	"RTNL_AUTH_OIDC_EMAIL_CLAIM":     "upn",
+	"RTNL_AUTH_OIDC_NAME_CLAIM":      "preferred_username",
+	"RTNL_AUTH_OIDC_PICTURE_CLAIM":   "avatar",
+	"RTNL_AUTH_OIDC_DOMAIN_CLAIM":    "hd",
+	"RTNL_AUTH_OIDC_TENANT_CLAIM":    "tenant",
+	"RTNL_AUTH_OIDC_TENANTS":         "1234:example.com",
+	"RTNL_RATE_LIMIT_ENABLED":        "true",
+	"RTNL_RATE_LIMIT_REDIRECT_RATE":  "100",
+	"RTNL_RATE_LIMIT_REDIRECT_BURST": "200",
+	"RTNL_RATE_LIMIT_SHORTEN_RATE":   "0.5",
+	"RTNL_RATE_LIMIT_SHORTEN_BURST":  "5",
+	"RTNL_RATE_LIMIT_LOGIN_RATE":     "0.05",
+	"RTNL_RATE_LIMIT_LOGIN_BURST":    "3",
+	"RTNL_RATE_LIMIT_API_RATE":       "20",
+	"RTNL_RATE_LIMIT_API_BURST":      "40",
 }
 
 func TestConfig(t *testing.T) {
@@ -49,23 +91,130 @@ func TestConfig(t *testing.T) {
 	require.True(t, conf.ConsoleLog)
 	require.Equal(t, testEnv["RTNL_BIND_ADDR"], conf.BindAddr)
 	require.Equal(t, []string{testEnv["RTNL_ALLOW_ORIGINS"]}, conf.AllowOrigins)
+	require.Equal(t, []string{"10.0.0.0/8", "127.0.0.1"}, conf.TrustedProxies)
 	require.Equal(t, testEnv["RTNL_ORIGIN"], conf.Origin)
 	require.Equal(t, testEnv["RTNL_ALT_ORIGIN"], conf.AltOrigin)
 	require.True(t, conf.Storage.ReadOnly)
 	require.Equal(t, testEnv["RTNL_STORAGE_DATA_PATH"], conf.Storage.DataPath)
+	require.True(t, conf.Storage.ManualMigrations)
+	require.Equal(t, 30*time.Minute, conf.Storage.GCInterval)
+	require.Equal(t, 0.7, conf.Storage.GCDiscardRatio)
+	require.Equal(t, 5*time.Minute, conf.Storage.ExpireInterval)
+	require.True(t, conf.Replica.Enabled)
+	require.Equal(t, testEnv["RTNL_REPLICA_PRIMARY"], conf.Replica.Primary)
+	require.Equal(t, testEnv["RTNL_REPLICA_API_KEY"], conf.Replica.APIKey)
+	require.Equal(t, 30*time.Second, conf.Replica.MaxBackoff)
 	require.Equal(t, testEnv["RTNL_AUTH_GOOGLE_CLIENT_ID"], conf.Auth.GoogleClientID)
-	require.Equal(t, testEnv["RTNL_AUTH_HD_CLAIM"], conf.Auth.HDClaim)
+	require.Equal(t, []string{"example.com", "example.org"}, conf.Auth.Domains)
+	require.Equal(t, []string{"collaborator@gmail.com"}, conf.Auth.Allow)
+	require.Equal(t, []string{"intern@example.com", "contractors.example.com"}, conf.Auth.Deny)
 	require.Equal(t, testEnv["RTNL_AUTH_COOKIE_DOMAIN"], conf.Auth.CookieDomain)
+	require.Equal(t, testEnv["RTNL_AUTH_KEYS_DIR"], conf.Auth.KeysDir)
+	require.Equal(t, 1*time.Minute, conf.Auth.KeysReload)
+	require.Equal(t, 2*time.Minute, conf.Auth.KeysSignDelay)
 	require.Equal(t, testEnv["RTNL_AUTH_AUDIENCE"], conf.Auth.Audience)
 	require.Equal(t, testEnv["RTNL_AUTH_ISSUER"], conf.Auth.Issuer)
 	require.Equal(t, 5*time.Minute, conf.Auth.AccessDuration)
 	require.Equal(t, 15*time.Minute, conf.Auth.RefreshDuration)
 	require.Equal(t, -5*time.Minute, conf.Auth.RefreshOverlap)
+	require.Equal(t, 10*time.Second, conf.Auth.RefreshReuse)
+	require.Equal(t, map[string]string{"jdoe@example.com": "admin", "asmith@example.com": "editor"}, conf.Auth.Roles)
+	require.Equal(t, testEnv["RTNL_AUTH_DEFAULT_ROLE"], conf.Auth.DefaultRole)
+	require.True(t, conf.Auth.LocalAccounts)
+	require.Equal(t, 3, conf.Auth.LockoutAttempts)
+	require.Equal(t, 1*time.Hour, conf.Auth.LockoutDuration)
+	require.True(t, conf.Auth.OIDC.Enabled)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_NAME"], conf.Auth.OIDC.Name)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_ISSUER"], conf.Auth.OIDC.Issuer)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_CLIENT_ID"], conf.Auth.OIDC.ClientID)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_CLIENT_SECRET"], conf.Auth.OIDC.ClientSecret)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_REDIRECT_URL"], conf.Auth.OIDC.RedirectURL)
+	require.Equal(t, []string{"openid", "email"}, conf.Auth.OIDC.Scopes)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_EMAIL_CLAIM"], conf.Auth.OIDC.EmailClaim)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_NAME_CLAIM"], conf.Auth.OIDC.NameClaim)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_PICTURE_CLAIM"], conf.Auth.OIDC.PictureClaim)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_DOMAIN_CLAIM"], conf.Auth.OIDC.DomainClaim)
+	require.Equal(t, testEnv["RTNL_AUTH_OIDC_TENANT_CLAIM"], conf.Auth.OIDC.TenantClaim)
+	require.Equal(t, map[string]string{"1234": "example.com"}, conf.Auth.OIDC.Tenants)
+	require.True(t, conf.RateLimit.Enabled)
+	require.Equal(t, 100.0, conf.RateLimit.RedirectRate)
+	require.Equal(t, 200, conf.RateLimit.RedirectBurst)
+	require.Equal(t, 0.5, conf.RateLimit.ShortenRate)
+	require.Equal(t, 5, conf.RateLimit.ShortenBurst)
+	require.Equal(t, 0.05, conf.RateLimit.LoginRate)
+	require.Equal(t, 3, conf.RateLimit.LoginBurst)
+	require.Equal(t, 20.0, conf.RateLimit.APIRate)
+	require.Equal(t, 40, conf.RateLimit.APIBurst)
+
+	// Web users that have not been assigned a role can create links by default
+	os.Unsetenv("RTNL_AUTH_DEFAULT_ROLE")
+	conf, err = config.New()
+	require.NoError(t, err, "could not process configuration from the environment")
+	require.Equal(t, "editor", conf.Auth.DefaultRole)
 
 	// Ensure the sentry release is correctly set
 	// require.True(t, strings.HasPrefix(conf.Sentry.GetRelease(), "rtnl@"))
 }
 
+func TestAuthDomains(t *testing.T) {
+	testCases := []struct {
+		domains  string
+		hdClaim  string
+		expected []string
+		err      string
+	}{
+		{"", "", []string{config.DefaultDomain}, ""},
+		{"example.com,example.org", "", []string{"example.com", "example.org"}, ""},
+		{"", "example.com", []string{"example.com"}, ""},
+		{"example.org", "example.com", nil, "invalid configuration: hd claim is deprecated and cannot be used with domains"},
+	}
+
+	for i, tc := range testCases {
+		setenv(t, "RTNL_AUTH_DOMAINS", tc.domains)
+		setenv(t, "RTNL_AUTH_HD_CLAIM", tc.hdClaim)
+
+		conf, err := config.New()
+		if tc.err != "" {
+			require.EqualError(t, err, tc.err, "test case %d", i)
+			continue
+		}
+
+		require.NoError(t, err, "test case %d", i)
+		require.Equal(t, tc.expected, conf.Auth.Domains, "test case %d", i)
+	}
+}
+
+// Set the environment variable for the duration of the test or unset it if empty.
+func setenv(t *testing.T, key, val string) {
+	t.Setenv(key, val)
+	if val == "" {
+		os.Unsetenv(key)
+	}
+}
+
+func TestStorageConfigValidate(t *testing.T) {
+	testCases := []struct {
+		conf config.StorageConfig
+		err  string
+	}{
+		{config.StorageConfig{GCInterval: time.Hour, GCDiscardRatio: 0.5}, ""},
+		{config.StorageConfig{GCInterval: 0, GCDiscardRatio: 0}, ""},
+		{config.StorageConfig{GCInterval: -1 * time.Minute, GCDiscardRatio: 0.5}, "invalid configuration: gc interval cannot be negative"},
+		{config.StorageConfig{GCInterval: time.Hour, GCDiscardRatio: 0}, "invalid configuration: gc discard ratio must be between 0 and 1"},
+		{config.StorageConfig{ExpireInterval: -1 * time.Minute}, "invalid configuration: expire interval cannot be negative"},
+		{config.StorageConfig{GCInterval: time.Hour, GCDiscardRatio: 1.0}, "invalid configuration: gc discard ratio must be between 0 and 1"},
+	}
+
+	for i, tc := range testCases {
+		err := tc.conf.Validate()
+		if tc.err == "" {
+			require.NoError(t, err, "expected test case %d to be valid", i)
+		} else {
+			require.EqualError(t, err, tc.err, "expected test case %d to be invalid", i)
+		}
+	}
+}
+
 // Returns the current environment for the specified keys, or if no keys are specified
 // then it returns the current environment for all keys in the testEnv variable.
 func curEnv(keys ...string) map[string]string {
@@ -115,3 +264,79 @@ func cleanupEnv(keys ...string) func() {
 		}
 	}
 }
+
+func TestOIDCConfigValidate(t *testing.T) {
+	testCases := []struct {
+		conf config.OIDCConfig
+		err  string
+	}{
+		{config.OIDCConfig{}, ""},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email", DomainClaim: "hd"}, ""},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email", TenantClaim: "tid", Tenants: map[string]string{"1234": "example.com"}}, ""},
+		{config.OIDCConfig{Enabled: true, ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email", DomainClaim: "hd"}, "invalid configuration: oidc requires an issuer, client id, and redirect url"},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email", DomainClaim: "hd"}, "invalid configuration: oidc requires an issuer, client id, and redirect url"},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", EmailClaim: "email", DomainClaim: "hd"}, "invalid configuration: oidc requires an issuer, client id, and redirect url"},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", DomainClaim: "hd"}, "invalid configuration: oidc requires an email claim"},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email"}, "invalid configuration: oidc requires a domain claim or tenant mapping"},
+		{config.OIDCConfig{Enabled: true, Issuer: "https://example.okta.com", ClientID: "0oa1234", RedirectURL: "https://rtnl.link/login/oidc/callback", EmailClaim: "email", TenantClaim: "tid"}, "invalid configuration: oidc requires a domain claim or tenant mapping"},
+	}
+
+	for i, tc := range testCases {
+		err := tc.conf.Validate()
+		if tc.err == "" {
+			require.NoError(t, err, "expected test case %d to be valid", i)
+		} else {
+			require.EqualError(t, err, tc.err, "expected test case %d to be invalid", i)
+		}
+	}
+}
+
+func TestRateLimitConfigValidate(t *testing.T) {
+	valid := config.RateLimitConfig{Enabled: true, RedirectRate: 20, RedirectBurst: 50, ShortenRate: 1, ShortenBurst: 10, LoginRate: 0.1, LoginBurst: 5, APIRate: 10, APIBurst: 30}
+	require.NoError(t, valid.Validate())
+	require.NoError(t, config.RateLimitConfig{}.Validate(), "disabled rate limits should not be validated")
+
+	conf := valid
+	conf.LoginRate = 0
+	require.EqualError(t, conf.Validate(), "invalid configuration: rate limits must be greater than zero")
+
+	conf = valid
+	conf.APIBurst = 0
+	require.EqualError(t, conf.Validate(), "invalid configuration: rate limit bursts must be at least one")
+}
+
+func TestSafeRedirect(t *testing.T) {
+	conf := &config.Config{Origin: "https://rtnl.link", AltOrigin: "https://r8l.co"}
+	testCases := []struct {
+		next     string
+		expected string
+	}{
+		{"", ""},
+		{"/", "/"},
+		{"/abc/info", "/abc/info"},
+		{"/links?mine=true", "/links?mine=true"},
+		{"https://rtnl.link/abc/info", "https://rtnl.link/abc/info"},
+		{"https://R8L.co/abc/info", "https://R8L.co/abc/info"},
+		{"https://rtnl.link", "https://rtnl.link/"},
+		{"https://example.com/abc", ""},
+		{"http://rtnl.link/abc", ""},
+		{"https://rtnl.link.example.com/abc", ""},
+		{"https://user@rtnl.link/abc", ""},
+		{"//example.com/abc", ""},
+		{"///example.com/abc", ""},
+		{"/\\example.com", ""},
+		{"\\\\example.com", ""},
+		{"abc/info", ""},
+		{"javascript:alert(1)", ""},
+		{"/abc\n/info", ""},
+		{"/login", ""},
+		{"/login/oidc/callback", ""},
+		{"/logout", ""},
+		{"https://rtnl.link/logout", ""},
+		{"/loginpage", "/loginpage"},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, conf.SafeRedirect(tc.next), "unexpected redirect for %q", tc.next)
+	}
+}
@@ -0,0 +1,154 @@
+/*
+Package ratelimit implements in-memory token bucket rate limiting for a single node.
+Each key (e.g. an API key, user, or client IP address) has its own bucket that holds up
+to burst tokens and is refilled at a constant rate; a request is allowed if it can take
+a token from its bucket. Buckets that have been refilled are removed periodically so
+that the memory used by the limiter is proportional to the number of active keys; the
+number of buckets is also capped so that a flood of distinct keys cannot exhaust memory,
+evicting the least recently used bucket when the limiter is full.
+*/
+package ratelimit
+
+import (
+	"container/list"
+	"math"
+	"sync"
+	"time"
+)
+
+// How often buckets that have been completely refilled are removed from the limiter.
+const sweepInterval = 1 * time.Minute
+
+// DefaultCapacity is the maximum number of buckets held by a limiter created with New.
+const DefaultCapacity = 100000
+
+// Limiter maintains a token bucket for each key that is rate limited.
+type Limiter struct {
+	sync.Mutex
+	rate     float64
+	burst    int
+	capacity int
+	buckets  map[string]*list.Element
+	recent   *list.List // buckets ordered from the most to the least recently used
+	swept    time.Time
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// Result describes the state of the bucket after a request so that rate limit headers
+// can be returned to the client.
+type Result struct {
+	Allowed    bool          // True if the request is allowed
+	Limit      int           // The maximum number of requests that can be made at once
+	Remaining  int           // The number of requests that can be made immediately
+	Reset      time.Duration // The amount of time until the bucket is completely refilled
+	RetryAfter time.Duration // The amount of time until the next request is allowed
+}
+
+// New creates a limiter that allows rate requests per second on average with bursts of
+// up to burst requests for each key.
+func New(rate float64, burst int) *Limiter {
+	return NewWithCapacity(rate, burst, DefaultCapacity)
+}
+
+// NewWithCapacity creates a limiter that holds at most capacity buckets; when a new key
+// is limited and the limiter is full, the least recently used bucket is evicted.
+func NewWithCapacity(rate float64, burst, capacity int) *Limiter {
+	return &Limiter{
+		rate:     rate,
+		burst:    burst,
+		capacity: capacity,
+		buckets:  make(map[string]*list.Element),
+		recent:   list.New(),
+		swept:    time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key if one is available.
+func (l *Limiter) Allow(key string) Result {
+	return l.AllowAt(key, time.Now())
+}
+
+// AllowAt takes a token from the bucket of the key at the specified time; times that
+// are before the last request for the key are treated as the time of the last request.
+func (l *Limiter) AllowAt(key string, now time.Time) (res Result) {
+	l.Lock()
+	defer l.Unlock()
+
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	var b *bucket
+	if elem, ok := l.buckets[key]; ok {
+		b = elem.Value.(*bucket)
+		l.recent.MoveToFront(elem)
+	} else {
+		if l.capacity > 0 && len(l.buckets) >= l.capacity {
+			l.evict()
+		}
+
+		b = &bucket{key: key, tokens: float64(l.burst), updated: now}
+		l.buckets[key] = l.recent.PushFront(b)
+	}
+	b.refill(now, l.rate, l.burst)
+
+	res.Limit = l.burst
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = l.duration(1 - b.tokens)
+	}
+
+	res.Remaining = int(math.Floor(b.tokens))
+	res.Reset = l.duration(float64(l.burst) - b.tokens)
+	return res
+}
+
+// Len returns the number of keys that currently have a bucket in the limiter.
+func (l *Limiter) Len() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.buckets)
+}
+
+// Removes buckets that would be full at the specified time since a new bucket for the
+// key would be identical; must be called while holding the lock.
+func (l *Limiter) sweep(now time.Time) {
+	for key, elem := range l.buckets {
+		b := elem.Value.(*bucket)
+		if b.refill(now, l.rate, l.burst); b.tokens >= float64(l.burst) {
+			l.recent.Remove(elem)
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
+
+// Removes the least recently used bucket; must be called while holding the lock.
+func (l *Limiter) evict() {
+	if elem := l.recent.Back(); elem != nil {
+		l.recent.Remove(elem)
+		delete(l.buckets, elem.Value.(*bucket).key)
+	}
+}
+
+// Returns the amount of time it takes to refill the specified number of tokens.
+func (l *Limiter) duration(tokens float64) time.Duration {
+	if tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens / l.rate * float64(time.Second)))
+}
+
+func (b *bucket) refill(now time.Time, rate float64, burst int) {
+	if elapsed := now.Sub(b.updated); elapsed > 0 {
+		b.tokens = math.Min(float64(burst), b.tokens+elapsed.Seconds()*rate)
+		b.updated = now
+	}
+}
@@ -0,0 +1,136 @@
+package ratelimit_test
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/ratelimit"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := ratelimit.New(2, 3)
+	now := time.Date(2023, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	// The bucket starts full so that a burst of requests is allowed
+	for i := 2; i >= 0; i-- {
+		res := limiter.AllowAt("alice", now)
+		require.True(t, res.Allowed, "expected request to be allowed during burst")
+		require.Equal(t, 3, res.Limit)
+		require.Equal(t, i, res.Remaining)
+	}
+
+	// Once the bucket is empty requests are rejected until a token is refilled
+	res := limiter.AllowAt("alice", now)
+	require.False(t, res.Allowed, "expected request to be rejected when bucket is empty")
+	require.Equal(t, 0, res.Remaining)
+	require.Equal(t, 500*time.Millisecond, res.RetryAfter)
+	require.Equal(t, 1500*time.Millisecond, res.Reset)
+
+	// Other keys have their own buckets
+	require.True(t, limiter.AllowAt("bob", now).Allowed, "expected other key to be allowed")
+
+	// Tokens are refilled at the rate
+	res = limiter.AllowAt("alice", now.Add(250*time.Millisecond))
+	require.False(t, res.Allowed)
+	require.Equal(t, 250*time.Millisecond, res.RetryAfter)
+
+	res = limiter.AllowAt("alice", now.Add(500*time.Millisecond))
+	require.True(t, res.Allowed, "expected request to be allowed after refill")
+	require.Zero(t, res.RetryAfter)
+
+	// The bucket cannot hold more than the burst
+	res = limiter.AllowAt("alice", now.Add(1*time.Hour))
+	require.True(t, res.Allowed)
+	require.Equal(t, 2, res.Remaining)
+	require.Equal(t, 500*time.Millisecond, res.Reset)
+
+	// Requests in the past do not refill the bucket
+	res = limiter.AllowAt("alice", now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+}
+
+func TestLimiterSweep(t *testing.T) {
+	// One token is refilled every 100 seconds
+	limiter := ratelimit.New(0.01, 5)
+	now := time.Now()
+
+	limiter.AllowAt("alice", now)
+	limiter.AllowAt("bob", now)
+	require.Equal(t, 2, limiter.Len())
+
+	// Buckets are not removed until the sweep interval has passed
+	limiter.AllowAt("carol", now.Add(30*time.Second))
+	require.Equal(t, 3, limiter.Len())
+
+	// Buckets that have been refilled are removed when the limiter is swept
+	limiter.AllowAt("bob", now.Add(2*time.Minute))
+	require.Equal(t, 2, limiter.Len(), "expected alice's full bucket to be removed")
+
+	// Buckets that are not full are kept
+	limiter.AllowAt("alice", now.Add(2*time.Minute))
+	limiter.AllowAt("alice", now.Add(2*time.Minute))
+	limiter.AllowAt("carol", now.Add(4*time.Minute))
+	require.Equal(t, 2, limiter.Len(), "expected bob's full bucket to be removed and alice's to be kept")
+}
+
+func TestLimiterCapacity(t *testing.T) {
+	limiter := ratelimit.NewWithCapacity(0.01, 2, 3)
+	now := time.Now()
+
+	// Empty alice's bucket so that evicting it would reset her limit
+	limiter.AllowAt("alice", now)
+	limiter.AllowAt("alice", now)
+	require.False(t, limiter.AllowAt("alice", now).Allowed)
+
+	limiter.AllowAt("bob", now)
+	limiter.AllowAt("carol", now)
+	require.Equal(t, 3, limiter.Len())
+
+	// Using alice's bucket makes bob's the least recently used bucket
+	limiter.AllowAt("alice", now)
+
+	// New keys evict the least recently used bucket rather than growing the limiter
+	for _, key := range []string{"dave", "erin"} {
+		limiter.AllowAt(key, now)
+		require.Equal(t, 3, limiter.Len(), "expected the limiter to be capped")
+	}
+
+	// Alice's bucket was not evicted since it was used recently so she is still limited
+	require.False(t, limiter.AllowAt("alice", now).Allowed, "recently used bucket was evicted")
+
+	// Bob's bucket was evicted so it starts full again
+	res := limiter.AllowAt("bob", now)
+	require.True(t, res.Allowed)
+	require.Equal(t, 1, res.Remaining)
+}
+
+func TestLimiterConcurrency(t *testing.T) {
+	limiter := ratelimit.New(0.001, 100)
+	now := time.Now()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if limiter.AllowAt("alice", now).Allowed {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	require.Equal(t, 100, allowed, "expected exactly the burst to be allowed")
+}
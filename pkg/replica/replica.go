@@ -0,0 +1,251 @@
+/*
+Package replica implements read-only followers that replicate the database of a primary
+rtnl server so that redirect-only replicas can be run close to users.
+
+A follower connects to the replication endpoint of the primary using a websocket that is
+authenticated with an API key with the replicate scope, specifying the version of the
+primary that it has already applied. The primary sends a snapshot of every key that has changed since that version as
+binary messages containing a badger KVList, followed by text messages containing a JSON
+Manifest of every key on the primary, and a JSON Checkpoint with the version of the
+snapshot. After the checkpoint, every change to the primary is sent as it happens. Keys
+with an empty value are deletes.
+
+Badger discards deleted keys during compaction, so a follower that has been disconnected
+for a long time may not receive the deletes that happened while it was away. When the
+checkpoint is received, the follower removes every key that was not in the manifest.
+
+Followers only serve redirects, so users, sessions, API keys, usage, and the audit log
+are not replicated; a follower cannot be used to recover those from the primary.
+*/
+package replica
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// Endpoint is the path of the replication websocket on the primary.
+	Endpoint = "/v1/replicate"
+
+	// SinceParam is the query parameter that specifies the version already applied.
+	SinceParam = "since"
+
+	// The initial amount of time to wait before reconnecting to the primary.
+	minBackoff = 1 * time.Second
+)
+
+// Checkpoint is sent by the primary when the snapshot is complete.
+type Checkpoint struct {
+	Version uint64 `json:"version"`
+}
+
+// Manifest is sent by the primary in batches after the snapshot with the keys that
+// exist on the primary.
+type Manifest struct {
+	Keys [][]byte `json:"keys"`
+}
+
+// Store is the storage a follower applies changes from the primary to.
+type Store interface {
+	Apply(*pb.KVList) error
+	Prune(manifest map[string]struct{}) (int, error)
+	Checkpoint(version uint64) error
+	Applied() (uint64, error)
+}
+
+// Follower consumes the replication stream from the primary and applies it locally.
+type Follower struct {
+	conf     config.ReplicaConfig
+	store    Store
+	endpoint *url.URL
+	dialer   *websocket.Dialer
+}
+
+// New creates a follower that replicates from the primary in the configuration.
+func New(conf config.ReplicaConfig, store Store) (f *Follower, err error) {
+	f = &Follower{
+		conf:   conf,
+		store:  store,
+		dialer: websocket.DefaultDialer,
+	}
+
+	if f.endpoint, err = url.Parse(conf.Primary); err != nil {
+		return nil, fmt.Errorf("could not parse primary endpoint: %w", err)
+	}
+
+	switch f.endpoint.Scheme {
+	case "https", "wss":
+		f.endpoint.Scheme = "wss"
+	case "http", "ws":
+		f.endpoint.Scheme = "ws"
+	default:
+		return nil, fmt.Errorf("unhandled primary endpoint scheme %q", f.endpoint.Scheme)
+	}
+
+	f.endpoint = f.endpoint.ResolveReference(&url.URL{Path: Endpoint})
+	return f, nil
+}
+
+// Run replicates from the primary until the context is done, reconnecting with an
+// exponential backoff whenever the connection to the primary is lost.
+func (f *Follower) Run(ctx context.Context) {
+	backoff := minBackoff
+	for {
+		connected, err := f.Sync(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+
+		// Reset the backoff if the follower was able to connect to the primary
+		if connected {
+			backoff = minBackoff
+		}
+
+		log.Warn().Err(err).Dur("backoff", backoff).Msg("replication interrupted")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > f.conf.MaxBackoff {
+			backoff = f.conf.MaxBackoff
+		}
+	}
+}
+
+// Sync connects to the primary and applies changes until the connection is closed or
+// the context is done. Connected is true if the connection to the primary was opened.
+func (f *Follower) Sync(ctx context.Context) (connected bool, err error) {
+	var applied uint64
+	if applied, err = f.store.Applied(); err != nil {
+		return false, err
+	}
+
+	endpoint := *f.endpoint
+	endpoint.RawQuery = url.Values{SinceParam: []string{strconv.FormatUint(applied, 10)}}.Encode()
+
+	header := make(http.Header)
+	header.Set("Authorization", "Bearer "+f.conf.APIKey)
+
+	var (
+		conn *websocket.Conn
+		rep  *http.Response
+	)
+	if conn, rep, err = f.dialer.DialContext(ctx, endpoint.String(), header); err != nil {
+		if rep != nil {
+			return false, fmt.Errorf("could not connect to primary: %s", rep.Status)
+		}
+		return false, err
+	}
+
+	// Close the connection when the context is done to interrupt reads
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	log.Info().Uint64("since", applied).Msg("replicating from primary")
+	snapshot := true
+	manifest := make(map[string]struct{})
+	for {
+		var (
+			mt   int
+			data []byte
+		)
+
+		if mt, data, err = conn.ReadMessage(); err != nil {
+			return true, err
+		}
+
+		switch mt {
+		case websocket.BinaryMessage:
+			kvs := &pb.KVList{}
+			if err = kvs.Unmarshal(data); err != nil {
+				return true, fmt.Errorf("could not unmarshal changes: %w", err)
+			}
+
+			if err = f.store.Apply(kvs); err != nil {
+				return true, fmt.Errorf("could not apply changes: %w", err)
+			}
+
+			// Snapshots are not ordered by version so the applied version can only be
+			// recorded after the snapshot checkpoint is received.
+			if !snapshot {
+				if version := maxVersion(kvs); version > applied {
+					if err = f.store.Checkpoint(version); err != nil {
+						return true, err
+					}
+					applied = version
+				}
+			}
+
+		case websocket.TextMessage:
+			msg := &struct {
+				*Checkpoint
+				*Manifest
+			}{}
+
+			if err = json.Unmarshal(data, msg); err != nil {
+				return true, fmt.Errorf("could not unmarshal control message: %w", err)
+			}
+
+			if msg.Manifest != nil {
+				for _, key := range msg.Keys {
+					manifest[string(key)] = struct{}{}
+				}
+				continue
+			}
+
+			if msg.Checkpoint == nil || !snapshot {
+				return true, errors.New("unexpected control message from primary")
+			}
+
+			var pruned int
+			if pruned, err = f.store.Prune(manifest); err != nil {
+				return true, fmt.Errorf("could not prune deleted keys: %w", err)
+			}
+			manifest = nil
+
+			checkpoint := msg.Checkpoint
+			if checkpoint.Version > applied {
+				if err = f.store.Checkpoint(checkpoint.Version); err != nil {
+					return true, err
+				}
+				applied = checkpoint.Version
+			}
+
+			snapshot = false
+			log.Info().Uint64("version", applied).Int("pruned", pruned).Msg("replica snapshot applied")
+
+		default:
+			return true, errors.New("unexpected message type from primary")
+		}
+	}
+}
+
+func maxVersion(kvs *pb.KVList) (version uint64) {
+	for _, kv := range kvs.Kv {
+		if kv.Version > version {
+			version = kv.Version
+		}
+	}
+	return version
+}
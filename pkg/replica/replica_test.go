@@ -0,0 +1,101 @@
+package replica_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/replica"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFollower(t *testing.T) {
+	link := &models.ShortURL{ID: 42, URL: "https://rotational.io"}
+	value, err := link.MarshalValue()
+	require.NoError(t, err)
+
+	created := &models.ShortURL{ID: 43, URL: "https://rotational.io/blog"}
+	createdValue, err := created.MarshalValue()
+	require.NoError(t, err)
+
+	// A link that was deleted on the primary but whose delete was never replicated
+	stale := &models.ShortURL{ID: 7, URL: "https://rotational.io/stale"}
+	staleValue, err := stale.MarshalValue()
+	require.NoError(t, err)
+
+	// The mock primary sends a snapshot, a manifest, a checkpoint, then a change and closes.
+	var since string
+	upgrader := websocket.Upgrader{}
+	primary := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		require.Equal(t, replica.Endpoint, r.URL.Path)
+		require.Equal(t, "Bearer testing", r.Header.Get("Authorization"))
+		since = r.URL.Query().Get(replica.SinceParam)
+
+		conn, err := upgrader.Upgrade(w, r, nil)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		snapshot := &pb.KVList{Kv: []*pb.KV{{Key: link.Key(), Value: value, Version: 7}}}
+		data, _ := snapshot.Marshal()
+		conn.WriteMessage(websocket.BinaryMessage, data)
+		conn.WriteJSON(&replica.Manifest{Keys: [][]byte{link.Key()}})
+		conn.WriteJSON(&replica.Checkpoint{Version: 5})
+
+		changes := &pb.KVList{Kv: []*pb.KV{{Key: created.Key(), Value: createdValue, Version: 9}}}
+		data, _ = changes.Marshal()
+		conn.WriteMessage(websocket.BinaryMessage, data)
+		conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	defer primary.Close()
+
+	store, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err)
+	defer store.Close()
+	require.NoError(t, store.Apply(&pb.KVList{Kv: []*pb.KV{{Key: stale.Key(), Value: staleValue}}}))
+
+	conf := config.ReplicaConfig{Enabled: true, Primary: primary.URL, APIKey: "testing", MaxBackoff: time.Second}
+	follower, err := replica.New(conf, store)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	connected, err := follower.Sync(ctx)
+	require.True(t, connected, "follower did not connect to the primary")
+	require.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), "unexpected error %v", err)
+	require.Equal(t, "0", since)
+
+	// Keys that are not in the manifest should be pruned from the follower
+	_, err = store.LoadInfo(link.ID)
+	require.NoError(t, err, "link in the manifest was pruned")
+
+	_, err = store.LoadInfo(stale.ID)
+	require.ErrorIs(t, err, storage.ErrNotFound, "stale link was not pruned")
+
+	// The change after the checkpoint should be applied and its version recorded
+	_, err = store.LoadInfo(created.ID)
+	require.NoError(t, err, "change after the checkpoint was not applied")
+
+	applied, err := store.Applied()
+	require.NoError(t, err)
+	require.Equal(t, uint64(9), applied)
+
+	// The follower should resume from the applied version
+	follower.Sync(ctx)
+	require.Equal(t, "9", since)
+}
+
+func TestNew(t *testing.T) {
+	_, err := replica.New(config.ReplicaConfig{Primary: "ftp://rtnl.link"}, nil)
+	require.Error(t, err, "expected unhandled scheme error")
+
+	_, err = replica.New(config.ReplicaConfig{Primary: "https://rtnl.link"}, nil)
+	require.NoError(t, err)
+}
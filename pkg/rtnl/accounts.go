@@ -0,0 +1,97 @@
+package rtnl
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/passwd"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/rs/zerolog/log"
+)
+
+// The subject of the claims of local accounts is prefixed so that it cannot collide
+// with the subject of users that log in with an identity provider.
+const localSubjectPrefix = "local:"
+
+// A derived key that is verified when a user does not exist so that the response time
+// of the login does not reveal which email addresses have local accounts.
+var (
+	dummyKey     string
+	dummyKeyOnce sync.Once
+)
+
+// CheckPassword verifies the email address and password of a local account and creates
+// claims for the user. Failed logins are counted and the account is locked for the
+// lockout duration after too many consecutive failures; locked, disabled, and denied
+// accounts cannot log in even with the correct password.
+func (s *Server) CheckPassword(c *gin.Context, email, password string) (_ *auth.Claims, err error) {
+	if !s.conf.Auth.LocalAccounts {
+		return nil, api.ErrLocalAccounts
+	}
+
+	var user *models.User
+	if user, err = s.db.RetrieveUser(email); err != nil || !user.IsLocal() {
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			log.Warn().Err(err).Msg("could not retrieve user from the database")
+		}
+
+		dummyKeyOnce.Do(func() { dummyKey, _ = passwd.CreateDerivedKey("") })
+		passwd.VerifyDerivedKey(dummyKey, password)
+		return nil, api.ErrInvalidCredentials
+	}
+
+	// Locked accounts return the same error as invalid credentials so that the response
+	// does not reveal which email addresses have local accounts.
+	if user.IsLocked() {
+		log.Info().Str("email", user.Email).Time("locked_until", user.LockedUntil).Msg("login attempted on locked local account")
+		return nil, api.ErrInvalidCredentials
+	}
+
+	// Disabled accounts cannot log in and do not accumulate failed logins
+	if user.IsDisabled() {
+		return nil, api.ErrInvalidCredentials
+	}
+
+	// Local accounts are subject to the same denylist as identity provider logins
+	if err = s.auth.AuthorizeLocal(user.Email); err != nil {
+		log.Info().Err(err).Str("email", user.Email).Msg("local account denied by login policy")
+		return nil, api.ErrInvalidCredentials
+	}
+
+	var verified bool
+	if verified, err = passwd.VerifyDerivedKey(user.DerivedKey, password); err != nil {
+		log.Error().Err(err).Str("email", user.Email).Msg("could not verify derived key")
+		return nil, api.ErrInvalidCredentials
+	}
+
+	if !verified {
+		if user, err = s.db.LoginFailed(user.Email, s.conf.Auth.LockoutAttempts, s.conf.Auth.LockoutDuration); err != nil {
+			log.Warn().Err(err).Str("email", email).Msg("could not record failed login")
+			return nil, api.ErrInvalidCredentials
+		}
+
+		if user.IsLocked() {
+			log.Info().Str("email", user.Email).Time("locked_until", user.LockedUntil).Msg("local account locked after too many failed logins")
+			s.Audit(c, user.Email, models.ActionUserLocked, user.Email, "", "locked_until="+user.LockedUntil.Format(time.RFC3339))
+		}
+		return nil, api.ErrInvalidCredentials
+	}
+
+	if err = s.db.LoginSucceeded(user.Email); err != nil {
+		log.Warn().Err(err).Str("email", user.Email).Msg("could not record successful login")
+	}
+
+	return &auth.Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject: localSubjectPrefix + user.Email,
+		},
+		Email: user.Email,
+		Name:  user.Name,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package rtnl_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDisabledAccount(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("RTNL_AUTH_LOCKOUT_ATTEMPTS", "2")
+	conf := testConfig(t)
+
+	var token string
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor, Disabled: time.Now()}, "supersecretsquirrel")
+		token = createAPIKey(t, db, &models.APIKey{Name: "laptop", Owner: "jdoe@example.com", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+
+	// Failed logins to a disabled account are not counted so it is never locked
+	for i := 0; i < 3; i++ {
+		out := &api.Reply{}
+		rep := request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: "jdoe@example.com", Password: "wrongpassword"}, out)
+		require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+		require.Equal(t, api.ErrInvalidCredentials.Error(), out.Error)
+	}
+
+	// A disabled account cannot log in even with the correct password
+	out := &api.Reply{}
+	rep := request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: "jdoe@example.com", Password: "supersecretsquirrel"}, out)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+	require.Equal(t, api.ErrInvalidCredentials.Error(), out.Error)
+
+	// The personal access tokens of a disabled account cannot be used
+	rep = request(t, srv, http.MethodGet, "/v1/stats", token, nil, nil)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+}
+
+func TestLockedAccount(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("RTNL_AUTH_LOCKOUT_ATTEMPTS", "2")
+	conf := testConfig(t)
+
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+	})
+
+	srv, _ := start(t, conf)
+
+	for i := 0; i < 2; i++ {
+		out := &api.Reply{}
+		rep := request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: "jdoe@example.com", Password: "wrongpassword"}, out)
+		require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+		require.Equal(t, api.ErrInvalidCredentials.Error(), out.Error)
+	}
+
+	// A locked account cannot log in and is indistinguishable from an unknown account
+	for _, email := range []string{"jdoe@example.com", "unknown@example.com"} {
+		out := &api.Reply{}
+		rep := request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: email, Password: "supersecretsquirrel"}, out)
+		require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+		require.Equal(t, api.ErrInvalidCredentials.Error(), out.Error)
+	}
+}
+
+func TestDeniedAccount(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("RTNL_AUTH_DENY", "contractors.example.com")
+	conf := testConfig(t)
+
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@contractors.example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+		createUser(t, db, &models.User{Email: "asmith@gmail.com", Name: "Alan Smith", Role: auth.RoleEditor}, "supersecretsquirrel")
+	})
+
+	srv, _ := start(t, conf)
+
+	// Local accounts on the denylist cannot log in even with the correct password
+	out := &api.Reply{}
+	rep := request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: "jdoe@contractors.example.com", Password: "supersecretsquirrel"}, out)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+	require.Equal(t, api.ErrInvalidCredentials.Error(), out.Error)
+
+	// Local accounts do not have to belong to an authorized domain
+	rep = request(t, srv, http.MethodPost, "/login", "", &api.LoginForm{Email: "asmith@gmail.com", Password: "supersecretsquirrel"}, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+}
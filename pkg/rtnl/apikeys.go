@@ -0,0 +1,263 @@
+package rtnl
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/passwd"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/rs/zerolog/log"
+)
+
+// How often the last used timestamps of API keys are written to the database.
+const usageFlushInterval = 1 * time.Minute
+
+func (s *Server) APIKeyList(c *gin.Context) {
+	keys, err := s.db.ListAPIKeys()
+	if err != nil {
+		log.Warn().Err(err).Msg("could not list api keys")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	out := &api.APIKeyList{APIKeys: make([]*api.APIKey, 0, len(keys))}
+	for _, key := range keys {
+		out.APIKeys = append(out.APIKeys, key.ToAPI())
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) CreateAPIKey(c *gin.Context) {
+	var (
+		err error
+		in  *api.APIKey
+	)
+
+	in = &api.APIKey{}
+	if err = c.BindJSON(in); err != nil {
+		log.Warn().Err(err).Msg("could not parse create api key request")
+		c.JSON(http.StatusBadRequest, api.ErrUnparsable)
+		return
+	}
+
+	if in.ClientID != "" {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(api.ErrReadOnlyField))
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	// Keys are granted the default scopes unless scopes are specified
+	scopes := auth.Scopes(in.Scopes)
+	if len(scopes) == 0 {
+		scopes = auth.DefaultScopes
+	}
+
+	if err = scopes.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	apikey := &models.APIKey{
+		ClientID: keygen.KeyID(),
+		Name:     in.Name,
+		Scopes:   scopes,
+		Quota:    quota(in.Quota),
+	}
+
+	if in.Expires != nil {
+		apikey.Expires = *in.Expires
+	}
+
+	secret := keygen.Secret()
+	if apikey.DerivedKey, err = passwd.CreateDerivedKey(secret); err != nil {
+		log.Error().Err(err).Msg("could not create derived key")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	if err = s.db.Register(apikey); err != nil {
+		log.Error().Err(err).Msg("could not register api key")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	log.Info().Str("client_id", apikey.ClientID).Str("name", apikey.Name).Strs("scopes", apikey.Scopes).Msg("api key created")
+	s.Audit(c, "", models.ActionAPIKeyCreated, apikey.ClientID, "", apikey.Summary())
+	out := apikey.ToAPI()
+	out.ClientSecret = secret
+	c.JSON(http.StatusCreated, out)
+}
+
+func (s *Server) UpdateAPIKey(c *gin.Context) {
+	var (
+		err error
+		in  *api.APIKey
+	)
+
+	in = &api.APIKey{}
+	if err = c.BindJSON(in); err != nil {
+		log.Warn().Err(err).Msg("could not parse update api key request")
+		c.JSON(http.StatusBadRequest, api.ErrUnparsable)
+		return
+	}
+
+	if in.ClientID != "" && in.ClientID != c.Param("id") {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("client id does not match resource"))
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	if err = auth.Scopes(in.Scopes).Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	apikey := &models.APIKey{ClientID: c.Param("id"), Name: in.Name, Scopes: in.Scopes, Quota: quota(in.Quota)}
+	if in.Expires != nil {
+		apikey.Expires = *in.Expires
+	}
+
+	// Load the current state of the key to record the change in the audit log
+	var before string
+	if prev, err := s.db.Retrieve(apikey.ClientID); err == nil {
+		before = prev.Summary()
+	}
+
+	if err = s.db.UpdateAPIKey(apikey); err != nil {
+		s.apikeyError(c, err, "could not update api key")
+		return
+	}
+
+	s.Audit(c, "", models.ActionAPIKeyUpdated, apikey.ClientID, before, apikey.Summary())
+
+	c.JSON(http.StatusOK, apikey.ToAPI())
+}
+
+func (s *Server) RevokeAPIKey(c *gin.Context) {
+	clientID := c.Param("id")
+	if err := s.db.RevokeAPIKey(clientID); err != nil {
+		s.apikeyError(c, err, "could not revoke api key")
+		return
+	}
+
+	log.Info().Str("client_id", clientID).Msg("api key revoked")
+	s.Audit(c, "", models.ActionAPIKeyRevoked, clientID, "", "")
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+func (s *Server) RotateAPIKey(c *gin.Context) {
+	var (
+		err        error
+		derivedKey string
+		apikey     *models.APIKey
+	)
+
+	secret := keygen.Secret()
+	if derivedKey, err = passwd.CreateDerivedKey(secret); err != nil {
+		log.Error().Err(err).Msg("could not create derived key")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	if apikey, err = s.db.RotateAPIKey(c.Param("id"), derivedKey); err != nil {
+		s.apikeyError(c, err, "could not rotate api key")
+		return
+	}
+
+	log.Info().Str("client_id", apikey.ClientID).Msg("api key rotated")
+	s.Audit(c, "", models.ActionAPIKeyRotated, apikey.ClientID, "", "")
+	out := apikey.ToAPI()
+	out.ClientSecret = secret
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) apikeyError(c *gin.Context, err error, msg string) {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		c.JSON(http.StatusNotFound, api.ErrorResponse("api key not found"))
+	case errors.Is(err, storage.ErrRevoked):
+		c.JSON(http.StatusConflict, api.ErrorResponse(err))
+	default:
+		log.Warn().Err(err).Str("client_id", c.Param("id")).Msg(msg)
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+	}
+}
+
+// Converts the api quota into a model quota; a nil quota is returned if no quota is set.
+func quota(in *api.Quota) *models.Quota {
+	if in == nil {
+		return nil
+	}
+	return &models.Quota{LinksPerDay: in.LinksPerDay, ActiveLinks: in.ActiveLinks}
+}
+
+// Usage buffers the last time each API key was used so that authentication does not
+// write to the database on every request; the buffer is flushed to the database
+// periodically in the background and when the server is shutdown.
+type Usage struct {
+	sync.Mutex
+	used map[string]time.Time
+}
+
+// Touch records that the API key was used now.
+func (u *Usage) Touch(clientID string) {
+	u.Lock()
+	defer u.Unlock()
+	if u.used == nil {
+		u.used = make(map[string]time.Time)
+	}
+	u.used[clientID] = time.Now()
+}
+
+// Drain returns the buffered timestamps and resets the buffer.
+func (u *Usage) Drain() (used map[string]time.Time) {
+	u.Lock()
+	defer u.Unlock()
+	used, u.used = u.used, nil
+	return used
+}
+
+// Flushes API key usage to the database until the server is shutdown.
+func (s *Server) TrackUsage() {
+	defer s.maint.Done()
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.done:
+			s.FlushUsage()
+			return
+		case <-ticker.C:
+			s.FlushUsage()
+		}
+	}
+}
+
+// FlushUsage writes the buffered API key usage to the database.
+func (s *Server) FlushUsage() {
+	used := s.usage.Drain()
+	if len(used) == 0 {
+		return
+	}
+
+	if err := s.db.TouchAPIKeys(used); err != nil {
+		log.Warn().Err(err).Int("apikeys", len(used)).Msg("could not record api key usage")
+	}
+}
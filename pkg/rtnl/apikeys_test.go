@@ -0,0 +1,168 @@
+package rtnl_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeys(t *testing.T) {
+	conf := testConfig(t)
+
+	var admin, editor string
+	seed(t, conf, func(db storage.Storage) {
+		admin = createAPIKey(t, db, &models.APIKey{Name: "admin", Scopes: []string{auth.ScopeAdmin}})
+		editor = createAPIKey(t, db, &models.APIKey{Name: "editor", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+
+	// Create an api key with a subset of scopes
+	created := &api.APIKey{}
+	rep := request(t, srv, http.MethodPost, "/v1/apikeys", admin, &api.APIKey{Name: "reader", Scopes: []string{auth.ScopeLinksRead}}, created)
+	require.Equal(t, http.StatusCreated, rep.StatusCode)
+	require.NotEmpty(t, created.ClientID)
+	require.NotEmpty(t, created.ClientSecret)
+	require.Equal(t, []string{auth.ScopeLinksRead}, created.Scopes)
+	reader := created.ClientID + "-" + created.ClientSecret
+
+	// The new key can only be used for the scopes it was granted
+	rep = request(t, srv, http.MethodGet, "/v1/links", reader, nil, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+
+	rep = request(t, srv, http.MethodPost, "/v1/shorten", reader, &api.LongURL{URL: "https://rotational.io"}, nil)
+	require.Equal(t, http.StatusForbidden, rep.StatusCode)
+
+	// The new key should be listed without its secret
+	list := &api.APIKeyList{}
+	rep = request(t, srv, http.MethodGet, "/v1/apikeys", admin, nil, list)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.Len(t, list.APIKeys, 3)
+	for _, key := range list.APIKeys {
+		require.Empty(t, key.ClientSecret, "client secret returned in list")
+	}
+
+	// Update the scopes of the key
+	updated := &api.APIKey{}
+	rep = request(t, srv, http.MethodPut, "/v1/apikeys/"+created.ClientID, admin, &api.APIKey{Name: "writer", Scopes: []string{auth.ScopeLinksWrite}}, updated)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.Equal(t, "writer", updated.Name)
+
+	rep = request(t, srv, http.MethodPost, "/v1/shorten", reader, &api.LongURL{URL: "https://rotational.io"}, nil)
+	require.Equal(t, http.StatusCreated, rep.StatusCode)
+
+	// Rotating the key should invalidate the old secret
+	rotated := &api.APIKey{}
+	rep = request(t, srv, http.MethodPost, "/v1/apikeys/"+created.ClientID+"/rotate", admin, nil, rotated)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.NotEqual(t, created.ClientSecret, rotated.ClientSecret)
+
+	rep = request(t, srv, http.MethodPost, "/v1/shorten", reader, &api.LongURL{URL: "https://rotational.io/blog"}, nil)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode, "old secret can still be used after rotation")
+
+	reader = rotated.ClientID + "-" + rotated.ClientSecret
+	rep = request(t, srv, http.MethodPost, "/v1/shorten", reader, &api.LongURL{URL: "https://rotational.io/blog"}, nil)
+	require.Equal(t, http.StatusCreated, rep.StatusCode)
+
+	// Revoking the key should prevent it from being used
+	rep = request(t, srv, http.MethodDelete, "/v1/apikeys/"+created.ClientID, admin, nil, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+
+	rep = request(t, srv, http.MethodGet, "/v1/links", reader, nil, nil)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode, "revoked key can still be used")
+
+	rep = request(t, srv, http.MethodDelete, "/v1/apikeys/"+created.ClientID, admin, nil, nil)
+	require.Equal(t, http.StatusConflict, rep.StatusCode)
+
+	rep = request(t, srv, http.MethodPost, "/v1/apikeys/"+created.ClientID+"/rotate", admin, nil, nil)
+	require.Equal(t, http.StatusConflict, rep.StatusCode, "revoked key was rotated")
+
+	t.Run("NotFound", func(t *testing.T) {
+		rep := request(t, srv, http.MethodPut, "/v1/apikeys/notarealkey", admin, &api.APIKey{Name: "missing"}, nil)
+		require.Equal(t, http.StatusNotFound, rep.StatusCode)
+
+		rep = request(t, srv, http.MethodDelete, "/v1/apikeys/notarealkey", admin, nil, nil)
+		require.Equal(t, http.StatusNotFound, rep.StatusCode)
+	})
+
+	t.Run("BadRequest", func(t *testing.T) {
+		testCases := []struct {
+			method string
+			path   string
+			body   interface{}
+		}{
+			{http.MethodPost, "/v1/apikeys", json.RawMessage("null")},
+			{http.MethodPost, "/v1/apikeys", &api.APIKey{}},
+			{http.MethodPost, "/v1/apikeys", &api.APIKey{Name: "unknown", Scopes: []string{"links:destroy"}}},
+			{http.MethodPost, "/v1/apikeys", &api.APIKey{Name: "client", ClientID: "foo"}},
+			{http.MethodPut, "/v1/apikeys/" + created.ClientID, json.RawMessage("null")},
+			{http.MethodPut, "/v1/apikeys/" + created.ClientID, &api.APIKey{Name: "other", ClientID: "foo"}},
+		}
+
+		for i, tc := range testCases {
+			rep := request(t, srv, tc.method, tc.path, admin, tc.body, nil)
+			require.Equal(t, http.StatusBadRequest, rep.StatusCode, "test case %d", i)
+		}
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		// Keys without the admin scope cannot manage api keys
+		testCases := []struct {
+			method string
+			path   string
+		}{
+			{http.MethodGet, "/v1/apikeys"},
+			{http.MethodPost, "/v1/apikeys"},
+			{http.MethodPut, "/v1/apikeys/" + created.ClientID},
+			{http.MethodDelete, "/v1/apikeys/" + created.ClientID},
+			{http.MethodPost, "/v1/apikeys/" + created.ClientID + "/rotate"},
+		}
+
+		for _, tc := range testCases {
+			out := &api.Reply{}
+			rep := request(t, srv, tc.method, tc.path, editor, &api.APIKey{Name: "escalate", Scopes: auth.AllScopes}, out)
+			require.Equal(t, http.StatusForbidden, rep.StatusCode, "%s %s", tc.method, tc.path)
+			require.Contains(t, out.Error, auth.ScopeAdmin)
+		}
+	})
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	conf := testConfig(t)
+
+	var valid, expired, revoked string
+	seed(t, conf, func(db storage.Storage) {
+		valid = createAPIKey(t, db, &models.APIKey{Name: "valid", Scopes: auth.DefaultScopes, Expires: time.Now().Add(time.Hour)})
+		expired = createAPIKey(t, db, &models.APIKey{Name: "expired", Scopes: auth.DefaultScopes, Expires: time.Now().Add(-time.Minute)})
+		revoked = createAPIKey(t, db, &models.APIKey{Name: "revoked", Scopes: auth.DefaultScopes, Revoked: time.Now().Add(-time.Minute)})
+	})
+
+	srv, _ := start(t, conf)
+
+	testCases := []struct {
+		name     string
+		token    string
+		expected int
+	}{
+		{"Valid", valid, http.StatusOK},
+		{"Expired", expired, http.StatusUnauthorized},
+		{"Revoked", revoked, http.StatusUnauthorized},
+		{"WrongSecret", valid[:len(valid)-4] + "abcd", http.StatusUnauthorized},
+		{"Malformed", "notatoken", http.StatusUnauthorized},
+		{"Missing", "", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			rep := request(t, srv, http.MethodGet, "/v1/stats", tc.token, nil, nil)
+			require.Equal(t, tc.expected, rep.StatusCode)
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package rtnl
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/rs/zerolog/log"
+)
+
+// The number of audit events returned if the query does not specify a limit.
+const defaultAuditLimit = 100
+
+func (s *Server) AuditLog(c *gin.Context) {
+	var (
+		err    error
+		in     *api.AuditQuery
+		events []*models.AuditEvent
+	)
+
+	in = &api.AuditQuery{}
+	if err = c.BindQuery(in); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	query := &storage.AuditQuery{Actor: in.Actor, Limit: in.Limit}
+	if query.Limit == 0 {
+		query.Limit = defaultAuditLimit
+	}
+
+	// The query has already been validated so the range can be parsed without error
+	query.After, query.Before, _ = in.Range()
+
+	if events, err = s.db.AuditLog(query); err != nil {
+		log.Warn().Err(err).Msg("could not query audit log")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	out := &api.AuditLog{Events: make([]*api.AuditEvent, 0, len(events))}
+	for _, event := range events {
+		out.Events = append(out.Events, event.ToAPI())
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+// Audit records the action in the audit log along with the client IP address and user
+// agent of the request. If the actor is empty, the authenticated principal of the
+// request is recorded as the actor. Failing to record the event does not fail the
+// request since the action has already been performed and the client would otherwise
+// retry it; instead the event is logged so that the action can still be traced.
+func (s *Server) Audit(c *gin.Context, actor, action, target, before, after string) {
+	if actor == "" {
+		actor = Principal(c)
+	}
+
+	event := &models.AuditEvent{
+		Actor:     actor,
+		Action:    action,
+		Target:    target,
+		IPAddr:    c.ClientIP(),
+		UserAgent: c.Request.UserAgent(),
+		Before:    before,
+		After:     after,
+	}
+
+	if err := s.db.Audit(event); err != nil {
+		log.Error().Err(err).Str("actor", actor).Str("action", action).Str("target", target).Str("before", before).Str("after", after).Msg("could not record audit event")
+	}
+}
@@ -2,7 +2,9 @@ package rtnl
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -13,12 +15,16 @@ import (
 	"github.com/rotationalio/rtnl.link/pkg/keygen"
 	"github.com/rotationalio/rtnl.link/pkg/passwd"
 	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	authorization      = "Authorization"
 	contextUserClaims  = "user_claims"
+	contextScopes      = "scopes"
+	contextAPIKey      = "apikey"
+	contextPrincipal   = "principal"
 	accessTokenCookie  = "access_token"
 	refreshTokenCookie = "refresh_token"
 )
@@ -64,6 +70,18 @@ func (s *Server) Authenticate(c *gin.Context) {
 		return
 	}
 
+	if apikey.IsRevoked() {
+		log.Debug().Str("clientID", clientID).Msg("attempted to authenticate with a revoked api key")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(api.ErrUnauthenticated))
+		return
+	}
+
+	if apikey.IsExpired() {
+		log.Debug().Str("clientID", clientID).Msg("attempted to authenticate with an expired api key")
+		c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(api.ErrUnauthenticated))
+		return
+	}
+
 	verified, err := passwd.VerifyDerivedKey(apikey.DerivedKey, secret)
 	if err != nil {
 		log.Error().Err(err).Msg("could not verify derived key")
@@ -76,12 +94,80 @@ func (s *Server) Authenticate(c *gin.Context) {
 		return
 	}
 
+	// Personal access tokens cannot be used for more than their owner's current role
+	// allows so that the tokens lose access when the role of the owner is changed, and
+	// cannot be used at all once the owner has been disabled.
+	scopes := auth.Scopes(apikey.Scopes)
+	if apikey.IsPersonal() {
+		role, disabled := s.UserAccess(apikey.Owner)
+		if disabled {
+			log.Debug().Str("clientID", clientID).Str("owner", apikey.Owner).Msg("attempted to authenticate with the token of a disabled user")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, api.ErrorResponse(api.ErrUnauthenticated))
+			return
+		}
+		scopes = scopes.Restrict(auth.RoleScopes(role))
+	}
+
+	// Subsequent requests with the token are rate limited by the verified API key
+	if s.conf.RateLimit.Enabled {
+		s.verified.Add(token, clientID)
+	}
+
+	// Record the usage of the key without writing to the database on the request path
+	s.usage.Touch(clientID)
+	c.Set(contextScopes, scopes)
+	c.Set(contextAPIKey, apikey)
+	c.Set(contextPrincipal, apikeyPrincipal(apikey, scopes))
 	c.Next()
 }
 
+// Authorize returns middleware that ensures that the authenticated API key or user has
+// been granted all of the specified scopes, otherwise a 403 is returned. Authorize must
+// be used after Authenticate in the handler chain.
+func (s *Server) Authorize(scopes ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		granted := GetScopes(c)
+		for _, scope := range scopes {
+			if !granted.Has(scope) {
+				c.AbortWithStatusJSON(http.StatusForbidden, api.ErrorResponse(fmt.Errorf("%w: %s", api.ErrForbidden, scope)))
+				return
+			}
+		}
+		c.Next()
+	}
+}
+
+// GetScopes returns the scopes granted to the authenticated API key or user.
+func GetScopes(c *gin.Context) auth.Scopes {
+	if scopes, ok := c.Get(contextScopes); ok {
+		if granted, ok := scopes.(auth.Scopes); ok {
+			return granted
+		}
+	}
+	return nil
+}
+
+// GetAPIKey returns the API key used to authenticate the request or nil if the request
+// was not authenticated with an API key.
+func GetAPIKey(c *gin.Context) *models.APIKey {
+	if apikey, ok := c.Get(contextAPIKey); ok {
+		if key, ok := apikey.(*models.APIKey); ok {
+			return key
+		}
+	}
+	return nil
+}
+
+// WebAuthenticate redirects users that are not logged in to the login page, carrying
+// the page they requested so that they are returned to it once they have logged in.
 func (s *Server) WebAuthenticate(c *gin.Context) {
 	if err := s.AuthorizeAccessToken(c); err != nil {
-		c.Redirect(http.StatusTemporaryRedirect, "/login")
+		location := "/login"
+		if c.Request.Method == http.MethodGet {
+			location += "?" + url.Values{"next": {c.Request.URL.RequestURI()}}.Encode()
+		}
+
+		c.Redirect(http.StatusTemporaryRedirect, location)
 		c.Abort()
 		return
 	}
@@ -93,6 +179,7 @@ func (s *Server) AuthorizeAccessToken(c *gin.Context) (err error) {
 	var (
 		accessToken  string
 		refreshToken string
+		refreshed    bool
 		claims       *auth.Claims
 	)
 
@@ -108,7 +195,8 @@ func (s *Server) AuthorizeAccessToken(c *gin.Context) (err error) {
 			return api.ErrUnauthenticated
 		}
 
-		if _, err = s.auth.Verify(refreshToken); err != nil {
+		var refreshClaims *auth.Claims
+		if refreshClaims, err = s.auth.Verify(refreshToken); err != nil {
 			log.Warn().Err(err).Msg("invalid access and refresh token")
 			return api.ErrUnauthenticated
 		}
@@ -119,22 +207,179 @@ func (s *Server) AuthorizeAccessToken(c *gin.Context) (err error) {
 			return api.ErrUnauthenticated
 		}
 
+		// The refresh token must have been issued with the access token
+		if refreshClaims.ID != claims.ID {
+			log.Warn().Msg("access and refresh tokens do not belong to the same session")
+			return api.ErrUnauthenticated
+		}
+
+		// Lookup the role on refresh so that role changes take effect without logout;
+		// disabled users cannot refresh their tokens.
+		var disabled bool
+		if claims.Role, disabled = s.UserAccess(claims.Email); disabled {
+			log.Debug().Str("email", claims.Email).Msg("disabled user attempted to refresh tokens")
+			return api.ErrUnauthenticated
+		}
+		session := claims.ID
+
 		var atks, rtks string
 		if atks, rtks, err = s.auth.CreateTokenPair(claims); err != nil {
 			log.Warn().Err(err).Msg("could not create access and refresh tokens from claims")
 			return api.ErrUnauthenticated
 		}
 
-		// Set new authentication cookies on refresh
-		s.SetAuthCookies(c, atks, rtks)
-		return err
+		// Rotate the session so that the refresh token cannot be used again; this fails
+		// if the session has been revoked or the refresh token has already been used.
+		// Concurrent requests with the same refresh token (e.g. when a page loads several
+		// resources at once) are allowed during the reuse window without refreshing the
+		// cookies again since the request that rotated the session sets the new cookies.
+		switch err = s.RefreshSession(c, session, claims, rtks); {
+		case err == nil:
+			// Set new authentication cookies on refresh
+			if err = s.SetAuthCookies(c, atks, rtks); err != nil {
+				log.Warn().Err(err).Msg("could not set refreshed authentication cookies")
+				return api.ErrUnauthenticated
+			}
+			refreshed = true
+		case errors.Is(err, storage.ErrRotated):
+			log.Debug().Str("session", session).Msg("refresh token reused during the reuse window")
+		default:
+			if !errors.Is(err, storage.ErrNotFound) {
+				log.Warn().Err(err).Msg("could not rotate session")
+			}
+			return api.ErrUnauthenticated
+		}
+	} else {
+		// Access tokens can only be used while their session has not been revoked
+		if _, err = s.db.RetrieveSession(claims.ID); err != nil {
+			if !errors.Is(err, storage.ErrNotFound) {
+				log.Warn().Err(err).Msg("could not retrieve session")
+			}
+			return api.ErrUnauthenticated
+		}
 	}
 
-	// Add claims to context for use in downstream processing and continue handlers
+	// Users that do not have a csrf token (e.g. because they logged in before csrf
+	// tokens were issued) are issued one on safe requests so the web UI can send it.
+	if !refreshed && isSafeMethod(c.Request.Method) {
+		if err = s.issueCSRFCookie(c); err != nil {
+			log.Warn().Err(err).Msg("could not issue csrf token")
+			return api.ErrUnauthenticated
+		}
+	}
+
+	// Add claims to context for use in downstream processing and continue handlers,
+	// whether or not the tokens were refreshed. Web users are granted the scopes of
+	// their role.
+	scopes := auth.RoleScopes(claims.Role)
 	c.Set(contextUserClaims, claims)
+	c.Set(contextScopes, scopes)
+	c.Set(contextPrincipal, userPrincipal(claims, scopes))
+	return nil
+}
+
+// UserRole returns the role of the web user with the specified email address. Roles
+// assigned in the database take precedence over roles assigned in the configuration.
+func (s *Server) UserRole(email string) string {
+	role, _ := s.UserAccess(email)
+	return role
+}
+
+// UserAccess returns the role of the web user with the specified email address and
+// whether the user has been disabled in the database. Users that are not in the
+// database are assigned the role from the configuration and are never disabled.
+func (s *Server) UserAccess(email string) (role string, disabled bool) {
+	user, err := s.db.RetrieveUser(email)
+	if err != nil {
+		if !errors.Is(err, storage.ErrNotFound) {
+			log.Warn().Err(err).Msg("could not retrieve user from the database")
+		}
+		return s.auth.Role(email), false
+	}
+
+	if role, err = auth.ParseRole(user.Role); err != nil {
+		log.Warn().Err(err).Str("email", user.Email).Msg("user has an invalid role in the database")
+		role = s.auth.Role(email)
+	}
+	return role, user.IsDisabled()
+}
+
+// GetClaims returns the claims of the authenticated web user or nil if the request was
+// not authenticated with an access token.
+func GetClaims(c *gin.Context) *auth.Claims {
+	if claims, ok := c.Get(contextUserClaims); ok {
+		if user, ok := claims.(*auth.Claims); ok {
+			return user
+		}
+	}
+	return nil
+}
+
+// GetPrincipal returns the web user or API key that authenticated the request or nil
+// if the request is not authenticated.
+func GetPrincipal(c *gin.Context) *api.Principal {
+	if principal, ok := c.Get(contextPrincipal); ok {
+		if p, ok := principal.(*api.Principal); ok {
+			return p
+		}
+	}
 	return nil
 }
 
+// Principal returns the identity of the authenticated user or API key that is used to
+// record the creator of a link: the email address of web users or the client ID of API
+// keys. Personal access tokens are attributed to the email address of their owner. An
+// empty string is returned if the request is not authenticated.
+func Principal(c *gin.Context) string {
+	if principal := GetPrincipal(c); principal != nil {
+		return principal.ID
+	}
+	return ""
+}
+
+func userPrincipal(claims *auth.Claims, scopes auth.Scopes) *api.Principal {
+	principal := &api.Principal{
+		ID:      claims.Email,
+		Subject: claims.Subject,
+		Name:    claims.Name,
+		Email:   claims.Email,
+		Picture: claims.Picture,
+		Role:    claims.Role,
+		Scopes:  scopes,
+	}
+
+	if principal.ID == "" {
+		principal.ID = claims.Subject
+	}
+	return principal
+}
+
+func apikeyPrincipal(apikey *models.APIKey, scopes auth.Scopes) *api.Principal {
+	principal := &api.Principal{
+		ID:       apikey.ClientID,
+		Name:     apikey.Name,
+		ClientID: apikey.ClientID,
+		Scopes:   scopes,
+	}
+
+	if apikey.IsPersonal() {
+		principal.ID = apikey.Owner
+		principal.Email = apikey.Owner
+	}
+	return principal
+}
+
+// CanModify returns true if the authenticated user or API key created the link or has
+// been granted the admin scope; links without a creator can only be modified by admins.
+func CanModify(c *gin.Context, link *models.ShortURL) bool {
+	if GetScopes(c).Has(auth.ScopeAdmin) {
+		return true
+	}
+
+	principal := Principal(c)
+	return principal != "" && link.CreatedBy == principal
+}
+
 func GetBearerToken(c *gin.Context) (tks string, err error) {
 	// Attempt to get the access token from the header.
 	if header := c.GetHeader(authorization); header != "" {
@@ -177,7 +422,9 @@ func (s *Server) SetAuthCookies(c *gin.Context, accessToken, refreshToken string
 
 	c.SetCookie(accessTokenCookie, accessToken, maxAge, "/", s.conf.Auth.CookieDomain, secure, true)
 	c.SetCookie(refreshTokenCookie, refreshToken, maxAge, "/", s.conf.Auth.CookieDomain, secure, true)
-	return nil
+
+	// The csrf token is rotated along with the access and refresh tokens
+	return s.setCSRFCookie(c, maxAge)
 }
 
 func (s *Server) ClearAuthCookies(c *gin.Context) {
@@ -187,4 +434,5 @@ func (s *Server) ClearAuthCookies(c *gin.Context) {
 	// Remove authentication cookies by setting expired empty string cookies
 	c.SetCookie(accessTokenCookie, "", -1, "/", s.conf.Auth.CookieDomain, secure, true)
 	c.SetCookie(refreshTokenCookie, "", -1, "/", s.conf.Auth.CookieDomain, secure, true)
+	c.SetCookie(csrfCookie, "", -1, "/", s.conf.Auth.CookieDomain, secure, false)
 }
@@ -0,0 +1,87 @@
+package rtnl
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	csrfHeader = "X-CSRF-TOKEN"
+)
+
+// CSRF protects requests that are authenticated with the access token cookie from
+// cross-site request forgery using the double submit cookie pattern: the csrf token
+// cookie is issued with the auth cookies and unsafe requests must echo its value in
+// the X-CSRF-TOKEN header, which a cross-site request cannot do since it cannot read
+// the cookie. Safe methods and requests without an access token cookie (e.g. API
+// requests authenticated with a bearer token) are not checked.
+func (s *Server) CSRF(c *gin.Context) {
+	if isSafeMethod(c.Request.Method) {
+		c.Next()
+		return
+	}
+
+	if cookie, _ := c.Cookie(accessTokenCookie); cookie == "" {
+		c.Next()
+		return
+	}
+
+	token, _ := c.Cookie(csrfCookie)
+	header := c.GetHeader(csrfHeader)
+	if token == "" || subtle.ConstantTimeCompare([]byte(token), []byte(header)) != 1 {
+		c.AbortWithStatusJSON(http.StatusForbidden, api.ErrorResponse(api.ErrCSRFVerification))
+		return
+	}
+
+	c.Next()
+}
+
+// Sets a new csrf token cookie that expires with the auth cookies. The cookie is not
+// http only so that the token can be read by the web UI and sent in the header.
+func (s *Server) setCSRFCookie(c *gin.Context, maxAge int) error {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return err
+	}
+
+	secure := s.conf.Auth.CookieDomain != "localhost"
+	c.SetCookie(csrfCookie, base64.RawURLEncoding.EncodeToString(data), maxAge, "/", s.conf.Auth.CookieDomain, secure, false)
+	return nil
+}
+
+// Sets a csrf token cookie if the request does not have one, expiring it with the
+// auth cookies of the authenticated request.
+func (s *Server) issueCSRFCookie(c *gin.Context) (err error) {
+	if token, _ := c.Cookie(csrfCookie); token != "" {
+		return nil
+	}
+
+	tks, _ := c.Cookie(refreshTokenCookie)
+	if tks == "" {
+		tks, _ = c.Cookie(accessTokenCookie)
+	}
+
+	var expiration time.Time
+	if expiration, err = auth.ExpiresAt(tks); err != nil {
+		return err
+	}
+	return s.setCSRFCookie(c, int(time.Until(expiration).Seconds()))
+}
+
+// Safe methods do not modify state so they do not require a csrf token.
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,109 @@
+package rtnl_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCSRF(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	conf := testConfig(t)
+
+	var apikey string
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+		apikey = createAPIKey(t, db, &models.APIKey{Name: "editor", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+	cookies := login(t, srv, "jdoe@example.com", "supersecretsquirrel")
+	token := cookie(cookies, "csrf_token")
+	require.NotEmpty(t, token, "no csrf token issued on login")
+
+	link := &api.LongURL{URL: "https://rotational.io"}
+
+	t.Run("SafeMethods", func(t *testing.T) {
+		for _, path := range []string{"/v1/links", "/v1/stats", "/links"} {
+			rep := browse(t, srv, http.MethodGet, path, cookies, nil)
+			require.Equal(t, http.StatusOK, rep.StatusCode, "GET %s required a csrf token", path)
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		rep := browse(t, srv, http.MethodPost, "/v1/shorten", cookies, link)
+		require.Equal(t, http.StatusForbidden, rep.StatusCode)
+
+		// The header must be checked against the cookie, not just be present
+		rep = browse(t, srv, http.MethodPost, "/v1/shorten", without(cookies, "csrf_token"), link, "X-CSRF-TOKEN", token)
+		require.Equal(t, http.StatusForbidden, rep.StatusCode)
+	})
+
+	t.Run("Mismatched", func(t *testing.T) {
+		rep := browse(t, srv, http.MethodPost, "/v1/shorten", cookies, link, "X-CSRF-TOKEN", "notthetoken")
+		require.Equal(t, http.StatusForbidden, rep.StatusCode)
+
+		rep = browse(t, srv, http.MethodDelete, "/abc123", cookies, nil, "X-CSRF-TOKEN", "notthetoken")
+		require.Equal(t, http.StatusForbidden, rep.StatusCode)
+	})
+
+	t.Run("Valid", func(t *testing.T) {
+		rep := browse(t, srv, http.MethodPost, "/v1/shorten", cookies, link, "X-CSRF-TOKEN", token)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+	})
+
+	t.Run("BearerToken", func(t *testing.T) {
+		// Requests without cookies cannot be forged so they do not need a csrf token
+		rep := request(t, srv, http.MethodPost, "/v1/shorten", apikey, &api.LongURL{URL: "https://rotational.io/blog"}, nil)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+	})
+
+	t.Run("Issued", func(t *testing.T) {
+		// Users without a csrf token are issued one on authenticated safe requests
+		legacy := without(cookies, "csrf_token")
+		rep := browse(t, srv, http.MethodGet, "/v1/links", legacy, nil)
+		require.Equal(t, http.StatusOK, rep.StatusCode)
+
+		issued := cookie(rep.Cookies(), "csrf_token")
+		require.NotEmpty(t, issued, "no csrf token issued on authenticated get")
+
+		rep = browse(t, srv, http.MethodPost, "/v1/shorten", append(legacy, &http.Cookie{Name: "csrf_token", Value: issued}), &api.LongURL{URL: "https://rotational.io/about"}, "X-CSRF-TOKEN", issued)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+
+		// Users that already have a csrf token are not issued a new one
+		rep = browse(t, srv, http.MethodGet, "/v1/links", cookies, nil)
+		require.Equal(t, http.StatusOK, rep.StatusCode)
+		require.Empty(t, cookie(rep.Cookies(), "csrf_token"))
+
+		// Unauthenticated requests are not issued a csrf token
+		rep = browse(t, srv, http.MethodGet, "/v1/links", nil, nil)
+		require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+		require.Empty(t, cookie(rep.Cookies(), "csrf_token"))
+	})
+}
+
+// Returns the value of the named cookie or an empty string if it is not set.
+func cookie(cookies []*http.Cookie, name string) string {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// Returns the cookies without the named cookie.
+func without(cookies []*http.Cookie, name string) []*http.Cookie {
+	out := make([]*http.Cookie, 0, len(cookies))
+	for _, c := range cookies {
+		if c.Name != name {
+			out = append(out, c)
+		}
+	}
+	return out
+}
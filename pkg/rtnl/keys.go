@@ -0,0 +1,43 @@
+package rtnl
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// JWKS publishes the public keys used to verify rtnl access and refresh tokens.
+func (s *Server) JWKS(c *gin.Context) {
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, s.auth.JWKS())
+}
+
+// ReloadKeys periodically reloads the signing keys from the keys directory in its own
+// go routine until the server is shutdown so that keys can be rotated without a restart.
+func (s *Server) ReloadKeys(interval time.Duration) {
+	defer s.maint.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Debug().Dur("interval", interval).Str("keys_dir", s.conf.Auth.KeysDir).Msg("signing key reloading started")
+	for {
+		select {
+		case <-s.done:
+			log.Debug().Msg("signing key reloading stopped")
+			return
+		case <-ticker.C:
+			current := s.auth.CurrentKey()
+			if err := s.auth.Reload(); err != nil {
+				// The previous keys continue to be used if the keys cannot be reloaded
+				log.Warn().Err(err).Msg("could not reload signing keys")
+				continue
+			}
+
+			if kid := s.auth.CurrentKey(); kid.Compare(current) != 0 {
+				log.Info().Str("kid", kid.String()).Msg("signing key rotated")
+			}
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package rtnl
+
+import (
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Maintain runs periodic database maintenance in its own go routine until the server
+// is shutdown. On each tick, the badger value log is garbage collected to reclaim
+// space from deleted and expired keys.
+func (s *Server) Maintain(interval time.Duration) {
+	defer s.maint.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Debug().Dur("interval", interval).Msg("database maintenance started")
+	for {
+		select {
+		case <-s.done:
+			log.Debug().Msg("database maintenance stopped")
+			return
+		case <-ticker.C:
+			s.RunMaintenance()
+		}
+	}
+}
+
+// RunMaintenance performs a single database maintenance pass, logging any errors since
+// maintenance failures should not stop the server.
+func (s *Server) RunMaintenance() {
+	start := time.Now()
+	reclaimed, err := s.db.RunGC(s.conf.Storage.GCDiscardRatio)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not run value log garbage collection")
+		return
+	}
+
+	log.Info().
+		Int64("reclaimed_bytes", reclaimed).
+		Dur("duration", time.Since(start)).
+		Msg("database maintenance complete")
+}
+
+// Sweep expired links from the aggregate counts in its own go routine until the server
+// is shutdown. The stats endpoint does not scan for expired links, so the interval
+// determines how long an expired link is included in the counts.
+func (s *Server) Sweep(interval time.Duration) {
+	defer s.maint.Done()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Debug().Dur("interval", interval).Msg("expired link sweeps started")
+	for {
+		select {
+		case <-s.done:
+			log.Debug().Msg("expired link sweeps stopped")
+			return
+		case <-ticker.C:
+			expired, err := s.db.ExpireLinks()
+			if err != nil {
+				log.Warn().Err(err).Msg("could not sweep expired links")
+				continue
+			}
+
+			if expired > 0 {
+				log.Debug().Int("expired", expired).Msg("expired links swept from counts")
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package rtnl_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl"
+	"github.com/stretchr/testify/require"
+)
+
+// Matches gin path parameters so that they can be converted into OpenAPI templates.
+var pathParam = regexp.MustCompile(`/:([A-Za-z0-9_]+)`)
+
+// Every v1 route registered by the server must be described by the OpenAPI spec and
+// every path in the spec must be served so that the spec does not drift from the API.
+func TestOpenAPIRoutes(t *testing.T) {
+	spec := loadSpec(t)
+	router := setupRouter(t)
+
+	served := make(map[string]map[string]bool)
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/v1/") {
+			continue
+		}
+
+		path := pathParam.ReplaceAllString(route.Path, "/{$1}")
+		method := strings.ToLower(route.Method)
+
+		ops, ok := spec.Paths[path]
+		require.True(t, ok, "route %s %s is not described by the openapi spec", route.Method, path)
+		require.Contains(t, ops, method, "route %s %s is not described by the openapi spec", route.Method, path)
+
+		if _, ok := served[path]; !ok {
+			served[path] = make(map[string]bool)
+		}
+		served[path][method] = true
+	}
+
+	for path, ops := range spec.Paths {
+		for method := range ops {
+			if method == "parameters" {
+				continue
+			}
+			require.True(t, served[path][method], "openapi spec describes %s %s which is not served", strings.ToUpper(method), path)
+		}
+	}
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	router := setupRouter(t)
+
+	req := httptest.NewRequest(http.MethodGet, "/v1/openapi.json", nil)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.Equal(t, gin.MIMEJSON, rec.Header().Get("Content-Type"))
+	require.JSONEq(t, string(api.OpenAPI()), rec.Body.String())
+}
+
+type openAPISpec struct {
+	OpenAPI string                                `json:"openapi"`
+	Paths   map[string]map[string]json.RawMessage `json:"paths"`
+}
+
+func loadSpec(t *testing.T) *openAPISpec {
+	spec := &openAPISpec{}
+	require.NoError(t, json.Unmarshal(api.OpenAPI(), spec), "could not parse openapi spec")
+	require.True(t, strings.HasPrefix(spec.OpenAPI, "3."), "expected an openapi 3 document")
+	require.NotEmpty(t, spec.Paths)
+	return spec
+}
+
+func setupRouter(t *testing.T) *gin.Engine {
+	t.Setenv("RTNL_MODE", gin.TestMode)
+	t.Setenv("RTNL_STORAGE_DATA_PATH", t.TempDir())
+	conf, err := config.New()
+	require.NoError(t, err, "could not create config")
+
+	srv, err := rtnl.New(conf)
+	require.NoError(t, err, "could not create server")
+
+	router := gin.New()
+	require.NoError(t, srv.Routes(router), "could not setup routes")
+	srv.SetReady(true)
+	return router
+}
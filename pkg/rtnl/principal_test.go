@@ -0,0 +1,122 @@
+package rtnl_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPrincipal(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	conf := testConfig(t)
+
+	var apikey, personal *models.APIKey
+	var apikeyToken, personalToken string
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+
+		apikey = &models.APIKey{Name: "ci", Scopes: auth.DefaultScopes}
+		apikeyToken = createAPIKey(t, db, apikey)
+
+		personal = &models.APIKey{Name: "laptop", Owner: "jdoe@example.com", Scopes: auth.DefaultScopes}
+		personalToken = createAPIKey(t, db, personal)
+	})
+
+	srv, _ := start(t, conf)
+	cookies := login(t, srv, "jdoe@example.com", "supersecretsquirrel")
+
+	// Serve the principal that the authentication middleware exposes to handlers
+	router := gin.New()
+	router.GET("/whoami", srv.Authenticate, func(c *gin.Context) {
+		require.Equal(t, rtnl.GetPrincipal(c).ID, rtnl.Principal(c))
+		c.JSON(http.StatusOK, rtnl.GetPrincipal(c))
+	})
+
+	whoami := func(t *testing.T, token string, cookies []*http.Cookie) *api.Principal {
+		req := httptest.NewRequest(http.MethodGet, "/whoami", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		out := &api.Principal{}
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), out), "could not decode principal")
+		return out
+	}
+
+	t.Run("User", func(t *testing.T) {
+		principal := whoami(t, "", cookies)
+		require.Equal(t, "jdoe@example.com", principal.ID)
+		require.Equal(t, "jdoe@example.com", principal.Email)
+		require.Equal(t, "Jane Doe", principal.Name)
+		require.Equal(t, "local:jdoe@example.com", principal.Subject)
+		require.Equal(t, auth.RoleEditor, principal.Role)
+		require.NotEmpty(t, principal.Scopes)
+		require.Empty(t, principal.ClientID)
+		require.False(t, principal.IsAPIKey())
+	})
+
+	t.Run("APIKey", func(t *testing.T) {
+		principal := whoami(t, apikeyToken, nil)
+		require.Equal(t, apikey.ClientID, principal.ID)
+		require.Equal(t, apikey.ClientID, principal.ClientID)
+		require.Equal(t, "ci", principal.Name)
+		require.Empty(t, principal.Email)
+		require.ElementsMatch(t, []string(auth.DefaultScopes), principal.Scopes)
+		require.True(t, principal.IsAPIKey())
+	})
+
+	t.Run("PersonalToken", func(t *testing.T) {
+		// Personal access tokens are attributed to the email address of their owner
+		principal := whoami(t, personalToken, nil)
+		require.Equal(t, "jdoe@example.com", principal.ID)
+		require.Equal(t, "jdoe@example.com", principal.Email)
+		require.Equal(t, personal.ClientID, principal.ClientID)
+		require.True(t, principal.IsAPIKey())
+	})
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		require.Nil(t, rtnl.GetPrincipal(c))
+		require.Empty(t, rtnl.Principal(c))
+	})
+
+	t.Run("Templates", func(t *testing.T) {
+		// The logged in user is displayed in the header of the web pages
+		for _, path := range []string{"/", "/links", "/settings"} {
+			req, err := http.NewRequest(http.MethodGet, srv.URL()+path, nil)
+			require.NoError(t, err, "could not create request")
+			for _, cookie := range cookies {
+				req.AddCookie(cookie)
+			}
+
+			rep, err := http.DefaultClient.Do(req)
+			require.NoError(t, err, "could not make request")
+			body, err := io.ReadAll(rep.Body)
+			rep.Body.Close()
+			require.NoError(t, err, "could not read response")
+
+			require.Equal(t, http.StatusOK, rep.StatusCode, "could not get %s", path)
+			require.Contains(t, string(body), `id="current-user"`, "user not displayed on %s", path)
+			require.Contains(t, string(body), "Jane Doe", "user not displayed on %s", path)
+			require.Contains(t, string(body), `title="jdoe@example.com"`, "user not displayed on %s", path)
+		}
+	})
+}
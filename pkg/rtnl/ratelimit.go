@@ -0,0 +1,144 @@
+package rtnl
+
+import (
+	"crypto/sha256"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/ratelimit"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	RateLimitLimit     = "RateLimit-Limit"
+	RateLimitRemaining = "RateLimit-Remaining"
+	RateLimitReset     = "RateLimit-Reset"
+)
+
+// RateLimits holds the limiter for each group of routes; a nil limiter does not limit
+// requests so all limiters are nil if rate limiting is disabled.
+type RateLimits struct {
+	Redirect *ratelimit.Limiter
+	Shorten  *ratelimit.Limiter
+	Login    *ratelimit.Limiter
+	API      *ratelimit.Limiter
+}
+
+// NewRateLimits creates the limiters for each group of routes from the configuration.
+func NewRateLimits(conf config.RateLimitConfig) RateLimits {
+	if !conf.Enabled {
+		return RateLimits{}
+	}
+
+	return RateLimits{
+		Redirect: ratelimit.New(conf.RedirectRate, conf.RedirectBurst),
+		Shorten:  ratelimit.New(conf.ShortenRate, conf.ShortenBurst),
+		Login:    ratelimit.New(conf.LoginRate, conf.LoginBurst),
+		API:      ratelimit.New(conf.APIRate, conf.APIBurst),
+	}
+}
+
+// RateLimit returns middleware that limits requests with the limiter, returning a 429
+// if the client has exceeded the limit. The rate limit headers are set on every
+// response so that clients can pace their requests. Rate limits are applied before
+// authentication so that credentials cannot be brute forced.
+func (s *Server) RateLimit(limiter *ratelimit.Limiter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if limiter == nil {
+			c.Next()
+			return
+		}
+
+		key := s.rateLimitKey(c)
+		res := limiter.Allow(key)
+
+		c.Header(RateLimitLimit, strconv.Itoa(res.Limit))
+		c.Header(RateLimitRemaining, strconv.Itoa(res.Remaining))
+		c.Header(RateLimitReset, seconds(res.Reset))
+
+		if !res.Allowed {
+			log.Debug().Str("key", key).Str("path", c.FullPath()).Msg("rate limit exceeded")
+			c.Header(RetryAfter, seconds(res.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, api.ErrorResponse(api.ErrRateLimited))
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// Returns the key that the request is rate limited by: the subject of the access token
+// cookie, the client ID of a bearer token that has already been verified, or the client
+// IP address. The request has not been authenticated yet, so the access token signature
+// is verified and bearer tokens are only keyed by client ID once Authenticate has
+// verified their secret; otherwise a client could exhaust the limits of another API key
+// or avoid its own limits by sending a different client ID with every request.
+func (s *Server) rateLimitKey(c *gin.Context) string {
+	if cookie, _ := c.Cookie(accessTokenCookie); cookie != "" {
+		if claims, err := s.auth.Parse(cookie); err == nil && claims.Subject != "" {
+			return "user:" + claims.Subject
+		}
+	}
+
+	if token, err := GetBearerToken(c); err == nil {
+		if clientID, ok := s.verified.Lookup(token); ok {
+			return "apikey:" + clientID
+		}
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// VerifiedTokens remembers the client IDs of bearer tokens that were recently verified
+// by Authenticate so that requests can be rate limited by API key before they are
+// authenticated without verifying the secret again. Tokens are stored as hashes and
+// are forgotten after a short time; the cache is reset if it grows too large.
+type VerifiedTokens struct {
+	sync.Mutex
+	tokens map[[sha256.Size]byte]verifiedToken
+}
+
+type verifiedToken struct {
+	clientID string
+	expires  time.Time
+}
+
+const (
+	verifiedTokenTTL  = 10 * time.Minute
+	maxVerifiedTokens = 10000
+)
+
+// Add records that the secret of the bearer token was verified for the client ID.
+func (v *VerifiedTokens) Add(token, clientID string) {
+	v.Lock()
+	defer v.Unlock()
+	if v.tokens == nil || len(v.tokens) >= maxVerifiedTokens {
+		v.tokens = make(map[[sha256.Size]byte]verifiedToken)
+	}
+	v.tokens[sha256.Sum256([]byte(token))] = verifiedToken{clientID: clientID, expires: time.Now().Add(verifiedTokenTTL)}
+}
+
+// Lookup returns the client ID of the bearer token if it was recently verified.
+func (v *VerifiedTokens) Lookup(token string) (string, bool) {
+	v.Lock()
+	defer v.Unlock()
+	key := sha256.Sum256([]byte(token))
+	if vt, ok := v.tokens[key]; ok {
+		if time.Now().Before(vt.expires) {
+			return vt.clientID, true
+		}
+		delete(v.tokens, key)
+	}
+	return "", false
+}
+
+// Formats the duration as a whole number of seconds, rounding up.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
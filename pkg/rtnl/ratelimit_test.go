@@ -0,0 +1,86 @@
+package rtnl_test
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitKey(t *testing.T) {
+	t.Setenv("RTNL_RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RTNL_RATE_LIMIT_API_RATE", "0.001")
+	t.Setenv("RTNL_RATE_LIMIT_API_BURST", "3")
+	conf := testConfig(t)
+
+	var victim string
+	seed(t, conf, func(db storage.Storage) {
+		victim = createAPIKey(t, db, &models.APIKey{Name: "victim", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+	clientID, _, err := rtnl.ParseToken(victim)
+	require.NoError(t, err, "could not parse api key")
+
+	// Until the secret has been verified, the request is limited by IP address
+	rep := request(t, srv, http.MethodGet, "/v1/stats", victim, nil, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.Equal(t, "2", rep.Header.Get(rtnl.RateLimitRemaining))
+
+	// Once verified, requests with the token are limited by the API key
+	rep = request(t, srv, http.MethodGet, "/v1/stats", victim, nil, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.Equal(t, "2", rep.Header.Get(rtnl.RateLimitRemaining))
+
+	// Requests with the client ID of the victim but the wrong secret are limited by IP
+	// address so that they cannot exhaust the limit of the victim's API key
+	forged := clientID + "-" + keygen.Secret()
+	for _, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		rep = request(t, srv, http.MethodGet, "/v1/stats", forged, nil, nil)
+		require.Equal(t, expected, rep.StatusCode)
+	}
+
+	// Sending a different client ID with every request does not avoid the limit
+	for i := 0; i < 3; i++ {
+		rep = request(t, srv, http.MethodGet, "/v1/status", keygen.KeyID()+"-"+keygen.Secret(), nil, nil)
+		require.Equal(t, http.StatusTooManyRequests, rep.StatusCode)
+	}
+
+	// The victim can still use their API key
+	rep = request(t, srv, http.MethodGet, "/v1/stats", victim, nil, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+	require.Equal(t, "1", rep.Header.Get(rtnl.RateLimitRemaining))
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	t.Setenv("RTNL_RATE_LIMIT_ENABLED", "true")
+	t.Setenv("RTNL_RATE_LIMIT_API_RATE", "0.001")
+	t.Setenv("RTNL_RATE_LIMIT_API_BURST", "2")
+
+	t.Run("Untrusted", func(t *testing.T) {
+		srv, _ := start(t, testConfig(t))
+
+		// Clients cannot avoid the limit by spoofing their address
+		for i, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+			rep := browse(t, srv, http.MethodGet, "/v1/status", nil, nil, "X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+			require.Equal(t, expected, rep.StatusCode)
+		}
+	})
+
+	t.Run("Trusted", func(t *testing.T) {
+		t.Setenv("RTNL_TRUSTED_PROXIES", "127.0.0.1")
+		srv, _ := start(t, testConfig(t))
+
+		// The address forwarded by a trusted proxy identifies the client
+		for i := 0; i < 3; i++ {
+			rep := browse(t, srv, http.MethodGet, "/v1/status", nil, nil, "X-Forwarded-For", fmt.Sprintf("203.0.113.%d", i))
+			require.Equal(t, http.StatusOK, rep.StatusCode)
+		}
+	})
+}
@@ -37,5 +37,6 @@ func (s *Server) Redirect(c *gin.Context) {
 	}
 
 	log.Info().Uint64("id", sid).Str("url", url).Msg("redirecting user")
+	s.clicked(sid)
 	c.Redirect(http.StatusFound, url)
 }
@@ -0,0 +1,232 @@
+package rtnl
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/replica"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// Opens the database as a replica and starts following the primary in the background
+// until the server is shutdown.
+func (s *Server) follow() (err error) {
+	if s.db, err = storage.OpenReplica(s.conf.Storage); err != nil {
+		return err
+	}
+
+	var follower *replica.Follower
+	if follower, err = replica.New(s.conf.Replica, s.db); err != nil {
+		s.db.Close()
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-s.done
+		cancel()
+	}()
+
+	s.maint.Add(1)
+	go func() {
+		defer s.maint.Done()
+		follower.Run(ctx)
+	}()
+
+	log.Info().Str("primary", s.conf.Replica.Primary).Msg("running as a read-only replica")
+	return nil
+}
+
+const (
+	// How often the primary pings followers to keep idle replication streams open.
+	replicatePingInterval = 30 * time.Second
+
+	// The maximum number of changes buffered for a follower before it is disconnected.
+	replicateBacklog = 10000
+)
+
+var errReplicationBacklog = errors.New("follower fell too far behind the primary")
+
+// Replicate streams the changes to the database to a follower over a websocket. A
+// snapshot of the keys that have changed since the version the follower has applied is
+// sent first, followed by a manifest of the keys on the primary, a checkpoint, and then
+// every change as it happens.
+func (s *Server) Replicate(c *gin.Context) {
+	var (
+		err   error
+		since uint64
+		conn  *websocket.Conn
+	)
+
+	if param := c.Query(replica.SinceParam); param != "" {
+		if since, err = strconv.ParseUint(param, 10, 64); err != nil {
+			c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse since version"))
+			return
+		}
+	}
+
+	if conn, err = s.upgrader.Upgrade(c.Writer, c.Request, nil); err != nil {
+		log.Error().Err(err).Msg("could not upgrade to websocket connection")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Stop replicating when the server shuts down
+	go func() {
+		select {
+		case <-s.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// The follower does not send messages, but reads are required to process control
+	// messages and to detect when the follower has closed the connection.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Subscribe to changes before taking the snapshot so that no changes are missed.
+	// Changes are buffered rather than blocking the subscription since that would stall
+	// every write on the primary while the snapshot is sent to a slow follower. The
+	// version of the first change received by the subscription is recorded to check
+	// that the subscription was registered before any change missing from the snapshot.
+	var first atomic.Uint64
+	changes := newBacklog(replicateBacklog)
+	go func() {
+		defer cancel()
+		err := s.db.Subscribe(ctx, first.Store, changes.Push)
+
+		if err != nil {
+			log.Warn().Err(err).Msg("replication subscription stopped")
+		}
+	}()
+
+	log.Info().Uint64("since", since).Str("client_ip", c.ClientIP()).Msg("replication stream opened")
+
+	var version uint64
+	if version, err = s.db.Stream(ctx, since, func(kvs *pb.KVList) error {
+		return writeChanges(conn, kvs)
+	}); err != nil {
+		log.Warn().Err(err).Msg("could not stream replication snapshot")
+		return
+	}
+
+	if err = s.db.Manifest(ctx, func(keys [][]byte) error {
+		return conn.WriteJSON(&replica.Manifest{Keys: keys})
+	}); err != nil {
+		log.Warn().Err(err).Msg("could not send replication manifest")
+		return
+	}
+
+	if err = conn.WriteJSON(&replica.Checkpoint{Version: version}); err != nil {
+		log.Warn().Err(err).Msg("could not send replication checkpoint")
+		return
+	}
+
+	ticker := time.NewTicker(replicatePingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Uint64("snapshot", version).Msg("replication stream closed")
+			return
+		case <-changes.Ready():
+			// The snapshot includes every version up to the checkpoint; if the first
+			// change is not the next version then changes committed before the
+			// subscription was registered may be missing, so the follower must resume
+			// from the checkpoint with a new snapshot.
+			if v := first.Load(); v > version+1 {
+				log.Warn().Uint64("snapshot", version).Uint64("subscribed", v).Msg("replication subscription started after the snapshot")
+				return
+			}
+
+			for _, kvs := range changes.Pop() {
+				if err = writeChanges(conn, kvs); err != nil {
+					log.Warn().Err(err).Msg("could not send replicated changes")
+					return
+				}
+			}
+		case <-ticker.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(replicatePingInterval)); err != nil {
+				log.Warn().Err(err).Msg("could not ping follower")
+				return
+			}
+		}
+	}
+}
+
+func writeChanges(conn *websocket.Conn, kvs *pb.KVList) (err error) {
+	var data []byte
+	if data, err = kvs.Marshal(); err != nil {
+		return err
+	}
+	return conn.WriteMessage(websocket.BinaryMessage, data)
+}
+
+// A backlog buffers the changes published by a subscription until they can be sent to
+// the follower without blocking the publisher. If the follower falls too far behind,
+// the subscription is stopped and the follower must reconnect and resume from the
+// version it has applied.
+type backlog struct {
+	sync.Mutex
+	limit   int
+	size    int
+	changes []*pb.KVList
+	ready   chan struct{}
+}
+
+func newBacklog(limit int) *backlog {
+	return &backlog{limit: limit, ready: make(chan struct{}, 1)}
+}
+
+// Push the changes onto the backlog; returns an error if the backlog is full.
+func (b *backlog) Push(kvs *pb.KVList) error {
+	b.Lock()
+	defer b.Unlock()
+
+	if b.size+len(kvs.Kv) > b.limit {
+		return errReplicationBacklog
+	}
+
+	b.changes = append(b.changes, kvs)
+	b.size += len(kvs.Kv)
+
+	select {
+	case b.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Ready is signaled when there are changes in the backlog.
+func (b *backlog) Ready() <-chan struct{} {
+	return b.ready
+}
+
+// Pop all of the changes from the backlog in the order they were pushed.
+func (b *backlog) Pop() (changes []*pb.KVList) {
+	b.Lock()
+	defer b.Unlock()
+	changes, b.changes, b.size = b.changes, nil, 0
+	return changes
+}
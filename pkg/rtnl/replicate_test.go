@@ -0,0 +1,112 @@
+package rtnl_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/replica"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicate(t *testing.T) {
+	conf := testConfig(t)
+
+	var replicator, writer string
+	seed(t, conf, func(db storage.Storage) {
+		replicator = createAPIKey(t, db, &models.APIKey{Name: "replica", Scopes: []string{auth.ScopeReplicate}})
+		writer = createAPIKey(t, db, &models.APIKey{Name: "writer", Scopes: auth.DefaultScopes})
+		require.NoError(t, db.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io"}))
+		require.NoError(t, db.SaveUser(&models.User{Email: "jdoe@rotational.io", DerivedKey: "secret"}))
+	})
+
+	srv, _ := start(t, conf)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		for _, token := range []string{"", writer} {
+			req, err := http.NewRequest(http.MethodGet, srv.URL()+replica.Endpoint, nil)
+			require.NoError(t, err)
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			rep, err := http.DefaultClient.Do(req)
+			require.NoError(t, err)
+			rep.Body.Close()
+			require.Contains(t, []int{http.StatusUnauthorized, http.StatusForbidden}, rep.StatusCode)
+		}
+
+		// The replication key cannot be used for administration
+		rep := request(t, srv, http.MethodGet, "/v1/apikeys", replicator, nil, nil)
+		require.Equal(t, http.StatusForbidden, rep.StatusCode)
+	})
+
+	t.Run("Follow", func(t *testing.T) {
+		store, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+		require.NoError(t, err, "could not open replica")
+		defer store.Close()
+
+		// A link that was deleted on the primary while the follower was disconnected
+		stale := &models.ShortURL{ID: 99, URL: "https://rotational.io/stale"}
+		value, err := stale.MarshalValue()
+		require.NoError(t, err)
+		require.NoError(t, store.Apply(&pb.KVList{Kv: []*pb.KV{{Key: stale.Key(), Value: value}}}))
+
+		follower, err := replica.New(config.ReplicaConfig{Enabled: true, Primary: srv.URL(), APIKey: replicator, MaxBackoff: time.Second}, store)
+		require.NoError(t, err, "could not create follower")
+
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			follower.Sync(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-done
+		}()
+
+		// The snapshot should be applied and the stale link pruned
+		require.Eventually(t, func() bool {
+			applied, err := store.Applied()
+			return err == nil && applied > 0
+		}, 5*time.Second, 10*time.Millisecond, "snapshot checkpoint was not applied")
+
+		_, err = store.LoadInfo(1)
+		require.NoError(t, err, "link was not replicated")
+
+		_, err = store.LoadInfo(stale.ID)
+		require.ErrorIs(t, err, storage.ErrNotFound, "stale link was not pruned")
+
+		// Users, sessions, and api keys are not replicated to followers
+		_, err = store.RetrieveUser("jdoe@rotational.io")
+		require.ErrorIs(t, err, storage.ErrNotFound, "user was replicated")
+
+		keys, err := store.ListAPIKeys()
+		require.NoError(t, err)
+		require.Empty(t, keys, "api keys were replicated")
+
+		// Changes on the primary after the snapshot should be streamed to the follower
+		req, err := http.NewRequest(http.MethodPost, srv.URL()+"/v1/shorten", strings.NewReader(`{"url": "https://rotational.io/blog"}`))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", "Bearer "+writer)
+		req.Header.Set("Content-Type", "application/json")
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		rep.Body.Close()
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+
+		require.Eventually(t, func() bool {
+			links, err := store.List()
+			return err == nil && len(links) == 2
+		}, 5*time.Second, 10*time.Millisecond, "new link was not replicated")
+	})
+}
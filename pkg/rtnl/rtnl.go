@@ -53,6 +53,14 @@ type Server struct {
 	started  time.Time          // The timestamp that the server was started (for uptime)
 	url      *url.URL           // The endpoint that the server is hosted on
 	echan    chan error         // Sending errors down this channel stops the server (is fatal)
+	done     chan struct{}      // Closing this channel stops background routines like maintenance
+	stop     sync.Once          // Ensures that the server is only shutdown once
+	stopErr  error              // The result of shutting down the server
+	maint    sync.WaitGroup     // Waits for background maintenance to complete on shutdown
+	usage    Usage              // Buffers the last time API keys were used
+	limits   RateLimits         // Throttles requests to each group of routes
+	verified VerifiedTokens     // Bearer tokens that can be rate limited by API key
+	clicks   Clicks             // Broadcasts clicks on short urls to updates subscribers
 }
 
 func New(conf config.Config) (s *Server, err error) {
@@ -69,6 +77,10 @@ func New(conf config.Config) (s *Server, err error) {
 		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 	}
 
+	if conf.Auth.HDClaim != "" {
+		log.Warn().Str("domain", conf.Auth.HDClaim).Msg("RTNL_AUTH_HD_CLAIM is deprecated, use RTNL_AUTH_DOMAINS instead")
+	}
+
 	// Prepare the API to generate correct web context data
 	api.Prepare(conf)
 
@@ -82,6 +94,12 @@ func New(conf config.Config) (s *Server, err error) {
 	router.UseRawPath = false
 	router.UnescapePathValues = true
 
+	// Only trust the client IP forwarded by the configured proxies, otherwise clients
+	// could avoid the rate limits by sending a different X-Forwarded-For every request.
+	if err = router.SetTrustedProxies(conf.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+
 	// Create the http server
 	srv := &http.Server{
 		Addr:              conf.BindAddr,
@@ -104,6 +122,8 @@ func New(conf config.Config) (s *Server, err error) {
 		router:   router,
 		upgrader: upgrader,
 		echan:    make(chan error, 1),
+		done:     make(chan struct{}),
+		limits:   NewRateLimits(conf.RateLimit),
 	}
 
 	// Create the authentication token manager
@@ -117,9 +137,37 @@ func New(conf config.Config) (s *Server, err error) {
 func (s *Server) Serve() (err error) {
 	// Setup database connections
 	if !s.conf.Maintenance {
-		if s.db, err = storage.Open(s.conf.Storage); err != nil {
+		if s.conf.Replica.Enabled {
+			if err = s.follow(); err != nil {
+				return err
+			}
+		} else if s.db, err = storage.Open(s.conf.Storage); err != nil {
 			return err
 		}
+
+		// Replicas do not authenticate API keys so there is no usage to record
+		if !s.conf.Replica.Enabled {
+			s.maint.Add(1)
+			go s.TrackUsage()
+		}
+
+		// Value log GC cannot be run on a read-only database
+		if s.conf.Storage.GCInterval > 0 && !s.conf.Storage.ReadOnly {
+			s.maint.Add(1)
+			go s.Maintain(s.conf.Storage.GCInterval)
+		}
+
+		// The aggregate counts of replicas are replicated from the primary
+		if s.conf.Storage.ExpireInterval > 0 && !s.conf.Storage.ReadOnly && !s.conf.Replica.Enabled {
+			s.maint.Add(1)
+			go s.Sweep(s.conf.Storage.ExpireInterval)
+		}
+	}
+
+	// Reload signing keys so that they can be rotated by adding them to the keys dir
+	if s.conf.Auth.KeysDir != "" && s.conf.Auth.KeysReload > 0 {
+		s.maint.Add(1)
+		go s.ReloadKeys(s.conf.Auth.KeysReload)
 	}
 
 	// Setup routes and middleware
@@ -135,7 +183,7 @@ func (s *Server) Serve() (err error) {
 
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 		defer cancel()
-		s.echan <- s.Shutdown(ctx)
+		s.Shutdown(ctx)
 	}()
 
 	// Create a socket to listen on and infer the final URL.
@@ -155,11 +203,12 @@ func (s *Server) Serve() (err error) {
 	s.healthy = true
 
 	// Listen for HTTP requests and handle them
+	// NOTE: when the server is closed, Shutdown sends its result on the error channel
+	// so that Serve does not return until maintenance and the database are stopped.
 	go func() {
 		if err := s.srv.Serve(sock); !errors.Is(err, http.ErrServerClosed) {
 			s.echan <- err
 		}
-		s.echan <- nil
 	}()
 
 	s.SetReady(true)
@@ -167,7 +216,24 @@ func (s *Server) Serve() (err error) {
 	return <-s.echan
 }
 
-func (s *Server) Shutdown(ctx context.Context) (err error) {
+// Shutdown the server gracefully, stopping background routines and closing the
+// database. Serve returns the result of the shutdown once it is complete; it is safe
+// to call Shutdown multiple times but only the first call shuts the server down.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.stop.Do(func() {
+		s.stopErr = s.shutdown(ctx)
+
+		// The error channel is buffered so this does not block if Serve has already
+		// returned because of a fatal error or if the server was never started.
+		select {
+		case s.echan <- s.stopErr:
+		default:
+		}
+	})
+	return s.stopErr
+}
+
+func (s *Server) shutdown(ctx context.Context) (err error) {
 	// Set ready to false to prevent additional requests
 	s.SetReady(false)
 	defer s.SetHealthy(false)
@@ -177,6 +243,10 @@ func (s *Server) Shutdown(ctx context.Context) (err error) {
 		err = errors.Join(err, serr)
 	}
 
+	// Stop background maintenance before closing the database
+	close(s.done)
+	s.maint.Wait()
+
 	if s.db != nil {
 		if serr := s.db.Close(); serr != nil {
 			err = errors.Join(err, serr)
@@ -242,33 +312,73 @@ func (s *Server) Routes(router *gin.Engine) (err error) {
 	}
 	router.StaticFS("/static", http.FS(static))
 
-	// Add the v1 API routes
-	v1 := router.Group("/v1")
+	// Rate limits are applied before authentication to prevent brute force attacks
+	var (
+		redirectLimit = s.RateLimit(s.limits.Redirect)
+		shortenLimit  = s.RateLimit(s.limits.Shorten)
+		loginLimit    = s.RateLimit(s.limits.Login)
+		apiLimit      = s.RateLimit(s.limits.API)
+	)
+
+	// Followers only serve redirects since they cannot modify the database
+	if s.conf.Replica.Enabled {
+		router.GET("/v1/status", s.Status)
+		router.GET("/favicon.ico", func(c *gin.Context) { c.Redirect(http.StatusPermanentRedirect, "/static/favicon.ico") })
+		router.GET("/robots.txt", func(c *gin.Context) { c.Redirect(http.StatusPermanentRedirect, "/static/robots.txt") })
+		router.GET("/:id", redirectLimit, s.Redirect)
+		router.NoRoute(s.NotFound)
+		router.NoMethod(s.NotAllowed)
+		return nil
+	}
+
+	// Add the v1 API routes; requests authenticated with cookies require a csrf token
+	v1 := router.Group("/v1", apiLimit, s.CSRF)
 	{
 		// Heartbeat route (no authentication required)
 		v1.GET("/status", s.Status)
-		v1.GET("/stats", s.Authenticate, s.ShortcrustStats)
-		v1.POST("/shorten", s.Authenticate, s.ShortenURL)
-		v1.GET("/updates", s.Updates) // TODO: add back authentication
-		v1.GET("/links", s.Authenticate, s.ShortURLList)
-		v1.POST("/links", s.Authenticate, s.ShortenURL)
-		v1.GET("/links/:id", s.Authenticate, s.ShortURLInfo)
-		v1.DELETE("/links/:id", s.Authenticate, s.DeleteShortURL)
-		v1.GET("/links/:id/updates", s.Authenticate, s.Updates)
+		v1.GET("/openapi.json", s.OpenAPI)
+		v1.GET("/stats", s.Authenticate, s.Authorize(auth.ScopeStatsRead), s.ShortcrustStats)
+		v1.POST("/shorten", shortenLimit, s.Authenticate, s.Authorize(auth.ScopeLinksWrite), s.ShortenURL)
+		v1.GET("/updates", s.Authenticate, s.Authorize(auth.ScopeLinksRead), s.Updates)
+		v1.GET("/links", s.Authenticate, s.Authorize(auth.ScopeLinksRead), s.ShortURLList)
+		v1.POST("/links", shortenLimit, s.Authenticate, s.Authorize(auth.ScopeLinksWrite), s.ShortenURL)
+		v1.POST("/links/batch", shortenLimit, s.Authenticate, s.Authorize(auth.ScopeLinksWrite), s.ShortenURLs)
+		v1.GET("/links/:id", s.Authenticate, s.Authorize(auth.ScopeLinksRead), s.ShortURLInfo)
+		v1.PATCH("/links/:id", s.Authenticate, s.Authorize(auth.ScopeLinksWrite), s.UpdateShortURL)
+		v1.DELETE("/links/:id", s.Authenticate, s.Authorize(auth.ScopeLinksDelete), s.DeleteShortURL)
+		v1.GET("/links/:id/updates", s.Authenticate, s.Authorize(auth.ScopeLinksRead), s.Updates)
+		v1.GET("/links/:id/qrcode", s.Authenticate, s.Authorize(auth.ScopeLinksRead), s.ShortURLQRCode)
+		v1.GET("/replicate", s.Authenticate, s.Authorize(auth.ScopeReplicate), s.Replicate)
+		v1.GET("/apikeys", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.APIKeyList)
+		v1.POST("/apikeys", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.CreateAPIKey)
+		v1.PUT("/apikeys/:id", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.UpdateAPIKey)
+		v1.DELETE("/apikeys/:id", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.RevokeAPIKey)
+		v1.POST("/apikeys/:id/rotate", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.RotateAPIKey)
+		v1.GET("/tokens", s.Authenticate, s.TokenList)
+		v1.POST("/tokens", s.Authenticate, s.CreateToken)
+		v1.DELETE("/tokens/:id", s.Authenticate, s.RevokeToken)
+		v1.GET("/sessions", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.SessionList)
+		v1.DELETE("/sessions", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.RevokeUserSessions)
+		v1.DELETE("/sessions/:id", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.RevokeSession)
+		v1.GET("/audit", s.Authenticate, s.Authorize(auth.ScopeAdmin), s.AuditLog)
 	}
 
 	// Web Routes
 	router.GET("/", s.WebAuthenticate, s.Index)
-	router.GET("/links", s.WebAuthenticate, s.List)
+	router.GET("/links", s.WebAuthenticate, s.Authorize(auth.ScopeLinksRead), s.List)
+	router.GET("/settings", s.WebAuthenticate, s.Settings)
 	router.GET("/login", s.LoginPage)
-	router.POST("/login", s.Login)
+	router.POST("/login", loginLimit, s.Login)
+	router.GET("/login/oidc", loginLimit, s.OIDCLogin)
+	router.GET("/login/oidc/callback", loginLimit, s.OIDCCallback)
 	router.GET("/logout", s.Logout)
+	router.GET("/.well-known/jwks.json", s.JWKS)
 
 	// Permenant Routes
-	router.GET("/:id", s.Redirect)
-	router.GET("/:id/info", s.WebAuthenticate, s.ShortURLDetail)
-	router.GET("/:id/qrcode", s.WebAuthenticate, s.ShortURLQRCode)
-	router.DELETE("/:id", s.WebAuthenticate, s.DeleteShortURL)
+	router.GET("/:id", redirectLimit, s.Redirect)
+	router.GET("/:id/info", s.WebAuthenticate, s.Authorize(auth.ScopeLinksRead), s.ShortURLDetail)
+	router.GET("/:id/qrcode", s.WebAuthenticate, s.Authorize(auth.ScopeLinksRead), s.ShortURLQRCode)
+	router.DELETE("/:id", s.CSRF, s.WebAuthenticate, s.Authorize(auth.ScopeLinksDelete), s.DeleteShortURL)
 
 	// Web Links
 	router.GET("/favicon.ico", func(c *gin.Context) { c.Redirect(http.StatusPermanentRedirect, "/static/favicon.ico") })
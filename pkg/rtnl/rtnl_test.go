@@ -0,0 +1,183 @@
+package rtnl_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/passwd"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeShutdown(t *testing.T) {
+	srv, errc := start(t, testConfig(t))
+
+	rep, err := http.Get(srv.URL() + "/readyz")
+	require.NoError(t, err, "could not make readyz request")
+	rep.Body.Close()
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+
+	// Calling shutdown directly should cause serve to return
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx), "could not shutdown server")
+
+	select {
+	case err := <-errc:
+		require.NoError(t, err, "serve returned an error after shutdown")
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "serve did not return after shutdown")
+	}
+
+	// Shutdown should be safe to call more than once
+	require.NotPanics(t, func() { srv.Shutdown(ctx) })
+	require.False(t, srv.IsReady())
+}
+
+// Create a test configuration that binds to a random port and stores its data in a
+// temporary directory.
+func testConfig(t *testing.T) config.Config {
+	t.Setenv("RTNL_MODE", gin.TestMode)
+	t.Setenv("RTNL_BIND_ADDR", "127.0.0.1:0")
+	t.Setenv("RTNL_STORAGE_DATA_PATH", t.TempDir())
+	t.Setenv("RTNL_STORAGE_GC_INTERVAL", "0")
+	t.Setenv("RTNL_STORAGE_EXPIRE_INTERVAL", "0")
+
+	conf, err := config.New()
+	require.NoError(t, err, "could not create config")
+	return conf
+}
+
+// Populate the database of the test configuration before the server opens it; the
+// database is closed before returning so that the server can take the directory lock.
+func seed(t *testing.T, conf config.Config, fn func(storage.Storage)) {
+	db, err := storage.Open(conf.Storage)
+	require.NoError(t, err, "could not open storage to seed")
+	defer db.Close()
+	fn(db)
+}
+
+// Register an API key with the specified scopes, returning the bearer token.
+func createAPIKey(t *testing.T, db storage.Storage, key *models.APIKey) (token string) {
+	secret := keygen.Secret()
+	key.ClientID = keygen.KeyID()
+
+	var err error
+	key.DerivedKey, err = passwd.CreateDerivedKey(secret)
+	require.NoError(t, err, "could not create derived key")
+	require.NoError(t, db.Register(key), "could not register api key")
+	return key.ClientID + "-" + secret
+}
+
+// Create a local account with the specified password so that the user can log in.
+func createUser(t *testing.T, db storage.Storage, user *models.User, password string) {
+	var err error
+	user.DerivedKey, err = passwd.CreateDerivedKey(password)
+	require.NoError(t, err, "could not create derived key")
+	require.NoError(t, db.SaveUser(user), "could not save user")
+}
+
+// Log in to the test server with the email and password of a local account, returning
+// the authentication cookies that were set by the server.
+func login(t *testing.T, srv *rtnl.Server, email, password string) []*http.Cookie {
+	rep := browse(t, srv, http.MethodPost, "/login", nil, &api.LoginForm{Email: email, Password: password})
+	require.Equal(t, http.StatusFound, rep.StatusCode, "could not log in")
+	require.NotEmpty(t, rep.Cookies(), "no cookies set on login")
+	return rep.Cookies()
+}
+
+// Make a request to the test server as a browser with the specified cookies, encoding
+// the body as JSON if it is not nil. Unlike request, redirects are not followed so that
+// the cookies set by the response can be inspected. The response body is closed.
+func browse(t *testing.T, srv *rtnl.Server, method, path string, cookies []*http.Cookie, body interface{}, headers ...string) *http.Response {
+	var in io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err, "could not marshal request body")
+		in = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, srv.URL()+path, in)
+	require.NoError(t, err, "could not create request")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	rep, err := client.Do(req)
+	require.NoError(t, err, "could not make request")
+	rep.Body.Close()
+	return rep
+}
+
+// Make a request to the test server authenticated with the bearer token (if not empty),
+// encoding the body as JSON if it is not nil. If out is not nil, the JSON response is
+// decoded into it. The response is returned with its body closed.
+func request(t *testing.T, srv *rtnl.Server, method, path, token string, body, out interface{}) *http.Response {
+	var in io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		require.NoError(t, err, "could not marshal request body")
+		in = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, srv.URL()+path, in)
+	require.NoError(t, err, "could not create request")
+	req.Header.Set("Accept", "application/json")
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rep, err := http.DefaultClient.Do(req)
+	require.NoError(t, err, "could not make request")
+	defer rep.Body.Close()
+
+	if out != nil {
+		require.NoError(t, json.NewDecoder(rep.Body).Decode(out), "could not decode response")
+	}
+	return rep
+}
+
+// Start serving the test configuration, returning the server once it is ready along
+// with a channel that receives the result of Serve. The server is shutdown when the
+// test is complete.
+func start(t *testing.T, conf config.Config) (*rtnl.Server, <-chan error) {
+	srv, err := rtnl.New(conf)
+	require.NoError(t, err, "could not create server")
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Serve() }()
+	require.Eventually(t, srv.IsReady, 5*time.Second, 10*time.Millisecond, "server did not become ready")
+
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(ctx)
+	})
+	return srv, errc
+}
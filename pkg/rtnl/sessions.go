@@ -0,0 +1,132 @@
+package rtnl
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/rs/zerolog/log"
+)
+
+func (s *Server) SessionList(c *gin.Context) {
+	var (
+		err      error
+		in       *api.SessionQuery
+		sessions []*models.Session
+	)
+
+	in = &api.SessionQuery{}
+	if err = c.BindQuery(in); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	if sessions, err = s.db.ListSessions(in.Email); err != nil {
+		log.Warn().Err(err).Msg("could not list sessions")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	out := &api.SessionList{Sessions: make([]*api.Session, 0, len(sessions))}
+	for _, session := range sessions {
+		out.Sessions = append(out.Sessions, session.ToAPI())
+	}
+
+	c.JSON(http.StatusOK, out)
+}
+
+func (s *Server) RevokeSession(c *gin.Context) {
+	id := c.Param("id")
+	if err := s.db.RevokeSession(id); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, api.ErrorResponse("session not found"))
+			return
+		}
+
+		log.Warn().Err(err).Str("session", id).Msg("could not revoke session")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	log.Info().Str("session", id).Msg("session revoked")
+	s.Audit(c, "", models.ActionSessionRevoked, id, "", "")
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// RevokeUserSessions logs the user out everywhere by revoking all of their sessions.
+func (s *Server) RevokeUserSessions(c *gin.Context) {
+	email := c.Query("email")
+	if email == "" {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(api.ErrMissingEmail))
+		return
+	}
+
+	revoked, err := s.db.RevokeUserSessions(email)
+	if err != nil {
+		log.Warn().Err(err).Str("email", email).Msg("could not revoke user sessions")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	log.Info().Str("email", email).Int("revoked", revoked).Msg("user sessions revoked")
+	s.Audit(c, "", models.ActionSessionRevoked, email, "", fmt.Sprintf("revoked=%d", revoked))
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
+
+// StartSession records the session of the tokens issued to the user when they log in.
+func (s *Server) StartSession(c *gin.Context, claims *auth.Claims, refreshToken string) (err error) {
+	var session *models.Session
+	if session, err = newSession(c, claims, refreshToken); err != nil {
+		return err
+	}
+	return s.db.CreateSession(session)
+}
+
+// RefreshSession rotates the session of the previous tokens to the refreshed tokens;
+// an error is returned if the previous session has been revoked or already refreshed.
+func (s *Server) RefreshSession(c *gin.Context, prevID string, claims *auth.Claims, refreshToken string) (err error) {
+	var session *models.Session
+	if session, err = newSession(c, claims, refreshToken); err != nil {
+		return err
+	}
+	return s.db.RotateSession(prevID, session, s.conf.Auth.RefreshReuse)
+}
+
+// EndSession revokes the session of the access token cookie when the user logs out.
+func (s *Server) EndSession(c *gin.Context) {
+	accessToken, _ := c.Cookie(accessTokenCookie)
+	if accessToken == "" {
+		return
+	}
+
+	// The access token may have expired but its signature must still be valid
+	claims, err := s.auth.Parse(accessToken)
+	if err != nil {
+		return
+	}
+
+	if err = s.db.RevokeSession(claims.ID); err != nil && !errors.Is(err, storage.ErrNotFound) {
+		log.Warn().Err(err).Msg("could not revoke session on logout")
+	}
+	s.Audit(c, claims.Email, models.ActionUserLogout, claims.ID, "", "")
+}
+
+func newSession(c *gin.Context, claims *auth.Claims, refreshToken string) (_ *models.Session, err error) {
+	session := &models.Session{
+		ID:        claims.ID,
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		UserAgent: c.Request.UserAgent(),
+		IPAddr:    c.ClientIP(),
+	}
+
+	if session.Expires, err = auth.ExpiresAt(refreshToken); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
@@ -0,0 +1,101 @@
+package rtnl_test
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConcurrentRefresh(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("RTNL_AUTH_ACCESS_DURATION", "1s")
+	conf := testConfig(t)
+
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+	})
+
+	srv, _ := start(t, conf)
+	cookies := login(t, srv, "jdoe@example.com", "supersecretsquirrel")
+
+	// Wait for the access token to expire so that the refresh token must be used
+	time.Sleep(2 * time.Second)
+
+	// A page that makes several requests at once should not be logged out
+	const requests = 8
+	var wg sync.WaitGroup
+	replies := make([]*http.Response, requests)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			replies[i] = browse(t, srv, http.MethodGet, "/v1/stats", cookies, nil)
+		}(i)
+	}
+	wg.Wait()
+
+	var refreshed []*http.Cookie
+	for _, rep := range replies {
+		require.Equal(t, http.StatusOK, rep.StatusCode, "concurrent refresh was not authorized")
+		if len(rep.Cookies()) > 0 {
+			require.Nil(t, refreshed, "expected the tokens to be refreshed only once")
+			refreshed = rep.Cookies()
+		}
+	}
+	require.NotNil(t, refreshed, "expected the tokens to be refreshed")
+
+	// The refreshed tokens can be used after the concurrent requests
+	rep := browse(t, srv, http.MethodGet, "/v1/stats", refreshed, nil)
+	require.Equal(t, http.StatusOK, rep.StatusCode)
+
+	// Logging out revokes the refreshed session so the old refresh token cannot be reused
+	rep = browse(t, srv, http.MethodGet, "/logout", refreshed, nil)
+	require.Equal(t, http.StatusFound, rep.StatusCode)
+
+	rep = browse(t, srv, http.MethodGet, "/v1/stats", cookies, nil)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode, "refresh token reused after logout")
+}
+
+func TestDisabledRefresh(t *testing.T) {
+	keys := t.TempDir()
+	_, err := auth.GenerateKey(keys)
+	require.NoError(t, err, "could not generate signing key")
+
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	t.Setenv("RTNL_AUTH_ACCESS_DURATION", "1s")
+	t.Setenv("RTNL_AUTH_KEYS_DIR", keys)
+	conf := testConfig(t)
+
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+	})
+
+	srv, _ := start(t, conf)
+	cookies := login(t, srv, "jdoe@example.com", "supersecretsquirrel")
+
+	// Disable the user without revoking their sessions while the server is stopped
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	require.NoError(t, srv.Shutdown(ctx), "could not shutdown server")
+
+	seed(t, conf, func(db storage.Storage) {
+		user, err := db.RetrieveUser("jdoe@example.com")
+		require.NoError(t, err, "could not retrieve user")
+		user.Disabled = time.Now()
+		require.NoError(t, db.SaveUser(user), "could not save user")
+	})
+
+	// Once the access token expires the disabled user cannot refresh it
+	srv, _ = start(t, conf)
+	time.Sleep(2 * time.Second)
+
+	rep := browse(t, srv, http.MethodGet, "/v1/stats", cookies, nil)
+	require.Equal(t, http.StatusUnauthorized, rep.StatusCode)
+}
@@ -3,8 +3,11 @@ package rtnl
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
@@ -20,11 +23,17 @@ import (
 )
 
 const (
-	ContentDisposition = "Content-Disposition"
-	ContentType        = "Content-Type"
-	ContentLength      = "Content-Length"
-	AcceptLength       = "Accept-Length"
-	ContentTypePNG     = "image/png"
+	ContentDisposition   = "Content-Disposition"
+	ContentType          = "Content-Type"
+	ContentLength        = "Content-Length"
+	AcceptLength         = "Accept-Length"
+	ContentTypePNG       = "image/png"
+	RetryAfter           = "Retry-After"
+	QuotaLimit           = "X-Quota-Limit"
+	QuotaRemaining       = "X-Quota-Remaining"
+	QuotaReset           = "X-Quota-Reset"
+	QuotaActiveLimit     = "X-Quota-Active-Limit"
+	QuotaActiveRemaining = "X-Quota-Active-Remaining"
 )
 
 func (s *Server) ShortenURL(c *gin.Context) {
@@ -58,10 +67,25 @@ func (s *Server) ShortenURL(c *gin.Context) {
 	model.ID, _ = base62.Decode(sid)
 	model.Expires, _ = long.ExpiresAt()
 
+	// Record the user or API key that created the link as its owner.
+	model.CreatedBy = Principal(c)
+
+	// Links created with an API key count against the quota of the key.
+	apikey := GetAPIKey(c)
+	if apikey != nil {
+		model.APIKey = apikey.ClientID
+	}
+
 	// By default we attempt to create the model, but if it already exists then we do
 	// not modify it and return a 200 status instead of a 201 status.
 	code := http.StatusCreated
 	if err = s.db.Save(model); err != nil {
+		if errors.Is(err, storage.ErrQuotaExceeded) {
+			s.setQuotaHeaders(c, apikey, true)
+			c.JSON(http.StatusTooManyRequests, api.ErrorResponse(api.ErrQuotaExceeded))
+			return
+		}
+
 		// If the URL already exists in the database return it without an error.
 		// If the error is not an already exists error than return 500.
 		if !errors.Is(err, storage.ErrAlreadyExists) {
@@ -81,7 +105,12 @@ func (s *Server) ShortenURL(c *gin.Context) {
 		code = http.StatusOK
 	}
 
+	if code == http.StatusCreated {
+		s.Audit(c, "", models.ActionLinkCreated, sid, "", model.URL)
+	}
+
 	// Create the output response to send back to the user.
+	s.setQuotaHeaders(c, apikey, false)
 	out := model.ToAPI()
 	out.URL, out.AltURL = s.conf.MakeOriginURLs(sid)
 
@@ -93,6 +122,105 @@ func (s *Server) ShortenURL(c *gin.Context) {
 	})
 }
 
+// ShortenURLs shortens a batch of urls and returns the result of each url in the same
+// order as the request. The links are saved in a single transaction; urls that are
+// invalid or that exceed the quota of the API key are reported as errors without
+// failing the rest of the batch.
+func (s *Server) ShortenURLs(c *gin.Context) {
+	var (
+		err error
+		in  *api.LongURLBatch
+	)
+
+	if err = c.BindJSON(&in); err != nil {
+		log.Warn().Err(err).Msg("could not parse batch shorten request")
+		c.JSON(http.StatusBadRequest, api.ErrUnparsable)
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	// Prepare the links to save, recording the position of each link in the batch
+	out := &api.ShortURLBatch{Results: make([]*api.BatchResult, len(in.URLs))}
+	links := make([]*models.ShortURL, 0, len(in.URLs))
+	positions := make([]int, 0, len(in.URLs))
+
+	creator := Principal(c)
+	apikey := GetAPIKey(c)
+
+	for i, long := range in.URLs {
+		if long == nil {
+			long = &api.LongURL{}
+		}
+
+		if err = long.Validate(); err != nil {
+			out.Results[i] = &api.BatchResult{Status: api.BatchError, Error: err.Error()}
+			continue
+		}
+
+		var sid string
+		if sid, err = short.URL(long.URL); err != nil {
+			log.Warn().Err(err).Str("url", long.URL).Msg("could not shorten url in batch")
+			out.Results[i] = &api.BatchResult{Status: api.BatchError, Error: "could not shorten url"}
+			continue
+		}
+
+		model := &models.ShortURL{URL: long.URL, CreatedBy: creator}
+		model.ID, _ = base62.Decode(sid)
+		model.Expires, _ = long.ExpiresAt()
+		if apikey != nil {
+			model.APIKey = apikey.ClientID
+		}
+
+		links = append(links, model)
+		positions = append(positions, i)
+	}
+
+	var errs []error
+	if errs, err = s.db.SaveMany(links); err != nil {
+		log.Error().Err(err).Int("links", len(links)).Msg("could not store batch of shortened urls")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("unable to complete request"))
+		return
+	}
+
+	var exceeded bool
+	for j, model := range links {
+		i := positions[j]
+		sid := base62.Encode(model.ID)
+
+		switch {
+		case errs[j] == nil:
+			s.Audit(c, "", models.ActionLinkCreated, sid, "", model.URL)
+			out.Results[i] = &api.BatchResult{Status: api.BatchCreated, Link: model.ToAPI()}
+		case errors.Is(errs[j], storage.ErrAlreadyExists):
+			// Return the link that already exists without modifying it
+			existing, err := s.db.LoadInfo(model.ID)
+			if err != nil {
+				log.Error().Err(err).Str("id", sid).Msg("could not fetch short url after already exists error")
+				out.Results[i] = &api.BatchResult{Status: api.BatchError, Error: "unable to complete request"}
+				continue
+			}
+			out.Results[i] = &api.BatchResult{Status: api.BatchExisting, Link: existing.ToAPI()}
+		case errors.Is(errs[j], storage.ErrQuotaExceeded):
+			exceeded = true
+			out.Results[i] = &api.BatchResult{Status: api.BatchError, Error: api.ErrQuotaExceeded.Error()}
+			continue
+		default:
+			log.Error().Err(errs[j]).Str("id", sid).Msg("could not store shortened url in batch")
+			out.Results[i] = &api.BatchResult{Status: api.BatchError, Error: "unable to complete request"}
+			continue
+		}
+
+		out.Results[i].Link.URL, out.Results[i].Link.AltURL = s.conf.MakeOriginURLs(sid)
+	}
+
+	s.setQuotaHeaders(c, apikey, exceeded)
+	c.JSON(http.StatusOK, out)
+}
+
 func (s *Server) ShortURLInfo(c *gin.Context) {
 	var (
 		err error
@@ -131,6 +259,73 @@ func (s *Server) ShortURLInfo(c *gin.Context) {
 	})
 }
 
+func (s *Server) UpdateShortURL(c *gin.Context) {
+	var (
+		err   error
+		sid   uint64
+		in    *api.ShortURL
+		model *models.ShortURL
+	)
+
+	// Get URL parameter from input
+	if sid, err = base62.Decode(c.Param("id")); err != nil {
+		log.Debug().Err(err).Str("input", c.Param("id")).Msg("could not parse user input")
+		c.JSON(http.StatusNotFound, api.ErrNotFoundReply)
+		return
+	}
+
+	if err = c.BindJSON(&in); err != nil {
+		log.Warn().Err(err).Msg("could not parse update short url request")
+		c.JSON(http.StatusBadRequest, api.ErrUnparsable)
+		return
+	}
+
+	// Only the owner of the link or an admin can edit it
+	if model, err = s.db.LoadInfo(sid); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, api.ErrorResponse("short url not found"))
+			return
+		}
+
+		log.Warn().Err(err).Uint64("id", sid).Msg("could not load url from database")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("unable to complete request"))
+		return
+	}
+
+	if !CanModify(c, model) {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(api.ErrNotOwner))
+		return
+	}
+
+	// Only the title and description of the link can be edited; fields that are
+	// omitted from the request are not modified.
+	before := linkSummary(model)
+	if title := strings.TrimSpace(in.Title); title != "" {
+		model.Title = title
+	}
+
+	if description := strings.TrimSpace(in.Description); description != "" {
+		model.Description = description
+	}
+
+	if err = s.db.Update(model); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, api.ErrorResponse("short url not found"))
+			return
+		}
+
+		log.Warn().Err(err).Uint64("id", sid).Msg("could not update url in database")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("unable to complete request"))
+		return
+	}
+
+	s.Audit(c, "", models.ActionLinkUpdated, base62.Encode(sid), before, linkSummary(model))
+
+	out := model.ToAPI()
+	out.URL, out.AltURL = s.conf.MakeOriginURLs(base62.Encode(sid))
+	c.JSON(http.StatusOK, out)
+}
+
 func (s *Server) DeleteShortURL(c *gin.Context) {
 	var (
 		err error
@@ -144,6 +339,24 @@ func (s *Server) DeleteShortURL(c *gin.Context) {
 		return
 	}
 
+	// Only the owner of the link or an admin can delete it
+	var model *models.ShortURL
+	if model, err = s.db.LoadInfo(sid); err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			c.JSON(http.StatusNotFound, api.ErrorResponse("short url not found"))
+			return
+		}
+
+		log.Warn().Err(err).Uint64("id", sid).Msg("could not load url from database")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("unable to complete request"))
+		return
+	}
+
+	if !CanModify(c, model) {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(api.ErrNotOwner))
+		return
+	}
+
 	// Delete URL info from the database
 	if err = s.db.Delete(sid); err != nil {
 		if errors.Is(err, storage.ErrNotFound) {
@@ -156,7 +369,8 @@ func (s *Server) DeleteShortURL(c *gin.Context) {
 		return
 	}
 
-	log.Info().Uint64("id", sid).Msg("short url deleted")
+	log.Info().Uint64("id", sid).Str("deleted_by", Principal(c)).Msg("short url deleted")
+	s.Audit(c, "", models.ActionLinkDeleted, base62.Encode(sid), linkSummary(model), "")
 
 	// Redirect the user if this is an HTMX request
 	if c.NegotiateFormat(binding.MIMEJSON, binding.MIMEHTML) == binding.MIMEHTML {
@@ -222,9 +436,10 @@ func (s *Server) ShortURLQRCode(c *gin.Context) {
 
 func (s *Server) ShortURLList(c *gin.Context) {
 	var (
-		err  error
-		page *api.PageQuery
-		out  *api.ShortURLList
+		err   error
+		page  *api.PageQuery
+		query *api.LinkQuery
+		out   *api.ShortURLList
 	)
 
 	// Bind and validate the page query request
@@ -240,10 +455,29 @@ func (s *Server) ShortURLList(c *gin.Context) {
 		return
 	}
 
+	// Bind and validate the link filters
+	query = &api.LinkQuery{}
+	if err = c.BindQuery(query); err != nil {
+		log.Warn().Err(err).Msg("could not bind link query")
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("could not parse link query from request"))
+		return
+	}
+
+	if err = query.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	filter := &storage.LinkQuery{Host: query.Host, CreatedBy: query.CreatedBy}
+	if query.Mine {
+		filter.CreatedBy = Principal(c)
+	}
+	filter.After, filter.Before, _ = query.Range()
+
 	// Retrieve the page from the database
 	// TODO: pass the page query to the listing function
 	var urls []*models.ShortURL
-	if urls, err = s.db.List(); err != nil {
+	if urls, err = s.db.Query(filter); err != nil {
 		log.Warn().Err(err).Msg("could not retrieve short url list from db")
 		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
 		return
@@ -257,10 +491,11 @@ func (s *Server) ShortURLList(c *gin.Context) {
 
 	for _, url := range urls {
 		out.URLs = append(out.URLs, &api.ShortURL{
-			URL:    base62.Encode(url.ID),
-			Target: url.URL,
-			Title:  url.Title,
-			Visits: url.Visits,
+			URL:       base62.Encode(url.ID),
+			Target:    url.URL,
+			Title:     url.Title,
+			Visits:    url.Visits,
+			CreatedBy: url.CreatedBy,
 		})
 	}
 
@@ -271,3 +506,46 @@ func (s *Server) ShortURLList(c *gin.Context) {
 		JSONData: out,
 	})
 }
+
+// Sets the quota headers on the response if the API key has a quota so that clients
+// can determine how many more links they can create. If the quota has been exceeded
+// then the Retry-After header is also set when the daily limit is the cause.
+func (s *Server) setQuotaHeaders(c *gin.Context, apikey *models.APIKey, exceeded bool) {
+	if apikey == nil || apikey.Quota.IsZero() {
+		return
+	}
+
+	usage, err := s.db.Usage(apikey.ClientID)
+	if err != nil {
+		log.Warn().Err(err).Str("client_id", apikey.ClientID).Msg("could not load api key usage")
+		return
+	}
+
+	if limit := apikey.Quota.LinksPerDay; limit > 0 {
+		reset := usage.Reset()
+		c.Header(QuotaLimit, strconv.FormatUint(limit, 10))
+		c.Header(QuotaRemaining, strconv.FormatUint(remaining(limit, usage.Links), 10))
+		c.Header(QuotaReset, strconv.FormatInt(reset.Unix(), 10))
+
+		if exceeded && usage.Links >= limit {
+			c.Header(RetryAfter, strconv.Itoa(int(time.Until(reset).Seconds())+1))
+		}
+	}
+
+	if limit := apikey.Quota.ActiveLinks; limit > 0 {
+		c.Header(QuotaActiveLimit, strconv.FormatUint(limit, 10))
+		c.Header(QuotaActiveRemaining, strconv.FormatUint(remaining(limit, usage.Active), 10))
+	}
+}
+
+func remaining(limit, used uint64) uint64 {
+	if used >= limit {
+		return 0
+	}
+	return limit - used
+}
+
+// Summarizes the editable fields of the link for the audit log.
+func linkSummary(link *models.ShortURL) string {
+	return fmt.Sprintf("url=%q title=%q description=%q", link.URL, link.Title, link.Description)
+}
@@ -0,0 +1,100 @@
+package rtnl_test
+
+import (
+	"net/http"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotas(t *testing.T) {
+	conf := testConfig(t)
+
+	var daily, active, unlimited string
+	seed(t, conf, func(db storage.Storage) {
+		daily = createAPIKey(t, db, &models.APIKey{Name: "daily", Scopes: auth.DefaultScopes, Quota: &models.Quota{LinksPerDay: 2}})
+		active = createAPIKey(t, db, &models.APIKey{Name: "active", Scopes: auth.DefaultScopes, Quota: &models.Quota{ActiveLinks: 1}})
+		unlimited = createAPIKey(t, db, &models.APIKey{Name: "unlimited", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+
+	t.Run("LinksPerDay", func(t *testing.T) {
+		for i, expected := range []string{"1", "0"} {
+			rep := request(t, srv, http.MethodPost, "/v1/shorten", daily, &api.LongURL{URL: "https://rotational.io/daily/" + strconv.Itoa(i)}, nil)
+			require.Equal(t, http.StatusCreated, rep.StatusCode)
+			require.Equal(t, "2", rep.Header.Get(rtnl.QuotaLimit))
+			require.Equal(t, expected, rep.Header.Get(rtnl.QuotaRemaining))
+			require.Empty(t, rep.Header.Get(rtnl.RetryAfter), "retry after set before quota was exceeded")
+		}
+
+		out := &api.Reply{}
+		rep := request(t, srv, http.MethodPost, "/v1/shorten", daily, &api.LongURL{URL: "https://rotational.io/daily/2"}, out)
+		require.Equal(t, http.StatusTooManyRequests, rep.StatusCode)
+		require.Equal(t, api.ErrQuotaExceeded.Error(), out.Error)
+		require.Equal(t, "2", rep.Header.Get(rtnl.QuotaLimit))
+		require.Equal(t, "0", rep.Header.Get(rtnl.QuotaRemaining))
+
+		reset, err := strconv.ParseInt(rep.Header.Get(rtnl.QuotaReset), 10, 64)
+		require.NoError(t, err, "could not parse quota reset header")
+		require.True(t, time.Unix(reset, 0).After(time.Now()), "quota reset is not in the future")
+
+		retry, err := strconv.Atoi(rep.Header.Get(rtnl.RetryAfter))
+		require.NoError(t, err, "could not parse retry after header")
+		require.Positive(t, retry)
+		require.LessOrEqual(t, retry, int(time.Until(time.Unix(reset, 0)).Seconds())+1)
+
+		// Links in a batch should also be rejected once the quota is exhausted
+		batch := &api.ShortURLBatch{}
+		rep = request(t, srv, http.MethodPost, "/v1/links/batch", daily, &api.LongURLBatch{URLs: []*api.LongURL{{URL: "https://rotational.io/daily/3"}}}, batch)
+		require.Equal(t, http.StatusOK, rep.StatusCode)
+		require.Len(t, batch.Results, 1)
+		require.Equal(t, api.BatchError, batch.Results[0].Status)
+		require.Equal(t, api.ErrQuotaExceeded.Error(), batch.Results[0].Error)
+		require.Equal(t, "0", rep.Header.Get(rtnl.QuotaRemaining))
+		require.NotEmpty(t, rep.Header.Get(rtnl.RetryAfter))
+
+		// Only the literal batch route is served
+		for _, path := range []string{"/v1/links:batch", "/v1/linksbatch"} {
+			rep = request(t, srv, http.MethodPost, path, daily, &api.LongURLBatch{URLs: []*api.LongURL{{URL: "https://rotational.io/daily/4"}}}, nil)
+			require.Equal(t, http.StatusNotFound, rep.StatusCode, "expected %s to not be routed", path)
+		}
+	})
+
+	t.Run("ActiveLinks", func(t *testing.T) {
+		created := &api.ShortURL{}
+		rep := request(t, srv, http.MethodPost, "/v1/shorten", active, &api.LongURL{URL: "https://rotational.io/active/0"}, created)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+		require.Equal(t, "1", rep.Header.Get(rtnl.QuotaActiveLimit))
+		require.Equal(t, "0", rep.Header.Get(rtnl.QuotaActiveRemaining))
+		require.Empty(t, rep.Header.Get(rtnl.QuotaLimit), "daily quota headers set without a daily quota")
+
+		rep = request(t, srv, http.MethodPost, "/v1/shorten", active, &api.LongURL{URL: "https://rotational.io/active/1"}, nil)
+		require.Equal(t, http.StatusTooManyRequests, rep.StatusCode)
+		require.Equal(t, "0", rep.Header.Get(rtnl.QuotaActiveRemaining))
+		require.Empty(t, rep.Header.Get(rtnl.RetryAfter), "active links do not reset so retry after should not be set")
+
+		// Deleting the link frees up the quota
+		rep = request(t, srv, http.MethodDelete, "/v1/links/"+path.Base(created.URL), active, nil, nil)
+		require.Equal(t, http.StatusOK, rep.StatusCode)
+
+		rep = request(t, srv, http.MethodPost, "/v1/shorten", active, &api.LongURL{URL: "https://rotational.io/active/1"}, nil)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+	})
+
+	t.Run("Unlimited", func(t *testing.T) {
+		rep := request(t, srv, http.MethodPost, "/v1/shorten", unlimited, &api.LongURL{URL: "https://rotational.io/unlimited"}, nil)
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+		for _, header := range []string{rtnl.QuotaLimit, rtnl.QuotaRemaining, rtnl.QuotaReset, rtnl.QuotaActiveLimit, rtnl.QuotaActiveRemaining, rtnl.RetryAfter} {
+			require.Empty(t, rep.Header.Get(header), "%s set for a key without a quota", header)
+		}
+	})
+}
@@ -22,4 +22,16 @@ function copyAltUrlToClipboard() {
         altShortIcon.classList.remove('fa-circle-check');
         altShortIcon.classList.add('fa-copy');
     }, 1000);
-}
\ No newline at end of file
+}
+function copyTokenToClipboard() {
+    const token = document.getElementById('token').innerText;
+    navigator.clipboard.writeText(token);
+
+    const tokenIcon = document.getElementById('token-icon');
+    tokenIcon.classList.remove('fa-copy');
+    tokenIcon.classList.add('fa-circle-check');
+    setTimeout(() => {
+        tokenIcon.classList.remove('fa-circle-check');
+        tokenIcon.classList.add('fa-copy');
+    }, 1000);
+}
@@ -3,7 +3,25 @@
   console.info("link shortening application logged in and ready");
 })();
 
-// Ensure the accept header is set to text/html for all htmx requests.
+// Ensure the accept header is set to text/html for all htmx requests and send the csrf
+// token from its cookie so that requests authenticated with cookies are accepted.
 document.body.addEventListener('htmx:configRequest', (e) => {
   e.detail.headers['Accept'] = 'text/html'
-});
\ No newline at end of file
+
+  const csrfToken = getCookie('csrf_token');
+  if (csrfToken) {
+    e.detail.headers['X-CSRF-TOKEN'] = csrfToken;
+  }
+});
+
+// Returns the value of the cookie with the specified name or null if it is not set.
+function getCookie(name) {
+  const prefix = name + '=';
+  for (const cookie of document.cookie.split(';')) {
+    const value = cookie.trim();
+    if (value.startsWith(prefix)) {
+      return decodeURIComponent(value.substring(prefix.length));
+    }
+  }
+  return null;
+}
\ No newline at end of file
@@ -56,6 +56,11 @@ func (s *Server) Status(c *gin.Context) {
 	})
 }
 
+// OpenAPI serves the OpenAPI specification of the v1 API.
+func (s *Server) OpenAPI(c *gin.Context) {
+	c.Data(http.StatusOK, gin.MIMEJSON, api.OpenAPI())
+}
+
 func (s *Server) NotFound(c *gin.Context) {
 	c.JSON(http.StatusNotFound, api.ErrNotFoundReply)
 }
@@ -0,0 +1,176 @@
+package rtnl
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/passwd"
+	"github.com/rotationalio/rtnl.link/pkg/rtnl/htmx"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Settings returns the settings page where users manage their personal access tokens.
+func (s *Server) Settings(c *gin.Context) {
+	data := api.GetWebData().WithUser(GetPrincipal(c))
+	c.HTML(http.StatusOK, "settings.html", data)
+}
+
+// TokenList returns the personal access tokens of the logged in user including revoked
+// tokens so that the user can see when their tokens were last used.
+func (s *Server) TokenList(c *gin.Context) {
+	claims := GetClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(api.ErrWebLoginRequired))
+		return
+	}
+
+	keys, err := s.db.ListOwnerAPIKeys(claims.Email)
+	if err != nil {
+		log.Warn().Err(err).Msg("could not list personal access tokens")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	out := &api.APIKeyList{APIKeys: make([]*api.APIKey, 0, len(keys))}
+	for _, key := range keys {
+		out.APIKeys = append(out.APIKeys, key.ToAPI())
+	}
+
+	c.Negotiate(http.StatusOK, gin.Negotiate{
+		Offered:  []string{gin.MIMEHTML, gin.MIMEJSON},
+		HTMLName: "tokens_list.html",
+		HTMLData: out,
+		JSONData: out,
+	})
+}
+
+// CreateToken creates a personal access token owned by the logged in user. The token
+// can only be granted scopes that are allowed by the role of the user and defaults to
+// the default scopes that the role allows.
+func (s *Server) CreateToken(c *gin.Context) {
+	var (
+		err error
+		in  *api.APIKey
+	)
+
+	claims := GetClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(api.ErrWebLoginRequired))
+		return
+	}
+
+	in = &api.APIKey{}
+	if err = c.Bind(in); err != nil {
+		log.Warn().Err(err).Msg("could not parse create token request")
+		c.JSON(http.StatusBadRequest, api.ErrUnparsable)
+		return
+	}
+
+	if in.ClientID != "" || in.Quota != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(api.ErrReadOnlyField))
+		return
+	}
+
+	if err = in.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	granted := auth.RoleScopes(claims.Role)
+	scopes := auth.Scopes(in.Scopes)
+	if len(scopes) == 0 {
+		scopes = auth.DefaultScopes.Restrict(granted)
+	}
+
+	if err = scopes.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse(err))
+		return
+	}
+
+	for _, scope := range scopes {
+		if !granted.Has(scope) {
+			c.JSON(http.StatusForbidden, api.ErrorResponse(fmt.Errorf("%w: %s", api.ErrForbidden, scope)))
+			return
+		}
+	}
+
+	apikey := &models.APIKey{
+		ClientID: keygen.KeyID(),
+		Name:     in.Name,
+		Scopes:   scopes,
+		Owner:    models.NormalizeEmail(claims.Email),
+	}
+
+	if in.Expires != nil {
+		apikey.Expires = *in.Expires
+	}
+
+	secret := keygen.Secret()
+	if apikey.DerivedKey, err = passwd.CreateDerivedKey(secret); err != nil {
+		log.Error().Err(err).Msg("could not create derived key")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	if err = s.db.Register(apikey); err != nil {
+		log.Error().Err(err).Msg("could not register personal access token")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not complete request"))
+		return
+	}
+
+	log.Info().Str("client_id", apikey.ClientID).Str("owner", apikey.Owner).Strs("scopes", apikey.Scopes).Msg("personal access token created")
+	s.Audit(c, "", models.ActionAPIKeyCreated, apikey.ClientID, "", apikey.Summary())
+
+	out := apikey.ToAPI()
+	out.ClientSecret = secret
+	c.Negotiate(http.StatusCreated, gin.Negotiate{
+		Offered:  []string{gin.MIMEHTML, gin.MIMEJSON},
+		HTMLName: "token_created.html",
+		HTMLData: out,
+		JSONData: out,
+	})
+}
+
+// RevokeToken revokes a personal access token owned by the logged in user; tokens that
+// are owned by other users are reported as not found.
+func (s *Server) RevokeToken(c *gin.Context) {
+	claims := GetClaims(c)
+	if claims == nil {
+		c.JSON(http.StatusForbidden, api.ErrorResponse(api.ErrWebLoginRequired))
+		return
+	}
+
+	clientID := c.Param("id")
+	apikey, err := s.db.Retrieve(clientID)
+	if err != nil {
+		s.apikeyError(c, err, "could not retrieve personal access token")
+		return
+	}
+
+	if apikey.Owner != models.NormalizeEmail(claims.Email) {
+		s.apikeyError(c, storage.ErrNotFound, "")
+		return
+	}
+
+	if err = s.db.RevokeAPIKey(clientID); err != nil {
+		s.apikeyError(c, err, "could not revoke personal access token")
+		return
+	}
+
+	log.Info().Str("client_id", clientID).Str("owner", apikey.Owner).Msg("personal access token revoked")
+	s.Audit(c, "", models.ActionAPIKeyRevoked, clientID, "", "")
+
+	// Reload the settings page if this is an HTMX request
+	if c.NegotiateFormat(gin.MIMEJSON, gin.MIMEHTML) == gin.MIMEHTML {
+		htmx.Redirect(c, http.StatusFound, "/settings")
+		return
+	}
+
+	c.JSON(http.StatusOK, &api.Reply{Success: true})
+}
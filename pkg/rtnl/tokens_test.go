@@ -0,0 +1,61 @@
+package rtnl_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokens(t *testing.T) {
+	t.Setenv("RTNL_AUTH_LOCAL_ACCOUNTS", "true")
+	conf := testConfig(t)
+
+	seed(t, conf, func(db storage.Storage) {
+		createUser(t, db, &models.User{Email: "jdoe@example.com", Name: "Jane Doe", Role: auth.RoleEditor}, "supersecretsquirrel")
+		createAPIKey(t, db, &models.APIKey{Name: "laptop", Owner: "jdoe@example.com", Scopes: auth.DefaultScopes})
+		createAPIKey(t, db, &models.APIKey{Name: "laptop", Owner: "asmith@example.com", Scopes: auth.DefaultScopes})
+		createAPIKey(t, db, &models.APIKey{Name: "ci", Scopes: auth.DefaultScopes})
+	})
+
+	srv, _ := start(t, conf)
+	cookies := login(t, srv, "jdoe@example.com", "supersecretsquirrel")
+	csrf := cookie(cookies, "csrf_token")
+
+	t.Run("NullBody", func(t *testing.T) {
+		rep := browse(t, srv, http.MethodPost, "/v1/tokens", cookies, json.RawMessage("null"), "X-CSRF-TOKEN", csrf, "Accept", "application/json")
+		require.Equal(t, http.StatusBadRequest, rep.StatusCode)
+	})
+
+	t.Run("Create", func(t *testing.T) {
+		rep := browse(t, srv, http.MethodPost, "/v1/tokens", cookies, &api.APIKey{Name: "cli"}, "X-CSRF-TOKEN", csrf, "Accept", "application/json")
+		require.Equal(t, http.StatusCreated, rep.StatusCode)
+	})
+
+	t.Run("List", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL()+"/v1/tokens", nil)
+		require.NoError(t, err, "could not create request")
+		req.Header.Set("Accept", "application/json")
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+
+		rep, err := http.DefaultClient.Do(req)
+		require.NoError(t, err, "could not make request")
+		defer rep.Body.Close()
+		require.Equal(t, http.StatusOK, rep.StatusCode)
+
+		// Only the tokens owned by the user are listed
+		out := &api.APIKeyList{}
+		require.NoError(t, json.NewDecoder(rep.Body).Decode(out), "could not decode response")
+		require.Len(t, out.APIKeys, 2)
+		for _, key := range out.APIKeys {
+			require.Equal(t, "jdoe@example.com", key.Owner)
+		}
+	})
+}
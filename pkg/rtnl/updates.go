@@ -0,0 +1,142 @@
+package rtnl
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/rotationalio/rtnl.link/pkg/base62"
+	"github.com/rs/zerolog/log"
+)
+
+// The number of clicks buffered for each updates subscriber; clicks are dropped rather
+// than blocking redirects if a subscriber cannot keep up.
+const clicksBufferSize = 64
+
+// Clicks broadcasts the clicks on short urls to the subscribers of the updates
+// websocket. Subscribers can filter the clicks to a single link.
+type Clicks struct {
+	sync.RWMutex
+	subs map[chan *api.Click]string
+}
+
+// Subscribe returns a channel of the clicks on the link or on all links if the link is
+// empty. The cancel function must be called to unsubscribe and close the channel.
+func (h *Clicks) Subscribe(link string) (clicks <-chan *api.Click, cancel func()) {
+	h.Lock()
+	defer h.Unlock()
+	if h.subs == nil {
+		h.subs = make(map[chan *api.Click]string)
+	}
+
+	sub := make(chan *api.Click, clicksBufferSize)
+	h.subs[sub] = link
+
+	var once sync.Once
+	return sub, func() {
+		once.Do(func() {
+			h.Lock()
+			defer h.Unlock()
+			delete(h.subs, sub)
+			close(sub)
+		})
+	}
+}
+
+// Publish sends the click to every subscriber of the link without blocking.
+func (h *Clicks) Publish(click *api.Click) {
+	h.RLock()
+	defer h.RUnlock()
+	for sub, link := range h.subs {
+		if link != "" && link != click.Link {
+			continue
+		}
+
+		select {
+		case sub <- click:
+		default:
+		}
+	}
+}
+
+// Records a click on the short url for the updates websocket.
+func (s *Server) clicked(sid uint64) {
+	s.clicks.Publish(&api.Click{
+		Link:  base62.Encode(sid),
+		Time:  time.Now().Truncate(time.Hour),
+		Views: 1,
+	})
+}
+
+// Updates serves a web socket connection to stream live updates back to the client.
+// Each click on a short url is sent as a JSON message; if a link id is specified then
+// only clicks on that link are sent.
+func (s *Server) Updates(c *gin.Context) {
+	var (
+		err    error
+		conn   *websocket.Conn
+		linkID string
+	)
+
+	// Parse the URL if given for filtering the stream
+	if linkID = c.Param("id"); linkID != "" {
+		if _, err = base62.Decode(linkID); err != nil {
+			log.Debug().Err(err).Str("input", linkID).Msg("could not parse user input")
+			c.JSON(http.StatusNotFound, api.ErrNotFoundReply)
+			return
+		}
+	}
+
+	// Upgrade the connection to an http/2 connection for websockets
+	if conn, err = s.upgrader.Upgrade(c.Writer, c.Request, nil); err != nil {
+		log.Error().Err(err).Msg("could not upgrade to websocket connection")
+		return
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The client does not send messages, but reads are required to process control
+	// messages and to detect when the client has closed the connection.
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	clicks, unsubscribe := s.clicks.Subscribe(linkID)
+	defer unsubscribe()
+
+	log.Info().Str("link_id", linkID).Msg("updates websocket opened")
+	ticker := time.NewTicker(replicatePingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Str("link_id", linkID).Msg("updates websocket closed")
+			return
+		case <-s.done:
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"), time.Now().Add(time.Second))
+			return
+		case click := <-clicks:
+			if err = conn.WriteJSON(click); err != nil {
+				log.Warn().Err(err).Msg("could not send update")
+				return
+			}
+		case <-ticker.C:
+			if err = conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(replicatePingInterval)); err != nil {
+				log.Warn().Err(err).Msg("could not ping updates client")
+				return
+			}
+		}
+	}
+}
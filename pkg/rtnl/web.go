@@ -1,24 +1,33 @@
 package rtnl
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"net/url"
+	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/gorilla/websocket"
 	"github.com/rotationalio/rtnl.link/pkg"
 	api "github.com/rotationalio/rtnl.link/pkg/api/v1"
 	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 	"github.com/rs/zerolog/log"
 )
 
+const (
+	oidcLoginCookie  = "oidc_login"
+	oidcLoginPath    = "/login/oidc"
+	oidcLoginTimeout = 10 * time.Minute
+)
+
 // Index returns the home page and landing dashboard.
 func (s *Server) Index(c *gin.Context) {
-	data := api.GetWebData()
+	data := api.GetWebData().WithUser(GetPrincipal(c))
 	c.HTML(http.StatusOK, "index.html", data)
 }
 
 func (s *Server) List(c *gin.Context) {
-	data := api.GetWebData()
+	data := api.GetWebData().WithUser(GetPrincipal(c))
 	c.HTML(http.StatusOK, "list.html", data)
 }
 
@@ -28,6 +37,7 @@ func (s *Server) ShortURLDetail(c *gin.Context) {
 	data := gin.H{
 		"ID":      c.Param("id"),
 		"Version": pkg.Version(),
+		"User":    GetPrincipal(c),
 	}
 	c.HTML(http.StatusOK, "info.html", data)
 }
@@ -35,10 +45,21 @@ func (s *Server) ShortURLDetail(c *gin.Context) {
 // Login page returns the web-based login for a Google sign-in button.
 func (s *Server) LoginPage(c *gin.Context) {
 	data := api.GetLoginData()
+
+	// Carry the page the user requested through each of the login flows
+	if data.Next = s.conf.SafeRedirect(c.Query("next")); data.Next != "" {
+		query := "?" + url.Values{"next": {data.Next}}.Encode()
+		data.LoginURI += query
+		if data.OIDCLoginURI != "" {
+			data.OIDCLoginURI += query
+		}
+	}
+
 	c.HTML(http.StatusOK, "login.html", data)
 }
 
-// Login handles the POST request from Google when a user successfully logs in.
+// Login handles the POST request from Google when a user successfully logs in or the
+// login form submitted by users of local accounts with their email and password.
 func (s *Server) Login(c *gin.Context) {
 	// TODO: switch to cookie-based authentication!
 	var (
@@ -53,26 +74,112 @@ func (s *Server) Login(c *gin.Context) {
 		return
 	}
 
-	if in.Credential == "" {
-		c.JSON(http.StatusBadRequest, api.ErrorResponse("jwt credential is required"))
+	var claims *auth.Claims
+	switch {
+	case in.Credential != "":
+		// Parse the JWT id token from Google and validate it
+		if claims, err = s.auth.CheckGoogleIDToken(c.Request.Context(), in.Credential); err != nil {
+			c.JSON(http.StatusUnauthorized, api.ErrorResponse(err))
+			return
+		}
+	case in.Email != "" || in.Password != "":
+		// Verify the password of the local account
+		if claims, err = s.CheckPassword(c, in.Email, in.Password); err != nil {
+			c.JSON(http.StatusUnauthorized, api.ErrorResponse(err))
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("jwt credential or email and password are required"))
 		return
 	}
 
-	// Parse the JWT id token from Google and validate it
-	var claims *auth.Claims
-	if claims, err = s.auth.CheckGoogleIDToken(c.Request.Context(), in.Credential); err != nil {
+	s.LoginUser(c, claims, in.Next)
+}
+
+// OIDCLogin redirects the user to the openid connect provider to log in, storing the
+// state, nonce, and PKCE verifier of the login request in a short-lived cookie.
+func (s *Server) OIDCLogin(c *gin.Context) {
+	login := auth.NewOIDCLogin()
+	login.Next = s.conf.SafeRedirect(c.Query("next"))
+	authURL, err := s.auth.OIDCAuthCodeURL(c.Request.Context(), login)
+	if err != nil {
+		log.Error().Err(err).Msg("could not create openid connect authorization url")
+		c.JSON(http.StatusServiceUnavailable, api.ErrorResponse("could not connect to login provider"))
+		return
+	}
+
+	// The cookie must be sent when the provider redirects back to the callback
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcLoginCookie, login.Encode(), int(oidcLoginTimeout.Seconds()), oidcLoginPath, s.conf.Auth.CookieDomain, s.conf.Auth.CookieDomain != "localhost", true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback handles the redirect from the openid connect provider after the user
+// logs in by exchanging the authorization code for the user's claims.
+func (s *Server) OIDCCallback(c *gin.Context) {
+	var (
+		err    error
+		cookie string
+		login  *auth.OIDCLogin
+		claims *auth.Claims
+	)
+
+	// The login request can only be used once
+	cookie, _ = c.Cookie(oidcLoginCookie)
+	c.SetCookie(oidcLoginCookie, "", -1, oidcLoginPath, s.conf.Auth.CookieDomain, s.conf.Auth.CookieDomain != "localhost", true)
+
+	if reason := c.Query("error"); reason != "" {
+		log.Debug().Str("error", reason).Str("description", c.Query("error_description")).Msg("openid connect login failed")
+		c.JSON(http.StatusUnauthorized, api.ErrorResponse("login was not completed with the provider"))
+		return
+	}
+
+	if login, err = auth.DecodeOIDCLogin(cookie); err != nil {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("login request has expired, please try again"))
+		return
+	}
+
+	if subtle.ConstantTimeCompare([]byte(login.State), []byte(c.Query("state"))) != 1 {
+		c.JSON(http.StatusBadRequest, api.ErrorResponse("login state does not match request"))
+		return
+	}
+
+	if claims, err = s.auth.CheckOIDCCode(c.Request.Context(), c.Query("code"), login); err != nil {
+		log.Debug().Err(err).Msg("could not verify openid connect login")
 		c.JSON(http.StatusUnauthorized, api.ErrorResponse(err))
 		return
 	}
 
+	s.LoginUser(c, claims, login.Next)
+}
+
+// LoginUser assigns the role of the user to their verified claims, sets the access and
+// refresh token cookies, and redirects the user to the next page if it is on this
+// server, otherwise the user is redirected back to the home page.
+func (s *Server) LoginUser(c *gin.Context, claims *auth.Claims, next string) {
+	// Assign the role of the user before creating tokens from the claims
+	var disabled bool
+	if claims.Role, disabled = s.UserAccess(claims.Email); disabled {
+		c.JSON(http.StatusUnauthorized, api.ErrorResponse(api.ErrInvalidCredentials))
+		return
+	}
+	log.Debug().Str("email", claims.Email).Str("role", claims.Role).Msg("user logged in")
+
 	// Create access and refresh tokens from the claims
-	var atks, rtks string
-	if atks, rtks, err = s.auth.CreateTokenPair(claims); err != nil {
+	atks, rtks, err := s.auth.CreateTokenPair(claims)
+	if err != nil {
 		log.Warn().Err(err).Msg("could not create access and refresh token pair from claims")
 		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create user credentials"))
 		return
 	}
 
+	// Record the session so that the refresh token can be rotated and revoked
+	if err = s.StartSession(c, claims, rtks); err != nil {
+		log.Warn().Err(err).Msg("could not create user session")
+		c.JSON(http.StatusInternalServerError, api.ErrorResponse("could not create user credentials"))
+		return
+	}
+
 	// Store the access token as a cookie on the outgoing response
 	if err = s.SetAuthCookies(c, atks, rtks); err != nil {
 		log.Warn().Err(err).Msg("could not parse expiration of refresh token")
@@ -80,35 +187,18 @@ func (s *Server) Login(c *gin.Context) {
 		return
 	}
 
-	// Redirect the user back to the home page
-	c.Redirect(http.StatusFound, "/")
+	s.Audit(c, claims.Email, models.ActionUserLogin, claims.ID, "", "role="+claims.Role)
+
+	// Redirect the user back to the page they requested or the home page
+	if next = s.conf.SafeRedirect(next); next == "" {
+		next = "/"
+	}
+	c.Redirect(http.StatusFound, next)
 }
 
 func (s *Server) Logout(c *gin.Context) {
-	// Remove authentication cookies and redirect to the login page
+	// Revoke the session, remove authentication cookies, and redirect to the login page
+	s.EndSession(c)
 	s.ClearAuthCookies(c)
 	c.Redirect(http.StatusFound, "/login")
 }
-
-// Updates serves a web socket connection to stream live updates back to the client.
-func (s *Server) Updates(c *gin.Context) {
-	var (
-		err    error
-		conn   *websocket.Conn
-		linkID string
-	)
-
-	// Upgrade the connection to an http/2 connection for websockets
-	if conn, err = s.upgrader.Upgrade(c.Writer, c.Request, nil); err != nil {
-		log.Error().Err(err).Msg("could not upgrade to websocket connection")
-		c.JSON(http.StatusInternalServerError, api.ErrorResponse(err))
-		return
-	}
-	defer conn.Close()
-
-	// Parse the URL if given for filtering the stream
-	linkID = c.Param("id")
-	log.Info().Str("link_id", linkID).Msg("updates websocket opened")
-
-	c.AbortWithStatus(http.StatusNotImplemented)
-}
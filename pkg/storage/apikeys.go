@@ -9,6 +9,10 @@ import (
 )
 
 func (s *Store) Register(obj *models.APIKey) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
 	if obj.Created.IsZero() {
 		obj.Created = time.Now()
 	}
@@ -29,7 +33,15 @@ func (s *Store) Register(obj *models.APIKey) error {
 			return err
 		}
 
-		return txn.Set(key, val)
+		if err := txn.Set(key, val); err != nil {
+			return err
+		}
+
+		// Index personal access tokens by owner so they can be listed without a scan
+		if obj.IsPersonal() {
+			return txn.Set(obj.OwnerKey(), []byte(obj.ClientID))
+		}
+		return nil
 	})
 	return err
 }
@@ -60,3 +72,192 @@ func (s *Store) Retrieve(clientID string) (*models.APIKey, error) {
 	}
 	return obj, nil
 }
+
+// ListAPIKeys returns all of the API keys in the database including revoked keys.
+func (s *Store) ListAPIKeys() ([]*models.APIKey, error) {
+	keys := make([]*models.APIKey, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := models.APIKeysBucket[:]
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			obj := &models.APIKey{}
+			if err := it.Item().Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+			keys = append(keys, obj)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// ListOwnerAPIKeys returns the personal access tokens owned by the user including
+// revoked tokens using the index of API keys by owner.
+func (s *Store) ListOwnerAPIKeys(owner string) ([]*models.APIKey, error) {
+	keys := make([]*models.APIKey, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := (&models.APIKey{Owner: models.NormalizeEmail(owner)}).OwnerKey()
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			obj := &models.APIKey{}
+			if err := it.Item().Value(func(val []byte) error {
+				obj.ClientID = string(val)
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			item, err := txn.Get(obj.Key())
+			if err != nil {
+				return err
+			}
+
+			if err = item.Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+			keys = append(keys, obj)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// UpdateAPIKey updates the name of the API key along with its scopes and quota if they
+// are not nil and its expiration if it is not zero; other fields are not modified.
+func (s *Store) UpdateAPIKey(obj *models.APIKey) error {
+	return s.modifyAPIKey(obj.ClientID, func(key *models.APIKey) error {
+		key.Name = obj.Name
+		if obj.Scopes != nil {
+			key.Scopes = obj.Scopes
+		}
+
+		if obj.Quota != nil {
+			key.Quota = obj.Quota
+		}
+
+		if !obj.Expires.IsZero() {
+			key.Expires = obj.Expires
+		}
+		*obj = *key
+		return nil
+	})
+}
+
+// RevokeAPIKey marks the API key as revoked so that it can no longer be used.
+func (s *Store) RevokeAPIKey(clientID string) error {
+	return s.modifyAPIKey(clientID, func(key *models.APIKey) error {
+		if key.IsRevoked() {
+			return ErrRevoked
+		}
+		key.Revoked = time.Now()
+		return nil
+	})
+}
+
+// RotateAPIKey replaces the derived key of the API key so that the previous secret
+// can no longer be used; the client ID and metadata of the key are unchanged.
+func (s *Store) RotateAPIKey(clientID, derivedKey string) (key *models.APIKey, err error) {
+	err = s.modifyAPIKey(clientID, func(obj *models.APIKey) error {
+		if obj.IsRevoked() {
+			return ErrRevoked
+		}
+		obj.DerivedKey = derivedKey
+		key = obj
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// TouchAPIKeys records the last time each API key was used. Keys that have been
+// deleted are ignored and timestamps older than the recorded last use are skipped.
+func (s *Store) TouchAPIKeys(used map[string]time.Time) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	return s.update(func(txn *badger.Txn) error {
+		for clientID, ts := range used {
+			obj := &models.APIKey{ClientID: clientID}
+			item, err := txn.Get(obj.Key())
+			if err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					continue
+				}
+				return err
+			}
+
+			if err = item.Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+
+			if !ts.After(obj.LastUsed) {
+				continue
+			}
+
+			obj.LastUsed = ts
+			var val []byte
+			if val, err = obj.MarshalValue(); err != nil {
+				return err
+			}
+
+			if err = txn.Set(obj.Key(), val); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Loads the API key, applies the modification, and saves the key in a transaction.
+func (s *Store) modifyAPIKey(clientID string, modify func(*models.APIKey) error) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	err := s.update(func(txn *badger.Txn) error {
+		obj := &models.APIKey{ClientID: clientID}
+		item, err := txn.Get(obj.Key())
+		if err != nil {
+			return err
+		}
+
+		if err = item.Value(obj.UnmarshalValue); err != nil {
+			return err
+		}
+
+		if err = modify(obj); err != nil {
+			return err
+		}
+
+		obj.Modified = time.Now()
+		var val []byte
+		if val, err = obj.MarshalValue(); err != nil {
+			return err
+		}
+		return txn.Set(obj.Key(), val)
+	})
+
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAPIKeyLifecycle(t *testing.T) {
+	db := openStore(t)
+
+	keys, err := db.ListAPIKeys()
+	require.NoError(t, err, "could not list api keys in empty database")
+	require.Len(t, keys, 0)
+
+	apikey := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "original", Name: "ci"}
+	require.NoError(t, db.Register(apikey), "could not register api key")
+	require.NoError(t, db.Register(&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "other"}))
+
+	keys, err = db.ListAPIKeys()
+	require.NoError(t, err, "could not list api keys")
+	require.Len(t, keys, 2)
+
+	// Updating the key should only change its name and scopes
+	update := &models.APIKey{ClientID: apikey.ClientID, Name: "github actions", DerivedKey: "ignored", Scopes: []string{"links:read"}}
+	require.NoError(t, db.UpdateAPIKey(update), "could not update api key")
+	require.Equal(t, "original", update.DerivedKey)
+
+	cmp, err := db.Retrieve(apikey.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, "github actions", cmp.Name)
+	require.Equal(t, "original", cmp.DerivedKey)
+	require.Equal(t, []string{"links:read"}, cmp.Scopes)
+
+	// Scopes are not modified if they are not specified
+	require.NoError(t, db.UpdateAPIKey(&models.APIKey{ClientID: apikey.ClientID, Name: "github actions"}))
+	cmp, err = db.Retrieve(apikey.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, []string{"links:read"}, cmp.Scopes)
+
+	// Rotating the key should replace the derived key
+	rotated, err := db.RotateAPIKey(apikey.ClientID, "rotated")
+	require.NoError(t, err, "could not rotate api key")
+	require.Equal(t, "rotated", rotated.DerivedKey)
+	require.Equal(t, "github actions", rotated.Name)
+
+	// Usage should only be recorded if it is more recent than the last use
+	now := time.Now().Truncate(time.Millisecond)
+	used := map[string]time.Time{apikey.ClientID: now, keygen.KeyID(): now}
+	require.NoError(t, db.TouchAPIKeys(used), "could not touch api keys")
+	require.NoError(t, db.TouchAPIKeys(map[string]time.Time{apikey.ClientID: now.Add(-1 * time.Hour)}))
+
+	cmp, err = db.Retrieve(apikey.ClientID)
+	require.NoError(t, err)
+	require.True(t, now.Equal(cmp.LastUsed), "last used was not recorded")
+
+	// Revoked keys cannot be revoked again or rotated
+	require.NoError(t, db.RevokeAPIKey(apikey.ClientID), "could not revoke api key")
+	require.ErrorIs(t, db.RevokeAPIKey(apikey.ClientID), storage.ErrRevoked)
+	_, err = db.RotateAPIKey(apikey.ClientID, "again")
+	require.ErrorIs(t, err, storage.ErrRevoked)
+
+	cmp, err = db.Retrieve(apikey.ClientID)
+	require.NoError(t, err)
+	require.True(t, cmp.IsRevoked())
+
+	// Unknown keys should return not found
+	unknown := keygen.KeyID()
+	require.ErrorIs(t, db.RevokeAPIKey(unknown), storage.ErrNotFound)
+	require.ErrorIs(t, db.UpdateAPIKey(&models.APIKey{ClientID: unknown}), storage.ErrNotFound)
+	_, err = db.RotateAPIKey(unknown, "rotated")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestOwnerAPIKeys(t *testing.T) {
+	db := openStore(t)
+
+	keys, err := db.ListOwnerAPIKeys("jdoe@example.com")
+	require.NoError(t, err, "could not list owner api keys in empty database")
+	require.Len(t, keys, 0)
+
+	personal := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Name: "laptop", Owner: "jdoe@example.com"}
+	require.NoError(t, db.Register(personal), "could not register personal access token")
+	require.NoError(t, db.Register(&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Name: "cli", Owner: "jdoe@example.com"}))
+	require.NoError(t, db.Register(&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Name: "laptop", Owner: "jdoe@example.co"}))
+	require.NoError(t, db.Register(&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Name: "ci"}))
+
+	// Only the keys owned by the user are listed, including revoked keys
+	require.NoError(t, db.RevokeAPIKey(personal.ClientID), "could not revoke api key")
+	keys, err = db.ListOwnerAPIKeys("JDoe@example.com")
+	require.NoError(t, err, "could not list owner api keys")
+	require.Len(t, keys, 2)
+
+	for _, key := range keys {
+		require.Equal(t, "jdoe@example.com", key.Owner)
+		if key.ClientID == personal.ClientID {
+			require.True(t, key.IsRevoked(), "expected the stored key to be listed")
+		}
+	}
+
+	keys, err = db.ListOwnerAPIKeys("asmith@example.com")
+	require.NoError(t, err)
+	require.Len(t, keys, 0)
+}
+
+func TestQuotas(t *testing.T) {
+	db := openStore(t)
+
+	apikey := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Quota: &models.Quota{LinksPerDay: 3, ActiveLinks: 2}}
+	require.NoError(t, db.Register(apikey), "could not register api key")
+
+	usage, err := db.Usage(apikey.ClientID)
+	require.NoError(t, err, "could not load usage of unused key")
+	require.Zero(t, usage.Links)
+	require.Zero(t, usage.Active)
+
+	// Links created with the key should count against its quota
+	require.NoError(t, db.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io", APIKey: apikey.ClientID}))
+	require.NoError(t, db.Save(&models.ShortURL{ID: 2, URL: "https://rotational.io/blog", APIKey: apikey.ClientID}))
+	require.ErrorIs(t, db.Save(&models.ShortURL{ID: 3, URL: "https://rotational.io/about", APIKey: apikey.ClientID}), storage.ErrQuotaExceeded)
+
+	// The link should not have been saved when the quota was exceeded
+	_, err = db.LoadInfo(3)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	usage, err = db.Usage(apikey.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(2), usage.Links)
+	require.Equal(t, uint64(2), usage.Active)
+
+	// Deleting a link frees up an active link but does not reset the daily usage
+	require.NoError(t, db.Delete(1), "could not delete link")
+	require.NoError(t, db.Save(&models.ShortURL{ID: 3, URL: "https://rotational.io/about", APIKey: apikey.ClientID}))
+	require.NoError(t, db.Delete(2), "could not delete link")
+	require.ErrorIs(t, db.Save(&models.ShortURL{ID: 4, URL: "https://rotational.io/careers", APIKey: apikey.ClientID}), storage.ErrQuotaExceeded)
+
+	usage, err = db.Usage(apikey.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(3), usage.Links)
+	require.Equal(t, uint64(1), usage.Active)
+
+	// Links created without a key or with a key without a quota are not limited
+	unlimited := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret"}
+	require.NoError(t, db.Register(unlimited), "could not register api key")
+	require.NoError(t, db.Save(&models.ShortURL{ID: 4, URL: "https://rotational.io/careers", APIKey: unlimited.ClientID}))
+	require.NoError(t, db.Save(&models.ShortURL{ID: 5, URL: "https://rotational.io/contact"}))
+
+	// Daily usage is not tracked for keys without a quota
+	usage, err = db.Usage(unlimited.ClientID)
+	require.NoError(t, err)
+	require.Zero(t, usage.Links)
+	require.Equal(t, uint64(1), usage.Active)
+
+	// Links cannot be created with unknown keys
+	require.ErrorIs(t, db.Save(&models.ShortURL{ID: 6, URL: "https://rotational.io/ensign", APIKey: keygen.KeyID()}), storage.ErrNotFound)
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// AuditQuery filters the audit log; zero valued fields are ignored.
+type AuditQuery struct {
+	Actor  string    // the user or client that performed the action
+	After  time.Time // events that occurred at or after this timestamp
+	Before time.Time // events that occurred before this timestamp
+	Limit  int       // the maximum number of events to return
+}
+
+// Audit appends the event to the audit log, assigning it an ID and timestamp if they
+// are not set. Events are never modified or deleted once they are recorded.
+func (s *Store) Audit(obj *models.AuditEvent) (err error) {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	if obj.Time.IsZero() {
+		obj.Time = time.Now()
+	}
+
+	if obj.ID.Compare(ulid.ULID{}) == 0 {
+		if obj.ID, err = ulid.New(ulid.Timestamp(obj.Time), ulid.DefaultEntropy()); err != nil {
+			return err
+		}
+	}
+
+	var val []byte
+	if val, err = obj.MarshalValue(); err != nil {
+		return err
+	}
+
+	return s.update(func(txn *badger.Txn) error {
+		// Do not overwrite an existing event in the audit log
+		if _, err := txn.Get(obj.Key()); err == nil {
+			return ErrAlreadyExists
+		}
+		return txn.Set(obj.Key(), val)
+	})
+}
+
+// AuditLog returns the events that match the query with the most recent events first.
+// Because events are keyed by ULID, the log is scanned in reverse starting at the end
+// of the time range and stops as soon as an event before the start of the range is found.
+func (s *Store) AuditLog(q *AuditQuery) ([]*models.AuditEvent, error) {
+	if q == nil {
+		q = &AuditQuery{}
+	}
+
+	events := make([]*models.AuditEvent, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		// In reverse mode seek finds the last key that is less than or equal to the seek
+		// key, so seek to the end of the bucket or to the first ULID of the end time.
+		prefix := models.AuditBucket[:]
+		seek := append(bytes.Clone(prefix), bytes.Repeat([]byte{0xff}, 16)...)
+		if !q.Before.IsZero() {
+			var start ulid.ULID
+			if err := start.SetTime(ulid.Timestamp(q.Before)); err != nil {
+				return err
+			}
+			seek = (&models.AuditEvent{ID: start}).Key()
+		}
+
+		for it.Seek(seek); it.ValidForPrefix(prefix); it.Next() {
+			obj := &models.AuditEvent{}
+			if err := it.Item().Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+
+			if !q.Before.IsZero() && !obj.Time.Before(q.Before) {
+				continue
+			}
+
+			if !q.After.IsZero() && obj.Time.Before(q.After) {
+				break
+			}
+
+			if q.Actor != "" && obj.Actor != q.Actor {
+				continue
+			}
+
+			events = append(events, obj)
+			if q.Limit > 0 && len(events) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return events, nil
+}
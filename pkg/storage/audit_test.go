@@ -0,0 +1,73 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	db := openStore(t)
+
+	events, err := db.AuditLog(nil)
+	require.NoError(t, err, "could not query empty audit log")
+	require.Len(t, events, 0)
+
+	// Record events an hour apart with the oldest event first
+	start := time.Now().Add(-24 * time.Hour).Truncate(time.Millisecond)
+	actors := []string{"jdoe@example.com", "asmith@example.com", "jdoe@example.com", "01hcr3d1vwvdhxgq8h5y8ts3fw", "jdoe@example.com"}
+	for i, actor := range actors {
+		event := &models.AuditEvent{Time: start.Add(time.Duration(i) * time.Hour), Actor: actor, Action: models.ActionLinkCreated}
+		require.NoError(t, db.Audit(event), "could not record event %d", i)
+		require.NotEqual(t, ulid.ULID{}, event.ID, "expected an ID to be assigned")
+		require.Equal(t, ulid.Timestamp(event.Time), event.ID.Time())
+	}
+
+	// Events are never overwritten
+	events, err = db.AuditLog(&storage.AuditQuery{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, events, 1)
+	require.ErrorIs(t, db.Audit(events[0]), storage.ErrAlreadyExists)
+
+	// The time is assigned if it is not set
+	now := &models.AuditEvent{Actor: "asmith@example.com", Action: models.ActionUserLogin}
+	require.NoError(t, db.Audit(now))
+	require.WithinDuration(t, time.Now(), now.Time, time.Second)
+
+	events, err = db.AuditLog(nil)
+	require.NoError(t, err)
+	require.Len(t, events, 6)
+	require.Equal(t, now.ID, events[0].ID, "expected most recent event first")
+	for i := 1; i < len(events); i++ {
+		require.True(t, events[i-1].Time.After(events[i].Time), "events are not in reverse chronological order")
+	}
+
+	testCases := []struct {
+		query    *storage.AuditQuery
+		expected []string
+	}{
+		{&storage.AuditQuery{Actor: "jdoe@example.com"}, []string{"jdoe@example.com", "jdoe@example.com", "jdoe@example.com"}},
+		{&storage.AuditQuery{Limit: 2}, []string{"asmith@example.com", "jdoe@example.com"}},
+		{&storage.AuditQuery{After: start.Add(3 * time.Hour)}, []string{"asmith@example.com", "jdoe@example.com", "01hcr3d1vwvdhxgq8h5y8ts3fw"}},
+		{&storage.AuditQuery{Before: start.Add(2 * time.Hour)}, []string{"asmith@example.com", "jdoe@example.com"}},
+		{&storage.AuditQuery{After: start.Add(time.Hour), Before: start.Add(4 * time.Hour)}, []string{"01hcr3d1vwvdhxgq8h5y8ts3fw", "jdoe@example.com", "asmith@example.com"}},
+		{&storage.AuditQuery{Actor: "jdoe@example.com", After: start.Add(time.Hour), Limit: 1}, []string{"jdoe@example.com"}},
+		{&storage.AuditQuery{Actor: "nobody@example.com"}, []string{}},
+		{&storage.AuditQuery{Before: start}, []string{}},
+	}
+
+	for i, tc := range testCases {
+		events, err := db.AuditLog(tc.query)
+		require.NoError(t, err, "could not query audit log in test case %d", i)
+
+		actual := make([]string, 0, len(events))
+		for _, event := range events {
+			actual = append(actual, event.Actor)
+		}
+		require.Equal(t, tc.expected, actual, "unexpected events in test case %d", i)
+	}
+}
@@ -0,0 +1,492 @@
+/*
+Package counters maintains the aggregate counts of links, clicks, and campaigns in the
+meta bucket of the database. The counters are updated in the same transaction as the
+write to the link so that the stats endpoint can return the counts without scanning
+every link in the database.
+
+To prevent every write from conflicting on a single record, the counters are striped
+across a fixed number of shards; each write only modifies the shard that the link id
+maps to so that concurrent clicks on different links do not conflict. The shards hold
+signed deltas that are added to a base record written by Rebuild, so loading the
+counts is a constant number of reads no matter how many links are in the database.
+
+Because badger expires links silently using TTLs, the contribution of each link that
+has an expiration date is recorded in an expiration ledger keyed by the expiration
+timestamp. Ledger entries that are in the past are periodically swept by Expire,
+which removes their contribution from the aggregates. Until an expired link is swept
+it is still included in the counts.
+*/
+package counters
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+const (
+	// Shards is the number of records that the counters are striped across.
+	Shards = 32
+
+	// BatchSize is the maximum number of writes made in a single transaction when
+	// the counters are rebuilt or expired links are swept.
+	BatchSize = 10000
+
+	maxRetries = 8
+)
+
+// Load the aggregate counts from the database by summing the base record and all of
+// the shards. If the counts have not been computed yet then zero valued counts are
+// returned without an error.
+func Load(txn *badger.Txn) (c *models.Counts, err error) {
+	c = &models.Counts{}
+	if err = get(txn, c.Key(), c.UnmarshalValue); err != nil {
+		return nil, err
+	}
+
+	total := &delta{}
+	for i := uint64(0); i < Shards; i++ {
+		var d *delta
+		if d, err = loadShard(txn, i); err != nil {
+			return nil, err
+		}
+		total.add(d)
+	}
+
+	total.apply(c)
+	return c, nil
+}
+
+// Save the base record of the aggregate counts to the database and clear all of the
+// shards so that the saved counts are the counts returned by Load.
+func Save(txn *badger.Txn, c *models.Counts) (err error) {
+	var data []byte
+	if data, err = c.MarshalValue(); err != nil {
+		return err
+	}
+
+	if err = txn.Set(c.Key(), data); err != nil {
+		return err
+	}
+
+	for i := uint64(0); i < Shards; i++ {
+		if err = txn.Delete(shardKey(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Create adds the contribution of a newly created link to the aggregate counts and
+// records the link in the expiration ledger if the link has an expiration date.
+func Create(txn *badger.Txn, link *models.ShortURL) (err error) {
+	if err = update(txn, link.ID, func(d *delta) { d.inc(link.Counts()) }); err != nil {
+		return err
+	}
+	return putLedger(txn, link)
+}
+
+// Click adds a single click to the aggregate counts. The link should already have its
+// visits incremented so that the expiration ledger records the current contribution.
+func Click(txn *badger.Txn, link *models.ShortURL) (err error) {
+	if err = update(txn, link.ID, func(d *delta) { d.Clicks++ }); err != nil {
+		return err
+	}
+	return putLedger(txn, link)
+}
+
+// Delete removes the contribution of the link from the aggregate counts along with
+// its entry in the expiration ledger if it has one.
+func Delete(txn *badger.Txn, link *models.ShortURL) (err error) {
+	if err = update(txn, link.ID, func(d *delta) { d.dec(link.Counts()) }); err != nil {
+		return err
+	}
+
+	if !link.Expires.IsZero() {
+		if err = txn.Delete(ledgerKey(link.Expires, link.ID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Expire sweeps the expiration ledger for links that expired before now, removing
+// their contribution from the aggregate counts and deleting the ledger entry. The
+// links themselves are removed by badger when their TTL expires. At most BatchSize
+// entries are swept so that the transaction does not grow too large; the number of
+// links swept is returned so callers can continue sweeping until it is less than the
+// batch size.
+func Expire(txn *badger.Txn, now time.Time) (n int, err error) {
+	// Collect the keys first since keys cannot be deleted while iterating.
+	expired := make([][]byte, 0)
+	deltas := make(map[uint64]*delta)
+	err = iterLedger(txn, now, func(key []byte, contrib *models.Counts) error {
+		shard := binary.LittleEndian.Uint64(key[12:]) % Shards
+		if _, ok := deltas[shard]; !ok {
+			deltas[shard] = &delta{}
+		}
+
+		deltas[shard].dec(contrib)
+		expired = append(expired, key)
+		if len(expired) >= BatchSize {
+			return errBatchFull
+		}
+		return nil
+	})
+
+	if err != nil && !errors.Is(err, errBatchFull) {
+		return 0, err
+	}
+
+	for _, key := range expired {
+		if err = txn.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+
+	for shard, d := range deltas {
+		if err = update(txn, shard, func(s *delta) { s.add(d) }); err != nil {
+			return 0, err
+		}
+	}
+	return len(expired), nil
+}
+
+// Rebuild the aggregate counts and the expiration ledger from scratch by scanning
+// every link in the transaction. This is used to initialize the counters when
+// migrating; use RebuildDB to repair the counters of a live database since it does
+// not require all of the ledger entries to fit into a single transaction.
+func Rebuild(txn *badger.Txn, now time.Time) (c *models.Counts, err error) {
+	if err = Reset(txn); err != nil {
+		return nil, err
+	}
+
+	links := make([]*models.ShortURL, 0)
+	if c, err = scan(txn, now, func(link *models.ShortURL) error {
+		links = append(links, link)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	for _, link := range links {
+		if err = putLedger(txn, link); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = Save(txn, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// RebuildDB rebuilds the aggregate counts and the expiration ledger in batches so
+// that databases with more links than fit into a single transaction can be repaired.
+// The links are scanned from a read-only snapshot and the ledger is written with a
+// write batch. The base record is then saved in a final transaction that removes the
+// snapshot's deltas from the shards, preserving any writes made during the scan.
+// Ledger entries of links modified while the rebuild is in progress may be stale.
+func RebuildDB(db *badger.DB, now time.Time) (c *models.Counts, err error) {
+	if err = resetLedger(db); err != nil {
+		return nil, err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	var snapshot []*delta
+	err = db.View(func(txn *badger.Txn) (err error) {
+		if c, err = scan(txn, now, func(link *models.ShortURL) error {
+			data, err := link.Counts().MarshalValue()
+			if err != nil {
+				return err
+			}
+			return wb.Set(ledgerKey(link.Expires, link.ID), data)
+		}); err != nil {
+			return err
+		}
+
+		snapshot = make([]*delta, Shards)
+		for i := uint64(0); i < Shards; i++ {
+			if snapshot[i], err = loadShard(txn, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = wb.Flush(); err != nil {
+		return nil, err
+	}
+
+	// The snapshot is still valid if the final transaction conflicts with a write to
+	// a shard, so only the final transaction needs to be retried.
+	for i := 0; i < maxRetries; i++ {
+		if err = db.Update(func(txn *badger.Txn) error {
+			return saveSnapshot(txn, c, snapshot)
+		}); !errors.Is(err, badger.ErrConflict) {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Save the base record and remove the deltas in the snapshot from the shards.
+func saveSnapshot(txn *badger.Txn, c *models.Counts, snapshot []*delta) (err error) {
+	var data []byte
+	if data, err = c.MarshalValue(); err != nil {
+		return err
+	}
+
+	if err = txn.Set(c.Key(), data); err != nil {
+		return err
+	}
+
+	for i, d := range snapshot {
+		if err = update(txn, uint64(i), func(s *delta) { s.sub(d) }); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset deletes the aggregate counts and all entries in the expiration ledger.
+func Reset(txn *badger.Txn) (err error) {
+	keys := make([][]byte, 0)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	iter := txn.NewIterator(opts)
+	prefix := models.ExpiresBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		keys = append(keys, iter.Item().KeyCopy(nil))
+	}
+	iter.Close()
+
+	keys = append(keys, (&models.Counts{}).Key())
+	for i := uint64(0); i < Shards; i++ {
+		keys = append(keys, shardKey(i))
+	}
+
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errBatchFull = errors.New("batch is full")
+
+// Scan the links in the transaction, returning the counts of links that have not
+// expired and calling fn for each unexpired link that has an expiration date.
+func scan(txn *badger.Txn, now time.Time, fn func(*models.ShortURL) error) (c *models.Counts, err error) {
+	c = &models.Counts{}
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	prefix := models.LinksBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		obj := &models.ShortURL{}
+		if err = iter.Item().Value(obj.UnmarshalValue); err != nil {
+			return nil, err
+		}
+
+		if !obj.Expires.IsZero() {
+			if obj.Expires.Before(now) {
+				// this is an expired link, so skip it in the counts
+				continue
+			}
+
+			if err = fn(obj); err != nil {
+				return nil, err
+			}
+		}
+
+		c.Add(obj.Counts())
+	}
+	return c, nil
+}
+
+// Delete all of the entries in the expiration ledger using a write batch.
+func resetLedger(db *badger.DB) (err error) {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		prefix := models.ExpiresBucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			if err := wb.Delete(iter.Item().KeyCopy(nil)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// The ledger key is the expires bucket followed by the big endian unix timestamp of
+// the expiration so that entries are sorted by time, followed by the link id.
+func ledgerKey(expires time.Time, id uint64) []byte {
+	key := make([]byte, 20)
+	copy(key[0:4], models.ExpiresBucket[:])
+	binary.BigEndian.PutUint64(key[4:12], uint64(expires.Unix()))
+	binary.LittleEndian.PutUint64(key[12:], id)
+	return key
+}
+
+func putLedger(txn *badger.Txn, link *models.ShortURL) (err error) {
+	if link.Expires.IsZero() {
+		return nil
+	}
+
+	var data []byte
+	if data, err = link.Counts().MarshalValue(); err != nil {
+		return err
+	}
+	return txn.Set(ledgerKey(link.Expires, link.ID), data)
+}
+
+// Iterate over the ledger entries that have expired before now in timestamp order.
+func iterLedger(txn *badger.Txn, now time.Time, fn func([]byte, *models.Counts) error) (err error) {
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	prefix := models.ExpiresBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		item := iter.Item()
+		key := item.KeyCopy(nil)
+		if int64(binary.BigEndian.Uint64(key[4:12])) > now.Unix() {
+			break
+		}
+
+		contrib := &models.Counts{}
+		if err = item.Value(contrib.UnmarshalValue); err != nil {
+			return err
+		}
+
+		if err = fn(key, contrib); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// The shard key is the key of the base counts record followed by the shard number.
+func shardKey(shard uint64) []byte {
+	base := (&models.Counts{}).Key()
+	key := make([]byte, len(base)+1)
+	copy(key, base)
+	key[len(base)] = byte(shard % Shards)
+	return key
+}
+
+func loadShard(txn *badger.Txn, shard uint64) (d *delta, err error) {
+	d = &delta{}
+	if err = get(txn, shardKey(shard), d.unmarshal); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Update the shard that the id maps to using the specified function.
+func update(txn *badger.Txn, id uint64, fn func(*delta)) (err error) {
+	var d *delta
+	if d, err = loadShard(txn, id); err != nil {
+		return err
+	}
+
+	fn(d)
+
+	var data []byte
+	if data, err = msgpack.Marshal(d); err != nil {
+		return err
+	}
+	return txn.Set(shardKey(id), data)
+}
+
+// Get the value of the key, ignoring keys that are not found.
+func get(txn *badger.Txn, key []byte, fn func([]byte) error) (err error) {
+	var item *badger.Item
+	if item, err = txn.Get(key); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil
+		}
+		return err
+	}
+	return item.Value(fn)
+}
+
+// A delta is the signed change to the aggregate counts stored in a shard; a shard
+// can be negative when links are deleted or expire after the base record is saved.
+type delta struct {
+	Links     int64 `msgpack:"links"`
+	Clicks    int64 `msgpack:"clicks"`
+	Campaigns int64 `msgpack:"campaigns"`
+}
+
+func (d *delta) unmarshal(data []byte) error {
+	return msgpack.Unmarshal(data, d)
+}
+
+func (d *delta) add(o *delta) {
+	d.Links += o.Links
+	d.Clicks += o.Clicks
+	d.Campaigns += o.Campaigns
+}
+
+func (d *delta) sub(o *delta) {
+	d.Links -= o.Links
+	d.Clicks -= o.Clicks
+	d.Campaigns -= o.Campaigns
+}
+
+func (d *delta) inc(c *models.Counts) {
+	d.Links += int64(c.Links)
+	d.Clicks += int64(c.Clicks)
+	d.Campaigns += int64(c.Campaigns)
+}
+
+func (d *delta) dec(c *models.Counts) {
+	d.Links -= int64(c.Links)
+	d.Clicks -= int64(c.Clicks)
+	d.Campaigns -= int64(c.Campaigns)
+}
+
+// Apply the delta to the counts; the counts will not go below zero so that any drift
+// in the aggregates does not cause the counters to wrap around.
+func (d *delta) apply(c *models.Counts) {
+	c.Links = apply(c.Links, d.Links)
+	c.Clicks = apply(c.Clicks, d.Clicks)
+	c.Campaigns = apply(c.Campaigns, d.Campaigns)
+}
+
+func apply(a uint64, d int64) uint64 {
+	if d < 0 && uint64(-d) > a {
+		return 0
+	}
+	return uint64(int64(a) + d)
+}
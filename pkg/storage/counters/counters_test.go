@@ -0,0 +1,237 @@
+package counters_test
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/counters"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounters(t *testing.T) {
+	db := openDB(t)
+
+	// An empty database should have zero counts
+	require.Equal(t, &models.Counts{}, load(t, db))
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog", Campaigns: []uint64{3, 4}},
+		{ID: 3, URL: "https://rotational.io/webinar", Expires: time.Now().Add(time.Hour)},
+	}
+	create(t, db, links...)
+	require.Equal(t, &models.Counts{Links: 3, Campaigns: 2}, load(t, db))
+
+	// Click on the links
+	for _, link := range []*models.ShortURL{links[0], links[0], links[2]} {
+		link.Visits++
+		err := db.Update(func(txn *badger.Txn) error {
+			return counters.Click(txn, link)
+		})
+		require.NoError(t, err, "could not click link %d", link.ID)
+	}
+	require.Equal(t, &models.Counts{Links: 3, Clicks: 3, Campaigns: 2}, load(t, db))
+
+	// Delete links and ensure their contribution is removed
+	err := db.Update(func(txn *badger.Txn) error {
+		if err := counters.Delete(txn, links[1]); err != nil {
+			return err
+		}
+		return counters.Delete(txn, links[2])
+	})
+	require.NoError(t, err, "could not delete links")
+	require.Equal(t, &models.Counts{Links: 1, Clicks: 2}, load(t, db))
+
+	// The counts should not wrap around if they drift below zero
+	err = db.Update(func(txn *badger.Txn) error {
+		return counters.Delete(txn, links[1])
+	})
+	require.NoError(t, err, "could not delete link")
+	require.Equal(t, &models.Counts{Clicks: 2}, load(t, db))
+}
+
+func TestConcurrentClicks(t *testing.T) {
+	db := openDB(t)
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog"},
+	}
+	create(t, db, links...)
+
+	// Clicks on different links in concurrent transactions should not conflict
+	txns := make([]*badger.Txn, len(links))
+	for i, link := range links {
+		txns[i] = db.NewTransaction(true)
+		defer txns[i].Discard()
+		require.NoError(t, counters.Click(txns[i], link))
+	}
+
+	for _, txn := range txns {
+		require.NoError(t, txn.Commit(), "concurrent clicks conflicted")
+	}
+	require.Equal(t, &models.Counts{Links: 2, Clicks: 2}, load(t, db))
+}
+
+func TestExpire(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+
+	// Create more expired links than can be swept in a single batch
+	links := make([]*models.ShortURL, 0, counters.BatchSize+5)
+	for i := 0; i < cap(links); i++ {
+		links = append(links, &models.ShortURL{ID: uint64(i + 1), Visits: 1, Expires: now.Add(-time.Minute)})
+	}
+	links = append(links, &models.ShortURL{ID: uint64(len(links) + 1), Expires: now.Add(time.Hour)})
+
+	wb := db.NewWriteBatch()
+	for _, link := range links {
+		data, err := (&models.Counts{Links: 1, Clicks: link.Visits}).MarshalValue()
+		require.NoError(t, err)
+		require.NoError(t, wb.Set(ledger(link), data))
+	}
+	require.NoError(t, wb.Flush())
+
+	err := db.Update(func(txn *badger.Txn) error {
+		return counters.Save(txn, &models.Counts{Links: uint64(len(links)), Clicks: uint64(len(links) - 1)})
+	})
+	require.NoError(t, err, "could not save counts")
+
+	// Expired links are included in the counts until they are swept
+	require.Equal(t, &models.Counts{Links: uint64(len(links)), Clicks: uint64(len(links) - 1)}, load(t, db))
+
+	for _, expected := range []int{counters.BatchSize, 5, 0} {
+		var n int
+		err := db.Update(func(txn *badger.Txn) (err error) {
+			n, err = counters.Expire(txn, now)
+			return err
+		})
+		require.NoError(t, err, "could not sweep expired links")
+		require.Equal(t, expected, n)
+	}
+
+	require.Equal(t, &models.Counts{Links: 1}, load(t, db))
+}
+
+func TestRebuild(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io", Visits: 4},
+		{ID: 2, URL: "https://rotational.io/blog", Visits: 2, Campaigns: []uint64{3, 4}},
+		{ID: 3, URL: "https://rotational.io/webinar", Visits: 1, Expires: now.Add(time.Hour)},
+		{ID: 4, URL: "https://rotational.io/expired", Visits: 8, Expires: now.Add(-time.Hour)},
+	}
+	expected := &models.Counts{Links: 3, Clicks: 7, Campaigns: 2}
+
+	// Save the links without updating the counters so that they must be rebuilt
+	err := db.Update(func(txn *badger.Txn) error {
+		for _, link := range links {
+			data, err := link.MarshalValue()
+			if err != nil {
+				return err
+			}
+
+			if err = txn.Set(link.Key(), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not save links")
+	require.Equal(t, &models.Counts{}, load(t, db))
+
+	t.Run("Txn", func(t *testing.T) {
+		var counts *models.Counts
+		err := db.Update(func(txn *badger.Txn) (err error) {
+			counts, err = counters.Rebuild(txn, now)
+			return err
+		})
+		require.NoError(t, err, "could not rebuild counters")
+		require.Equal(t, expected, counts)
+		require.Equal(t, expected, load(t, db))
+		require.Equal(t, 1, ledgerEntries(t, db), "expected only the unexpired link in the ledger")
+	})
+
+	t.Run("DB", func(t *testing.T) {
+		// Add some drift to the counters that should be repaired
+		err := db.Update(func(txn *badger.Txn) error {
+			return counters.Click(txn, links[0])
+		})
+		require.NoError(t, err)
+		require.NotEqual(t, expected, load(t, db))
+
+		counts, err := counters.RebuildDB(db, now)
+		require.NoError(t, err, "could not rebuild counters")
+		require.Equal(t, expected, counts)
+		require.Equal(t, expected, load(t, db))
+		require.Equal(t, 1, ledgerEntries(t, db), "expected only the unexpired link in the ledger")
+
+		// Incremental updates should be applied on top of the rebuilt counts
+		create(t, db, &models.ShortURL{ID: 5, URL: "https://rotational.io/new"})
+		require.Equal(t, &models.Counts{Links: 4, Clicks: 7, Campaigns: 2}, load(t, db))
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		require.NoError(t, db.Update(counters.Reset), "could not reset counters")
+		require.Equal(t, &models.Counts{}, load(t, db))
+		require.Equal(t, 0, ledgerEntries(t, db), "expected the ledger to be empty")
+	})
+}
+
+func openDB(t *testing.T) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	require.NoError(t, err, "could not open in-memory database")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func create(t *testing.T, db *badger.DB, links ...*models.ShortURL) {
+	err := db.Update(func(txn *badger.Txn) error {
+		for _, link := range links {
+			if err := counters.Create(txn, link); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not create links")
+}
+
+func load(t *testing.T, db *badger.DB) (counts *models.Counts) {
+	err := db.View(func(txn *badger.Txn) (err error) {
+		counts, err = counters.Load(txn)
+		return err
+	})
+	require.NoError(t, err, "could not load counts")
+	return counts
+}
+
+func ledgerEntries(t *testing.T, db *badger.DB) (n int) {
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		prefix := models.ExpiresBucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			n++
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not count ledger entries")
+	return n
+}
+
+// Construct the expiration ledger key for the link, which must match the counters.
+func ledger(link *models.ShortURL) []byte {
+	key := make([]byte, 20)
+	copy(key[0:4], models.ExpiresBucket[:])
+	binary.BigEndian.PutUint64(key[4:12], uint64(link.Expires.Unix()))
+	binary.LittleEndian.PutUint64(key[12:], link.ID)
+	return key
+}
@@ -4,45 +4,53 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/counters"
 	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 )
 
+// Counts returns the aggregate counts maintained in the meta bucket. Links that have
+// expired are included in the counts until they are swept by ExpireLinks.
 func (s *Store) Counts() (c *models.Counts, err error) {
-	c = &models.Counts{}
+	err = s.db.View(func(txn *badger.Txn) (err error) {
+		c, err = counters.Load(txn)
+		return err
+	})
 
-	err = s.db.View(func(txn *badger.Txn) error {
-		it := txn.NewIterator(badger.DefaultIteratorOptions)
-		defer it.Close()
+	if err != nil {
+		return nil, err
+	}
 
-		prefix := models.LinksBucket[:]
-		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
-			item := it.Item()
-			obj := &models.ShortURL{}
+	return c, nil
+}
 
-			err := item.Value(func(v []byte) error {
-				return obj.UnmarshalValue(v)
-			})
+// Recount rebuilds the aggregate counts from a full scan of the links in the database
+// and returns the repaired counts. The rebuild is batched across several transactions
+// so that it can be run on databases of any size.
+func (s *Store) Recount() (c *models.Counts, err error) {
+	if s.replica {
+		return nil, ErrReadOnly
+	}
+	return counters.RebuildDB(s.db, time.Now())
+}
 
-			if err != nil {
-				return err
-			}
+// ExpireLinks removes the contribution of expired links from the aggregate counts and
+// returns the number of expired links that were swept. Expired links are swept in
+// batches so that any number of links can be swept at once.
+func (s *Store) ExpireLinks() (n int, err error) {
+	if s.replica {
+		return 0, ErrReadOnly
+	}
 
-			if !obj.Expires.IsZero() && obj.Expires.Before(time.Now()) {
-				// this is an expired link, so skip it in the counts
-				continue
-			}
+	for {
+		var swept int
+		err = s.update(func(txn *badger.Txn) (err error) {
+			swept, err = counters.Expire(txn, time.Now())
+			return err
+		})
 
-			c.Links++
-			c.Clicks += obj.Visits
-			c.Campaigns += uint64(len(obj.Campaigns))
+		n += swept
+		if err != nil || swept < counters.BatchSize {
+			return n, err
 		}
-
-		return nil
-	})
-
-	if err != nil {
-		return nil, err
 	}
-
-	return c, nil
 }
@@ -5,4 +5,8 @@ import "errors"
 var (
 	ErrNotFound      = errors.New("object not found in database")
 	ErrAlreadyExists = errors.New("object already exists in the database")
+	ErrReadOnly      = errors.New("cannot modify a read-only replica")
+	ErrRevoked       = errors.New("api key has been revoked")
+	ErrQuotaExceeded = errors.New("api key quota exceeded")
+	ErrRotated       = errors.New("session has already been rotated")
 )
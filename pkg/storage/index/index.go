@@ -0,0 +1,304 @@
+/*
+Package index maintains secondary indexes of links by target host, by creator, by
+creation date, and by the API key used to create the link so that links can be looked
+up without scanning the entire database.
+Index entries are written in the same transaction as the link and share the link's
+TTL so that they expire along with the link.
+
+Each index key is the index bucket followed by the indexed value and the little endian
+id of the link; the value of each entry is also the id of the link. Hosts, creators,
+and API keys are terminated by a zero byte so that prefix scans match the value exactly.
+*/
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// Buckets lists all of the secondary index buckets.
+var Buckets = []models.Bucket{
+	models.HostIndexBucket,
+	models.CreatorIndexBucket,
+	models.CreatedIndexBucket,
+	models.APIKeyIndexBucket,
+}
+
+// Put adds the link to all of the secondary indexes it belongs to.
+func Put(txn *badger.Txn, link *models.ShortURL) (err error) {
+	for _, entry := range entries(link) {
+		if err = txn.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete removes the link from all of the secondary indexes it belongs to.
+func Delete(txn *badger.Txn, link *models.ShortURL) (err error) {
+	for _, key := range Keys(link) {
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns the index keys for the link.
+func Keys(link *models.ShortURL) [][]byte {
+	keys := make([][]byte, 0, 4)
+	if host := Hostname(link.URL); host != "" {
+		keys = append(keys, key(models.HostIndexBucket, stringPrefix(host), link.ID))
+	}
+
+	if link.CreatedBy != "" {
+		keys = append(keys, key(models.CreatorIndexBucket, stringPrefix(link.CreatedBy), link.ID))
+	}
+
+	if !link.Created.IsZero() {
+		keys = append(keys, key(models.CreatedIndexBucket, timePrefix(link.Created), link.ID))
+	}
+
+	if link.APIKey != "" {
+		keys = append(keys, key(models.APIKeyIndexBucket, stringPrefix(link.APIKey), link.ID))
+	}
+	return keys
+}
+
+// Host returns the ids of all links that redirect to the specified host.
+func Host(txn *badger.Txn, host string) ([]uint64, error) {
+	host = strings.ToLower(strings.TrimSpace(host))
+	prefix := append(models.HostIndexBucket[:], stringPrefix(host)...)
+	return scan(txn, prefix, nil, nil)
+}
+
+// Creator returns the ids of all links created by the specified user or client.
+func Creator(txn *badger.Txn, creator string) ([]uint64, error) {
+	prefix := append(models.CreatorIndexBucket[:], stringPrefix(creator)...)
+	return scan(txn, prefix, nil, nil)
+}
+
+// Created returns the ids of all links created in the specified time range in the
+// order that they were created. A zero valued after or before is treated as unbounded.
+func Created(txn *badger.Txn, after, before time.Time) ([]uint64, error) {
+	prefix := models.CreatedIndexBucket[:]
+	seek := prefix
+	if !after.IsZero() {
+		seek = append(models.CreatedIndexBucket[:], timePrefix(after)...)
+	}
+
+	var stop []byte
+	if !before.IsZero() {
+		stop = append(models.CreatedIndexBucket[:], timePrefix(before)...)
+	}
+
+	return scan(txn, prefix, seek, stop)
+}
+
+// CountAPIKey returns the number of unexpired links created with the specified API key.
+func CountAPIKey(txn *badger.Txn, clientID string) (n uint64, err error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	iter := txn.NewIterator(opts)
+	defer iter.Close()
+
+	prefix := append(models.APIKeyIndexBucket[:], stringPrefix(clientID)...)
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		n++
+	}
+	return n, nil
+}
+
+// Rebuild all of the secondary indexes from scratch by scanning every link.
+func Rebuild(txn *badger.Txn) (err error) {
+	if err = Reset(txn); err != nil {
+		return err
+	}
+
+	links := make([]*models.ShortURL, 0)
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	prefix := models.LinksBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		obj := &models.ShortURL{}
+		if err = iter.Item().Value(obj.UnmarshalValue); err != nil {
+			iter.Close()
+			return err
+		}
+		links = append(links, obj)
+	}
+	iter.Close()
+
+	for _, link := range links {
+		if err = Put(txn, link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildDB rebuilds all of the secondary indexes in batches so that databases with
+// more links than fit into a single transaction can be indexed. The links are scanned
+// from a read-only snapshot and the index entries are written with a write batch, so
+// links modified while the rebuild is in progress may have stale entries.
+func RebuildDB(db *badger.DB) (err error) {
+	if err = resetDB(db); err != nil {
+		return err
+	}
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	if err = db.View(func(txn *badger.Txn) (err error) {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := models.LinksBucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			link := &models.ShortURL{}
+			if err = iter.Item().Value(link.UnmarshalValue); err != nil {
+				return err
+			}
+
+			for _, entry := range entries(link) {
+				if err = wb.SetEntry(entry); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// Reset deletes all entries in the secondary indexes.
+func Reset(txn *badger.Txn) (err error) {
+	keys := make([][]byte, 0)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	iter := txn.NewIterator(opts)
+	for _, bucket := range Buckets {
+		prefix := bucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			keys = append(keys, iter.Item().KeyCopy(nil))
+		}
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete all entries in the secondary indexes using a write batch.
+func resetDB(db *badger.DB) (err error) {
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	if err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for _, bucket := range Buckets {
+			prefix := bucket[:]
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				if err := wb.Delete(iter.Item().KeyCopy(nil)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+	return wb.Flush()
+}
+
+// Returns the entries of the link in the secondary indexes; the value of each entry is
+// the link id and the entries expire with the link.
+func entries(link *models.ShortURL) []*badger.Entry {
+	value := make([]byte, 8)
+	binary.LittleEndian.PutUint64(value, link.ID)
+
+	keys := Keys(link)
+	entries := make([]*badger.Entry, 0, len(keys))
+	for _, key := range keys {
+		entry := badger.NewEntry(key, value)
+		if !link.Expires.IsZero() {
+			entry.ExpiresAt = uint64(link.Expires.Unix())
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Hostname returns the normalized host of the url for indexing.
+func Hostname(link string) string {
+	u, err := url.Parse(strings.TrimSpace(link))
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+func key(bucket models.Bucket, value []byte, id uint64) []byte {
+	key := make([]byte, 4+len(value)+8)
+	copy(key[0:4], bucket[:])
+	copy(key[4:], value)
+	binary.LittleEndian.PutUint64(key[4+len(value):], id)
+	return key
+}
+
+func stringPrefix(s string) []byte {
+	return append([]byte(s), 0)
+}
+
+func timePrefix(ts time.Time) []byte {
+	prefix := make([]byte, 8)
+	binary.BigEndian.PutUint64(prefix, uint64(ts.UnixNano()))
+	return prefix
+}
+
+// Scan the index for the specified prefix starting at the seek key (or the prefix if
+// seek is nil) and stopping before the stop key if it is not nil.
+func scan(txn *badger.Txn, prefix, seek, stop []byte) (ids []uint64, err error) {
+	if seek == nil {
+		seek = prefix
+	}
+
+	ids = make([]uint64, 0)
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	for iter.Seek(seek); iter.ValidForPrefix(prefix); iter.Next() {
+		item := iter.Item()
+		if stop != nil && bytes.Compare(item.Key(), stop) >= 0 {
+			break
+		}
+
+		err = item.Value(func(val []byte) error {
+			ids = append(ids, binary.LittleEndian.Uint64(val))
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ids, nil
+}
@@ -0,0 +1,249 @@
+package index_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/index"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io", CreatedBy: "jdoe@rotational.io", Created: now.Add(-72 * time.Hour), APIKey: "client1"},
+		{ID: 2, URL: "https://docs.rotational.io/ensign", CreatedBy: "jdoe@rotational.io", Created: now.Add(-48 * time.Hour)},
+		{ID: 3, URL: "https://DOCS.rotational.io/rtnl", CreatedBy: "jdoe@rotational.io.evil", Created: now.Add(-24 * time.Hour), APIKey: "client1"},
+		{ID: 4, URL: "https://docs.rotational.io.evil/", Created: now.Add(-2 * time.Hour), APIKey: "client10"},
+		{ID: 5, URL: "https://rotational.io/blog", CreatedBy: "asmith@rotational.io", Created: now.Add(-1 * time.Hour), Expires: now.Add(time.Hour)},
+	}
+
+	err := db.Update(func(txn *badger.Txn) error {
+		for _, link := range links {
+			if err := index.Put(txn, link); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not index links")
+
+	t.Run("Host", func(t *testing.T) {
+		testCases := []struct {
+			host     string
+			expected []uint64
+		}{
+			{"rotational.io", []uint64{1, 5}},
+			{"docs.rotational.io", []uint64{2, 3}},
+			{" DOCS.Rotational.IO ", []uint64{2, 3}},
+			{"docs.rotational.io.evil", []uint64{4}},
+			{"rotational", []uint64{}},
+			{"example.com", []uint64{}},
+		}
+
+		for _, tc := range testCases {
+			ids := view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+				return index.Host(txn, tc.host)
+			})
+			require.ElementsMatch(t, tc.expected, ids, "unexpected links for host %q", tc.host)
+		}
+	})
+
+	t.Run("Creator", func(t *testing.T) {
+		testCases := []struct {
+			creator  string
+			expected []uint64
+		}{
+			{"jdoe@rotational.io", []uint64{1, 2}},
+			{"jdoe@rotational.io.evil", []uint64{3}},
+			{"asmith@rotational.io", []uint64{5}},
+			{"jdoe", []uint64{}},
+		}
+
+		for _, tc := range testCases {
+			ids := view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+				return index.Creator(txn, tc.creator)
+			})
+			require.ElementsMatch(t, tc.expected, ids, "unexpected links for creator %q", tc.creator)
+		}
+	})
+
+	t.Run("Created", func(t *testing.T) {
+		testCases := []struct {
+			after    time.Time
+			before   time.Time
+			expected []uint64
+		}{
+			{time.Time{}, time.Time{}, []uint64{1, 2, 3, 4, 5}},
+			{now.Add(-36 * time.Hour), time.Time{}, []uint64{3, 4, 5}},
+			{time.Time{}, now.Add(-36 * time.Hour), []uint64{1, 2}},
+			{now.Add(-48 * time.Hour), now.Add(-2 * time.Hour), []uint64{2, 3}},
+			{now, time.Time{}, []uint64{}},
+		}
+
+		for i, tc := range testCases {
+			ids := view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+				return index.Created(txn, tc.after, tc.before)
+			})
+			require.Equal(t, tc.expected, ids, "test case %d: links not returned in creation order", i)
+		}
+	})
+
+	t.Run("CountAPIKey", func(t *testing.T) {
+		testCases := []struct {
+			clientID string
+			expected uint64
+		}{
+			{"client1", 2},
+			{"client10", 1},
+			{"client", 0},
+		}
+
+		for _, tc := range testCases {
+			err := db.View(func(txn *badger.Txn) error {
+				n, err := index.CountAPIKey(txn, tc.clientID)
+				require.Equal(t, tc.expected, n, "unexpected count for client %q", tc.clientID)
+				return err
+			})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("TTL", func(t *testing.T) {
+		err := db.View(func(txn *badger.Txn) error {
+			for _, key := range index.Keys(links[4]) {
+				item, err := txn.Get(key)
+				if err != nil {
+					return err
+				}
+				require.Equal(t, uint64(links[4].Expires.Unix()), item.ExpiresAt(), "index entry does not expire with link")
+			}
+			return nil
+		})
+		require.NoError(t, err)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		err := db.Update(func(txn *badger.Txn) error {
+			return index.Delete(txn, links[0])
+		})
+		require.NoError(t, err, "could not delete link from indexes")
+
+		ids := view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+			return index.Host(txn, "rotational.io")
+		})
+		require.Equal(t, []uint64{5}, ids)
+
+		ids = view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+			return index.Creator(txn, "jdoe@rotational.io")
+		})
+		require.Equal(t, []uint64{2}, ids)
+	})
+}
+
+func TestRebuild(t *testing.T) {
+	db := openDB(t)
+	now := time.Now()
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io", CreatedBy: "jdoe@rotational.io", Created: now.Add(-2 * time.Hour)},
+		{ID: 2, URL: "https://docs.rotational.io", Created: now.Add(-1 * time.Hour), APIKey: "client1"},
+		{ID: 3, URL: "https://rotational.io/blog"},
+	}
+
+	// Save the links without indexing them
+	err := db.Update(func(txn *badger.Txn) error {
+		for _, link := range links {
+			data, err := link.MarshalValue()
+			if err != nil {
+				return err
+			}
+
+			if err = txn.Set(link.Key(), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not save links")
+	require.Equal(t, 0, entries(t, db))
+
+	require.NoError(t, db.Update(index.Rebuild), "could not rebuild indexes")
+	require.Equal(t, 7, entries(t, db))
+
+	ids := view(t, db, func(txn *badger.Txn) ([]uint64, error) {
+		return index.Host(txn, "rotational.io")
+	})
+	require.Equal(t, []uint64{1, 3}, ids)
+
+	// Rebuilding again should not duplicate entries
+	require.NoError(t, db.Update(index.Rebuild), "could not rebuild indexes")
+	require.Equal(t, 7, entries(t, db))
+
+	require.NoError(t, db.Update(index.Reset), "could not reset indexes")
+	require.Equal(t, 0, entries(t, db))
+
+	// Rebuilding in batches should produce the same entries
+	require.NoError(t, index.RebuildDB(db), "could not rebuild indexes in batches")
+	require.Equal(t, 7, entries(t, db))
+
+	require.NoError(t, index.RebuildDB(db), "could not rebuild indexes in batches")
+	require.Equal(t, 7, entries(t, db))
+}
+
+func TestHostname(t *testing.T) {
+	testCases := []struct {
+		in       string
+		expected string
+	}{
+		{"https://rotational.io", "rotational.io"},
+		{"  https://Docs.Rotational.IO/ensign?q=1 ", "docs.rotational.io"},
+		{"http://localhost:8080/foo", "localhost"},
+		{"/relative/path", ""},
+		{"://bad", ""},
+	}
+
+	for _, tc := range testCases {
+		require.Equal(t, tc.expected, index.Hostname(tc.in), "unexpected hostname for %q", tc.in)
+	}
+}
+
+func openDB(t *testing.T) *badger.DB {
+	db, err := badger.Open(badger.DefaultOptions("").WithInMemory(true).WithLogger(nil))
+	require.NoError(t, err, "could not open in-memory database")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func view(t *testing.T, db *badger.DB, fn func(*badger.Txn) ([]uint64, error)) (ids []uint64) {
+	err := db.View(func(txn *badger.Txn) (err error) {
+		ids, err = fn(txn)
+		return err
+	})
+	require.NoError(t, err, "could not query index")
+	return ids
+}
+
+// Count the number of entries in all of the secondary indexes.
+func entries(t *testing.T, db *badger.DB) (n int) {
+	err := db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for _, bucket := range index.Buckets {
+			prefix := bucket[:]
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				n++
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not count index entries")
+	return n
+}
@@ -5,10 +5,16 @@ import (
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/counters"
+	"github.com/rotationalio/rtnl.link/pkg/storage/index"
 	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 )
 
 func (s *Store) Save(obj *models.ShortURL) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
 	if obj.Created.IsZero() {
 		obj.Created = time.Now()
 	}
@@ -26,7 +32,7 @@ func (s *Store) Save(obj *models.ShortURL) error {
 		entry = entry.WithTTL(time.Until(obj.Expires))
 	}
 
-	err = s.db.Update(func(txn *badger.Txn) error {
+	err = s.update(func(txn *badger.Txn) error {
 		// If the entry already exists, do not overwrite it
 		if _, err := txn.Get(key); !errors.Is(err, badger.ErrKeyNotFound) {
 			if err == nil {
@@ -35,11 +41,100 @@ func (s *Store) Save(obj *models.ShortURL) error {
 			return err
 		}
 
-		return txn.SetEntry(entry)
+		// Links created with an API key count against the quota of the key
+		if obj.APIKey != "" {
+			if err := consumeQuota(txn, obj); err != nil {
+				return err
+			}
+		}
+
+		if err := txn.SetEntry(entry); err != nil {
+			return err
+		}
+
+		if err := index.Put(txn, obj); err != nil {
+			return err
+		}
+
+		return counters.Create(txn, obj)
 	})
 	return err
 }
 
+// SaveMany saves the links in a single transaction and returns an error for each link
+// in the same order as the links. Links that already exist are not overwritten and
+// ErrAlreadyExists is returned in their place; ErrQuotaExceeded is returned for links
+// that exceed the quota of their API key. Any other error aborts the transaction so
+// that none of the links are saved and is returned as the second return value.
+func (s *Store) SaveMany(objs []*models.ShortURL) (errs []error, err error) {
+	if s.replica {
+		return nil, ErrReadOnly
+	}
+
+	now := time.Now()
+	entries := make([]*badger.Entry, len(objs))
+	for i, obj := range objs {
+		if obj.Created.IsZero() {
+			obj.Created = now
+		}
+		obj.Modified = now
+
+		var val []byte
+		if val, err = obj.MarshalValue(); err != nil {
+			return nil, err
+		}
+
+		entries[i] = badger.NewEntry(obj.Key(), val)
+		if !obj.Expires.IsZero() {
+			entries[i] = entries[i].WithTTL(time.Until(obj.Expires))
+		}
+	}
+
+	err = s.update(func(txn *badger.Txn) error {
+		// The transaction may be retried so the errors are reset on every attempt
+		errs = make([]error, len(objs))
+		for i, obj := range objs {
+			// If the entry already exists (including earlier in the batch) do not overwrite it
+			if _, err := txn.Get(entries[i].Key); !errors.Is(err, badger.ErrKeyNotFound) {
+				if err == nil {
+					errs[i] = ErrAlreadyExists
+					continue
+				}
+				return err
+			}
+
+			// The quota is checked before anything is written for the link
+			if obj.APIKey != "" {
+				if err := consumeQuota(txn, obj); err != nil {
+					if errors.Is(err, ErrQuotaExceeded) {
+						errs[i] = err
+						continue
+					}
+					return err
+				}
+			}
+
+			if err := txn.SetEntry(entries[i]); err != nil {
+				return err
+			}
+
+			if err := index.Put(txn, obj); err != nil {
+				return err
+			}
+
+			if err := counters.Create(txn, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return errs, nil
+}
+
 // TODO: support pagination when listing links.
 func (s *Store) List() ([]*models.ShortURL, error) {
 	urls := make([]*models.ShortURL, 0)
@@ -74,11 +169,117 @@ func (s *Store) List() ([]*models.ShortURL, error) {
 	return urls, nil
 }
 
+// LinkQuery filters links using the secondary indexes; zero valued fields are ignored.
+type LinkQuery struct {
+	Host      string    // the host that the link redirects to
+	CreatedBy string    // the user or client that created the link
+	After     time.Time // links created at or after this timestamp
+	Before    time.Time // links created before this timestamp
+}
+
+// IsZero returns true if the query has no filters.
+func (q *LinkQuery) IsZero() bool {
+	return q == nil || (q.Host == "" && q.CreatedBy == "" && q.After.IsZero() && q.Before.IsZero())
+}
+
+// Query returns the links that match all of the filters in the query, using the
+// secondary indexes rather than scanning all of the links in the database. If the
+// query filters on creation date, the links are returned in the order they were
+// created. If the query has no filters then all links are listed.
+func (s *Store) Query(q *LinkQuery) ([]*models.ShortURL, error) {
+	if q.IsZero() {
+		return s.List()
+	}
+
+	urls := make([]*models.ShortURL, 0)
+	err := s.db.View(func(txn *badger.Txn) (err error) {
+		// The first lookup determines the order of the results and subsequent lookups
+		// filter the results to the intersection of the index lookups.
+		var ids []uint64
+		lookups := make([]func() ([]uint64, error), 0, 3)
+
+		if !q.After.IsZero() || !q.Before.IsZero() {
+			lookups = append(lookups, func() ([]uint64, error) { return index.Created(txn, q.After, q.Before) })
+		}
+
+		if q.Host != "" {
+			lookups = append(lookups, func() ([]uint64, error) { return index.Host(txn, q.Host) })
+		}
+
+		if q.CreatedBy != "" {
+			lookups = append(lookups, func() ([]uint64, error) { return index.Creator(txn, q.CreatedBy) })
+		}
+
+		for i, lookup := range lookups {
+			var found []uint64
+			if found, err = lookup(); err != nil {
+				return err
+			}
+
+			if i == 0 {
+				ids = found
+				continue
+			}
+
+			ids = intersect(ids, found)
+		}
+
+		for _, id := range ids {
+			obj := &models.ShortURL{ID: id}
+
+			var item *badger.Item
+			if item, err = txn.Get(obj.Key()); err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					// The link expired between the index lookup and the get.
+					continue
+				}
+				return err
+			}
+
+			if err = item.Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+			urls = append(urls, obj)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return urls, nil
+}
+
+// Returns the ids in a that are also in b, preserving the order of a.
+func intersect(a, b []uint64) []uint64 {
+	set := make(map[uint64]struct{}, len(b))
+	for _, id := range b {
+		set[id] = struct{}{}
+	}
+
+	out := make([]uint64, 0, len(a))
+	for _, id := range a {
+		if _, ok := set[id]; ok {
+			out = append(out, id)
+		}
+	}
+	return out
+}
+
 func (s *Store) Load(key uint64) (string, error) {
+	// Replicas cannot record visits so the link is only read.
+	if s.replica {
+		obj, err := s.LoadInfo(key)
+		if err != nil {
+			return "", err
+		}
+		return obj.URL, nil
+	}
+
 	obj := &models.ShortURL{ID: key}
 	keyb := obj.Key()
 
-	err := s.db.Update(func(txn *badger.Txn) error {
+	err := s.update(func(txn *badger.Txn) error {
 		item, err := txn.Get(keyb)
 		if err != nil {
 			return err
@@ -103,7 +304,14 @@ func (s *Store) Load(key uint64) (string, error) {
 			return err
 		}
 
-		return txn.Set(keyb, data)
+		// Preserve the TTL of the link so that clicks do not prevent it from expiring.
+		entry := badger.NewEntry(keyb, data)
+		entry.ExpiresAt = item.ExpiresAt()
+		if err = txn.SetEntry(entry); err != nil {
+			return err
+		}
+
+		return counters.Click(txn, obj)
 	})
 
 	if err != nil {
@@ -142,12 +350,81 @@ func (s *Store) LoadInfo(key uint64) (*models.ShortURL, error) {
 	return obj, nil
 }
 
+// Update modifies the title and description of the link; the other fields of the link
+// are not modified and the TTL of the link is preserved. The updated link is copied
+// back into the object that was passed in.
+func (s *Store) Update(obj *models.ShortURL) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	keyb := obj.Key()
+	err := s.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyb)
+		if err != nil {
+			return err
+		}
+
+		link := &models.ShortURL{}
+		if err = item.Value(link.UnmarshalValue); err != nil {
+			return err
+		}
+
+		link.Title = obj.Title
+		link.Description = obj.Description
+		link.Modified = time.Now()
+
+		var data []byte
+		if data, err = link.MarshalValue(); err != nil {
+			return err
+		}
+
+		entry := badger.NewEntry(keyb, data)
+		entry.ExpiresAt = item.ExpiresAt()
+		if err = txn.SetEntry(entry); err != nil {
+			return err
+		}
+
+		*obj = *link
+		return nil
+	})
+
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
 func (s *Store) Delete(key uint64) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
 	obj := &models.ShortURL{ID: key}
 	keyb := obj.Key()
 
-	err := s.db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(keyb)
+	err := s.update(func(txn *badger.Txn) error {
+		item, err := txn.Get(keyb)
+		if err != nil {
+			return err
+		}
+
+		if err = item.Value(obj.UnmarshalValue); err != nil {
+			return err
+		}
+
+		if err = txn.Delete(keyb); err != nil {
+			return err
+		}
+
+		if err = index.Delete(txn, obj); err != nil {
+			return err
+		}
+
+		return counters.Delete(txn, obj)
 	})
 
 	if err != nil {
@@ -1 +1,286 @@
 package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounts(t *testing.T) {
+	db := openStore(t)
+
+	// An empty database should have zero counts
+	counts, err := db.Counts()
+	require.NoError(t, err, "could not fetch counts from empty database")
+	require.Equal(t, &models.Counts{}, counts)
+
+	// Create some links, one of which expires in the future
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog", Campaigns: []uint64{3, 4}},
+		{ID: 3, URL: "https://rotational.io/blog?utm_source=twitter", CampaignID: 2},
+		{ID: 4, URL: "https://rotational.io/blog?utm_source=linkedin", CampaignID: 2},
+		{ID: 5, URL: "https://rotational.io/webinar", Expires: time.Now().Add(1 * time.Hour)},
+	}
+
+	for _, link := range links {
+		require.NoError(t, db.Save(link), "could not save link %d", link.ID)
+	}
+
+	// Saving a link that already exists should not modify the counts
+	require.ErrorIs(t, db.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io"}), storage.ErrAlreadyExists)
+
+	// Click on some of the links
+	for _, id := range []uint64{1, 1, 3, 5, 5, 5} {
+		_, err := db.Load(id)
+		require.NoError(t, err, "could not load link %d", id)
+	}
+
+	counts, err = db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, &models.Counts{Links: 5, Clicks: 6, Campaigns: 2}, counts)
+
+	// Clicking on an expiring link should not remove its TTL
+	info, err := db.LoadInfo(5)
+	require.NoError(t, err, "could not load expiring link info")
+	require.Equal(t, uint64(3), info.Visits)
+
+	// Delete a link and ensure the counts are decremented
+	require.NoError(t, db.Delete(5), "could not delete link")
+	require.ErrorIs(t, db.Delete(5), storage.ErrNotFound)
+
+	counts, err = db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, &models.Counts{Links: 4, Clicks: 3, Campaigns: 2}, counts)
+
+	// Recounting should produce the same counts as the incremental updates
+	recount, err := db.Recount()
+	require.NoError(t, err, "could not recount database")
+	require.Equal(t, counts, recount)
+
+	counts, err = db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, recount, counts)
+}
+
+func TestExpiredCounts(t *testing.T) {
+	db := openStore(t)
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/webinar", Expires: time.Now().Add(1 * time.Second)},
+	}
+
+	for _, link := range links {
+		require.NoError(t, db.Save(link), "could not save link %d", link.ID)
+	}
+
+	_, err := db.Load(2)
+	require.NoError(t, err, "could not click on expiring link")
+
+	counts, err := db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, &models.Counts{Links: 2, Clicks: 1}, counts)
+
+	// Wait for the link to expire
+	time.Sleep(2100 * time.Millisecond)
+
+	// The expired link is included in the counts until it is swept
+	counts, err = db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, &models.Counts{Links: 2, Clicks: 1}, counts)
+
+	n, err := db.ExpireLinks()
+	require.NoError(t, err, "could not sweep expired links")
+	require.Equal(t, 1, n)
+
+	n, err = db.ExpireLinks()
+	require.NoError(t, err, "could not sweep expired links")
+	require.Equal(t, 0, n)
+
+	counts, err = db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, &models.Counts{Links: 1}, counts)
+}
+
+func TestQuery(t *testing.T) {
+	db := openStore(t)
+
+	now := time.Now()
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io", CreatedBy: "jdoe@rotational.io", Created: now.Add(-72 * time.Hour)},
+		{ID: 2, URL: "https://docs.rotational.io/ensign", CreatedBy: "jdoe@rotational.io", Created: now.Add(-48 * time.Hour)},
+		{ID: 3, URL: "https://DOCS.rotational.io/rtnl", CreatedBy: "asmith@rotational.io", Created: now.Add(-24 * time.Hour)},
+		{ID: 4, URL: "https://docs.rotational.io/", Created: now.Add(-2 * time.Hour)},
+		{ID: 5, URL: "https://rotational.io/blog", CreatedBy: "jdoe@rotational.io", Created: now.Add(-1 * time.Hour), Expires: now.Add(1 * time.Hour)},
+	}
+
+	for _, link := range links {
+		require.NoError(t, db.Save(link), "could not save link %d", link.ID)
+	}
+
+	testCases := []struct {
+		query    *storage.LinkQuery
+		expected []uint64
+	}{
+		{&storage.LinkQuery{Host: "docs.rotational.io"}, []uint64{2, 3, 4}},
+		{&storage.LinkQuery{Host: "rotational.io"}, []uint64{1, 5}},
+		{&storage.LinkQuery{Host: "example.com"}, []uint64{}},
+		{&storage.LinkQuery{CreatedBy: "jdoe@rotational.io"}, []uint64{1, 2, 5}},
+		{&storage.LinkQuery{CreatedBy: "jdoe"}, []uint64{}},
+		{&storage.LinkQuery{After: now.Add(-50 * time.Hour)}, []uint64{2, 3, 4, 5}},
+		{&storage.LinkQuery{After: now.Add(-50 * time.Hour), Before: now.Add(-3 * time.Hour)}, []uint64{2, 3}},
+		{&storage.LinkQuery{Before: now.Add(-48 * time.Hour)}, []uint64{1}},
+		{&storage.LinkQuery{Host: "docs.rotational.io", CreatedBy: "jdoe@rotational.io"}, []uint64{2}},
+		{&storage.LinkQuery{CreatedBy: "jdoe@rotational.io", After: now.Add(-50 * time.Hour)}, []uint64{2, 5}},
+	}
+
+	for i, tc := range testCases {
+		out, err := db.Query(tc.query)
+		require.NoError(t, err, "could not query links in test case %d", i)
+
+		ids := make([]uint64, 0, len(out))
+		for _, link := range out {
+			ids = append(ids, link.ID)
+		}
+
+		// Only date queries are returned in a specific order
+		if tc.query.After.IsZero() && tc.query.Before.IsZero() {
+			require.ElementsMatch(t, tc.expected, ids, "unexpected links returned in test case %d", i)
+		} else {
+			require.Equal(t, tc.expected, ids, "unexpected links returned in test case %d", i)
+		}
+	}
+
+	// Deleting a link should remove it from the indexes
+	require.NoError(t, db.Delete(2), "could not delete link")
+	out, err := db.Query(&storage.LinkQuery{Host: "docs.rotational.io"})
+	require.NoError(t, err, "could not query links")
+	require.Len(t, out, 2)
+
+	// An empty query should list all links
+	out, err = db.Query(&storage.LinkQuery{})
+	require.NoError(t, err, "could not query links")
+	require.Len(t, out, 4)
+}
+
+func TestUpdate(t *testing.T) {
+	db := openStore(t)
+
+	link := &models.ShortURL{ID: 1, URL: "https://rotational.io", CreatedBy: "jdoe@rotational.io", Expires: time.Now().Add(1 * time.Hour)}
+	require.NoError(t, db.Save(link), "could not save link")
+
+	_, err := db.Load(1)
+	require.NoError(t, err, "could not click on link")
+
+	// Only the title and description should be modified
+	update := &models.ShortURL{ID: 1, URL: "https://example.com", Title: "Rotational", Description: "Home page", CreatedBy: "asmith@rotational.io"}
+	require.NoError(t, db.Update(update), "could not update link")
+	require.Equal(t, "https://rotational.io", update.URL)
+	require.Equal(t, "jdoe@rotational.io", update.CreatedBy)
+
+	cmp, err := db.LoadInfo(1)
+	require.NoError(t, err, "could not load link")
+	require.Equal(t, "Rotational", cmp.Title)
+	require.Equal(t, "Home page", cmp.Description)
+	require.Equal(t, "https://rotational.io", cmp.URL)
+	require.Equal(t, uint64(1), cmp.Visits)
+	require.False(t, cmp.Expires.IsZero())
+
+	// The creator index should not be modified by the update
+	out, err := db.Query(&storage.LinkQuery{CreatedBy: "jdoe@rotational.io"})
+	require.NoError(t, err, "could not query links")
+	require.Len(t, out, 1)
+
+	require.ErrorIs(t, db.Update(&models.ShortURL{ID: 2, Title: "Missing"}), storage.ErrNotFound)
+}
+
+func TestSaveMany(t *testing.T) {
+	db := openStore(t)
+	require.NoError(t, db.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io"}), "could not save link")
+
+	apikey := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret", Quota: &models.Quota{LinksPerDay: 1}}
+	require.NoError(t, db.Register(apikey), "could not register api key")
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog", CreatedBy: "jdoe@rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog", CreatedBy: "jdoe@rotational.io"},
+		{ID: 3, URL: "https://rotational.io/about", APIKey: apikey.ClientID},
+		{ID: 4, URL: "https://rotational.io/careers", APIKey: apikey.ClientID},
+		{ID: 5, URL: "https://rotational.io/webinar", Expires: time.Now().Add(1 * time.Hour)},
+	}
+
+	errs, err := db.SaveMany(links)
+	require.NoError(t, err, "could not save links")
+	require.Len(t, errs, len(links))
+
+	// Errors are returned in the same order as the links, including duplicates in the batch
+	require.ErrorIs(t, errs[0], storage.ErrAlreadyExists)
+	require.NoError(t, errs[1])
+	require.ErrorIs(t, errs[2], storage.ErrAlreadyExists)
+	require.NoError(t, errs[3])
+	require.ErrorIs(t, errs[4], storage.ErrQuotaExceeded)
+	require.NoError(t, errs[5])
+
+	for _, id := range []uint64{2, 3, 5} {
+		info, err := db.LoadInfo(id)
+		require.NoError(t, err, "could not load link %d", id)
+		require.False(t, info.Created.IsZero())
+	}
+
+	_, err = db.LoadInfo(4)
+	require.ErrorIs(t, err, storage.ErrNotFound, "link that exceeded the quota should not be saved")
+
+	// The counts and indices should be updated for every saved link
+	counts, err := db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, uint64(4), counts.Links)
+
+	out, err := db.Query(&storage.LinkQuery{CreatedBy: "jdoe@rotational.io"})
+	require.NoError(t, err, "could not query links")
+	require.Len(t, out, 1)
+
+	usage, err := db.Usage(apikey.ClientID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), usage.Links)
+
+	// An empty batch is not an error
+	errs, err = db.SaveMany(nil)
+	require.NoError(t, err)
+	require.Empty(t, errs)
+}
+
+func TestMaintenance(t *testing.T) {
+	db := openStore(t)
+	for i := uint64(1); i <= 64; i++ {
+		require.NoError(t, db.Save(&models.ShortURL{ID: i, URL: "https://rotational.io/blog"}), "could not save link")
+	}
+
+	for i := uint64(1); i <= 64; i += 2 {
+		require.NoError(t, db.Delete(i), "could not delete link")
+	}
+
+	require.NoError(t, db.Flatten(), "could not flatten database")
+
+	// Nothing should be lost by garbage collecting the value log
+	reclaimed, err := db.RunGC(0.5)
+	require.NoError(t, err, "could not run value log gc")
+	require.GreaterOrEqual(t, reclaimed, int64(0))
+
+	counts, err := db.Counts()
+	require.NoError(t, err, "could not fetch counts")
+	require.Equal(t, uint64(32), counts.Links)
+}
+
+func openStore(t *testing.T) storage.Storage {
+	db, err := storage.Open(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err, "could not open storage")
+	t.Cleanup(func() { db.Close() })
+	return db
+}
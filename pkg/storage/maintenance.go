@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"runtime"
+
+	"github.com/dgraph-io/badger/v4"
+)
+
+// RunGC runs badger value log garbage collection repeatedly until there is nothing left
+// to rewrite, returning the number of bytes reclaimed from the value log. Files are only
+// rewritten if at least discardRatio of the file can be discarded.
+func (s *Store) RunGC(discardRatio float64) (reclaimed int64, err error) {
+	var before, after int64
+	if before, err = s.vlogSize(); err != nil {
+		return 0, err
+	}
+
+	// Each successful call rewrites at most one value log file, so GC is repeated
+	// until badger reports that no file could be rewritten.
+	for err == nil {
+		err = s.db.RunValueLogGC(discardRatio)
+	}
+
+	if !errors.Is(err, badger.ErrNoRewrite) {
+		return 0, err
+	}
+
+	if after, err = s.vlogSize(); err != nil {
+		return 0, err
+	}
+	return before - after, nil
+}
+
+// Flatten compacts all of the levels of the LSM tree into a single level. Flatten should
+// only be run when the database is not serving requests since it blocks compactions.
+func (s *Store) Flatten() error {
+	return s.db.Flatten(runtime.NumCPU())
+}
+
+// Computes the size of the value log on disk. The size reported by badger is only
+// refreshed periodically, so the size is computed directly from the value log files.
+func (s *Store) vlogSize() (size int64, err error) {
+	err = filepath.WalkDir(s.db.Opts().ValueDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.IsDir() && filepath.Ext(path) == ".vlog" {
+			var info fs.FileInfo
+			if info, err = d.Info(); err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
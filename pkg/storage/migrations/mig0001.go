@@ -64,3 +64,44 @@ func Migration0001(txn *badger.Txn) error {
 
 	return nil
 }
+
+// Rollback0001 reverses Migration0001 by removing the bucket prefixes from the keys of
+// short urls and api keys, restoring the key-length based identification of objects.
+func Rollback0001(txn *badger.Txn) error {
+	for _, bucket := range []models.Bucket{models.LinksBucket, models.APIKeysBucket} {
+		// Collect the items to rekey since keys cannot be modified while iterating.
+		entries := make([]*badger.Entry, 0)
+		keys := make([][]byte, 0)
+
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		prefix := bucket[:]
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+			key := item.KeyCopy(nil)
+
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+
+			entry := badger.NewEntry(key[4:], value).WithMeta(item.UserMeta())
+			entry.ExpiresAt = item.ExpiresAt()
+
+			entries = append(entries, entry)
+			keys = append(keys, key)
+		}
+		iter.Close()
+
+		for i, entry := range entries {
+			if err := txn.SetEntry(entry); err != nil {
+				return err
+			}
+
+			if err := txn.Delete(keys[i]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
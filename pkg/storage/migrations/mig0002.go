@@ -0,0 +1,28 @@
+package migrations
+
+import (
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/counters"
+)
+
+// Migration0002 computes the aggregate counts of links, clicks, and campaigns and
+// stores them in the meta bucket so that the counts can be incrementally updated on
+// writes rather than computed by scanning the database on every stats request.
+func Migration0002(txn *badger.Txn) error {
+	_, err := counters.Rebuild(txn, time.Now())
+	return err
+}
+
+// MigrateDB0002 computes the aggregate counts in batches so that the expiration ledger
+// of databases with more links than fit into a single transaction can be written.
+func MigrateDB0002(db *badger.DB) error {
+	_, err := counters.RebuildDB(db, time.Now())
+	return err
+}
+
+// Rollback0002 removes the aggregate counts and the expiration ledger.
+func Rollback0002(txn *badger.Txn) error {
+	return counters.Reset(txn)
+}
@@ -0,0 +1,23 @@
+package migrations
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/index"
+)
+
+// Migration0003 backfills the secondary indexes of links by target host, creator, and
+// creation date for all links that were created before the indexes were maintained.
+func Migration0003(txn *badger.Txn) error {
+	return index.Rebuild(txn)
+}
+
+// MigrateDB0003 backfills the secondary indexes with a write batch so that databases
+// with more links than fit into a single transaction can be indexed.
+func MigrateDB0003(db *badger.DB) error {
+	return index.RebuildDB(db)
+}
+
+// Rollback0003 removes all entries from the secondary link indexes.
+func Rollback0003(txn *badger.Txn) error {
+	return index.Reset(txn)
+}
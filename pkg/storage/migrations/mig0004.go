@@ -0,0 +1,61 @@
+package migrations
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// Migration0004 grants the default scopes to API keys that were created before keys
+// had scopes so that existing integrations keep access to the non-admin endpoints.
+func Migration0004(txn *badger.Txn) error {
+	return updateAPIKeys(txn, func(key *models.APIKey) bool {
+		if len(key.Scopes) > 0 {
+			return false
+		}
+		key.Scopes = auth.DefaultScopes
+		return true
+	})
+}
+
+// Rollback0004 removes the scopes from all API keys.
+func Rollback0004(txn *badger.Txn) error {
+	return updateAPIKeys(txn, func(key *models.APIKey) bool {
+		if key.Scopes == nil {
+			return false
+		}
+		key.Scopes = nil
+		return true
+	})
+}
+
+// Applies the update function to every API key, saving the keys that were modified.
+func updateAPIKeys(txn *badger.Txn, update func(*models.APIKey) bool) (err error) {
+	keys := make([]*models.APIKey, 0)
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	prefix := models.APIKeysBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		obj := &models.APIKey{}
+		if err = iter.Item().Value(obj.UnmarshalValue); err != nil {
+			iter.Close()
+			return err
+		}
+
+		if update(obj) {
+			keys = append(keys, obj)
+		}
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		var data []byte
+		if data, err = key.MarshalValue(); err != nil {
+			return err
+		}
+
+		if err = txn.Set(key.Key(), data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
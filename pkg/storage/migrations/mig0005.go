@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// Migration0005 backfills the index of API keys by owner for personal access tokens
+// that were created before the index was maintained.
+func Migration0005(txn *badger.Txn) (err error) {
+	keys := make([]*models.APIKey, 0)
+	iter := txn.NewIterator(badger.DefaultIteratorOptions)
+	prefix := models.APIKeysBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		obj := &models.APIKey{}
+		if err = iter.Item().Value(obj.UnmarshalValue); err != nil {
+			iter.Close()
+			return err
+		}
+
+		if obj.IsPersonal() {
+			keys = append(keys, obj)
+		}
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		if err = txn.Set(key.OwnerKey(), []byte(key.ClientID)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Rollback0005 removes all entries from the index of API keys by owner.
+func Rollback0005(txn *badger.Txn) (err error) {
+	keys := make([][]byte, 0)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	iter := txn.NewIterator(opts)
+	prefix := models.OwnerIndexBucket[:]
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		keys = append(keys, iter.Item().KeyCopy(nil))
+	}
+	iter.Close()
+
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -2,14 +2,26 @@ package migrations
 
 import (
 	"errors"
+	"fmt"
 	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 	"github.com/rs/zerolog/log"
 )
 
+var (
+	ErrMigrationRequired = errors.New("database is not at the latest migration; run rtnl db:migrate up")
+	ErrNoMigrations      = errors.New("no migrations have been applied to the database")
+	ErrIrreversible      = errors.New("migration cannot be rolled back")
+	ErrUnknownMigration  = errors.New("database is at a migration that is not registered")
+)
+
 // Migrate the database to the current version or ensure that the database is current.
-func Migrate(txn *badger.Txn) (err error) {
+// Each migration is applied and recorded in its own transaction (or in batches if the
+// migration defines a MigrateDB function) so that a migration is only recorded once it
+// has completed; if a migration fails the database remains at the last one applied.
+func Migrate(db *badger.DB) (err error) {
 	// If there are no migrations to apply, do nothing
 	if len(migrations) == 0 {
 		return nil
@@ -17,7 +29,10 @@ func Migrate(txn *badger.Txn) (err error) {
 
 	// Load the current migration from the database
 	var current *Migration
-	if current, err = Current(txn); err != nil {
+	if err = db.View(func(txn *badger.Txn) (err error) {
+		current, err = Current(txn)
+		return err
+	}); err != nil {
 		return err
 	}
 
@@ -32,21 +47,12 @@ func Migrate(txn *badger.Txn) (err error) {
 	// Keep applying migrations while the current migration has next
 	for migrations.HasNext(current) {
 		next := migrations.Next(current)
-		if err := next.Migrate(txn); err != nil {
+		if current, err = applyDB(db, next, current); err != nil {
 			return err
 		}
-
-		next.Applied = time.Now()
-		next.Previous = current
-		current = next
 		applied++
 	}
 
-	// Save the current migration to the database
-	if err = saveMigration(txn, current); err != nil {
-		return err
-	}
-
 	// Log the results of the migration
 	if applied > 0 {
 		log.Info().
@@ -61,6 +67,131 @@ func Migrate(txn *badger.Txn) (err error) {
 	return nil
 }
 
+// Rollback the current migration using its down function, returning the migration
+// that the database is at after the rollback (nil if no migrations remain applied).
+// The migration history chain is restored to the state before the migration.
+func Rollback(txn *badger.Txn) (_ *Migration, err error) {
+	var current *Migration
+	if current, err = Current(txn); err != nil {
+		return nil, err
+	}
+
+	if current == nil || current.Version == 0 {
+		return nil, ErrNoMigrations
+	}
+
+	registered := migrations.Get(current.Version)
+	if registered == nil {
+		return nil, ErrUnknownMigration
+	}
+
+	if !registered.Reversible() {
+		return nil, fmt.Errorf("migration %d: %w", current.Version, ErrIrreversible)
+	}
+
+	started := time.Now()
+	if err = registered.Down(txn); err != nil {
+		return nil, fmt.Errorf("could not rollback migration %d: %w", current.Version, err)
+	}
+
+	if current.Previous == nil {
+		if err = txn.Delete(migrationKey); err != nil {
+			return nil, err
+		}
+	} else {
+		if err = saveMigration(txn, current.Previous); err != nil {
+			return nil, err
+		}
+	}
+
+	log.Info().
+		Uint16("rolled_back", current.Version).
+		Dur("duration", time.Since(started)).
+		Msg("database migration rolled back")
+	return current.Previous, nil
+}
+
+// Status returns the migration the database is currently at and the registered
+// migrations that have not yet been applied.
+func Status(txn *badger.Txn) (current *Migration, pending Migrations, err error) {
+	if current, err = Current(txn); err != nil {
+		return nil, nil, err
+	}
+	return current, migrations.Pending(current), nil
+}
+
+// Verify returns ErrMigrationRequired if the database has pending migrations.
+func Verify(txn *badger.Txn) (err error) {
+	var pending Migrations
+	if _, pending, err = Status(txn); err != nil {
+		return err
+	}
+
+	if len(pending) > 0 {
+		return ErrMigrationRequired
+	}
+	return nil
+}
+
+// Report describes the changes that a dry run of the migrations would make to the
+// database, including the number of keys in each bucket before and after.
+type Report struct {
+	From    uint16
+	To      uint16
+	Applied Migrations
+	Before  map[string]int
+	After   map[string]int
+}
+
+// DryRun applies all pending migrations in the transaction and reports the changes
+// that were made. The caller must discard the transaction rather than committing it
+// so that no changes are persisted to the database. Migrations are always applied with
+// their MigrateFn, so a dry run of a large database may fail with ErrTxnTooBig.
+func DryRun(txn *badger.Txn) (report *Report, err error) {
+	report = &Report{}
+	if report.Before, err = countBuckets(txn); err != nil {
+		return nil, err
+	}
+
+	var current *Migration
+	if current, err = Current(txn); err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		report.From = current.Version
+	}
+
+	for migrations.HasNext(current) {
+		next := migrations.Next(current)
+		if current, err = apply(txn, next, current); err != nil {
+			return nil, err
+		}
+
+		if err = saveMigration(txn, current); err != nil {
+			return nil, err
+		}
+	}
+
+	if current, err = Current(txn); err != nil {
+		return nil, err
+	}
+
+	if current != nil {
+		report.To = current.Version
+		for _, m := range current.History() {
+			if m.Version > report.From {
+				report.Applied = append(report.Applied, m)
+			}
+		}
+	}
+
+	if report.After, err = countBuckets(txn); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
 func Current(txn *badger.Txn) (_ *Migration, err error) {
 	var item *badger.Item
 	if item, err = txn.Get(migrationKey); err != nil {
@@ -80,6 +211,59 @@ func Current(txn *badger.Txn) (_ *Migration, err error) {
 	return migration, nil
 }
 
+// Apply the registered migration to the database and save the record of the migration
+// once it has completed. Migrations that define a MigrateDB function are applied outside
+// of a transaction, otherwise the migration and its record are written in the same one.
+func applyDB(db *badger.DB, next, current *Migration) (_ *Migration, err error) {
+	if next.MigrateDB == nil {
+		var m *Migration
+		if err = db.Update(func(txn *badger.Txn) (err error) {
+			if m, err = apply(txn, next, current); err != nil {
+				return err
+			}
+			return saveMigration(txn, m)
+		}); err != nil {
+			return nil, err
+		}
+		return m, nil
+	}
+
+	started := time.Now()
+	if err = next.MigrateDB(db); err != nil {
+		return nil, fmt.Errorf("could not apply migration %d: %w", next.Version, err)
+	}
+
+	m := record(next, current, started)
+	if err = db.Update(func(txn *badger.Txn) error {
+		return saveMigration(txn, m)
+	}); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Apply the registered migration in the transaction, returning the record of the
+// migration without saving it.
+func apply(txn *badger.Txn, next, current *Migration) (_ *Migration, err error) {
+	started := time.Now()
+	if err = next.Migrate(txn); err != nil {
+		return nil, fmt.Errorf("could not apply migration %d: %w", next.Version, err)
+	}
+	return record(next, current, started), nil
+}
+
+// Returns a copy of the migration that records when the migration was applied and how
+// long it took, chained to the previous migration.
+func record(next, current *Migration, started time.Time) *Migration {
+	return &Migration{
+		Version:     next.Version,
+		Description: next.Description,
+		Applied:     started,
+		Duration:    time.Since(started),
+		Previous:    current,
+	}
+}
+
 func saveMigration(txn *badger.Txn, m *Migration) (err error) {
 	var data []byte
 	if data, err = m.MarshalValue(); err != nil {
@@ -87,3 +271,23 @@ func saveMigration(txn *badger.Txn, m *Migration) (err error) {
 	}
 	return txn.Set(migrationKey, data)
 }
+
+// Count the number of keys in each bucket using the bucket name.
+func countBuckets(txn *badger.Txn) (counts map[string]int, err error) {
+	counts = make(map[string]int)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+
+	iter := txn.NewIterator(opts)
+	defer iter.Close()
+
+	for iter.Rewind(); iter.Valid(); iter.Next() {
+		key := iter.Item().Key()
+		if len(key) < 4 {
+			counts[models.UnknownBucket]++
+			continue
+		}
+		counts[models.Bucket(key[0:4]).Name()]++
+	}
+	return counts, nil
+}
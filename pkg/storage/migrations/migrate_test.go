@@ -8,15 +8,19 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/auth"
+	"github.com/rotationalio/rtnl.link/pkg/storage/counters"
+	"github.com/rotationalio/rtnl.link/pkg/storage/index"
 	"github.com/rotationalio/rtnl.link/pkg/storage/migrations"
 	"github.com/rotationalio/rtnl.link/pkg/storage/models"
 	"github.com/stretchr/testify/require"
 )
 
 // NOTE: must update this value when new migrations are added!
-const latestMigration = uint16(1)
+const latestMigration = uint16(5)
 
 func TestMigrate(t *testing.T) {
 	t.Run("MIG0000", func(t *testing.T) {
@@ -30,7 +34,7 @@ func TestMigrate(t *testing.T) {
 		require.Equal(t, 1, records["🔑"], "unexpected number of apikeys, have the fixtures changed?")
 
 		// Apply the migrtions
-		err = db.Update(migrations.Migrate)
+		err = migrations.Migrate(db)
 		require.NoError(t, err)
 
 		// Check that we're at the latest registered migration
@@ -41,11 +45,171 @@ func TestMigrate(t *testing.T) {
 		newRecords, err := counts(db)
 		delete(newRecords, "meta")
 
+		// Check that the secondary indexes were backfilled
+		require.Equal(t, records["🔗"], newRecords[models.HostIndexBucket.String()], "host index was not backfilled")
+		require.Equal(t, records["🔗"], newRecords[models.CreatedIndexBucket.String()], "created index was not backfilled")
+		for _, bucket := range index.Buckets {
+			delete(newRecords, bucket.String())
+		}
+
 		require.NoError(t, err, "could not count contents of database")
 		require.Equal(t, records, newRecords, "counts do not match original counts")
+
+		// Check that the aggregate counts were computed
+		err = db.View(func(txn *badger.Txn) error {
+			counts, err := counters.Load(txn)
+			require.NoError(t, err, "could not load aggregate counts")
+			require.Equal(t, uint64(records["🔗"]), counts.Links, "aggregate counts do not match number of links")
+			return nil
+		})
+		require.NoError(t, err)
+
+		// Check that the existing api keys were granted the default scopes
+		err = db.View(func(txn *badger.Txn) error {
+			iter := txn.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			prefix := models.APIKeysBucket[:]
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				key := &models.APIKey{}
+				require.NoError(t, iter.Item().Value(key.UnmarshalValue))
+				require.Equal(t, []string(auth.DefaultScopes), key.Scopes, "api key was not granted default scopes")
+			}
+			return nil
+		})
+		require.NoError(t, err)
 	})
 }
 
+func TestRollback(t *testing.T) {
+	db := makeFixtureDB(t, "testdata/mig0.tgz")
+	original, err := countsByKeyLength(db)
+	require.NoError(t, err, "could not count contents of database")
+
+	// Apply all the migrations and then roll them all back
+	require.NoError(t, migrations.Migrate(db), "could not apply migrations")
+	require.NoError(t, checkLatest(db), "not at latest registered migration")
+
+	for version := latestMigration; version > 0; version-- {
+		var current *migrations.Migration
+		err = db.Update(func(txn *badger.Txn) (err error) {
+			current, err = migrations.Rollback(txn)
+			return err
+		})
+		require.NoError(t, err, "could not rollback migration %d", version)
+
+		if version > 1 {
+			require.Equal(t, version-1, current.Version, "unexpected migration after rollback")
+			require.Len(t, current.History(), int(version-1), "unexpected history after rollback")
+		} else {
+			require.Nil(t, current, "expected no migrations after final rollback")
+		}
+	}
+
+	// There should be nothing more to roll back
+	err = db.Update(func(txn *badger.Txn) (err error) {
+		_, err = migrations.Rollback(txn)
+		return err
+	})
+	require.ErrorIs(t, err, migrations.ErrNoMigrations)
+
+	// The database should be back in its original state
+	rolledback, err := countsByKeyLength(db)
+	require.NoError(t, err, "could not count contents of database")
+	require.Equal(t, original, rolledback)
+
+	err = db.View(func(txn *badger.Txn) (err error) {
+		current, pending, err := migrations.Status(txn)
+		require.Nil(t, current)
+		require.Len(t, pending, int(latestMigration))
+		require.ErrorIs(t, migrations.Verify(txn), migrations.ErrMigrationRequired)
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestDryRun(t *testing.T) {
+	db := makeFixtureDB(t, "testdata/mig0.tgz")
+	original, err := countsByKeyLength(db)
+	require.NoError(t, err, "could not count contents of database")
+
+	txn := db.NewTransaction(true)
+	report, err := migrations.DryRun(txn)
+	txn.Discard()
+
+	require.NoError(t, err, "could not dry run migrations")
+	require.Equal(t, uint16(0), report.From)
+	require.Equal(t, latestMigration, report.To)
+	require.Len(t, report.Applied, int(latestMigration))
+	require.Equal(t, map[string]int{models.UnknownBucket: original["🔗"] + original["🔑"]}, report.Before)
+	require.Equal(t, original["🔗"], report.After[models.LinksBucket.Name()])
+	require.Equal(t, original["🔑"], report.After[models.APIKeysBucket.Name()])
+
+	for _, m := range report.Applied {
+		require.NotEmpty(t, m.Description, "migration %d has no description", m.Version)
+		require.False(t, m.Applied.IsZero(), "migration %d has no applied timestamp", m.Version)
+	}
+
+	// Nothing should have been changed in the database
+	after, err := countsByKeyLength(db)
+	require.NoError(t, err, "could not count contents of database")
+	require.Equal(t, original, after)
+
+	err = db.View(func(txn *badger.Txn) error {
+		current, err := migrations.Current(txn)
+		require.Nil(t, current, "dry run persisted the migration")
+		return err
+	})
+	require.NoError(t, err)
+}
+
+func TestMigrateDB0002(t *testing.T) {
+	db := makeLargeDB(t, 4000)
+
+	// Computing the counts in a single transaction exceeds the transaction size
+	require.ErrorIs(t, db.Update(migrations.Migration0002), badger.ErrTxnTooBig)
+
+	require.NoError(t, migrations.MigrateDB0002(db), "could not compute counts in batches")
+	err := db.View(func(txn *badger.Txn) error {
+		counts, err := counters.Load(txn)
+		require.NoError(t, err, "could not load aggregate counts")
+		require.Equal(t, uint64(4000), counts.Links)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+func TestMigrateDB0003(t *testing.T) {
+	db := makeLargeDB(t, 4000)
+
+	// Backfilling the indexes in a single transaction exceeds the transaction size
+	require.ErrorIs(t, db.Update(migrations.Migration0003), badger.ErrTxnTooBig)
+
+	require.NoError(t, migrations.MigrateDB0003(db), "could not backfill indexes in batches")
+	records, err := counts(db)
+	require.NoError(t, err, "could not count contents of database")
+	require.Equal(t, 4000, records[models.HostIndexBucket.String()])
+	require.Equal(t, 4000, records[models.CreatedIndexBucket.String()])
+}
+
+func TestMigrateLarge(t *testing.T) {
+	db := makeLargeDB(t, 4000)
+
+	// Start from the first migration since the links are already prefixed
+	err := db.Update(func(txn *badger.Txn) error {
+		m := &migrations.Migration{Version: 1, Description: "rekey objects with bucket prefixes"}
+		data, err := m.MarshalValue()
+		if err != nil {
+			return err
+		}
+		return txn.Set(m.Key(), data)
+	})
+	require.NoError(t, err, "could not record the first migration")
+
+	require.NoError(t, migrations.Migrate(db), "could not apply migrations to a large database")
+	require.NoError(t, checkLatest(db), "not at latest registered migration")
+}
+
 func counts(db *badger.DB) (map[string]int, error) {
 	counter := make(map[string]int)
 	err := db.View(func(txn *badger.Txn) error {
@@ -127,6 +291,32 @@ func makeFixtureDB(t *testing.T, fixturePath string) (db *badger.DB) {
 	return db
 }
 
+// Create a badger database with a small memtable so that migrations that write an
+// entry for every link exceed the transaction size, populated with n expiring links.
+func makeLargeDB(t *testing.T, n int) (db *badger.DB) {
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.MemTableSize = 1 << 20
+	opts.ValueThreshold = 1 << 10
+	opts.Logger = nil
+
+	db, err := badger.Open(opts)
+	require.NoError(t, err, "could not open badger database")
+	t.Cleanup(func() { db.Close() })
+
+	wb := db.NewWriteBatch()
+	defer wb.Cancel()
+
+	created := time.Now().Add(-1 * time.Hour)
+	for i := 1; i <= n; i++ {
+		link := &models.ShortURL{ID: uint64(i), URL: fmt.Sprintf("https://rotational.io/%d", i), Created: created, Expires: created.Add(24 * time.Hour)}
+		data, err := link.MarshalValue()
+		require.NoError(t, err, "could not marshal link")
+		require.NoError(t, wb.Set(link.Key(), data), "could not write link")
+	}
+	require.NoError(t, wb.Flush(), "could not write links")
+	return db
+}
+
 // Unzip the fixture path to a temporary directory, ready to load a database.
 func unzipFixtures(t *testing.T, fixturePath string) (dbpath string) {
 	f, err := os.Open(fixturePath)
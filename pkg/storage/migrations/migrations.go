@@ -9,7 +9,35 @@ import (
 
 func init() {
 	// NOTE: Register migrations here in the order that they should be applied!
-	register(Migration0001)
+	register(
+		&Migration{
+			Description: "rekey objects with bucket prefixes",
+			Migrate:     Migration0001,
+			Down:        Rollback0001,
+		},
+		&Migration{
+			Description: "compute aggregate link counts",
+			Migrate:     Migration0002,
+			MigrateDB:   MigrateDB0002,
+			Down:        Rollback0002,
+		},
+		&Migration{
+			Description: "backfill secondary link indexes",
+			Migrate:     Migration0003,
+			MigrateDB:   MigrateDB0003,
+			Down:        Rollback0003,
+		},
+		&Migration{
+			Description: "grant default scopes to api keys",
+			Migrate:     Migration0004,
+			Down:        Rollback0004,
+		},
+		&Migration{
+			Description: "index personal access tokens by owner",
+			Migrate:     Migration0005,
+			Down:        Rollback0005,
+		},
+	)
 }
 
 var (
@@ -17,9 +45,8 @@ var (
 	migrations   = Migrations{}
 )
 
-func register(migrateFunctions ...MigrateFn) {
-	for _, migrate := range migrateFunctions {
-		migration := &Migration{Migrate: migrate}
+func register(toRegister ...*Migration) {
+	for _, migration := range toRegister {
 		if len(migrations) > 0 {
 			migration.Previous = migrations[len(migrations)-1]
 			migration.Version = migration.Previous.Version + 1
@@ -37,17 +64,33 @@ func register(migrateFunctions ...MigrateFn) {
 // create a file with m000n.go then create a migration instance and register it. The
 // store will automatically check the migration is at the latest version or apply the
 // migrations in order if the database is not at the latest version.
+//
+// A migration may optionally define a Down function that reverses the changes made by
+// the migration so that the database can be rolled back to a previous version. When a
+// migration is applied, the time it was applied and how long it took are recorded in
+// the migration history chain that is saved to the database.
+//
+// Migrations that write more keys than fit into a single transaction should also define
+// a MigrateDB function that applies the migration in batches. It is used instead of the
+// MigrateFn when the migration is applied to the database and the migration is recorded
+// only after it completes; the MigrateFn is still used by dry runs.
 type Migration struct {
-	Version  uint16     `msgpack:"version"`
-	Applied  time.Time  `msgpack:"applied"`
-	Previous *Migration `msgpack:"previous"`
-	Migrate  MigrateFn  `msgpack:"-"`
+	Version     uint16        `msgpack:"version"`
+	Description string        `msgpack:"description"`
+	Applied     time.Time     `msgpack:"applied"`
+	Duration    time.Duration `msgpack:"duration"`
+	Previous    *Migration    `msgpack:"previous"`
+	Migrate     MigrateFn     `msgpack:"-"`
+	MigrateDB   MigrateDBFn   `msgpack:"-"`
+	Down        MigrateFn     `msgpack:"-"`
 }
 
 type Migrations []*Migration
 
 type MigrateFn func(txn *badger.Txn) error
 
+type MigrateDBFn func(db *badger.DB) error
+
 func (m *Migration) Key() []byte {
 	return migrationKey
 }
@@ -60,6 +103,21 @@ func (m *Migration) UnmarshalValue(data []byte) error {
 	return msgpack.Unmarshal(data, m)
 }
 
+// History returns the chain of applied migrations from the first migration applied to
+// the database to this migration.
+func (m *Migration) History() Migrations {
+	history := make(Migrations, 0, m.Version)
+	for node := m; node != nil; node = node.Previous {
+		history = append(Migrations{node}, history...)
+	}
+	return history
+}
+
+// Reversible returns true if the registered migration defines a down function.
+func (m *Migration) Reversible() bool {
+	return m.Down != nil
+}
+
 func (m Migrations) HasNext(n *Migration) bool {
 	if n == nil || n.Version == 0 {
 		return len(m) > 0
@@ -83,3 +141,33 @@ func (m Migrations) Next(n *Migration) *Migration {
 	}
 	return nil
 }
+
+// Get returns the registered migration for the specified version or nil.
+func (m Migrations) Get(version uint16) *Migration {
+	if version == 0 || int(version) > len(m) {
+		return nil
+	}
+	return m[version-1]
+}
+
+// Pending returns the migrations that have not been applied after n.
+func (m Migrations) Pending(n *Migration) Migrations {
+	pending := make(Migrations, 0)
+	for next := m.Next(n); next != nil; next = m.Next(next) {
+		pending = append(pending, next)
+	}
+	return pending
+}
+
+// Registered returns all of the migrations registered with the package in order.
+func Registered() Migrations {
+	return migrations
+}
+
+// Latest returns the version of the latest registered migration.
+func Latest() uint16 {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
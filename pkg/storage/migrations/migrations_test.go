@@ -58,3 +58,31 @@ func TestMigrations(t *testing.T) {
 	}
 	t.Run("Multiple", makeTest(multiTestCases, multiMigrations))
 }
+
+func TestRegistered(t *testing.T) {
+	registered := Registered()
+	require.NotEmpty(t, registered, "no migrations are registered")
+	require.Equal(t, registered[len(registered)-1].Version, Latest())
+
+	for i, m := range registered {
+		require.Equal(t, uint16(i+1), m.Version, "migration registered with wrong version")
+		require.NotEmpty(t, m.Description, "migration %d requires a description", m.Version)
+		require.NotNil(t, m.Migrate, "migration %d requires a migrate function", m.Version)
+		require.Equal(t, m, registered.Get(m.Version))
+	}
+
+	require.Nil(t, registered.Get(0))
+	require.Nil(t, registered.Get(Latest()+1))
+	require.Len(t, registered.Pending(nil), len(registered))
+	require.Len(t, registered.Pending(&Migration{Version: 1}), len(registered)-1)
+	require.Empty(t, registered.Pending(&Migration{Version: Latest()}))
+}
+
+func TestHistory(t *testing.T) {
+	first := &Migration{Version: 1}
+	second := &Migration{Version: 2, Previous: first}
+	third := &Migration{Version: 3, Previous: second}
+
+	require.Equal(t, Migrations{first}, first.History())
+	require.Equal(t, Migrations{first, second, third}, third.History())
+}
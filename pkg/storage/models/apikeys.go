@@ -2,18 +2,38 @@ package models
 
 import (
 	"encoding/base64"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
 	"github.com/vmihailenco/msgpack/v5"
 )
 
+// APIKey stores the derived key of an API client secret along with metadata to manage
+// the lifecycle of the key. Revoked and expired keys are kept in the database so that
+// they can be listed, but can no longer be used to authenticate.
 type APIKey struct {
 	ClientID   string    `msgpack:"client_id"`
 	DerivedKey string    `msgpack:"derived_key"`
+	Name       string    `msgpack:"name"`
+	Scopes     []string  `msgpack:"scopes"`
+	Expires    time.Time `msgpack:"expires"`
+	Quota      *Quota    `msgpack:"quota"`
+	Owner      string    `msgpack:"owner"`
+	LastUsed   time.Time `msgpack:"last_used"`
+	Revoked    time.Time `msgpack:"revoked"`
 	Created    time.Time `msgpack:"created"`
 	Modified   time.Time `msgpack:"modified"`
 }
 
+// Quota limits the number of links that can be created with an API key. A zero value
+// for either limit means that the limit is not enforced.
+type Quota struct {
+	LinksPerDay uint64 `msgpack:"links_per_day"`
+	ActiveLinks uint64 `msgpack:"active_links"`
+}
+
 var _ Model = &APIKey{}
 
 func (m *APIKey) Key() []byte {
@@ -24,6 +44,17 @@ func (m *APIKey) Key() []byte {
 	return key
 }
 
+// OwnerKey returns the key of the personal access token in the index of API keys by
+// owner: the owner terminated by a zero byte followed by the client ID of the key.
+func (m *APIKey) OwnerKey() []byte {
+	data, _ := base64.RawStdEncoding.DecodeString(m.ClientID)
+	key := make([]byte, 0, 4+len(m.Owner)+1+len(data))
+	key = append(key, OwnerIndexBucket[:]...)
+	key = append(key, m.Owner...)
+	key = append(key, 0)
+	return append(key, data...)
+}
+
 func (m *APIKey) MarshalValue() ([]byte, error) {
 	return msgpack.Marshal(m)
 }
@@ -31,3 +62,88 @@ func (m *APIKey) MarshalValue() ([]byte, error) {
 func (m *APIKey) UnmarshalValue(data []byte) error {
 	return msgpack.Unmarshal(data, m)
 }
+
+// IsPersonal returns true if the key is a personal access token that was created by a
+// web user; links created with the key are attributed to the user.
+func (m *APIKey) IsPersonal() bool {
+	return m.Owner != ""
+}
+
+// Summary describes the name and permissions of the key for the audit log.
+func (m *APIKey) Summary() string {
+	summary := fmt.Sprintf("name=%q scopes=%s", m.Name, strings.Join(m.Scopes, ","))
+	if !m.Expires.IsZero() {
+		summary += " expires=" + m.Expires.Format(time.RFC3339)
+	}
+	return summary
+}
+
+// IsRevoked returns true if the key has been revoked and can no longer be used.
+func (m *APIKey) IsRevoked() bool {
+	return !m.Revoked.IsZero()
+}
+
+// IsExpired returns true if the key has an expiration date that has passed.
+func (m *APIKey) IsExpired() bool {
+	return !m.Expires.IsZero() && !m.Expires.After(time.Now())
+}
+
+// IsZero returns true if the quota does not enforce any limits.
+func (q *Quota) IsZero() bool {
+	return q == nil || (q.LinksPerDay == 0 && q.ActiveLinks == 0)
+}
+
+// Exceeded returns true if creating another link would exceed the quota.
+func (q *Quota) Exceeded(usage *Usage) bool {
+	if q.IsZero() {
+		return false
+	}
+
+	if q.LinksPerDay > 0 && usage.Links >= q.LinksPerDay {
+		return true
+	}
+
+	if q.ActiveLinks > 0 && usage.Active >= q.ActiveLinks {
+		return true
+	}
+	return false
+}
+
+// ToAPI returns the public metadata of the key; the derived key is never returned.
+func (m *APIKey) ToAPI() *api.APIKey {
+	out := &api.APIKey{
+		ClientID: m.ClientID,
+		Name:     m.Name,
+		Scopes:   m.Scopes,
+		Owner:    m.Owner,
+	}
+
+	if !m.Expires.IsZero() {
+		out.Expires = &m.Expires
+	}
+
+	if !m.Quota.IsZero() {
+		out.Quota = &api.Quota{
+			LinksPerDay: m.Quota.LinksPerDay,
+			ActiveLinks: m.Quota.ActiveLinks,
+		}
+	}
+
+	if !m.LastUsed.IsZero() {
+		out.LastUsed = &m.LastUsed
+	}
+
+	if !m.Revoked.IsZero() {
+		out.Revoked = &m.Revoked
+	}
+
+	if !m.Created.IsZero() {
+		out.Created = &m.Created
+	}
+
+	if !m.Modified.IsZero() {
+		out.Modified = &m.Modified
+	}
+
+	return out
+}
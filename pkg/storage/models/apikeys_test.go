@@ -22,8 +22,93 @@ func TestAPIKeys(t *testing.T) {
 	testCases := []models.Model{
 		&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret()},
 		&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret(), Created: time.Now().Truncate(time.Millisecond), Modified: time.Now().Truncate(time.Millisecond)},
+		&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret(), Name: "ci", Scopes: []string{"links:read", "stats:read"}, LastUsed: time.Now().Truncate(time.Millisecond), Revoked: time.Now().Truncate(time.Millisecond)},
+		&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret(), Expires: time.Now().Add(time.Hour).Truncate(time.Millisecond), Quota: &models.Quota{LinksPerDay: 100, ActiveLinks: 1000}},
+		&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret(), Name: "scripts", Scopes: []string{"links:read", "links:write"}, Owner: "jdoe@example.com"},
 	}
 
 	test := makeModelsTest(models.APIKeysBucket, testCases)
 	test(t)
 }
+
+func TestAPIKeyToAPI(t *testing.T) {
+	model := &models.APIKey{ClientID: keygen.KeyID(), DerivedKey: keygen.Secret(), Name: "ci", Created: time.Now()}
+	require.False(t, model.IsRevoked())
+
+	out := model.ToAPI()
+	require.Equal(t, model.ClientID, out.ClientID)
+	require.Equal(t, model.Name, out.Name)
+	require.Empty(t, out.ClientSecret, "the secret should never be returned")
+	require.Nil(t, out.LastUsed)
+	require.Nil(t, out.Revoked)
+	require.Nil(t, out.Expires)
+	require.Nil(t, out.Quota)
+	require.NotNil(t, out.Created)
+
+	model.Revoked = time.Now()
+	require.True(t, model.IsRevoked())
+	require.NotNil(t, model.ToAPI().Revoked)
+}
+
+func TestAPIKeyExpires(t *testing.T) {
+	model := &models.APIKey{ClientID: keygen.KeyID()}
+	require.False(t, model.IsExpired(), "keys without an expiration should not expire")
+
+	model.Expires = time.Now().Add(time.Hour)
+	require.False(t, model.IsExpired())
+	require.NotNil(t, model.ToAPI().Expires)
+
+	model.Expires = time.Now().Add(-time.Second)
+	require.True(t, model.IsExpired())
+}
+
+func TestQuota(t *testing.T) {
+	var quota *models.Quota
+	require.True(t, quota.IsZero())
+	require.False(t, quota.Exceeded(&models.Usage{Links: 1000, Active: 1000}))
+	require.True(t, (&models.Quota{}).IsZero())
+
+	testCases := []struct {
+		quota    *models.Quota
+		usage    *models.Usage
+		exceeded bool
+	}{
+		{&models.Quota{LinksPerDay: 10}, &models.Usage{Links: 9, Active: 100}, false},
+		{&models.Quota{LinksPerDay: 10}, &models.Usage{Links: 10}, true},
+		{&models.Quota{ActiveLinks: 10}, &models.Usage{Links: 100, Active: 9}, false},
+		{&models.Quota{ActiveLinks: 10}, &models.Usage{Active: 11}, true},
+		{&models.Quota{LinksPerDay: 10, ActiveLinks: 5}, &models.Usage{Links: 4, Active: 5}, true},
+	}
+
+	for i, tc := range testCases {
+		require.Equal(t, tc.exceeded, tc.quota.Exceeded(tc.usage), "test case %d failed", i)
+	}
+
+	model := &models.APIKey{ClientID: keygen.KeyID(), Quota: &models.Quota{LinksPerDay: 10}}
+	out := model.ToAPI()
+	require.NotNil(t, out.Quota)
+	require.Equal(t, uint64(10), out.Quota.LinksPerDay)
+}
+
+func TestUsage(t *testing.T) {
+	clientID := keygen.KeyID()
+	day := time.Date(2023, 11, 2, 0, 0, 0, 0, time.UTC)
+	testCases := []models.Model{
+		&models.Usage{ClientID: clientID, Day: day.Local(), Links: 42},
+		&models.Usage{ClientID: keygen.KeyID(), Day: day.Add(24 * time.Hour).Local()},
+	}
+
+	test := makeModelsTest(models.UsageBucket, testCases)
+	test(t)
+
+	// Usage for the same key on the same day should have the same key
+	usage := &models.Usage{ClientID: clientID, Day: models.Today(day.Add(13 * time.Hour))}
+	require.Equal(t, testCases[0].Key(), usage.Key())
+	require.Len(t, usage.Key(), 24)
+	require.True(t, day.Equal(usage.Day))
+	require.True(t, day.Add(24*time.Hour).Equal(usage.Reset()))
+
+	// Usage on different days should be sorted by day
+	next := &models.Usage{ClientID: clientID, Day: day.Add(24 * time.Hour)}
+	require.Less(t, string(usage.Key()), string(next.Key()))
+}
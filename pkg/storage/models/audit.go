@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Actions that are recorded in the audit log.
+const (
+	ActionLinkCreated    = "link.created"
+	ActionLinkUpdated    = "link.updated"
+	ActionLinkDeleted    = "link.deleted"
+	ActionAPIKeyCreated  = "apikey.created"
+	ActionAPIKeyUpdated  = "apikey.updated"
+	ActionAPIKeyRevoked  = "apikey.revoked"
+	ActionAPIKeyRotated  = "apikey.rotated"
+	ActionUserLogin      = "user.login"
+	ActionUserLogout     = "user.logout"
+	ActionUserLocked     = "user.locked"
+	ActionSessionRevoked = "session.revoked"
+)
+
+// AuditEvent records who performed an administrative action or modified a link. Audit
+// events are identified by a ULID so that they are sorted by the time they occurred;
+// they are only ever appended to the audit log and are never modified or deleted. The
+// before and after fields contain short human readable summaries of the target.
+type AuditEvent struct {
+	ID        ulid.ULID `msgpack:"id"`
+	Time      time.Time `msgpack:"time"`
+	Actor     string    `msgpack:"actor"`
+	Action    string    `msgpack:"action"`
+	Target    string    `msgpack:"target"`
+	IPAddr    string    `msgpack:"ip_addr"`
+	UserAgent string    `msgpack:"user_agent"`
+	Before    string    `msgpack:"before"`
+	After     string    `msgpack:"after"`
+}
+
+var _ Model = &AuditEvent{}
+
+// Key is the audit bucket followed by the bytes of the ULID.
+func (m *AuditEvent) Key() []byte {
+	key := make([]byte, 20)
+	copy(key[0:4], AuditBucket[:])
+	copy(key[4:], m.ID[:])
+	return key
+}
+
+func (m *AuditEvent) MarshalValue() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (m *AuditEvent) UnmarshalValue(data []byte) error {
+	return msgpack.Unmarshal(data, m)
+}
+
+func (m *AuditEvent) ToAPI() *api.AuditEvent {
+	return &api.AuditEvent{
+		ID:        m.ID.String(),
+		Time:      m.Time,
+		Actor:     m.Actor,
+		Action:    m.Action,
+		Target:    m.Target,
+		IPAddr:    m.IPAddr,
+		UserAgent: m.UserAgent,
+		Before:    m.Before,
+		After:     m.After,
+	}
+}
@@ -0,0 +1,30 @@
+package models_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditEvents(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	testCases := []models.Model{
+		&models.AuditEvent{ID: ulid.Make(), Time: now, Actor: "jdoe@example.com", Action: models.ActionUserLogin},
+		&models.AuditEvent{ID: ulid.Make(), Time: now, Actor: "01hcr3d1vwvdhxgq8h5y8ts3fw", Action: models.ActionLinkUpdated, Target: "4eAX2", IPAddr: "192.168.1.1", UserAgent: "Mozilla/5.0", Before: "https://example.com/a", After: "https://example.com/b"},
+	}
+
+	test := makeModelsTest(models.AuditBucket, testCases)
+	test(t)
+}
+
+func TestAuditEventKeyOrder(t *testing.T) {
+	// Keys must sort by time so that the audit log can be scanned by time range
+	first := &models.AuditEvent{ID: ulid.MustNew(ulid.Timestamp(time.Now().Add(-1*time.Hour)), ulid.DefaultEntropy())}
+	second := &models.AuditEvent{ID: ulid.Make()}
+	require.Len(t, first.Key(), 20)
+	require.Equal(t, -1, bytes.Compare(first.Key(), second.Key()))
+}
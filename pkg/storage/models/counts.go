@@ -1,13 +1,51 @@
 package models
 
-import "github.com/rotationalio/rtnl.link/pkg/api/v1"
+import (
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/vmihailenco/msgpack/v5"
+)
 
+// The aggregate counts are stored as a single record in the meta bucket.
+var countsKey = []byte{0, 0, 0, 109, 99, 111, 117, 110, 116, 115}
+
+// Counts holds the aggregate number of active links, clicks, and campaigns in the
+// database. Rather than scanning every link, the counts are kept up to date as links
+// are saved, deleted, clicked, and expire so that stats can be returned quickly.
 type Counts struct {
 	Links     uint64 `msgpack:"links"`
 	Clicks    uint64 `msgpack:"clicks"`
 	Campaigns uint64 `msgpack:"campaigns"`
 }
 
+var _ Model = &Counts{}
+
+func (c *Counts) Key() []byte {
+	return countsKey
+}
+
+func (c *Counts) MarshalValue() ([]byte, error) {
+	return msgpack.Marshal(c)
+}
+
+func (c *Counts) UnmarshalValue(data []byte) error {
+	return msgpack.Unmarshal(data, c)
+}
+
+// Add the other counts to these counts.
+func (c *Counts) Add(o *Counts) {
+	c.Links += o.Links
+	c.Clicks += o.Clicks
+	c.Campaigns += o.Campaigns
+}
+
+// Sub removes the other counts from these counts; the counts will not go below zero
+// so that any drift in the aggregates does not cause the counters to wrap around.
+func (c *Counts) Sub(o *Counts) {
+	c.Links = sub(c.Links, o.Links)
+	c.Clicks = sub(c.Clicks, o.Clicks)
+	c.Campaigns = sub(c.Campaigns, o.Campaigns)
+}
+
 func (c *Counts) ToAPI() *api.ShortcrustInfo {
 	return &api.ShortcrustInfo{
 		Links:     c.Links,
@@ -15,3 +53,10 @@ func (c *Counts) ToAPI() *api.ShortcrustInfo {
 		Campaigns: c.Campaigns,
 	}
 }
+
+func sub(a, b uint64) uint64 {
+	if b > a {
+		return 0
+	}
+	return a - b
+}
@@ -0,0 +1,32 @@
+package models_test
+
+import (
+	"testing"
+
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCounts(t *testing.T) {
+	testCases := []models.Model{
+		&models.Counts{},
+		&models.Counts{Links: 42, Clicks: 1231, Campaigns: 8},
+	}
+
+	test := makeModelsTest(models.MetaBucket, testCases)
+	test(t)
+}
+
+func TestCountsArithmetic(t *testing.T) {
+	counts := &models.Counts{}
+	counts.Add(&models.Counts{Links: 1, Clicks: 10, Campaigns: 2})
+	counts.Add(&models.Counts{Links: 1, Clicks: 4})
+	require.Equal(t, &models.Counts{Links: 2, Clicks: 14, Campaigns: 2}, counts)
+
+	counts.Sub(&models.Counts{Links: 1, Clicks: 10, Campaigns: 2})
+	require.Equal(t, &models.Counts{Links: 1, Clicks: 4}, counts)
+
+	// Counts should not wrap around if they drift
+	counts.Sub(&models.Counts{Links: 2, Clicks: 5, Campaigns: 1})
+	require.Equal(t, &models.Counts{}, counts)
+}
@@ -29,6 +29,7 @@ type ShortURL struct {
 	Created     time.Time `msgpack:"created"`
 	Modified    time.Time `msgpack:"modified"`
 	CreatedBy   string    `msgpack:"created_by"`
+	APIKey      string    `msgpack:"apikey"`
 	CampaignID  uint64    `msgpack:"campaign_id"`
 	Campaigns   []uint64  `msgpack:"campaigns"`
 }
@@ -50,6 +51,15 @@ func (m *ShortURL) UnmarshalValue(data []byte) error {
 	return msgpack.Unmarshal(data, m)
 }
 
+// Counts returns the contribution of this link to the aggregate counts.
+func (m *ShortURL) Counts() *Counts {
+	return &Counts{
+		Links:     1,
+		Clicks:    m.Visits,
+		Campaigns: uint64(len(m.Campaigns)),
+	}
+}
+
 // Creates an api.ShortURL object and populates it with the fields from the model that
 // can be populated directly. Note that URL and AltURL cannot be directly populated
 // without a configuration object.
@@ -59,6 +69,7 @@ func (m *ShortURL) ToAPI() *api.ShortURL {
 		Title:       m.Title,
 		Description: m.Description,
 		Visits:      m.Visits,
+		CreatedBy:   m.CreatedBy,
 		CampaignID:  m.CampaignID,
 		Campaigns:   m.Campaigns,
 	}
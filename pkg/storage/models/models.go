@@ -15,8 +15,61 @@ var (
 	LinksBucket    = Bucket{240, 159, 148, 151}
 	APIKeysBucket  = Bucket{240, 159, 148, 145}
 	CampaignBucket = Bucket{240, 159, 142, 186}
+	ExpiresBucket  = Bucket{240, 159, 149, 176}
+	UsageBucket    = Bucket{240, 159, 147, 136}
+	UsersBucket    = Bucket{240, 159, 145, 165}
+	SessionsBucket = Bucket{240, 159, 142, 171}
+	AuditBucket    = Bucket{240, 159, 147, 156}
 )
 
+// Index buckets in use by the secondary indexes in rtnl.link
+var (
+	HostIndexBucket    = Bucket{240, 159, 140, 144}
+	CreatorIndexBucket = Bucket{240, 159, 145, 164}
+	CreatedIndexBucket = Bucket{240, 159, 147, 133}
+	APIKeyIndexBucket  = Bucket{240, 159, 151, 157}
+	OwnerIndexBucket   = Bucket{240, 159, 148, 143}
+)
+
+// UnknownBucket is the name of keys that are not in a bucket used by rtnl.link
+const UnknownBucket = "unknown"
+
+// Name returns a human readable name of the bucket, e.g. for reporting.
+func (b Bucket) Name() string {
+	switch b {
+	case MetaBucket:
+		return "meta"
+	case LinksBucket:
+		return "links"
+	case APIKeysBucket:
+		return "apikeys"
+	case CampaignBucket:
+		return "campaigns"
+	case ExpiresBucket:
+		return "expires"
+	case UsageBucket:
+		return "usage"
+	case UsersBucket:
+		return "users"
+	case SessionsBucket:
+		return "sessions"
+	case AuditBucket:
+		return "audit"
+	case HostIndexBucket:
+		return "host_index"
+	case CreatorIndexBucket:
+		return "creator_index"
+	case CreatedIndexBucket:
+		return "created_index"
+	case APIKeyIndexBucket:
+		return "apikey_index"
+	case OwnerIndexBucket:
+		return "owner_index"
+	default:
+		return UnknownBucket
+	}
+}
+
 func (b Bucket) String() string {
 	if b == MetaBucket {
 		return "meta"
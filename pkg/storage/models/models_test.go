@@ -25,6 +25,16 @@ func makeModelsTest(bucket models.Bucket, testCases []models.Model) func(t *test
 				cmp = &models.ShortURL{}
 			case *models.APIKey:
 				cmp = &models.APIKey{}
+			case *models.Counts:
+				cmp = &models.Counts{}
+			case *models.Usage:
+				cmp = &models.Usage{}
+			case *models.User:
+				cmp = &models.User{}
+			case *models.Session:
+				cmp = &models.Session{}
+			case *models.AuditEvent:
+				cmp = &models.AuditEvent{}
 			default:
 				require.Failf(t, "unknown model type", "test case %d had unknown type of model %T", i, model)
 			}
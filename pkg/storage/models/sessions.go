@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/api/v1"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Session tracks the access and refresh tokens issued to a web user when they log in
+// by the token ID (jti) that is shared by both tokens. A refresh token can only be used
+// if its session exists; the session is rotated to the ID of the new tokens when they
+// are refreshed and deleted when the user logs out or the session is revoked. Sessions
+// are written with a TTL so they are removed when the refresh token expires. A rotated
+// session is kept for a short reuse window with the ID of the session that replaced it
+// so that concurrent requests with the same refresh token are not logged out.
+type Session struct {
+	ID         string    `msgpack:"id"`
+	Subject    string    `msgpack:"subject"`
+	Email      string    `msgpack:"email"`
+	UserAgent  string    `msgpack:"user_agent"`
+	IPAddr     string    `msgpack:"ip_addr"`
+	Created    time.Time `msgpack:"created"`
+	Refreshed  time.Time `msgpack:"refreshed"`
+	Expires    time.Time `msgpack:"expires"`
+	ReplacedBy string    `msgpack:"replaced_by"`
+}
+
+var _ Model = &Session{}
+
+// Key is the sessions bucket followed by the token ID.
+func (m *Session) Key() []byte {
+	key := make([]byte, len(m.ID)+4)
+	copy(key[0:4], SessionsBucket[:])
+	copy(key[4:], m.ID)
+	return key
+}
+
+func (m *Session) MarshalValue() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (m *Session) UnmarshalValue(data []byte) error {
+	return msgpack.Unmarshal(data, m)
+}
+
+// IsExpired returns true if the refresh token of the session has expired.
+func (m *Session) IsExpired() bool {
+	return !m.Expires.After(time.Now())
+}
+
+// IsRotated returns true if the session has been replaced by refreshed tokens.
+func (m *Session) IsRotated() bool {
+	return m.ReplacedBy != ""
+}
+
+func (m *Session) ToAPI() *api.Session {
+	out := &api.Session{
+		ID:        m.ID,
+		Email:     m.Email,
+		UserAgent: m.UserAgent,
+		IPAddr:    m.IPAddr,
+		Created:   m.Created,
+		Expires:   m.Expires,
+	}
+
+	if !m.Refreshed.IsZero() {
+		out.Refreshed = &m.Refreshed
+	}
+	return out
+}
@@ -0,0 +1,29 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	now := time.Now().Truncate(time.Millisecond)
+	testCases := []models.Model{
+		&models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fw", Email: "jdoe@example.com", Created: now, Expires: now.Add(2 * time.Hour)},
+		&models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fx", Subject: "1234", Email: "asmith@example.com", UserAgent: "Mozilla/5.0", IPAddr: "192.168.1.1", Created: now, Refreshed: now.Add(time.Hour), Expires: now.Add(3 * time.Hour)},
+	}
+
+	test := makeModelsTest(models.SessionsBucket, testCases)
+	test(t)
+}
+
+func TestSessionExpired(t *testing.T) {
+	session := &models.Session{Expires: time.Now().Add(time.Hour)}
+	require.False(t, session.IsExpired())
+
+	session.Expires = time.Now().Add(-1 * time.Second)
+	require.True(t, session.IsExpired())
+	require.True(t, (&models.Session{}).IsExpired(), "sessions without an expiration are expired")
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// The amount of time daily usage records are kept before they expire.
+const UsageTTL = 48 * time.Hour
+
+// Usage records the number of links created with an API key on a single UTC day so
+// that daily quotas can be enforced. Usage records are written with a TTL so that old
+// records are removed from the database automatically.
+//
+// The number of active links is not stored with the usage record; it is counted from
+// the API key index when the usage is loaded.
+type Usage struct {
+	ClientID string    `msgpack:"client_id"`
+	Day      time.Time `msgpack:"day"`
+	Links    uint64    `msgpack:"links"`
+	Active   uint64    `msgpack:"-"`
+}
+
+var _ Model = &Usage{}
+
+// Key is the usage bucket followed by the client ID and the big endian day in the form
+// YYYYMMDD so that all of the usage records for a key are sorted by day.
+func (m *Usage) Key() []byte {
+	data, _ := base64.RawStdEncoding.DecodeString(m.ClientID)
+	key := make([]byte, len(data)+8)
+	copy(key[0:4], UsageBucket[:])
+	copy(key[4:], data)
+
+	day := m.Day.UTC()
+	binary.BigEndian.PutUint32(key[len(data)+4:], uint32(day.Year()*10000+int(day.Month())*100+day.Day()))
+	return key
+}
+
+func (m *Usage) MarshalValue() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (m *Usage) UnmarshalValue(data []byte) error {
+	return msgpack.Unmarshal(data, m)
+}
+
+// Today truncates the timestamp to the start of its UTC day.
+func Today(ts time.Time) time.Time {
+	return ts.UTC().Truncate(24 * time.Hour)
+}
+
+// Reset returns the time that the daily usage will be reset.
+func (m *Usage) Reset() time.Time {
+	return Today(m.Day).Add(24 * time.Hour)
+}
@@ -0,0 +1,65 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// User assigns a role to a web user by their email address. Roles assigned to users in
+// the database take precedence over roles assigned in the configuration. Users with a
+// derived key are local accounts that can log in with their email and password; local
+// accounts are locked after too many failed logins and can be disabled by an operator.
+type User struct {
+	Email        string    `msgpack:"email"`
+	Name         string    `msgpack:"name"`
+	Role         string    `msgpack:"role"`
+	DerivedKey   string    `msgpack:"derived_key"`
+	FailedLogins int       `msgpack:"failed_logins"`
+	LockedUntil  time.Time `msgpack:"locked_until"`
+	Disabled     time.Time `msgpack:"disabled"`
+	LastLogin    time.Time `msgpack:"last_login"`
+	Created      time.Time `msgpack:"created"`
+	Modified     time.Time `msgpack:"modified"`
+}
+
+var _ Model = &User{}
+
+// Key is the users bucket followed by the normalized email address of the user.
+func (m *User) Key() []byte {
+	email := NormalizeEmail(m.Email)
+	key := make([]byte, len(email)+4)
+	copy(key[0:4], UsersBucket[:])
+	copy(key[4:], email)
+	return key
+}
+
+func (m *User) MarshalValue() ([]byte, error) {
+	return msgpack.Marshal(m)
+}
+
+func (m *User) UnmarshalValue(data []byte) error {
+	return msgpack.Unmarshal(data, m)
+}
+
+// IsLocal returns true if the user has a password and can log in with a local account.
+func (m *User) IsLocal() bool {
+	return m.DerivedKey != ""
+}
+
+// IsDisabled returns true if the local account has been disabled by an operator.
+func (m *User) IsDisabled() bool {
+	return !m.Disabled.IsZero()
+}
+
+// IsLocked returns true if the local account is locked after too many failed logins.
+func (m *User) IsLocked() bool {
+	return !m.LockedUntil.IsZero() && m.LockedUntil.After(time.Now())
+}
+
+// NormalizeEmail trims and lowercases the email address so that users can be looked up
+// regardless of how the identity provider capitalizes the address.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,45 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	testCases := []models.Model{
+		&models.User{Email: "jdoe@example.com", Role: "admin"},
+		&models.User{Email: "asmith@example.com", Role: "viewer", Created: time.Now().Truncate(time.Millisecond), Modified: time.Now().Truncate(time.Millisecond)},
+		&models.User{Email: "admin@localhost", Name: "Admin", Role: "admin", DerivedKey: "$argon2id$v=19$m=65536,t=1,p=2$FTz/7HjvJ+Ye1FMz5Xp4Vw==$qD0c8hL3/VVDq/5ThGj+7uy8ig/TNAuSB2TJHDmMrz4=", FailedLogins: 2, LockedUntil: time.Now().Add(time.Hour).Truncate(time.Millisecond), Disabled: time.Now().Truncate(time.Millisecond), LastLogin: time.Now().Truncate(time.Millisecond)},
+	}
+
+	test := makeModelsTest(models.UsersBucket, testCases)
+	test(t)
+}
+
+func TestUserKey(t *testing.T) {
+	user := &models.User{Email: "jdoe@example.com"}
+	require.Equal(t, user.Key(), (&models.User{Email: " JDoe@Example.com "}).Key(), "email addresses should be normalized")
+	require.NotEqual(t, user.Key(), (&models.User{Email: "jdoe@example.co"}).Key())
+}
+
+func TestUserLocalAccount(t *testing.T) {
+	user := &models.User{Email: "jdoe@example.com", Role: "viewer"}
+	require.False(t, user.IsLocal())
+	require.False(t, user.IsDisabled())
+	require.False(t, user.IsLocked())
+
+	user.DerivedKey = "$argon2id$v=19$m=65536,t=1,p=2$FTz/7HjvJ+Ye1FMz5Xp4Vw==$qD0c8hL3/VVDq/5ThGj+7uy8ig/TNAuSB2TJHDmMrz4="
+	require.True(t, user.IsLocal())
+
+	user.LockedUntil = time.Now().Add(-1 * time.Minute)
+	require.False(t, user.IsLocked(), "lockout should expire")
+
+	user.LockedUntil = time.Now().Add(time.Minute)
+	require.True(t, user.IsLocked())
+
+	user.Disabled = time.Now()
+	require.True(t, user.IsDisabled())
+}
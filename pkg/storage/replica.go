@@ -0,0 +1,304 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/dgraph-io/ristretto/z"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+var (
+	// The version of the primary that has been applied by a follower.
+	replicaKey = []byte{0, 0, 0, 109, 'r', 'e', 'p', 'l', 'i', 'c', 'a'}
+
+	// Written to the primary by earlier versions to detect when a subscription was
+	// receiving changes; it may still exist in the database but is never replicated.
+	subscribeKey = []byte{0, 0, 0, 109, 's', 'u', 'b', 's', 'c', 'r', 'i', 'b', 'e'}
+
+	// Prefix of the keys used internally by badger.
+	badgerPrefix = []byte("!badger!")
+
+	// Followers only serve redirects, so credentials, sessions, and the records of who
+	// did what on the primary are never sent to them.
+	unreplicated = []models.Bucket{
+		models.UsersBucket,
+		models.SessionsBucket,
+		models.APIKeysBucket,
+		models.APIKeyIndexBucket,
+		models.OwnerIndexBucket,
+		models.UsageBucket,
+		models.AuditBucket,
+	}
+)
+
+// The maximum number of keys sent in a single manifest batch.
+const manifestBatchSize = 1000
+
+// OpenReplica opens the database as a read-only follower of a primary. Replicas are not
+// migrated since the migrations are replicated from the primary, and only modify the
+// database by applying changes from the primary. Clicks on replicas are not counted.
+func OpenReplica(conf config.StorageConfig) (_ Storage, err error) {
+	opts := badger.DefaultOptions(conf.DataPath)
+	opts.Logger = nil
+
+	store := &Store{replica: true}
+	if store.db, err = badger.Open(opts); err != nil {
+		return nil, err
+	}
+	return store, nil
+}
+
+// Stream sends a snapshot of every key that has changed since the specified version to
+// fn. Unlike a badger backup, deleted and expired keys are sent as tombstones (keys with
+// an empty value) so that followers can remove them. The returned version is a lower
+// bound on the version of the snapshot; changes after it may be in the snapshot.
+func (s *Store) Stream(ctx context.Context, since uint64, fn func(*pb.KVList) error) (version uint64, err error) {
+	version = s.db.MaxVersion()
+
+	stream := s.db.NewStream()
+	stream.LogPrefix = "rtnl.replicate"
+	stream.SinceTs = since
+	stream.ChooseKey = func(item *badger.Item) bool { return replicated(item.Key()) }
+	stream.KeyToList = tombstones
+	stream.Send = func(buf *z.Buffer) (err error) {
+		var kvs *pb.KVList
+		if kvs, err = badger.BufferToKVList(buf); err != nil {
+			return err
+		}
+		return fn(kvs)
+	}
+
+	if err = stream.Orchestrate(ctx); err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Only the latest version of each key is sent, with an empty value if it is deleted.
+func tombstones(key []byte, iter *badger.Iterator) (*pb.KVList, error) {
+	item := iter.Item()
+	kv := &pb.KV{
+		Key:       item.KeyCopy(nil),
+		Version:   item.Version(),
+		ExpiresAt: item.ExpiresAt(),
+		UserMeta:  []byte{item.UserMeta()},
+	}
+
+	if !item.IsDeletedOrExpired() {
+		var err error
+		if kv.Value, err = item.ValueCopy(nil); err != nil {
+			return nil, err
+		}
+	}
+	return &pb.KVList{Kv: []*pb.KV{kv}}, nil
+}
+
+// Manifest sends the keys of every replicated key that exists in the database to fn in
+// sorted batches. Followers remove any key that is not in the manifest so that deletes
+// are not lost if their tombstones were discarded by compaction before the follower
+// received a snapshot containing them.
+func (s *Store) Manifest(ctx context.Context, fn func([][]byte) error) (err error) {
+	return s.db.View(func(txn *badger.Txn) (err error) {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		keys := make([][]byte, 0, manifestBatchSize)
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			key := iter.Item().Key()
+			if !replicated(key) {
+				continue
+			}
+
+			keys = append(keys, iter.Item().KeyCopy(nil))
+			if len(keys) == manifestBatchSize {
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+
+				if err = fn(keys); err != nil {
+					return err
+				}
+				keys = make([][]byte, 0, manifestBatchSize)
+			}
+		}
+
+		if len(keys) > 0 {
+			return fn(keys)
+		}
+		return nil
+	})
+}
+
+// Subscribe sends every change to the database to fn until the context is done. Badger
+// does not signal when a subscription has been registered, so first is called with the
+// version of the first change that the subscription receives (including changes that
+// are not replicated); every change after that version is received, so a snapshot that
+// includes all versions up to one less than it will not miss any changes. Badger's
+// publisher waits on fn, so fn should not block.
+func (s *Store) Subscribe(ctx context.Context, first func(version uint64), fn func(*pb.KVList) error) (err error) {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	var once sync.Once
+	match := []pb.Match{{Prefix: []byte{}}}
+	err = s.db.Subscribe(ctx, func(kvs *badger.KVList) error {
+		once.Do(func() {
+			var version uint64
+			for _, kv := range kvs.Kv {
+				if version == 0 || kv.Version < version {
+					version = kv.Version
+				}
+			}
+			first(version)
+		})
+
+		changes := &pb.KVList{Kv: make([]*pb.KV, 0, len(kvs.Kv))}
+		for _, kv := range kvs.Kv {
+			if replicated(kv.Key) {
+				changes.Kv = append(changes.Kv, kv)
+			}
+		}
+
+		if len(changes.Kv) == 0 {
+			return nil
+		}
+		return fn(changes)
+	}, match)
+
+	if errors.Is(err, context.Canceled) {
+		return nil
+	}
+	return err
+}
+
+// Apply changes from the primary to a replica. Keys with an empty value are deleted
+// and all other keys are set with the expiration and user meta from the primary.
+func (s *Store) Apply(kvs *pb.KVList) (err error) {
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	for _, kv := range kvs.Kv {
+		if len(kv.Value) == 0 {
+			if err = batch.Delete(kv.Key); err != nil {
+				return err
+			}
+			continue
+		}
+
+		entry := badger.NewEntry(kv.Key, kv.Value)
+		entry.ExpiresAt = kv.ExpiresAt
+		if meta := userMeta(kv); meta != 0 {
+			entry = entry.WithMeta(meta)
+		}
+
+		if err = batch.SetEntry(entry); err != nil {
+			return err
+		}
+	}
+	return batch.Flush()
+}
+
+// Prune deletes every key from the replica that is not in the manifest of keys sent by
+// the primary, returning the number of keys that were deleted.
+func (s *Store) Prune(manifest map[string]struct{}) (n int, err error) {
+	batch := s.db.NewWriteBatch()
+	defer batch.Cancel()
+
+	err = s.db.View(func(txn *badger.Txn) (err error) {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		iter := txn.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			key := iter.Item().Key()
+			if bytes.Equal(key, replicaKey) || bytes.HasPrefix(key, badgerPrefix) {
+				continue
+			}
+
+			if _, ok := manifest[string(key)]; !ok {
+				if err = batch.Delete(iter.Item().KeyCopy(nil)); err != nil {
+					return err
+				}
+				n++
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return 0, err
+	}
+
+	if err = batch.Flush(); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// Checkpoint records the version of the primary that has been applied to the replica.
+func (s *Store) Checkpoint(version uint64) error {
+	return s.db.Update(func(txn *badger.Txn) error {
+		val := make([]byte, 8)
+		binary.LittleEndian.PutUint64(val, version)
+		return txn.Set(replicaKey, val)
+	})
+}
+
+// Applied returns the version of the primary that has been applied to the replica or
+// zero if no changes have been applied.
+func (s *Store) Applied() (version uint64, err error) {
+	err = s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(replicaKey)
+		if err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return nil
+			}
+			return err
+		}
+
+		return item.Value(func(val []byte) error {
+			version = binary.LittleEndian.Uint64(val)
+			return nil
+		})
+	})
+	return version, err
+}
+
+// Returns true if the key should be sent to followers. Internal badger keys are also
+// published by subscriptions but cannot be applied to a replica.
+func replicated(key []byte) bool {
+	if bytes.HasPrefix(key, badgerPrefix) || bytes.Equal(key, subscribeKey) {
+		return false
+	}
+
+	for _, bucket := range unreplicated {
+		if bytes.HasPrefix(key, bucket[:]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Snapshots set the user meta field while subscriptions set the meta field.
+func userMeta(kv *pb.KV) byte {
+	if len(kv.UserMeta) > 0 {
+		return kv.UserMeta[0]
+	}
+	if len(kv.Meta) > 0 {
+		return kv.Meta[0]
+	}
+	return 0
+}
@@ -0,0 +1,283 @@
+package storage_test
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4/pb"
+	"github.com/rotationalio/rtnl.link/pkg/config"
+	"github.com/rotationalio/rtnl.link/pkg/keygen"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplication(t *testing.T) {
+	primary := openStore(t)
+	follower, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err, "could not open replica")
+	t.Cleanup(func() { follower.Close() })
+
+	// A new replica has not applied any changes
+	applied, err := follower.Applied()
+	require.NoError(t, err)
+	require.Zero(t, applied)
+
+	// Replicas cannot be modified except by applying changes
+	require.ErrorIs(t, follower.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io"}), storage.ErrReadOnly)
+	require.ErrorIs(t, follower.Delete(1), storage.ErrReadOnly)
+
+	_, err = follower.SaveMany([]*models.ShortURL{{ID: 1, URL: "https://rotational.io"}})
+	require.ErrorIs(t, err, storage.ErrReadOnly)
+
+	links := []*models.ShortURL{
+		{ID: 1, URL: "https://rotational.io"},
+		{ID: 2, URL: "https://rotational.io/blog"},
+		{ID: 3, URL: "https://rotational.io/webinar", Expires: time.Now().Add(1 * time.Hour)},
+	}
+
+	for _, link := range links {
+		require.NoError(t, primary.Save(link), "could not save link")
+	}
+	require.NoError(t, primary.Delete(2), "could not delete link")
+
+	// Apply a snapshot of the primary to the replica
+	version, err := primary.Stream(context.Background(), 0, follower.Apply)
+	require.NoError(t, err, "could not stream snapshot")
+	require.NoError(t, follower.Checkpoint(version))
+
+	applied, err = follower.Applied()
+	require.NoError(t, err)
+	require.Equal(t, version, applied)
+
+	url, err := follower.Load(1)
+	require.NoError(t, err, "link was not replicated")
+	require.Equal(t, "https://rotational.io", url)
+
+	_, err = follower.Load(2)
+	require.ErrorIs(t, err, storage.ErrNotFound, "deleted link was replicated")
+
+	// The TTL and aggregate counts should be replicated from the primary
+	info, err := follower.LoadInfo(3)
+	require.NoError(t, err, "link was not replicated")
+	require.Equal(t, links[2].Expires.Unix(), info.Expires.Unix())
+
+	expected, err := primary.Counts()
+	require.NoError(t, err)
+	actual, err := follower.Counts()
+	require.NoError(t, err)
+	require.Equal(t, expected, actual)
+
+	// Loading a link on a replica should not record a visit
+	info, err = follower.LoadInfo(1)
+	require.NoError(t, err)
+	require.Zero(t, info.Visits)
+
+	// A snapshot since the applied version should only contain newer changes
+	require.NoError(t, primary.Delete(1), "could not delete link")
+
+	var kvs []*pb.KV
+	_, err = primary.Stream(context.Background(), version, func(list *pb.KVList) error {
+		kvs = append(kvs, list.Kv...)
+		return nil
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, kvs)
+
+	for _, kv := range kvs {
+		require.Greater(t, kv.Version, version, "snapshot contains an older change")
+	}
+
+	require.NoError(t, follower.Apply(&pb.KVList{Kv: kvs}))
+	_, err = follower.Load(1)
+	require.ErrorIs(t, err, storage.ErrNotFound, "delete was not replicated")
+}
+
+func TestSubscribe(t *testing.T) {
+	primary := openStore(t)
+	follower, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err, "could not open replica")
+	t.Cleanup(func() { follower.Close() })
+
+	// Replicas cannot be subscribed to
+	err = follower.Subscribe(context.Background(), func(uint64) {}, follower.Apply)
+	require.ErrorIs(t, err, storage.ErrReadOnly)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg     sync.WaitGroup
+		subErr error
+	)
+
+	wg.Add(1)
+	first := make(chan uint64, 1)
+	go func() {
+		defer wg.Done()
+		subErr = primary.Subscribe(ctx, func(version uint64) { first <- version }, follower.Apply)
+	}()
+
+	// The first change received by the subscription is reported with its version
+	version := waitSubscribed(t, primary, first)
+	require.Positive(t, version)
+
+	require.NoError(t, primary.Save(&models.ShortURL{ID: 42, URL: "https://rotational.io"}))
+	require.Eventually(t, func() bool {
+		_, err := follower.LoadInfo(42)
+		return err == nil
+	}, 5*time.Second, 10*time.Millisecond, "change was not replicated")
+
+	require.NoError(t, primary.Delete(42))
+	require.Eventually(t, func() bool {
+		_, err := follower.LoadInfo(42)
+		return err != nil
+	}, 5*time.Second, 10*time.Millisecond, "delete was not replicated")
+
+	cancel()
+	wg.Wait()
+	require.NoError(t, subErr, "subscription returned an error")
+}
+
+func TestReplicationExcludesCredentials(t *testing.T) {
+	primary := openStore(t)
+	follower, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err, "could not open replica")
+	t.Cleanup(func() { follower.Close() })
+
+	require.NoError(t, primary.Save(&models.ShortURL{ID: 1, URL: "https://rotational.io"}))
+	require.NoError(t, primary.Register(&models.APIKey{ClientID: keygen.KeyID(), DerivedKey: "secret"}))
+	require.NoError(t, primary.SaveUser(&models.User{Email: "jdoe@rotational.io", DerivedKey: "secret"}))
+	require.NoError(t, primary.CreateSession(&models.Session{ID: "session", Email: "jdoe@rotational.io", Expires: time.Now().Add(time.Hour)}))
+
+	sensitive := []models.Bucket{models.APIKeysBucket, models.UsersBucket, models.SessionsBucket}
+	isSensitive := func(key []byte) bool {
+		for _, bucket := range sensitive {
+			if bytes.HasPrefix(key, bucket[:]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// Neither the snapshot nor the manifest should contain credentials or sessions
+	_, err = primary.Stream(context.Background(), 0, func(kvs *pb.KVList) error {
+		for _, kv := range kvs.Kv {
+			require.False(t, isSensitive(kv.Key), "snapshot contains key in bucket %s", models.Bucket(kv.Key[:4]).Name())
+		}
+		return follower.Apply(kvs)
+	})
+	require.NoError(t, err, "could not stream snapshot")
+
+	manifest := make(map[string]struct{})
+	err = primary.Manifest(context.Background(), func(keys [][]byte) error {
+		for _, key := range keys {
+			require.False(t, isSensitive(key), "manifest contains key in bucket %s", models.Bucket(key[:4]).Name())
+			manifest[string(key)] = struct{}{}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not send manifest")
+	require.Contains(t, manifest, string((&models.ShortURL{ID: 1}).Key()))
+
+	_, err = follower.Load(1)
+	require.NoError(t, err, "link was not replicated")
+
+	// Nor should any changes to them be published to subscribers
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	first := make(chan uint64, 1)
+	changes := make(chan *pb.KV, 64)
+	go primary.Subscribe(ctx, func(version uint64) { first <- version }, func(kvs *pb.KVList) error {
+		for _, kv := range kvs.Kv {
+			changes <- kv
+		}
+		return nil
+	})
+	waitSubscribed(t, primary, first)
+
+	require.NoError(t, primary.CreateSession(&models.Session{ID: "another", Email: "jdoe@rotational.io", Expires: time.Now().Add(time.Hour)}))
+	require.NoError(t, primary.Save(&models.ShortURL{ID: 2, URL: "https://rotational.io/blog"}))
+
+	for {
+		select {
+		case kv := <-changes:
+			require.False(t, isSensitive(kv.Key), "published change in bucket %s", models.Bucket(kv.Key[:4]).Name())
+			if bytes.Equal(kv.Key, (&models.ShortURL{ID: 2}).Key()) {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("link change was not published")
+		}
+	}
+}
+
+// Badger does not signal when a subscription is registered, so touch an unreplicated key
+// on the primary until the subscription receives a change and return its version.
+func waitSubscribed(t *testing.T, primary storage.Storage, first <-chan uint64) uint64 {
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+
+	for {
+		require.NoError(t, primary.CreateSession(&models.Session{ID: "subscribe", Email: "jdoe@rotational.io", Expires: time.Now().Add(time.Hour)}))
+		select {
+		case version := <-first:
+			return version
+		case <-ticker.C:
+		case <-timeout:
+			t.Fatal("subscription did not receive any changes")
+		}
+	}
+}
+
+func TestPrune(t *testing.T) {
+	primary := openStore(t)
+	follower, err := storage.OpenReplica(config.StorageConfig{DataPath: t.TempDir()})
+	require.NoError(t, err, "could not open replica")
+	t.Cleanup(func() { follower.Close() })
+
+	for _, link := range []*models.ShortURL{{ID: 1, URL: "https://rotational.io"}, {ID: 2, URL: "https://rotational.io/blog"}} {
+		require.NoError(t, primary.Save(link), "could not save link")
+	}
+
+	version, err := primary.Stream(context.Background(), 0, follower.Apply)
+	require.NoError(t, err, "could not stream snapshot")
+	require.NoError(t, follower.Checkpoint(version))
+
+	// Simulate a delete whose tombstone was compacted away before it was replicated
+	require.NoError(t, primary.Delete(2), "could not delete link")
+
+	manifest := make(map[string]struct{})
+	err = primary.Manifest(context.Background(), func(keys [][]byte) error {
+		for _, key := range keys {
+			manifest[string(key)] = struct{}{}
+		}
+		return nil
+	})
+	require.NoError(t, err, "could not send manifest")
+
+	n, err := follower.Prune(manifest)
+	require.NoError(t, err, "could not prune follower")
+	require.Positive(t, n)
+
+	_, err = follower.Load(1)
+	require.NoError(t, err, "link in the manifest was pruned")
+
+	_, err = follower.LoadInfo(2)
+	require.ErrorIs(t, err, storage.ErrNotFound, "deleted link was not pruned")
+
+	// The version applied by the replica must not be pruned
+	applied, err := follower.Applied()
+	require.NoError(t, err)
+	require.Equal(t, version, applied)
+
+	// Pruning again should not delete anything
+	n, err = follower.Prune(manifest)
+	require.NoError(t, err, "could not prune follower")
+	require.Zero(t, n)
+}
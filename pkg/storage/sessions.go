@@ -0,0 +1,223 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// CreateSession stores a new session that expires when its refresh token expires.
+func (s *Store) CreateSession(obj *models.Session) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	obj.Email = models.NormalizeEmail(obj.Email)
+	if obj.Created.IsZero() {
+		obj.Created = time.Now()
+	}
+
+	return s.update(func(txn *badger.Txn) error {
+		return setSession(txn, obj)
+	})
+}
+
+// RetrieveSession returns the session with the specified token ID. Expired sessions
+// that have not yet been removed by badger are not returned.
+func (s *Store) RetrieveSession(id string) (*models.Session, error) {
+	obj := &models.Session{ID: id}
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(obj.Key())
+		if err != nil {
+			return err
+		}
+		return item.Value(obj.UnmarshalValue)
+	})
+
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	if obj.IsExpired() || obj.IsRotated() {
+		return nil, ErrNotFound
+	}
+	return obj, nil
+}
+
+// RotateSession replaces the session with the specified token ID with the session of
+// the refreshed tokens, preserving when the session was created. The old session is
+// replaced in the same transaction so that a refresh token can only be rotated once. If
+// reuse is positive, the old session is kept for the reuse window and rotating it again
+// returns ErrRotated while the session that replaced it is active, allowing concurrent
+// requests to continue with the refreshed tokens; otherwise the old session is deleted.
+// If the session has expired or been revoked ErrNotFound is returned.
+func (s *Store) RotateSession(id string, next *models.Session, reuse time.Duration) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	return s.update(func(txn *badger.Txn) (err error) {
+		prev := &models.Session{ID: id}
+
+		var item *badger.Item
+		if item, err = txn.Get(prev.Key()); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+
+		if err = item.Value(prev.UnmarshalValue); err != nil {
+			return err
+		}
+
+		if prev.IsExpired() {
+			return ErrNotFound
+		}
+
+		// The refresh token has already been used; it can only be reused while the
+		// session that replaced it has not been revoked.
+		if prev.IsRotated() {
+			if _, err = txn.Get((&models.Session{ID: prev.ReplacedBy}).Key()); err != nil {
+				if errors.Is(err, badger.ErrKeyNotFound) {
+					return ErrNotFound
+				}
+				return err
+			}
+			return ErrRotated
+		}
+
+		next.Email = prev.Email
+		next.Created = prev.Created
+		next.Refreshed = time.Now()
+		if err = setSession(txn, next); err != nil {
+			return err
+		}
+
+		if reuse <= 0 {
+			return txn.Delete(prev.Key())
+		}
+
+		prev.ReplacedBy = next.ID
+		if expires := next.Refreshed.Add(reuse); expires.Before(prev.Expires) {
+			prev.Expires = expires
+		}
+		return setSession(txn, prev)
+	})
+}
+
+// ListSessions returns the active sessions of the user with the specified email
+// address or all active sessions if the email address is empty.
+func (s *Store) ListSessions(email string) ([]*models.Session, error) {
+	email = models.NormalizeEmail(email)
+	sessions := make([]*models.Session, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := models.SessionsBucket[:]
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			obj := &models.Session{}
+			if err := it.Item().Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+
+			if obj.IsExpired() || obj.IsRotated() || (email != "" && obj.Email != email) {
+				continue
+			}
+			sessions = append(sessions, obj)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes the session so that its tokens can no longer be used.
+func (s *Store) RevokeSession(id string) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	obj := &models.Session{ID: id}
+	return s.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(obj.Key()); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		return txn.Delete(obj.Key())
+	})
+}
+
+// RevokeUserSessions deletes all of the sessions of the user with the specified email
+// address, logging the user out everywhere, and returns the number of sessions revoked.
+func (s *Store) RevokeUserSessions(email string) (revoked int, err error) {
+	if s.replica {
+		return 0, ErrReadOnly
+	}
+
+	err = s.update(func(txn *badger.Txn) (err error) {
+		revoked, err = revokeUserSessions(txn, email)
+		return err
+	})
+
+	if err != nil {
+		return 0, err
+	}
+	return revoked, nil
+}
+
+// Deletes all of the sessions of the user in the transaction, returning the number of
+// sessions that were revoked; rotated sessions are deleted but not counted.
+func revokeUserSessions(txn *badger.Txn, email string) (revoked int, err error) {
+	email = models.NormalizeEmail(email)
+	it := txn.NewIterator(badger.DefaultIteratorOptions)
+
+	keys := make([][]byte, 0)
+	prefix := models.SessionsBucket[:]
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		obj := &models.Session{}
+		if err = it.Item().Value(obj.UnmarshalValue); err != nil {
+			it.Close()
+			return 0, err
+		}
+
+		if obj.Email == email {
+			keys = append(keys, it.Item().KeyCopy(nil))
+			if !obj.IsRotated() {
+				revoked++
+			}
+		}
+	}
+	it.Close()
+
+	for _, key := range keys {
+		if err = txn.Delete(key); err != nil {
+			return 0, err
+		}
+	}
+	return revoked, nil
+}
+
+func setSession(txn *badger.Txn, obj *models.Session) (err error) {
+	ttl := time.Until(obj.Expires)
+	if ttl <= 0 {
+		return errors.New("cannot store an expired session")
+	}
+
+	var val []byte
+	if val, err = obj.MarshalValue(); err != nil {
+		return err
+	}
+	return txn.SetEntry(badger.NewEntry(obj.Key(), val).WithTTL(ttl))
+}
@@ -0,0 +1,105 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessions(t *testing.T) {
+	db := openStore(t)
+
+	sessions, err := db.ListSessions("")
+	require.NoError(t, err, "could not list sessions in empty database")
+	require.Len(t, sessions, 0)
+
+	_, err = db.RetrieveSession("01hcr3d1vwvdhxgq8h5y8ts3fw")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	expires := time.Now().Add(2 * time.Hour)
+	session := &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fw", Email: "JDoe@example.com", Expires: expires}
+	require.NoError(t, db.CreateSession(session), "could not create session")
+	require.Equal(t, "jdoe@example.com", session.Email)
+	require.False(t, session.Created.IsZero())
+
+	require.NoError(t, db.CreateSession(&models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fx", Email: "jdoe@example.com", Expires: expires}))
+	require.NoError(t, db.CreateSession(&models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fy", Email: "asmith@example.com", Expires: expires}))
+	require.Error(t, db.CreateSession(&models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fz", Email: "asmith@example.com", Expires: time.Now().Add(-1 * time.Hour)}), "expired sessions should not be stored")
+
+	sessions, err = db.ListSessions("")
+	require.NoError(t, err, "could not list sessions")
+	require.Len(t, sessions, 3)
+
+	sessions, err = db.ListSessions("jdoe@EXAMPLE.com")
+	require.NoError(t, err, "could not list user sessions")
+	require.Len(t, sessions, 2)
+
+	// Rotating the session should replace it and preserve when it was created
+	next := &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g0", Expires: expires.Add(time.Hour)}
+	require.NoError(t, db.RotateSession(session.ID, next, 0), "could not rotate session")
+	require.Equal(t, "jdoe@example.com", next.Email)
+	require.True(t, session.Created.Equal(next.Created))
+	require.False(t, next.Refreshed.IsZero())
+
+	_, err = db.RetrieveSession(session.ID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	cmp, err := db.RetrieveSession(next.ID)
+	require.NoError(t, err, "could not retrieve rotated session")
+	require.Equal(t, "jdoe@example.com", cmp.Email)
+
+	// A session can only be rotated once so refresh tokens cannot be reused
+	require.ErrorIs(t, db.RotateSession(session.ID, &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g1", Expires: expires}, 0), storage.ErrNotFound)
+
+	// Revoking a session prevents it from being retrieved or rotated
+	require.NoError(t, db.RevokeSession(next.ID), "could not revoke session")
+	require.ErrorIs(t, db.RevokeSession(next.ID), storage.ErrNotFound)
+	require.ErrorIs(t, db.RotateSession(next.ID, &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g1", Expires: expires}, 0), storage.ErrNotFound)
+
+	// Revoking the sessions of a user should not affect other users
+	revoked, err := db.RevokeUserSessions("jdoe@example.com")
+	require.NoError(t, err, "could not revoke user sessions")
+	require.Equal(t, 1, revoked)
+
+	sessions, err = db.ListSessions("")
+	require.NoError(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "asmith@example.com", sessions[0].Email)
+}
+
+func TestSessionReuse(t *testing.T) {
+	db := openStore(t)
+
+	expires := time.Now().Add(2 * time.Hour)
+	session := &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3fw", Email: "jdoe@example.com", Expires: expires}
+	require.NoError(t, db.CreateSession(session), "could not create session")
+
+	next := &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g0", Expires: expires.Add(time.Hour)}
+	require.NoError(t, db.RotateSession(session.ID, next, time.Minute), "could not rotate session")
+
+	// The rotated session cannot be retrieved or listed but can be reused in the window
+	_, err := db.RetrieveSession(session.ID)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	sessions, err := db.ListSessions("jdoe@example.com")
+	require.NoError(t, err, "could not list sessions")
+	require.Len(t, sessions, 1)
+	require.Equal(t, next.ID, sessions[0].ID)
+
+	require.ErrorIs(t, db.RotateSession(session.ID, &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g1", Expires: expires}, time.Minute), storage.ErrRotated)
+	_, err = db.RetrieveSession("01hcr3d1vwvdhxgq8h5y8ts3g1")
+	require.ErrorIs(t, err, storage.ErrNotFound, "reusing a refresh token should not create a session")
+
+	// The refresh token cannot be reused once the session that replaced it is revoked
+	require.NoError(t, db.RevokeSession(next.ID), "could not revoke session")
+	require.ErrorIs(t, db.RotateSession(session.ID, &models.Session{ID: "01hcr3d1vwvdhxgq8h5y8ts3g1", Expires: expires}, time.Minute), storage.ErrNotFound)
+
+	// Rotated sessions are removed but not counted when the user's sessions are revoked
+	revoked, err := db.RevokeUserSessions("jdoe@example.com")
+	require.NoError(t, err, "could not revoke user sessions")
+	require.Equal(t, 0, revoked)
+	require.ErrorIs(t, db.RevokeSession(session.ID), storage.ErrNotFound)
+}
@@ -1,9 +1,13 @@
 package storage
 
 import (
+	"context"
+	"errors"
 	"io"
+	"time"
 
 	"github.com/dgraph-io/badger/v4"
+	"github.com/dgraph-io/badger/v4/pb"
 	"github.com/rotationalio/rtnl.link/pkg/config"
 	"github.com/rotationalio/rtnl.link/pkg/storage/migrations"
 	"github.com/rotationalio/rtnl.link/pkg/storage/models"
@@ -13,24 +17,81 @@ type Storage interface {
 	io.Closer
 	LinkStorage
 	APIKeyStorage
+	UserStorage
+	SessionStorage
+	AuditStorage
 	StorageInfo
+	StorageMaintenance
+	StorageReplication
 }
 
 type LinkStorage interface {
 	Save(*models.ShortURL) error
+	SaveMany([]*models.ShortURL) ([]error, error)
 	List() ([]*models.ShortURL, error)
+	Query(*LinkQuery) ([]*models.ShortURL, error)
 	Load(uint64) (string, error)
 	LoadInfo(uint64) (*models.ShortURL, error)
+	Update(*models.ShortURL) error
 	Delete(uint64) error
 }
 
 type APIKeyStorage interface {
 	Register(*models.APIKey) error
 	Retrieve(string) (*models.APIKey, error)
+	ListAPIKeys() ([]*models.APIKey, error)
+	ListOwnerAPIKeys(owner string) ([]*models.APIKey, error)
+	UpdateAPIKey(*models.APIKey) error
+	RevokeAPIKey(string) error
+	RotateAPIKey(clientID, derivedKey string) (*models.APIKey, error)
+	TouchAPIKeys(map[string]time.Time) error
+	Usage(string) (*models.Usage, error)
+}
+
+type UserStorage interface {
+	SaveUser(*models.User) error
+	RetrieveUser(string) (*models.User, error)
+	ListUsers() ([]*models.User, error)
+	DeleteUser(string) error
+	SetPassword(email, derivedKey string) error
+	DisableUser(string) error
+	LoginSucceeded(string) error
+	LoginFailed(email string, attempts int, lockout time.Duration) (*models.User, error)
+}
+
+type SessionStorage interface {
+	CreateSession(*models.Session) error
+	RetrieveSession(string) (*models.Session, error)
+	RotateSession(id string, next *models.Session, reuse time.Duration) error
+	ListSessions(email string) ([]*models.Session, error)
+	RevokeSession(string) error
+	RevokeUserSessions(email string) (int, error)
+}
+
+type AuditStorage interface {
+	Audit(*models.AuditEvent) error
+	AuditLog(*AuditQuery) ([]*models.AuditEvent, error)
 }
 
 type StorageInfo interface {
 	Counts() (*models.Counts, error)
+	Recount() (*models.Counts, error)
+	ExpireLinks() (int, error)
+}
+
+type StorageMaintenance interface {
+	RunGC(discardRatio float64) (int64, error)
+	Flatten() error
+}
+
+type StorageReplication interface {
+	Stream(ctx context.Context, since uint64, fn func(*pb.KVList) error) (uint64, error)
+	Manifest(ctx context.Context, fn func([][]byte) error) error
+	Subscribe(ctx context.Context, first func(uint64), fn func(*pb.KVList) error) error
+	Apply(*pb.KVList) error
+	Prune(manifest map[string]struct{}) (int, error)
+	Checkpoint(version uint64) error
+	Applied() (uint64, error)
 }
 
 func Open(conf config.StorageConfig) (_ Storage, err error) {
@@ -43,8 +104,16 @@ func Open(conf config.StorageConfig) (_ Storage, err error) {
 		return nil, err
 	}
 
-	// Run the migrations to ensure the database is up to date.
-	if err = store.db.Update(migrations.Migrate); err != nil {
+	// Run the migrations to ensure the database is up to date. If the database is
+	// read-only or migrations are managed manually, only verify that it is up to date.
+	if conf.ReadOnly || conf.ManualMigrations {
+		err = store.db.View(migrations.Verify)
+	} else {
+		err = migrations.Migrate(store.db)
+	}
+
+	if err != nil {
+		store.db.Close()
 		return nil, err
 	}
 
@@ -52,7 +121,8 @@ func Open(conf config.StorageConfig) (_ Storage, err error) {
 }
 
 type Store struct {
-	db *badger.DB
+	db      *badger.DB
+	replica bool
 }
 
 var _ Storage = &Store{}
@@ -64,3 +134,19 @@ func (s *Store) Close() error {
 func (s *Store) DB() *badger.DB {
 	return s.db
 }
+
+// The number of times a transaction is retried if it conflicts with another.
+const maxTxnRetries = 8
+
+// update executes the function in a read-write transaction, retrying the transaction if
+// it conflicts with a concurrent transaction. Because writes to links also update the
+// aggregate counts, conflicts are expected when many links are clicked at once. The
+// function may be called more than once so it should not modify external state.
+func (s *Store) update(fn func(txn *badger.Txn) error) (err error) {
+	for i := 0; i < maxTxnRetries; i++ {
+		if err = s.db.Update(fn); !errors.Is(err, badger.ErrConflict) {
+			return err
+		}
+	}
+	return err
+}
@@ -0,0 +1,88 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/index"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// Usage returns the number of links created with the API key today along with the
+// number of links created with the key that are still active.
+func (s *Store) Usage(clientID string) (usage *models.Usage, err error) {
+	err = s.db.View(func(txn *badger.Txn) (err error) {
+		usage, err = loadUsage(txn, clientID, time.Now(), true)
+		return err
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// Checks the quota of the API key that is creating the link and records the link in
+// the daily usage of the key. Returns ErrQuotaExceeded if the link cannot be created.
+// Keys without a quota are not tracked so that creating links with them does not
+// require scanning the index or writing to the usage bucket.
+func consumeQuota(txn *badger.Txn, link *models.ShortURL) (err error) {
+	apikey := &models.APIKey{ClientID: link.APIKey}
+
+	var item *badger.Item
+	if item, err = txn.Get(apikey.Key()); err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+
+	if err = item.Value(apikey.UnmarshalValue); err != nil {
+		return err
+	}
+
+	if apikey.Quota.IsZero() {
+		return nil
+	}
+
+	var usage *models.Usage
+	if usage, err = loadUsage(txn, link.APIKey, link.Created, apikey.Quota.ActiveLinks > 0); err != nil {
+		return err
+	}
+
+	if apikey.Quota.Exceeded(usage) {
+		return ErrQuotaExceeded
+	}
+
+	usage.Links++
+	var val []byte
+	if val, err = usage.MarshalValue(); err != nil {
+		return err
+	}
+	return txn.SetEntry(badger.NewEntry(usage.Key(), val).WithTTL(models.UsageTTL))
+}
+
+// Loads the daily usage of the API key for the day of the timestamp and, if active is
+// true, counts the active links created with the key from the API key index.
+func loadUsage(txn *badger.Txn, clientID string, ts time.Time, active bool) (usage *models.Usage, err error) {
+	usage = &models.Usage{ClientID: clientID, Day: models.Today(ts)}
+
+	var item *badger.Item
+	if item, err = txn.Get(usage.Key()); err != nil {
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, err
+		}
+	} else {
+		if err = item.Value(usage.UnmarshalValue); err != nil {
+			return nil, err
+		}
+	}
+
+	if active {
+		if usage.Active, err = index.CountAPIKey(txn, clientID); err != nil {
+			return nil, err
+		}
+	}
+	return usage, nil
+}
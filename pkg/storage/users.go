@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+)
+
+// SaveUser creates or updates the user; the created timestamp of an existing user is
+// preserved.
+func (s *Store) SaveUser(obj *models.User) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	obj.Email = models.NormalizeEmail(obj.Email)
+	return s.update(func(txn *badger.Txn) (err error) {
+		var item *badger.Item
+		if item, err = txn.Get(obj.Key()); err == nil {
+			existing := &models.User{}
+			if err = item.Value(existing.UnmarshalValue); err != nil {
+				return err
+			}
+			obj.Created = existing.Created
+		} else if !errors.Is(err, badger.ErrKeyNotFound) {
+			return err
+		}
+
+		if obj.Created.IsZero() {
+			obj.Created = time.Now()
+		}
+		obj.Modified = time.Now()
+
+		var val []byte
+		if val, err = obj.MarshalValue(); err != nil {
+			return err
+		}
+		return txn.Set(obj.Key(), val)
+	})
+}
+
+// RetrieveUser returns the user with the specified email address.
+func (s *Store) RetrieveUser(email string) (*models.User, error) {
+	obj := &models.User{Email: email}
+	err := s.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(obj.Key())
+		if err != nil {
+			return err
+		}
+		return item.Value(obj.UnmarshalValue)
+	})
+
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return obj, nil
+}
+
+// ListUsers returns all of the users that have been assigned a role in the database.
+func (s *Store) ListUsers() ([]*models.User, error) {
+	users := make([]*models.User, 0)
+	err := s.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		prefix := models.UsersBucket[:]
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			obj := &models.User{}
+			if err := it.Item().Value(obj.UnmarshalValue); err != nil {
+				return err
+			}
+			users = append(users, obj)
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser removes the user so that their role is determined by the configuration.
+func (s *Store) DeleteUser(email string) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	obj := &models.User{Email: email}
+	return s.update(func(txn *badger.Txn) error {
+		if _, err := txn.Get(obj.Key()); err != nil {
+			if errors.Is(err, badger.ErrKeyNotFound) {
+				return ErrNotFound
+			}
+			return err
+		}
+		return txn.Delete(obj.Key())
+	})
+}
+
+// SetPassword sets the derived key of the user's password, unlocking and enabling the
+// local account. The sessions of the user are revoked so that anyone who was logged in
+// with the previous password must log in again. ErrNotFound is returned if the user
+// does not exist.
+func (s *Store) SetPassword(email, derivedKey string) error {
+	return s.modifyUserTxn(email, func(txn *badger.Txn, user *models.User) (err error) {
+		user.DerivedKey = derivedKey
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+		user.Disabled = time.Time{}
+		_, err = revokeUserSessions(txn, user.Email)
+		return err
+	})
+}
+
+// DisableUser prevents the user from logging in and revokes all of their sessions so
+// that they are logged out everywhere.
+func (s *Store) DisableUser(email string) error {
+	return s.modifyUserTxn(email, func(txn *badger.Txn, user *models.User) (err error) {
+		user.Disabled = time.Now()
+		_, err = revokeUserSessions(txn, user.Email)
+		return err
+	})
+}
+
+// LoginSucceeded records a successful login and resets the failed login count.
+func (s *Store) LoginSucceeded(email string) error {
+	return s.modifyUser(email, func(user *models.User) error {
+		user.FailedLogins = 0
+		user.LockedUntil = time.Time{}
+		user.LastLogin = time.Now()
+		return nil
+	})
+}
+
+// LoginFailed records a failed login; if the number of consecutive failed logins
+// reaches the maximum number of attempts the account is locked for the lockout
+// duration. The updated user is returned so the caller can determine if it is locked.
+func (s *Store) LoginFailed(email string, attempts int, lockout time.Duration) (user *models.User, err error) {
+	err = s.modifyUser(email, func(obj *models.User) error {
+		obj.FailedLogins++
+		if attempts > 0 && obj.FailedLogins >= attempts {
+			obj.FailedLogins = 0
+			obj.LockedUntil = time.Now().Add(lockout)
+		}
+		user = obj
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Loads the user, applies the modification, and saves the user in a transaction.
+func (s *Store) modifyUser(email string, modify func(*models.User) error) error {
+	return s.modifyUserTxn(email, func(_ *badger.Txn, user *models.User) error {
+		return modify(user)
+	})
+}
+
+// Loads the user, applies the modification, and saves the user in a transaction that
+// the modification can also use to make related changes.
+func (s *Store) modifyUserTxn(email string, modify func(*badger.Txn, *models.User) error) error {
+	if s.replica {
+		return ErrReadOnly
+	}
+
+	err := s.update(func(txn *badger.Txn) error {
+		obj := &models.User{Email: email}
+		item, err := txn.Get(obj.Key())
+		if err != nil {
+			return err
+		}
+
+		if err = item.Value(obj.UnmarshalValue); err != nil {
+			return err
+		}
+
+		if err = modify(txn, obj); err != nil {
+			return err
+		}
+
+		obj.Modified = time.Now()
+		var val []byte
+		if val, err = obj.MarshalValue(); err != nil {
+			return err
+		}
+		return txn.Set(obj.Key(), val)
+	})
+
+	if err != nil {
+		if errors.Is(err, badger.ErrKeyNotFound) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
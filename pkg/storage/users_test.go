@@ -0,0 +1,130 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/oklog/ulid/v2"
+	"github.com/rotationalio/rtnl.link/pkg/storage"
+	"github.com/rotationalio/rtnl.link/pkg/storage/models"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUsers(t *testing.T) {
+	db := openStore(t)
+
+	users, err := db.ListUsers()
+	require.NoError(t, err, "could not list users in empty database")
+	require.Len(t, users, 0)
+
+	_, err = db.RetrieveUser("jdoe@example.com")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	user := &models.User{Email: "JDoe@example.com", Role: "editor"}
+	require.NoError(t, db.SaveUser(user), "could not save user")
+	require.Equal(t, "jdoe@example.com", user.Email)
+	require.False(t, user.Created.IsZero())
+	require.NoError(t, db.SaveUser(&models.User{Email: "asmith@example.com", Role: "viewer"}))
+
+	// Saving an existing user should update the role but not the created timestamp
+	update := &models.User{Email: "jdoe@example.com", Role: "admin"}
+	require.NoError(t, db.SaveUser(update), "could not update user")
+	require.True(t, user.Created.Equal(update.Created))
+
+	cmp, err := db.RetrieveUser(" jdoe@EXAMPLE.com")
+	require.NoError(t, err, "could not retrieve user")
+	require.Equal(t, "admin", cmp.Role)
+
+	users, err = db.ListUsers()
+	require.NoError(t, err, "could not list users")
+	require.Len(t, users, 2)
+
+	require.NoError(t, db.DeleteUser("jdoe@example.com"), "could not delete user")
+	require.ErrorIs(t, db.DeleteUser("jdoe@example.com"), storage.ErrNotFound)
+
+	_, err = db.RetrieveUser("jdoe@example.com")
+	require.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func TestLocalAccounts(t *testing.T) {
+	db := openStore(t)
+
+	require.ErrorIs(t, db.SetPassword("jdoe@example.com", "derived"), storage.ErrNotFound)
+	require.ErrorIs(t, db.DisableUser("jdoe@example.com"), storage.ErrNotFound)
+	require.ErrorIs(t, db.LoginSucceeded("jdoe@example.com"), storage.ErrNotFound)
+	_, err := db.LoginFailed("jdoe@example.com", 3, time.Hour)
+	require.ErrorIs(t, err, storage.ErrNotFound)
+
+	require.NoError(t, db.SaveUser(&models.User{Email: "jdoe@example.com", Role: "editor"}))
+	require.NoError(t, db.SetPassword("JDoe@example.com", "derived"), "could not set password")
+
+	user, err := db.RetrieveUser("jdoe@example.com")
+	require.NoError(t, err)
+	require.True(t, user.IsLocal())
+	require.Equal(t, "editor", user.Role, "setting the password should not modify the role")
+
+	// The account is locked after the maximum number of failed attempts
+	for i := 1; i < 3; i++ {
+		user, err = db.LoginFailed("jdoe@example.com", 3, time.Hour)
+		require.NoError(t, err)
+		require.Equal(t, i, user.FailedLogins)
+		require.False(t, user.IsLocked())
+	}
+
+	user, err = db.LoginFailed("jdoe@example.com", 3, time.Hour)
+	require.NoError(t, err)
+	require.True(t, user.IsLocked())
+	require.Equal(t, 0, user.FailedLogins)
+
+	// Resetting the password unlocks the account
+	require.NoError(t, db.SetPassword("jdoe@example.com", "derived2"))
+	user, err = db.RetrieveUser("jdoe@example.com")
+	require.NoError(t, err)
+	require.False(t, user.IsLocked())
+	require.Equal(t, "derived2", user.DerivedKey)
+
+	// A successful login resets the failed login count
+	_, err = db.LoginFailed("jdoe@example.com", 3, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, db.LoginSucceeded("jdoe@example.com"))
+	user, err = db.RetrieveUser("jdoe@example.com")
+	require.NoError(t, err)
+	require.Equal(t, 0, user.FailedLogins)
+	require.False(t, user.LastLogin.IsZero())
+
+	require.NoError(t, db.DisableUser("jdoe@example.com"))
+	user, err = db.RetrieveUser("jdoe@example.com")
+	require.NoError(t, err)
+	require.True(t, user.IsDisabled())
+	require.True(t, user.IsLocal())
+}
+
+func TestDisableRevokesSessions(t *testing.T) {
+	db := openStore(t)
+	require.NoError(t, db.SaveUser(&models.User{Email: "jdoe@example.com", Role: "editor"}))
+
+	expires := time.Now().Add(time.Hour)
+	createSessions := func() {
+		require.NoError(t, db.CreateSession(&models.Session{ID: ulid.Make().String(), Email: "jdoe@example.com", Expires: expires}))
+		require.NoError(t, db.CreateSession(&models.Session{ID: ulid.Make().String(), Email: "asmith@example.com", Expires: expires}))
+	}
+
+	// Resetting the password logs the user out everywhere
+	createSessions()
+	require.NoError(t, db.SetPassword("jdoe@example.com", "derived"), "could not set password")
+	sessions, err := db.ListSessions("jdoe@example.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 0, "sessions were not revoked when the password was reset")
+
+	// Disabling the user logs the user out everywhere
+	createSessions()
+	require.NoError(t, db.DisableUser("jdoe@example.com"), "could not disable user")
+	sessions, err = db.ListSessions("jdoe@example.com")
+	require.NoError(t, err)
+	require.Len(t, sessions, 0, "sessions were not revoked when the user was disabled")
+
+	// The sessions of other users are not affected
+	sessions, err = db.ListSessions("")
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+}